@@ -81,7 +81,7 @@ func main() {
 	fmt.Println("Running ADK Go SDK example...")
 	fmt.Printf("User: %s\n", userMessage.Parts[0].Text)
 	
-	eventChan, err := runner.RunAsync(ctx, "user123", "session456", userMessage)
+	eventChan, err := runner.RunAsync(ctx, "user123", "session456", userMessage, nil)
 	if err != nil {
 		log.Fatalf("Failed to run agent: %v", err)
 	}
@@ -115,16 +115,31 @@ func main() {
 		[]agents.Agent{greeter, taskExecutor},
 	)
 	
+	// An agent has a single parent, so reuse the same definitions in other
+	// workflows through clones
+	parallelGreeter, err := agents.Clone(greeter, nil)
+	if err != nil {
+		log.Fatalf("Failed to clone greeter: %v", err)
+	}
+	parallelTaskExecutor, err := agents.Clone(taskExecutor, nil)
+	if err != nil {
+		log.Fatalf("Failed to clone task executor: %v", err)
+	}
+	loopGreeter, err := agents.Clone(greeter, nil)
+	if err != nil {
+		log.Fatalf("Failed to clone greeter: %v", err)
+	}
+	
 	// Create a parallel agent
 	parallelAgent := agents.NewParallelAgent(
 		"parallel_workflow",
-		[]agents.Agent{greeter, taskExecutor},
+		[]agents.Agent{parallelGreeter, parallelTaskExecutor},
 	)
 	
 	// Create a loop agent
 	loopAgent := agents.NewLoopAgent(
 		"loop_workflow",
-		[]agents.Agent{greeter},
+		[]agents.Agent{loopGreeter},
 		2, // Max 2 iterations
 	)
 	
@@ -150,7 +165,7 @@ func main() {
 			},
 		}
 		
-		eventChan, err := workflowRunner.RunAsync(ctx, "user123", "workflow_session", workflowMessage)
+		eventChan, err := workflowRunner.RunAsync(ctx, "user123", "workflow_session", workflowMessage, nil)
 		if err != nil {
 			log.Printf("Failed to run %s workflow: %v", workflow.name, err)
 			continue
@@ -0,0 +1,393 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agents
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// identifierPattern matches valid agent names
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// reservedAgentNames cannot be used as agent names
+var reservedAgentNames = map[string]bool{
+	"user": true,
+}
+
+// ValidateName checks that a name is a valid identifier and not reserved
+func ValidateName(name string) error {
+	if !identifierPattern.MatchString(name) {
+		return fmt.Errorf("agent name %q is not a valid identifier", name)
+	}
+	if reservedAgentNames[name] {
+		return fmt.Errorf("agent name %q is reserved", name)
+	}
+	return nil
+}
+
+// TreeValidationError lists the problems found in an agent tree
+type TreeValidationError struct {
+	Problems []string
+}
+
+// Error joins all problems into a single message
+func (e *TreeValidationError) Error() string {
+	return "invalid agent tree: " + strings.Join(e.Problems, "; ")
+}
+
+// identity returns a comparable value identifying an agent, regardless of
+// whether it is viewed as its concrete type or as its embedded BaseAgent
+func identity(agent Agent) interface{} {
+	if embedded, ok := agent.(interface{ base() *BaseAgent }); ok {
+		return embedded.base()
+	}
+	return agent
+}
+
+// sameAgent reports whether two agent values refer to the same agent
+func sameAgent(a, b Agent) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return identity(a) == identity(b)
+}
+
+// Validate checks an agent tree: names are unique valid identifiers, each
+// agent has a single parent, the tree has no cycles, and every transfer
+// target of an LLM agent resolves to that agent by name from the root.
+// LLM, router and graph agents are checked with their own validation as well.
+func Validate(root Agent) error {
+	v := &treeValidator{
+		root:    root,
+		names:   make(map[string]Agent),
+		parents: make(map[interface{}]Agent),
+		onPath:  make(map[interface{}]bool),
+	}
+	v.walk(root, nil)
+
+	// Transfer targets are resolved by name, which is only meaningful on a
+	// structurally sound tree
+	if len(v.problems) == 0 {
+		v.checkTransferTargets(root)
+	}
+
+	if len(v.problems) > 0 {
+		return &TreeValidationError{Problems: v.problems}
+	}
+	return nil
+}
+
+// treeValidator accumulates problems while walking an agent tree
+type treeValidator struct {
+	root     Agent
+	names    map[string]Agent
+	parents  map[interface{}]Agent
+	onPath   map[interface{}]bool
+	problems []string
+}
+
+// report records a problem
+func (v *treeValidator) report(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+// walk checks an agent and its subtree
+func (v *treeValidator) walk(agent Agent, parent Agent) {
+	id := identity(agent)
+	name := agent.GetName()
+
+	if v.onPath[id] {
+		v.report("agent %q is its own ancestor", name)
+		return
+	}
+	if previous, seen := v.parents[id]; seen {
+		v.report("agent %q is attached to multiple parents (%s, %s)", name, agentName(previous), agentName(parent))
+		return
+	}
+	v.parents[id] = parent
+
+	if err := ValidateName(name); err != nil {
+		v.report("%v", err)
+	}
+	if _, duplicate := v.names[name]; duplicate {
+		v.report("agent name %q is used more than once", name)
+	} else {
+		v.names[name] = agent
+	}
+
+	if parent != nil && !sameAgent(agent.GetParentAgent(), parent) {
+		v.report("agent %q is listed under %q but its parent is %s", name, parent.GetName(), agentName(agent.GetParentAgent()))
+	}
+	if parent == nil && agent.GetParentAgent() != nil {
+		v.report("root agent %q has parent %q", name, agent.GetParentAgent().GetName())
+	}
+
+	switch a := agent.(type) {
+	case *LlmAgent:
+		if err := a.validate(); err != nil {
+			v.report("%v", err)
+		}
+	case *RouterAgent:
+		if err := a.validate(); err != nil {
+			v.report("%v", err)
+		}
+	case *GraphAgent:
+		if err := a.Validate(); err != nil {
+			v.report("%v", err)
+		}
+	}
+
+	v.onPath[id] = true
+	for _, subAgent := range agent.GetSubAgents() {
+		v.walk(subAgent, agent)
+	}
+	delete(v.onPath, id)
+}
+
+// checkTransferTargets checks that every agent an LLM agent may transfer to
+// is the agent found under that name from the root
+func (v *treeValidator) checkTransferTargets(agent Agent) {
+	if llmAgent, ok := agent.(*LlmAgent); ok {
+		for _, target := range transferTargets(llmAgent) {
+			if found := v.root.FindAgent(target.GetName()); !sameAgent(found, target) {
+				v.report("transfer target %q of agent %q is not reachable by name from the root", target.GetName(), llmAgent.Name)
+			}
+		}
+	}
+
+	for _, subAgent := range agent.GetSubAgents() {
+		v.checkTransferTargets(subAgent)
+	}
+}
+
+// transferTargets returns the agents an LLM agent may transfer control to
+func transferTargets(agent *LlmAgent) []Agent {
+	targets := make([]Agent, 0, len(agent.SubAgents))
+	targets = append(targets, agent.SubAgents...)
+
+	parent := agent.GetParentAgent()
+	if parent == nil {
+		return targets
+	}
+	if !agent.DisallowTransferToParent {
+		targets = append(targets, parent)
+	}
+	if !agent.DisallowTransferToPeers {
+		for _, peer := range parent.GetSubAgents() {
+			if !sameAgent(peer, agent) {
+				targets = append(targets, peer)
+			}
+		}
+	}
+	return targets
+}
+
+// agentName returns an agent's quoted name, or "none" for a nil agent
+func agentName(agent Agent) string {
+	if agent == nil {
+		return "none"
+	}
+	return fmt.Sprintf("%q", agent.GetName())
+}
+
+// Clone deep-copies an agent subtree. The clone has no parent, its sub-agents
+// are clones parented to it, and slices such as tools are copied so that
+// they can be changed independently. Overrides set fields of the root clone
+// by their JSON name, e.g. {"name": "greeter_2", "instruction": "..."};
+// "sub_agents" replaces the sub-agents with clones of the given ones, leaving
+// them with their current parents.
+func Clone(agent Agent, overrides map[string]interface{}) (Agent, error) {
+	clone, err := cloneAgent(agent)
+	if err != nil {
+		return nil, err
+	}
+
+	for field, value := range overrides {
+		if field == "sub_agents" {
+			subAgents, ok := value.([]Agent)
+			if !ok {
+				return nil, fmt.Errorf("override sub_agents: expected []Agent, got %T", value)
+			}
+			base := clone.(interface{ base() *BaseAgent }).base()
+			base.SubAgents = make([]Agent, 0, len(subAgents))
+			for _, subAgent := range subAgents {
+				subClone, err := cloneAgent(subAgent)
+				if err != nil {
+					return nil, err
+				}
+				base.AddSubAgent(subClone)
+			}
+			continue
+		}
+		if err := setField(reflect.ValueOf(clone).Elem(), field, value); err != nil {
+			return nil, err
+		}
+	}
+
+	return clone, nil
+}
+
+// cloneAgent deep-copies an agent and its sub-agents
+func cloneAgent(agent Agent) (Agent, error) {
+	original := reflect.ValueOf(agent)
+	if original.Kind() != reflect.Ptr || original.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("cannot clone agent %s of type %T", agent.GetName(), agent)
+	}
+
+	copied := copyStruct(original)
+	clone := copied.Interface().(Agent)
+
+	embedded, ok := clone.(interface{ base() *BaseAgent })
+	if !ok {
+		return nil, fmt.Errorf("cannot clone agent %s of type %T: it does not embed *BaseAgent", agent.GetName(), agent)
+	}
+	base := embedded.base()
+	base.ParentAgent = nil
+	if base.self != nil {
+		base.self = clone
+	}
+
+	originalSubAgents := base.SubAgents
+	base.SubAgents = make([]Agent, 0, len(originalSubAgents))
+	subAgentClones := make(map[interface{}]Agent, len(originalSubAgents))
+	for _, subAgent := range originalSubAgents {
+		subClone, err := cloneAgent(subAgent)
+		if err != nil {
+			return nil, err
+		}
+		base.AddSubAgent(subClone)
+		subAgentClones[identity(subAgent)] = subClone
+	}
+
+	// Re-point references to sub-agents held outside SubAgents
+	switch a := clone.(type) {
+	case *GraphAgent:
+		for i, node := range a.Nodes {
+			nodeCopy := *node
+			if node.Agent != nil {
+				nodeCopy.Agent = subAgentClones[identity(node.Agent)]
+			}
+			a.Nodes[i] = &nodeCopy
+		}
+		for i, edge := range a.Edges {
+			edgeCopy := *edge
+			a.Edges[i] = &edgeCopy
+		}
+	case *RouterAgent:
+		for i, rule := range a.Rules {
+			ruleCopy := *rule
+			a.Rules[i] = &ruleCopy
+		}
+	}
+
+	return clone, nil
+}
+
+// copyStruct copies the struct a pointer refers to, recursively copying
+// embedded struct pointers and exported slices and maps
+func copyStruct(ptr reflect.Value) reflect.Value {
+	copied := reflect.New(ptr.Elem().Type())
+	copied.Elem().Set(ptr.Elem())
+
+	structValue := copied.Elem()
+	structType := structValue.Type()
+	for i := 0; i < structType.NumField(); i++ {
+		field := structValue.Field(i)
+		if !field.CanSet() || isNillable(field.Kind()) && field.IsNil() {
+			continue
+		}
+
+		switch {
+		case structType.Field(i).Anonymous && field.Kind() == reflect.Ptr && field.Elem().Kind() == reflect.Struct:
+			field.Set(copyStruct(field))
+		case field.Kind() == reflect.Slice:
+			sliceCopy := reflect.MakeSlice(field.Type(), field.Len(), field.Len())
+			reflect.Copy(sliceCopy, field)
+			field.Set(sliceCopy)
+		case field.Kind() == reflect.Map:
+			mapCopy := reflect.MakeMapWithSize(field.Type(), field.Len())
+			iter := field.MapRange()
+			for iter.Next() {
+				mapCopy.SetMapIndex(iter.Key(), iter.Value())
+			}
+			field.Set(mapCopy)
+		}
+	}
+
+	return copied
+}
+
+// isNillable reports whether values of a kind can be nil
+func isNillable(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface, reflect.Func, reflect.Chan:
+		return true
+	}
+	return false
+}
+
+// setField sets the field with the given JSON name on a struct or any struct
+// it embeds
+func setField(structValue reflect.Value, name string, value interface{}) error {
+	found, err := setFieldIn(structValue, name, value)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("override %s: no such field on %s", name, structValue.Type())
+	}
+	return nil
+}
+
+// setFieldIn searches a struct and its embedded structs for the field and
+// reports whether it was found
+func setFieldIn(structValue reflect.Value, name string, value interface{}) (bool, error) {
+	structType := structValue.Type()
+	for i := 0; i < structType.NumField(); i++ {
+		fieldType := structType.Field(i)
+		field := structValue.Field(i)
+
+		if fieldType.Anonymous && field.Kind() == reflect.Ptr && !field.IsNil() && field.Elem().Kind() == reflect.Struct {
+			if found, err := setFieldIn(field.Elem(), name, value); found || err != nil {
+				return found, err
+			}
+			continue
+		}
+
+		tag := strings.Split(fieldType.Tag.Get("json"), ",")[0]
+		if tag != name || tag == "" || tag == "-" || !field.CanSet() {
+			continue
+		}
+
+		if value == nil {
+			field.Set(reflect.Zero(field.Type()))
+			return true, nil
+		}
+		newValue := reflect.ValueOf(value)
+		switch {
+		case newValue.Type().AssignableTo(field.Type()):
+			field.Set(newValue)
+		case newValue.Kind() == field.Kind() && newValue.Type().ConvertibleTo(field.Type()):
+			field.Set(newValue.Convert(field.Type()))
+		default:
+			return true, fmt.Errorf("override %s: cannot use %T as %s", name, value, field.Type())
+		}
+		return true, nil
+	}
+	return false, nil
+}
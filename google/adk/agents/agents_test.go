@@ -16,10 +16,21 @@ package agents
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+	"unicode/utf8"
 
+	"github.com/adrienveepee/adk-go/google/adk/agents/invocation"
+	"github.com/adrienveepee/adk-go/google/adk/artifacts"
 	"github.com/adrienveepee/adk-go/google/adk/events"
+	"github.com/adrienveepee/adk-go/google/adk/memory"
+	"github.com/adrienveepee/adk-go/google/adk/models"
 	"github.com/adrienveepee/adk-go/google/adk/sessions"
+	"github.com/adrienveepee/adk-go/google/adk/tools"
 )
 
 func TestNewBaseAgent(t *testing.T) {
@@ -300,28 +311,30 @@ func TestLoopAgent(t *testing.T) {
 	}
 }
 
-func TestLoopAgentShouldExitLoop(t *testing.T) {
+func TestLoopAgentShouldContinue(t *testing.T) {
 	agent1 := NewBaseAgent("agent1", "First agent")
 	loopAgent := NewLoopAgent("loop", []Agent{agent1}, 3)
 	
 	session := sessions.NewSession("app", "user", "session", nil)
 	invocationCtx := &InvocationContext{Session: *session}
 	
-	// Test default state (should not exit)
-	if loopAgent.shouldExitLoop(invocationCtx) {
-		t.Error("Should not exit loop by default")
+	// Test without condition (should continue)
+	if !loopAgent.shouldContinue(invocationCtx) {
+		t.Error("Should continue loop without condition")
 	}
 	
-	// Test with exit flag set
-	session.State.Set("exit_loop", true)
-	if !loopAgent.shouldExitLoop(invocationCtx) {
-		t.Error("Should exit loop when exit_loop flag is true")
+	// Test with condition reading session state
+	loopAgent.SetCondition(func(ctx *InvocationContext) bool {
+		done, _ := ctx.Session.State.Get("done")
+		return done != true
+	})
+	if !loopAgent.shouldContinue(invocationCtx) {
+		t.Error("Should continue loop while condition is true")
 	}
 	
-	// Test with false exit flag
-	session.State.Set("exit_loop", false)
-	if loopAgent.shouldExitLoop(invocationCtx) {
-		t.Error("Should not exit loop when exit_loop flag is false")
+	session.State.Set("done", true)
+	if loopAgent.shouldContinue(invocationCtx) {
+		t.Error("Should not continue loop when condition is false")
 	}
 }
 
@@ -336,9 +349,2002 @@ func TestLoopAgentShouldExitLoopFromEvent(t *testing.T) {
 		t.Error("Should not exit loop from default event")
 	}
 	
-	// Test with skip summarization set (proxy for exit loop)
+	// Skip summarization alone no longer exits the loop
 	event.Actions.SkipSummarization = true
+	if loopAgent.shouldExitLoopFromEvent(event) {
+		t.Error("Should not exit loop when only SkipSummarization is true")
+	}
+	
+	// Test with escalate set
+	event.Actions.Escalate = true
 	if !loopAgent.shouldExitLoopFromEvent(event) {
-		t.Error("Should exit loop when SkipSummarization is true")
+		t.Error("Should exit loop when Escalate is true")
+	}
+}
+
+// countingAgent is a test agent that counts its runs and escalates on the
+// given run
+type countingAgent struct {
+	*BaseAgent
+	runs       int
+	escalateOn int
+}
+
+func (a *countingAgent) RunAsync(ctx context.Context, invocationCtx *InvocationContext) (<-chan *events.Event, error) {
+	a.runs++
+	eventChan := make(chan *events.Event, 1)
+	event := events.NewEvent()
+	event.Author = a.Name
+	event.Actions.Escalate = a.runs == a.escalateOn
+	eventChan <- event
+	close(eventChan)
+	return eventChan, nil
+}
+
+func runLoop(t *testing.T, loopAgent *LoopAgent, session *sessions.Session) int {
+	t.Helper()
+	eventChan, err := loopAgent.RunAsync(context.Background(), &InvocationContext{Session: *session})
+	if err != nil {
+		t.Fatalf("RunAsync should not return error: %v", err)
+	}
+	eventCount := 0
+	for range eventChan {
+		eventCount++
+	}
+	return eventCount
+}
+
+func TestLoopAgentRunAsync(t *testing.T) {
+	// Stops at MaxIterations
+	counter := &countingAgent{BaseAgent: NewBaseAgent("counter", "")}
+	session := sessions.NewSession("app", "user", "session", nil)
+	if n := runLoop(t, NewLoopAgent("loop", []Agent{counter}, 3), session); n != 3 {
+		t.Errorf("Expected 3 events, got %d", n)
+	}
+	if iteration, _ := session.State.Get("loop_iteration"); iteration != 3 {
+		t.Errorf("Expected iteration state to be 3, got %v", iteration)
+	}
+	
+	// Escalation stops the loop after the escalating sub-agent
+	escalator := &countingAgent{BaseAgent: NewBaseAgent("escalator", ""), escalateOn: 2}
+	follower := &countingAgent{BaseAgent: NewBaseAgent("follower", "")}
+	session = sessions.NewSession("app", "user", "session", nil)
+	if n := runLoop(t, NewLoopAgent("loop", []Agent{escalator, follower}, 5), session); n != 3 {
+		t.Errorf("Expected 3 events, got %d", n)
+	}
+	if follower.runs != 1 {
+		t.Errorf("Expected follower to run once, got %d", follower.runs)
+	}
+	
+	// MaxIterations 0 is unbounded and relies on the condition
+	counter = &countingAgent{BaseAgent: NewBaseAgent("counter", "")}
+	session = sessions.NewSession("app", "user", "session", nil)
+	loopAgent := NewLoopAgent("unbounded", []Agent{counter}, 0).SetCondition(func(ctx *InvocationContext) bool {
+		iteration, _ := ctx.Session.State.Get("unbounded_iteration")
+		return iteration.(int) < 10
+	})
+	if n := runLoop(t, loopAgent, session); n != 10 {
+		t.Errorf("Expected 10 events, got %d", n)
+	}
+}
+
+// emittingAgent is a test agent that emits a single event with the given text
+type emittingAgent struct {
+	*BaseAgent
+	text string
+}
+
+func newEmittingAgent(name, text string) *emittingAgent {
+	return &emittingAgent{
+		BaseAgent: NewBaseAgent(name, "Emitting agent"),
+		text:      text,
+	}
+}
+
+func (a *emittingAgent) RunAsync(ctx context.Context, invocationCtx *InvocationContext) (<-chan *events.Event, error) {
+	eventChan := make(chan *events.Event, 1)
+	event := events.NewEvent()
+	event.Author = a.Name
+	event.Content = &events.Content{Role: "model", Parts: []events.Part{{Text: a.text}}}
+	eventChan <- event
+	close(eventChan)
+	return eventChan, nil
+}
+
+func TestParallelAgentBranches(t *testing.T) {
+	researcher := newEmittingAgent("researcher", "research notes")
+	writer := newEmittingAgent("writer", "draft")
+	
+	parallelAgent := NewParallelAgent("parallel", []Agent{researcher, writer})
+	
+	session := sessions.NewSession("app", "user", "session", nil)
+	eventChan, err := parallelAgent.RunAsync(context.Background(), &InvocationContext{Session: *session})
+	if err != nil {
+		t.Fatalf("RunAsync should not return error: %v", err)
+	}
+	
+	branches := make(map[string]string)
+	for event := range eventChan {
+		branches[event.Author] = event.Branch
+	}
+	
+	if branches["researcher"] != "parallel.researcher" {
+		t.Errorf("Expected researcher branch to be 'parallel.researcher', got %q", branches["researcher"])
+	}
+	if branches["writer"] != "parallel.writer" {
+		t.Errorf("Expected writer branch to be 'parallel.writer', got %q", branches["writer"])
+	}
+	
+	// Nested parallel agents extend the current branch with their own name
+	nested := NewParallelAgent("root", []Agent{
+		NewSequentialAgent("step", []Agent{
+			NewParallelAgent("nested", []Agent{newEmittingAgent("leaf", "leaf")}),
+		}),
+	})
+	eventChan, _ = nested.RunAsync(context.Background(), &InvocationContext{Session: *session})
+	for event := range eventChan {
+		if event.Branch != "root.step.nested.leaf" {
+			t.Errorf("Expected nested branch to be 'root.step.nested.leaf', got %q", event.Branch)
+		}
+	}
+}
+
+func TestLlmAgentBuildContentsFiltersBranches(t *testing.T) {
+	agent := NewLlmAgent("researcher", "gemini-2.0-flash", "")
+	
+	session := sessions.NewSession("app", "user", "session", nil)
+	for _, e := range []struct{ branch, text string }{
+		{"", "user question"},
+		{"parallel", "coordinator note"},
+		{"parallel.researcher", "own notes"},
+		{"parallel.writer", "sibling chatter"},
+	} {
+		event := events.NewEvent()
+		event.Branch = e.branch
+		event.Content = &events.Content{Role: "model", Parts: []events.Part{{Text: e.text}}}
+		session.AddEvent(event)
+	}
+	
+	invocationCtx := &InvocationContext{Session: *session, Branch: "parallel.researcher"}
+	contents := agent.buildContents(invocationCtx, nil)
+	
+	var texts []string
+	for _, content := range contents {
+		texts = append(texts, content.Parts[0].Text)
+	}
+	
+	expected := []string{"user question", "coordinator note", "own notes"}
+	if len(texts) != len(expected) {
+		t.Fatalf("Expected contents %v, got %v", expected, texts)
+	}
+	for i := range expected {
+		if texts[i] != expected[i] {
+			t.Errorf("Expected content %d to be %q, got %q", i, expected[i], texts[i])
+		}
+	}
+}
+
+func collectAuthors(t *testing.T, agent Agent, session *sessions.Session) []string {
+	t.Helper()
+	eventChan, err := agent.RunAsync(context.Background(), &InvocationContext{Session: *session})
+	if err != nil {
+		t.Fatalf("RunAsync should not return error: %v", err)
+	}
+	var authors []string
+	for event := range eventChan {
+		authors = append(authors, event.Author)
+	}
+	return authors
+}
+
+func TestGraphAgentConditionalEdges(t *testing.T) {
+	graph := NewGraphAgent("router").
+		AddFuncNode("classify", func(ctx context.Context, invocationCtx *InvocationContext) (*events.Event, error) {
+			invocationCtx.Session.State.Set("category", "billing")
+			return nil, nil
+		}).
+		AddNode(newEmittingAgent("billing", "billing answer")).
+		AddNode(newEmittingAgent("support", "support answer")).
+		AddConditionalEdge("classify", "billing", StateEquals("category", "billing")).
+		AddConditionalEdge("classify", "support", StateEquals("category", "support"))
+	
+	authors := collectAuthors(t, graph, sessions.NewSession("app", "user", "session", nil))
+	if len(authors) != 1 || authors[0] != "billing" {
+		t.Errorf("Expected only billing to run, got %v", authors)
+	}
+}
+
+func TestGraphAgentCycleCap(t *testing.T) {
+	// A self loop is bounded by MaxIterations, which reports the cut
+	graph := NewGraphAgent("cycle").
+		AddNode(newEmittingAgent("worker", "work")).
+		AddEdge("worker", "worker").
+		SetMaxIterations(4)
+	
+	session := sessions.NewSession("app", "user", "session", nil)
+	received := runAndCollect(t, graph, &InvocationContext{InvocationID: "invocation-1", Session: *session})
+	if len(received) != 5 {
+		t.Fatalf("Expected 4 events and an error event, got %d events", len(received))
+	}
+	errorEvent := received[4]
+	if errorEvent.Author != "cycle" || errorEvent.ErrorCode != GraphMaxIterationsErrorCode || errorEvent.InvocationID != "invocation-1" {
+		t.Errorf("Expected a max iterations error event from the graph, got %+v", errorEvent)
+	}
+	if errorEvent.ErrorMessage != "graph agent cycle: maximum iterations exceeded after 4 steps" {
+		t.Errorf("Expected the error message to name the cap, got %q", errorEvent.ErrorMessage)
+	}
+	
+	// A cycle exits through a condition on the last event
+	count := 0
+	graph = NewGraphAgent("retry").
+		AddFuncNode("attempt", func(ctx context.Context, invocationCtx *InvocationContext) (*events.Event, error) {
+			count++
+			event := events.NewEvent()
+			event.Actions.Escalate = count == 3
+			return event, nil
+		}).
+		AddNode(newEmittingAgent("done", "done")).
+		AddConditionalEdge("attempt", "attempt", func(ctx *InvocationContext, last *events.Event) bool {
+			return !last.Actions.Escalate
+		}).
+		AddConditionalEdge("attempt", "done", func(ctx *InvocationContext, last *events.Event) bool {
+			return last.Actions.Escalate
+		})
+	
+	authors := collectAuthors(t, graph, sessions.NewSession("app", "user", "session", nil))
+	expected := []string{"retry", "retry", "retry", "done"}
+	if len(authors) != len(expected) {
+		t.Fatalf("Expected authors %v, got %v", expected, authors)
+	}
+	for i := range expected {
+		if authors[i] != expected[i] {
+			t.Errorf("Expected author %d to be %s, got %s", i, expected[i], authors[i])
+		}
+	}
+}
+
+func TestGraphAgentJoin(t *testing.T) {
+	graph := NewGraphAgent("fanout").
+		AddFuncNode("start", func(ctx context.Context, invocationCtx *InvocationContext) (*events.Event, error) {
+			return nil, nil
+		}).
+		AddNode(newEmittingAgent("fast", "fast")).
+		AddNode(newEmittingAgent("slow_1", "slow")).
+		AddNode(newEmittingAgent("slow_2", "slower")).
+		AddNode(newEmittingAgent("merge", "merged")).
+		AddEdge("start", "fast").
+		AddEdge("start", "slow_1").
+		AddEdge("slow_1", "slow_2").
+		AddEdge("fast", "merge").
+		AddEdge("slow_2", "merge").
+		SetJoin("merge")
+	
+	eventChan, err := graph.RunAsync(context.Background(), &InvocationContext{Session: *sessions.NewSession("app", "user", "session", nil)})
+	if err != nil {
+		t.Fatalf("RunAsync should not return error: %v", err)
+	}
+	
+	var authors []string
+	branches := make(map[string]string)
+	for event := range eventChan {
+		authors = append(authors, event.Author)
+		branches[event.Author] = event.Branch
+	}
+	
+	if len(authors) != 4 {
+		t.Fatalf("Expected 4 events, got %v", authors)
+	}
+	if authors[3] != "merge" {
+		t.Errorf("Expected merge to run last and once, got %v", authors)
+	}
+	if branches["fast"] != "fanout.fast" {
+		t.Errorf("Expected fast to run in branch 'fanout.fast', got %q", branches["fast"])
+	}
+	if branches["merge"] != "" {
+		t.Errorf("Expected merge to run in the root branch, got %q", branches["merge"])
+	}
+}
+
+func TestGraphAgentNodeErrors(t *testing.T) {
+	testCases := []struct {
+		name    string
+		failing func(graph *GraphAgent) *GraphAgent
+		message string
+	}{
+		{
+			name: "function node",
+			failing: func(graph *GraphAgent) *GraphAgent {
+				return graph.AddFuncNode("fail", func(ctx context.Context, invocationCtx *InvocationContext) (*events.Event, error) {
+					return nil, errors.New("lookup failed")
+				})
+			},
+			message: "graph node fail: lookup failed",
+		},
+		{
+			name: "agent node",
+			failing: func(graph *GraphAgent) *GraphAgent {
+				// An empty graph cannot start
+				return graph.AddNode(NewGraphAgent("fail"))
+			},
+			message: "graph node fail: graph agent fail has no nodes",
+		},
+	}
+	
+	for _, tc := range testCases {
+		graph := NewGraphAgent("graph").
+			AddFuncNode("start", func(ctx context.Context, invocationCtx *InvocationContext) (*events.Event, error) {
+				return events.NewEvent(), nil
+			})
+		graph = tc.failing(graph).
+			AddNode(newEmittingAgent("after", "after")).
+			AddEdge("start", "fail").
+			AddEdge("fail", "after")
+		
+		session := sessions.NewSession("app", "user", "session", nil)
+		eventChan, err := graph.RunAsync(context.Background(), &InvocationContext{InvocationID: "invocation-1", Session: *session})
+		if err != nil {
+			t.Fatalf("%s: RunAsync should not return error: %v", tc.name, err)
+		}
+		var collected []*events.Event
+		for event := range eventChan {
+			collected = append(collected, event)
+		}
+		
+		if len(collected) != 2 {
+			t.Fatalf("%s: expected the start event and an error event, got %d events", tc.name, len(collected))
+		}
+		if collected[0].InvocationID != "invocation-1" {
+			t.Errorf("%s: expected the function node's event to carry the invocation ID, got %q", tc.name, collected[0].InvocationID)
+		}
+		errorEvent := collected[1]
+		if errorEvent.Author != "graph" || errorEvent.ErrorCode != GraphAgentErrorCode || errorEvent.InvocationID != "invocation-1" {
+			t.Errorf("%s: expected an error event from the graph, got %+v", tc.name, errorEvent)
+		}
+		if errorEvent.ErrorMessage != tc.message {
+			t.Errorf("%s: expected error message %q, got %q", tc.name, tc.message, errorEvent.ErrorMessage)
+		}
+	}
+}
+
+func TestGraphAgentValidate(t *testing.T) {
+	graph := NewGraphAgent("graph").
+		AddNode(newEmittingAgent("a", "a")).
+		AddNode(newEmittingAgent("b", "b")).
+		AddNode(newEmittingAgent("orphan", "orphan")).
+		AddEdge("a", "b")
+	
+	if err := graph.Validate(); err == nil {
+		t.Error("Expected error for unreachable node")
+	}
+	if _, err := graph.RunAsync(context.Background(), &InvocationContext{}); err == nil {
+		t.Error("RunAsync should reject an invalid graph")
+	}
+	
+	graph.AddEdge("b", "orphan")
+	if err := graph.Validate(); err != nil {
+		t.Errorf("Expected valid graph, got %v", err)
+	}
+	
+	graph.AddEdge("b", "missing")
+	if err := graph.Validate(); err == nil {
+		t.Error("Expected error for edge to missing node")
+	}
+	
+	if err := NewGraphAgent("empty").Validate(); err == nil {
+		t.Error("Expected error for empty graph")
+	}
+}
+
+// fakeLLM is a test model that answers every request with a fixed text
+type fakeLLM struct {
+	*models.BaseLLM
+	response string
+	requests []*models.LLMRequest
+}
+
+func newFakeLLM(response string) *fakeLLM {
+	return &fakeLLM{BaseLLM: models.NewBaseLLM("fake"), response: response}
+}
+
+func (f *fakeLLM) Connect(ctx context.Context, request *models.LLMRequest) (models.LLMConnection, error) {
+	return nil, errors.New("live connections are not supported")
+}
+
+func (f *fakeLLM) GenerateContentAsync(ctx context.Context, request *models.LLMRequest) (<-chan *events.Event, error) {
+	f.requests = append(f.requests, request)
+	eventChan := make(chan *events.Event, 1)
+	event := events.NewEvent()
+	event.Author = f.ModelName
+	event.Content = &events.Content{Role: "model", Parts: []events.Part{{Text: f.response}}}
+	event.IsFinalResponse = true
+	eventChan <- event
+	close(eventChan)
+	return eventChan, nil
+}
+
+func (f *fakeLLM) SupportedModels() []string {
+	return []string{"fake"}
+}
+
+func routeMessage(t *testing.T, router *RouterAgent, message string) (*RoutingDecision, []string) {
+	t.Helper()
+	session := sessions.NewSession("app", "user", "session", nil)
+	userEvent := events.NewEvent()
+	userEvent.Author = "user"
+	userEvent.Content = &events.Content{Role: "user", Parts: []events.Part{{Text: message}}}
+	session.AddEvent(userEvent)
+	
+	eventChan, err := router.RunAsync(context.Background(), &InvocationContext{Session: *session})
+	if err != nil {
+		t.Fatalf("RunAsync should not return error: %v", err)
+	}
+	
+	var decision *RoutingDecision
+	var authors []string
+	for event := range eventChan {
+		if d, ok := event.CustomMetadata[RoutingDecisionMetadataKey].(RoutingDecision); ok {
+			decision = &d
+			continue
+		}
+		authors = append(authors, event.Author)
+	}
+	if decision == nil {
+		t.Fatal("Expected a routing event")
+	}
+	return decision, authors
+}
+
+func TestRouterAgentRules(t *testing.T) {
+	llm := newFakeLLM(`{"agent": "tech", "confidence": 0.9, "reason": "technical"}`)
+	router := NewRouterAgent("router", "fake", []Agent{
+		newEmittingAgent("billing", "billing answer"),
+		newEmittingAgent("tech", "tech answer"),
+	}).
+		AddKeywordRoute("billing", "invoice", "refund").
+		AddPatternRoute("tech", `(?i)error \d+`)
+	router.llm = llm
+	
+	decision, authors := routeMessage(t, router, "Where is my INVOICE?")
+	if decision.Agent != "billing" || decision.Method != RoutingMethodRule {
+		t.Errorf("Expected keyword rule route to billing, got %+v", decision)
+	}
+	if len(authors) != 1 || authors[0] != "billing" {
+		t.Errorf("Expected billing to run, got %v", authors)
+	}
+	
+	decision, _ = routeMessage(t, router, "I get Error 42 on startup")
+	if decision.Agent != "tech" || decision.Method != RoutingMethodRule {
+		t.Errorf("Expected pattern rule route to tech, got %+v", decision)
+	}
+	
+	if len(llm.requests) != 0 {
+		t.Errorf("Rules should skip the model call, got %d calls", len(llm.requests))
+	}
+	
+	// Rules set on the field after a run are used too
+	router.Rules = append(router.Rules, &RouteRule{Agent: "billing", Pattern: `^pay\b`})
+	decision, _ = routeMessage(t, router, "pay now")
+	if decision.Agent != "billing" || decision.Method != RoutingMethodRule {
+		t.Errorf("Expected the added pattern rule to route to billing, got %+v", decision)
+	}
+}
+
+func TestRouterAgentConcurrentRuns(t *testing.T) {
+	router := NewRouterAgent("router", "fake", []Agent{newEmittingAgent("tech", "tech answer")}).
+		AddPatternRoute("tech", `(?i)error \d+`)
+	
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			session := sessions.NewSession("app", "user", "session", nil)
+			userEvent := events.NewEvent()
+			userEvent.Author = "user"
+			userEvent.Content = &events.Content{Role: "user", Parts: []events.Part{{Text: "error 42"}}}
+			session.AddEvent(userEvent)
+			
+			eventChan, err := router.RunAsync(context.Background(), &InvocationContext{Session: *session})
+			if err != nil {
+				t.Errorf("RunAsync should not return error: %v", err)
+				return
+			}
+			for range eventChan {
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRouterAgentModel(t *testing.T) {
+	billing := newEmittingAgent("billing", "billing answer")
+	billing.Description = "Handles payments"
+	tech := newEmittingAgent("tech", "tech answer")
+	tech.Description = "Handles technical issues"
+	
+	llm := newFakeLLM("```json\n{\"agent\": \"tech\", \"confidence\": 0.8, \"reason\": \"crash report\"}\n```")
+	router := NewRouterAgent("router", "fake", []Agent{billing, tech}).SetFallback("billing")
+	router.llm = llm
+	
+	decision, authors := routeMessage(t, router, "The app crashes")
+	if decision.Agent != "tech" || decision.Method != RoutingMethodModel || decision.Reason != "crash report" {
+		t.Errorf("Expected model route to tech, got %+v", decision)
+	}
+	if len(authors) != 1 || authors[0] != "tech" {
+		t.Errorf("Expected tech to run, got %v", authors)
+	}
+	if !strings.Contains(llm.requests[0].Contents[0].Parts[0].Text, "tech: Handles technical issues") {
+		t.Error("Classification instruction should list sub-agent descriptions")
+	}
+	
+	// Low confidence goes to the fallback agent
+	router.SetConfidenceThreshold(0.9)
+	decision, authors = routeMessage(t, router, "The app crashes")
+	if decision.Agent != "billing" || decision.Method != RoutingMethodFallback {
+		t.Errorf("Expected fallback route to billing, got %+v", decision)
+	}
+	if len(authors) != 1 || authors[0] != "billing" {
+		t.Errorf("Expected billing to run, got %v", authors)
+	}
+	
+	// Unparseable responses go to the fallback agent
+	router.llm = newFakeLLM("I think tech")
+	decision, _ = routeMessage(t, router, "The app crashes")
+	if decision.Agent != "billing" || decision.Method != RoutingMethodFallback {
+		t.Errorf("Expected fallback route to billing, got %+v", decision)
+	}
+}
+
+func TestRouterAgentErrors(t *testing.T) {
+	// Without a fallback agent, an unknown choice has nowhere to go
+	router := NewRouterAgent("router", "fake", []Agent{newEmittingAgent("tech", "tech answer")})
+	router.llm = newFakeLLM(`{"agent": "sales", "confidence": 0.9, "reason": "pricing"}`)
+	decision, authors := routeMessage(t, router, "How much is it?")
+	if decision.Agent != "" || decision.Method != RoutingMethodFallback {
+		t.Errorf("Expected a fallback decision, got %+v", decision)
+	}
+	if len(authors) != 1 || authors[0] != "router" {
+		t.Fatalf("Expected only an error event from the router, got %v", authors)
+	}
+	
+	// A sub-agent that cannot start is reported too
+	router = NewRouterAgent("router", "fake", []Agent{NewGraphAgent("broken")}).AddKeywordRoute("broken", "help")
+	session := sessions.NewSession("app", "user", "session", nil)
+	userEvent := events.NewEvent()
+	userEvent.Author = "user"
+	userEvent.Content = &events.Content{Role: "user", Parts: []events.Part{{Text: "help"}}}
+	session.AddEvent(userEvent)
+	
+	eventChan, err := router.RunAsync(context.Background(), &InvocationContext{InvocationID: "invocation-1", Session: *session})
+	if err != nil {
+		t.Fatalf("RunAsync should not return error: %v", err)
+	}
+	var collected []*events.Event
+	for event := range eventChan {
+		collected = append(collected, event)
+	}
+	if len(collected) != 2 {
+		t.Fatalf("Expected a routing event and an error event, got %d events", len(collected))
+	}
+	if collected[0].InvocationID != "invocation-1" {
+		t.Errorf("Expected the routing event to carry the invocation ID, got %q", collected[0].InvocationID)
+	}
+	errorEvent := collected[1]
+	if errorEvent.ErrorCode != RouterAgentErrorCode || !strings.Contains(errorEvent.ErrorMessage, "graph agent broken has no nodes") || errorEvent.InvocationID != "invocation-1" {
+		t.Errorf("Expected an error event for the failed sub-agent, got %+v", errorEvent)
+	}
+}
+
+func TestRouterAgentCallBudget(t *testing.T) {
+	tech := NewLlmAgent("tech", "fake", "")
+	tech.llm = newFakeLLM("tech answer")
+	router := NewRouterAgent("router", "fake", []Agent{tech})
+	router.llm = newFakeLLM(`{"agent": "tech", "confidence": 0.9, "reason": "technical"}`)
+	session := sessions.NewSession("app", "user", "session", nil)
+	userEvent := events.NewEvent()
+	userEvent.Author = "user"
+	userEvent.Content = &events.Content{Role: "user", Parts: []events.Part{{Text: "It crashes"}}}
+	session.AddEvent(userEvent)
+	
+	// The classification uses the only call, leaving none for the target
+	invocationCtx := &InvocationContext{Session: *session, RunConfig: &RunConfig{MaxLLMCalls: 1}}
+	received := runAndCollect(t, router, invocationCtx)
+	if len(received) != 2 || received[1].Author != "tech" || received[1].ErrorCode != LLMCallLimitErrorCode {
+		t.Fatalf("Expected a routing event and a limit error from tech, got %+v", received)
+	}
+	if invocationCtx.LLMCallCount() != 2 {
+		t.Errorf("Expected the classification and the target's call to be counted, got %d", invocationCtx.LLMCallCount())
+	}
+	
+	// Without calls left the router does not classify
+	invocationCtx = &InvocationContext{Session: *session, RunConfig: &RunConfig{MaxLLMCalls: 1}}
+	invocationCtx.IncrementLLMCallCount()
+	received = runAndCollect(t, router, invocationCtx)
+	if len(received) != 1 || received[0].Author != "router" || received[0].ErrorCode != LLMCallLimitErrorCode {
+		t.Errorf("Expected only a limit error from the router, got %+v", received)
+	}
+}
+
+func TestRouterAgentValidate(t *testing.T) {
+	router := NewRouterAgent("router", "fake", []Agent{newEmittingAgent("a", "a")}).SetFallback("missing")
+	if _, err := router.RunAsync(context.Background(), &InvocationContext{}); err == nil {
+		t.Error("Expected error for unknown fallback agent")
+	}
+	
+	router = NewRouterAgent("router", "fake", []Agent{newEmittingAgent("a", "a")}).AddPatternRoute("a", "(")
+	if _, err := router.RunAsync(context.Background(), &InvocationContext{}); err == nil {
+		t.Error("Expected error for invalid pattern")
+	}
+	
+	router = NewRouterAgent("router", "fake", []Agent{newEmittingAgent("a", "a")})
+	router.Rules = []*RouteRule{{Agent: "a", Pattern: "["}}
+	if _, err := router.RunAsync(context.Background(), &InvocationContext{}); err == nil {
+		t.Error("Expected error for an invalid pattern set on the field")
+	}
+}
+
+func TestFindAgentReturnsConcreteAgent(t *testing.T) {
+	child := NewLlmAgent("child", "gemini-2.0-flash", "")
+	root := NewSequentialAgent("root", []Agent{child})
+	
+	if _, ok := root.FindAgent("child").(*LlmAgent); !ok {
+		t.Errorf("Expected FindAgent to return *LlmAgent, got %T", root.FindAgent("child"))
+	}
+	if _, ok := root.FindAgent("root").(*SequentialAgent); !ok {
+		t.Errorf("Expected FindAgent to return *SequentialAgent, got %T", root.FindAgent("root"))
+	}
+	if _, ok := child.GetParentAgent().(*SequentialAgent); !ok {
+		t.Errorf("Expected parent to be *SequentialAgent, got %T", child.GetParentAgent())
+	}
+	if _, ok := child.GetRootAgent().(*SequentialAgent); !ok {
+		t.Errorf("Expected root to be *SequentialAgent, got %T", child.GetRootAgent())
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := NewSequentialAgent("root", []Agent{
+		NewLlmAgent("researcher", "gemini-2.0-flash", ""),
+		NewLlmAgent("writer", "gemini-2.0-flash", ""),
+	})
+	if err := Validate(valid); err != nil {
+		t.Errorf("Expected valid tree, got %v", err)
 	}
-}
\ No newline at end of file
+	
+	testCases := []struct {
+		name    string
+		build   func() Agent
+		problem string
+	}{
+		{
+			name: "duplicate names",
+			build: func() Agent {
+				return NewSequentialAgent("root", []Agent{
+					NewLlmAgent("worker", "gemini-2.0-flash", ""),
+					NewParallelAgent("group", []Agent{NewLlmAgent("worker", "gemini-2.0-flash", "")}),
+				})
+			},
+			problem: `agent name "worker" is used more than once`,
+		},
+		{
+			name: "invalid identifier",
+			build: func() Agent {
+				return NewSequentialAgent("root", []Agent{NewLlmAgent("my-agent", "gemini-2.0-flash", "")})
+			},
+			problem: `agent name "my-agent" is not a valid identifier`,
+		},
+		{
+			name: "reserved name",
+			build: func() Agent {
+				return NewSequentialAgent("root", []Agent{NewLlmAgent("user", "gemini-2.0-flash", "")})
+			},
+			problem: `agent name "user" is reserved`,
+		},
+		{
+			name: "multiple parents",
+			build: func() Agent {
+				shared := NewLlmAgent("shared", "gemini-2.0-flash", "")
+				first := NewSequentialAgent("first", []Agent{shared})
+				NewSequentialAgent("second", []Agent{shared})
+				return first
+			},
+			problem: `agent "shared" is listed under "first" but its parent is "second"`,
+		},
+		{
+			name: "cycle",
+			build: func() Agent {
+				root := NewSequentialAgent("root", nil)
+				child := NewSequentialAgent("child", []Agent{root})
+				root.AddSubAgent(child)
+				root.SetParentAgent(nil)
+				return root
+			},
+			problem: `agent "root" is its own ancestor`,
+		},
+		{
+			name: "invalid router",
+			build: func() Agent {
+				return NewRouterAgent("router", "gemini-2.0-flash", nil).SetFallback("missing")
+			},
+			problem: `fallback agent "missing" is not a sub-agent`,
+		},
+		{
+			name: "unknown compaction strategy",
+			build: func() Agent {
+				return NewLlmAgent("assistant", "gemini-2.0-flash", "").SetCompaction(&CompactionConfig{Strategy: "compress"})
+			},
+			problem: `unknown compaction strategy "compress"`,
+		},
+	}
+	
+	for _, tc := range testCases {
+		err := Validate(tc.build())
+		if err == nil {
+			t.Errorf("%s: expected validation error", tc.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.problem) {
+			t.Errorf("%s: expected problem %q, got %v", tc.name, tc.problem, err)
+		}
+	}
+}
+
+func TestClone(t *testing.T) {
+	tool := &countingTool{}
+	greeter := NewLlmAgent("greeter", "gemini-2.0-flash", "Say hello").AddTool(tool)
+	helper := NewLlmAgent("helper", "gemini-2.0-flash", "Help")
+	greeter.AddSubAgent(helper)
+	NewSequentialAgent("first", []Agent{greeter})
+	
+	cloned, err := Clone(greeter, map[string]interface{}{
+		"name":        "greeter_2",
+		"instruction": "Say hi",
+	})
+	if err != nil {
+		t.Fatalf("Clone should not return error: %v", err)
+	}
+	
+	clone, ok := cloned.(*LlmAgent)
+	if !ok {
+		t.Fatalf("Expected *LlmAgent, got %T", cloned)
+	}
+	if clone.Name != "greeter_2" || clone.Instruction != "Say hi" || clone.Model != "gemini-2.0-flash" {
+		t.Errorf("Clone not configured as expected: %+v", clone)
+	}
+	if greeter.Name != "greeter" || greeter.Instruction != "Say hello" {
+		t.Error("Overrides should not change the original")
+	}
+	if clone.GetParentAgent() != nil {
+		t.Error("Clone should have no parent")
+	}
+	
+	// Sub-agents are cloned and parented to the clone
+	clonedHelper := clone.FindSubAgent("helper")
+	if clonedHelper == nil || clonedHelper == Agent(helper) {
+		t.Fatal("Expected sub-agent to be cloned")
+	}
+	if clonedHelper.GetParentAgent() != Agent(clone) {
+		t.Error("Cloned sub-agent should be parented to the clone")
+	}
+	if helper.GetParentAgent() != Agent(greeter) {
+		t.Error("Original sub-agent parent should be unchanged")
+	}
+	
+	// Slices are copied
+	clone.AddTool(&countingTool{})
+	if len(greeter.Tools) != 1 {
+		t.Error("Adding a tool to the clone should not change the original")
+	}
+	
+	// The clone can be attached under another parent
+	second := NewSequentialAgent("second", []Agent{cloned})
+	if err := Validate(second); err != nil {
+		t.Errorf("Expected clone to be valid under a new parent, got %v", err)
+	}
+	
+	// Overriding sub-agents clones them, leaving them with their parents
+	replaced, err := Clone(greeter, map[string]interface{}{"name": "greeter_3", "sub_agents": []Agent{helper}})
+	if err != nil {
+		t.Fatalf("Clone should not return error: %v", err)
+	}
+	replacedHelper := replaced.(*LlmAgent).FindSubAgent("helper")
+	if replacedHelper == nil || replacedHelper == Agent(helper) || replacedHelper.GetParentAgent() != replaced {
+		t.Error("Expected the override sub-agent to be cloned and parented to the clone")
+	}
+	if helper.GetParentAgent() != Agent(greeter) || greeter.FindSubAgent("helper") != Agent(helper) {
+		t.Error("Override sub-agent should stay with its original parent")
+	}
+	if err := Validate(greeter.GetParentAgent()); err != nil {
+		t.Errorf("Expected the original tree to stay valid, got %v", err)
+	}
+	
+	if _, err := Clone(greeter, map[string]interface{}{"no_such_field": 1}); err == nil {
+		t.Error("Expected error for unknown override")
+	}
+	if _, err := Clone(greeter, map[string]interface{}{"name": 42}); err == nil {
+		t.Error("Expected error for mistyped override")
+	}
+}
+
+func TestCloneGraphAgent(t *testing.T) {
+	graph := NewGraphAgent("graph").
+		AddNode(newEmittingAgent("a", "a")).
+		AddNode(newEmittingAgent("b", "b")).
+		AddEdge("a", "b")
+	
+	cloned, err := Clone(graph, nil)
+	if err != nil {
+		t.Fatalf("Clone should not return error: %v", err)
+	}
+	
+	clone := cloned.(*GraphAgent)
+	for i, node := range clone.Nodes {
+		if node.Agent != clone.SubAgents[i] {
+			t.Errorf("Expected node %s to reference the cloned sub-agent", node.Name)
+		}
+		if node == graph.Nodes[i] {
+			t.Errorf("Expected node %s to be copied", node.Name)
+		}
+	}
+	
+	authors := collectAuthors(t, clone, sessions.NewSession("app", "user", "session", nil))
+	if len(authors) != 2 {
+		t.Errorf("Expected cloned graph to run both nodes, got %v", authors)
+	}
+}
+
+// countingTool is a minimal tool for tests
+type countingTool struct {
+	calls int
+}
+
+func (c *countingTool) GetName() string        { return "counting_tool" }
+func (c *countingTool) GetDescription() string { return "Counts calls" }
+func (c *countingTool) IsLongRunning() bool    { return false }
+func (c *countingTool) RunAsync(ctx context.Context, args map[string]interface{}, toolCtx *tools.ToolContext) (interface{}, error) {
+	c.calls++
+	return c.calls, nil
+}
+
+func (c *countingTool) ProcessLLMRequest(toolCtx *tools.ToolContext, llmRequest *models.LLMRequest) error {
+	return nil
+}
+
+func TestExportGraph(t *testing.T) {
+	researcher := NewLlmAgent("researcher", "gemini-2.0-flash", "").AddTool(&countingTool{})
+	researcher.DisallowTransferToPeers = true
+	writer := NewLlmAgent("writer", "gemini-2.0-flash", "")
+	writer.DisallowTransferToParent = true
+	critic := NewLlmAgent("critic", "gemini-2.0-flash", "")
+	critic.DisallowTransferToParent = true
+	critic.DisallowTransferToPeers = true
+	
+	root := NewSequentialAgent("pipeline", []Agent{
+		NewParallelAgent("gather", []Agent{researcher, writer}),
+		NewLoopAgent("refine", []Agent{critic}, 3),
+	})
+	
+	dot, err := ExportGraph(root, GraphFormatDOT)
+	if err != nil {
+		t.Fatalf("ExportGraph should not return error: %v", err)
+	}
+	for _, expected := range []string{
+		"digraph agents {",
+		`"pipeline" [label="pipeline\n(sequential)", shape=box];`,
+		`"pipeline" -> "gather" [label="1"];`,
+		`"pipeline" -> "refine" [label="2"];`,
+		`"gather" -> "researcher" [label="parallel"];`,
+		`"refine" -> "critic" [label="1"];`,
+		`"critic" -> "critic" [label="repeat (max 3)"];`,
+		`"researcher__tool__counting_tool" [label="counting_tool", shape=note];`,
+		`"researcher" -> "researcher__tool__counting_tool" [style=dashed, arrowhead=none];`,
+		`"researcher" -> "gather" [label="transfer", style=dotted];`,
+		`"writer" -> "researcher" [label="transfer", style=dotted];`,
+	} {
+		if !strings.Contains(dot, expected) {
+			t.Errorf("Expected DOT output to contain %q, got:\n%s", expected, dot)
+		}
+	}
+	for _, unexpected := range []string{
+		`"researcher" -> "writer"`,
+		`"writer" -> "gather"`,
+		`"critic" -> "refine" [label="transfer"`,
+	} {
+		if strings.Contains(dot, unexpected) {
+			t.Errorf("Expected DOT output not to contain %q", unexpected)
+		}
+	}
+	
+	mermaid, err := ExportGraph(root, GraphFormatMermaid)
+	if err != nil {
+		t.Fatalf("ExportGraph should not return error: %v", err)
+	}
+	for _, expected := range []string{
+		"flowchart TD",
+		`pipeline["pipeline<br/>(sequential)"]`,
+		`researcher(["researcher<br/>(llm)"])`,
+		`pipeline -->|"1"| gather`,
+		`critic -->|"repeat (max 3)"| critic`,
+		`researcher -.- researcher__tool__counting_tool`,
+		`writer -.->|"transfer"| researcher`,
+	} {
+		if !strings.Contains(mermaid, expected) {
+			t.Errorf("Expected Mermaid output to contain %q, got:\n%s", expected, mermaid)
+		}
+	}
+	
+	if _, err := ExportGraph(root, "svg"); err == nil {
+		t.Error("Expected error for unsupported format")
+	}
+}
+
+func TestExportGraphWorkflowNodes(t *testing.T) {
+	graph := NewGraphAgent("flow").
+		AddFuncNode("classify", func(ctx context.Context, invocationCtx *InvocationContext) (*events.Event, error) {
+			return nil, nil
+		}).
+		AddNode(newEmittingAgent("billing", "billing")).
+		AddConditionalEdge("classify", "billing", StateEquals("category", "billing"))
+	router := NewRouterAgent("front", "gemini-2.0-flash", []Agent{graph, newEmittingAgent("general", "general")}).
+		SetFallback("general")
+	
+	mermaid, err := ExportGraph(router, GraphFormatMermaid)
+	if err != nil {
+		t.Fatalf("ExportGraph should not return error: %v", err)
+	}
+	for _, expected := range []string{
+		`front -->|"route"| flow`,
+		`front -->|"fallback"| general`,
+		`flow__classify{{"classify"}}`,
+		`flow -->|"start"| flow__classify`,
+		`flow__classify -->|"if"| billing`,
+	} {
+		if !strings.Contains(mermaid, expected) {
+			t.Errorf("Expected Mermaid output to contain %q, got:\n%s", expected, mermaid)
+		}
+	}
+}
+
+func TestInvocationContextCallbacks(t *testing.T) {
+	worker := NewLlmAgent("worker", "fake", "")
+	worker.llm = newFakeLLM("done")
+	
+	var agentName, invocationID string
+	worker.BeforeAgentCallback = func(callbackCtx *CallbackContext) error {
+		agentName = callbackCtx.AgentName()
+		invocationID = callbackCtx.InvocationID()
+		return callbackCtx.SaveArtifact(context.Background(), "notes.txt", []byte("notes"), nil)
+	}
+	pipeline := NewSequentialAgent("pipeline", []Agent{worker})
+	
+	session := sessions.NewSession("app", "user", "session", nil)
+	invocationCtx := &InvocationContext{
+		InvocationID:    "e-123",
+		Session:         *session,
+		ArtifactService: artifacts.NewInMemoryArtifactService(),
+	}
+	eventChan, err := pipeline.RunAsync(context.Background(), invocationCtx)
+	if err != nil {
+		t.Fatalf("RunAsync should not return error: %v", err)
+	}
+	
+	var artifactDelta map[string]interface{}
+	var modelEvent *events.Event
+	for event := range eventChan {
+		if event.Actions.ArtifactDelta != nil {
+			artifactDelta = event.Actions.ArtifactDelta
+		} else {
+			modelEvent = event
+		}
+	}
+	
+	if agentName != "worker" {
+		t.Errorf("Expected callback agent name to be 'worker', got %q", agentName)
+	}
+	if invocationID != "e-123" {
+		t.Errorf("Expected callback invocation ID to be 'e-123', got %q", invocationID)
+	}
+	if artifactDelta["notes.txt"] != 1 {
+		t.Errorf("Expected artifact delta for notes.txt to be version 1, got %v", artifactDelta)
+	}
+	if modelEvent == nil || modelEvent.InvocationID != "e-123" {
+		t.Errorf("Expected model event to carry invocation ID 'e-123', got %+v", modelEvent)
+	}
+}
+
+func TestEndInvocation(t *testing.T) {
+	first := NewLlmAgent("first", "fake", "")
+	first.llm = newFakeLLM("never sent")
+	first.BeforeAgentCallback = func(callbackCtx *CallbackContext) error {
+		callbackCtx.EndInvocation()
+		return nil
+	}
+	second := newEmittingAgent("second", "never run")
+	
+	session := sessions.NewSession("app", "user", "session", nil)
+	authors := collectAuthors(t, NewSequentialAgent("pipeline", []Agent{first, second}), session)
+	if len(authors) != 0 {
+		t.Errorf("Expected no events after the invocation ended, got %v", authors)
+	}
+}
+
+func TestMaxLLMCalls(t *testing.T) {
+	agent := NewLlmAgent("chatty", "fake", "")
+	agent.llm = newFakeLLM("hello")
+	
+	session := sessions.NewSession("app", "user", "session", nil)
+	invocationCtx := &InvocationContext{Session: *session, RunConfig: &RunConfig{MaxLLMCalls: 2}}
+	
+	responses := 0
+	var errorEvents []*events.Event
+	for i := 0; i < 3; i++ {
+		for _, event := range runAndCollect(t, agent, invocationCtx) {
+			if event.ErrorCode != "" {
+				errorEvents = append(errorEvents, event)
+			} else {
+				responses++
+			}
+		}
+	}
+	
+	if responses != 2 {
+		t.Errorf("Expected 2 model responses, got %d", responses)
+	}
+	
+	// The run over the limit reports it instead of ending silently
+	if len(errorEvents) != 1 || errorEvents[0].ErrorCode != LLMCallLimitErrorCode || errorEvents[0].Author != "chatty" || !strings.Contains(errorEvents[0].ErrorMessage, "llm calls exceeded") {
+		t.Errorf("Expected a single limit error event, got %+v", errorEvents)
+	}
+	if !invocationCtx.InvocationEnded() {
+		t.Error("Expected exceeding the limit to end the invocation")
+	}
+	if invocationCtx.LLMCallCount() != 3 {
+		t.Errorf("Expected 3 recorded model calls, got %d", invocationCtx.LLMCallCount())
+	}
+}
+
+func TestAgentTool(t *testing.T) {
+	helper := newEmittingAgent("helper", "help")
+	agentTool := tools.NewAgentTool(helper)
+	
+	if agentTool.GetName() != "transfer_to_helper" {
+		t.Errorf("Expected tool name 'transfer_to_helper', got %q", agentTool.GetName())
+	}
+	
+	session := sessions.NewSession("app", "user", "session", nil)
+	toolCtx := tools.NewToolContext(&InvocationContext{Session: *session}, "call-1")
+	if _, err := agentTool.RunAsync(context.Background(), nil, toolCtx); err != nil {
+		t.Errorf("Expected agent tool to run, got %v", err)
+	}
+}
+
+func TestLlmAgentRunConfig(t *testing.T) {
+	agent := NewLlmAgent("speaker", "fake", "")
+	temperature := float32(0.2)
+	agent.SetGenerateContentConfig(&models.GenerateContentConfig{Temperature: &temperature})
+	
+	session := sessions.NewSession("app", "user", "session", nil)
+	invocationCtx := &InvocationContext{
+		Session: *session,
+		RunConfig: &RunConfig{
+			StreamingMode:      invocation.StreamingModeSSE,
+			ResponseModalities: []string{"AUDIO"},
+			SpeechConfig:       &models.SpeechConfig{VoiceName: "Puck"},
+		},
+	}
+	request := agent.buildLLMRequest(invocationCtx, nil)
+	
+	if !request.Stream {
+		t.Error("Expected SSE streaming mode to request streaming")
+	}
+	if request.Config == nil || len(request.Config.ResponseModalities) != 1 || request.Config.ResponseModalities[0] != "AUDIO" {
+		t.Errorf("Expected response modalities [AUDIO], got %+v", request.Config)
+	}
+	if request.Config.SpeechConfig == nil || request.Config.SpeechConfig.VoiceName != "Puck" {
+		t.Errorf("Expected speech config voice 'Puck', got %+v", request.Config.SpeechConfig)
+	}
+	if request.Config.Temperature != &temperature {
+		t.Error("Expected the agent's temperature to be kept")
+	}
+	if agent.GenerateContentConfig.ResponseModalities != nil {
+		t.Error("Expected the agent's own config to be left unchanged")
+	}
+}
+
+func TestLlmAgentDeadline(t *testing.T) {
+	agent := NewLlmAgent("slow", "fake", "")
+	agent.llm = newFakeLLM("too late")
+	
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	
+	session := sessions.NewSession("app", "user", "session", nil)
+	eventChan, err := agent.RunAsync(ctx, &InvocationContext{Session: *session})
+	if err != nil {
+		t.Fatalf("RunAsync should not return error: %v", err)
+	}
+	for event := range eventChan {
+		t.Errorf("Expected no events past the deadline, got %+v", event)
+	}
+}
+
+// scriptedLLM is a test model that answers successive requests with the
+// given contents, repeating the last one
+type scriptedLLM struct {
+	*models.BaseLLM
+	responses []*events.Content
+	requests  []*models.LLMRequest
+}
+
+func newScriptedLLM(responses ...*events.Content) *scriptedLLM {
+	return &scriptedLLM{BaseLLM: models.NewBaseLLM("scripted"), responses: responses}
+}
+
+func (s *scriptedLLM) Connect(ctx context.Context, request *models.LLMRequest) (models.LLMConnection, error) {
+	return nil, errors.New("live connections are not supported")
+}
+
+func (s *scriptedLLM) GenerateContentAsync(ctx context.Context, request *models.LLMRequest) (<-chan *events.Event, error) {
+	index := len(s.requests)
+	if index >= len(s.responses) {
+		index = len(s.responses) - 1
+	}
+	s.requests = append(s.requests, request)
+	
+	eventChan := make(chan *events.Event, 1)
+	event := events.NewEvent()
+	event.Content = s.responses[index]
+	event.IsFinalResponse = len(event.GetFunctionCalls()) == 0
+	eventChan <- event
+	close(eventChan)
+	return eventChan, nil
+}
+
+func (s *scriptedLLM) SupportedModels() []string {
+	return []string{"scripted"}
+}
+
+func functionCallContent(id, name string) *events.Content {
+	return &events.Content{Role: "model", Parts: []events.Part{{FunctionCall: &events.FunctionCall{ID: id, Name: name}}}}
+}
+
+func textContent(text string) *events.Content {
+	return &events.Content{Role: "model", Parts: []events.Part{{Text: text}}}
+}
+
+// pendingTool is a long-running test tool that only acknowledges its calls
+type pendingTool struct {
+	*tools.BaseTool
+}
+
+func (p *pendingTool) RunAsync(ctx context.Context, args map[string]interface{}, toolCtx *tools.ToolContext) (interface{}, error) {
+	return map[string]interface{}{"status": "pending"}, nil
+}
+
+func TestLlmAgentToolCalls(t *testing.T) {
+	tool := &countingTool{}
+	llm := newScriptedLLM(functionCallContent("call-1", "counting_tool"), textContent("counted"))
+	agent := NewLlmAgent("counter", "scripted", "").AddTool(tool)
+	agent.llm = llm
+	
+	session := sessions.NewSession("app", "user", "session", nil)
+	eventChan, err := agent.RunAsync(context.Background(), &InvocationContext{Session: *session})
+	if err != nil {
+		t.Fatalf("RunAsync should not return error: %v", err)
+	}
+	var received []*events.Event
+	for event := range eventChan {
+		received = append(received, event)
+	}
+	
+	if len(received) != 3 {
+		t.Fatalf("Expected call, response and answer events, got %d events", len(received))
+	}
+	responses := received[1].GetFunctionResponses()
+	if len(responses) != 1 || responses[0].ID != "call-1" || responses[0].Response["result"] != 1 {
+		t.Errorf("Expected a response to call-1 with result 1, got %+v", responses)
+	}
+	if received[2].Content.Parts[0].Text != "counted" || received[2].Author != "counter" {
+		t.Errorf("Expected final answer 'counted' by counter, got %+v", received[2])
+	}
+	
+	// The second model call sees the call and its response
+	if len(llm.requests) != 2 {
+		t.Fatalf("Expected 2 model calls, got %d", len(llm.requests))
+	}
+	contents := llm.requests[1].Contents
+	if len(contents) < 2 || contents[len(contents)-1].Parts[0].FunctionResponse == nil {
+		t.Errorf("Expected the second request to end with the function response, got %+v", contents)
+	}
+}
+
+func TestLlmAgentExitLoopTool(t *testing.T) {
+	// The model asks for changes once, then calls exit_loop
+	llm := newScriptedLLM(textContent("needs work"), functionCallContent("call-1", "exit_loop"))
+	critic := NewLlmAgent("critic", "scripted", "Call exit_loop when the draft is good").AddTool(tools.NewExitLoopTool())
+	critic.llm = llm
+	loopAgent := NewLoopAgent("refine", []Agent{critic}, 5)
+	
+	session := sessions.NewSession("app", "user", "session", nil)
+	eventChan, err := loopAgent.RunAsync(context.Background(), &InvocationContext{Session: *session})
+	if err != nil {
+		t.Fatalf("RunAsync should not return error: %v", err)
+	}
+	var received []*events.Event
+	for event := range eventChan {
+		received = append(received, event)
+	}
+	
+	if len(received) != 3 {
+		t.Fatalf("Expected the first answer, the exit_loop call and its response, got %d events", len(received))
+	}
+	response := received[2]
+	if responses := response.GetFunctionResponses(); len(responses) != 1 || responses[0].Name != "exit_loop" {
+		t.Errorf("Expected the exit_loop response last, got %+v", response)
+	}
+	if !response.Actions.Escalate || !response.Actions.SkipSummarization {
+		t.Errorf("Expected the response to escalate without summarization, got %+v", response.Actions)
+	}
+	
+	// The agent stops at the response instead of calling the model again,
+	// and the loop stops after its second iteration
+	if len(llm.requests) != 2 {
+		t.Errorf("Expected 2 model calls, got %d", len(llm.requests))
+	}
+	if iteration, _ := session.State.Get("refine_iteration"); iteration != 2 {
+		t.Errorf("Expected the loop to stop at iteration 2, got %v", iteration)
+	}
+}
+
+func TestLlmAgentLongRunningTool(t *testing.T) {
+	approval := &pendingTool{BaseTool: tools.NewBaseTool("request_approval", "Requests approval", true)}
+	llm := newScriptedLLM(functionCallContent("call-1", "request_approval"), textContent("approved"))
+	agent := NewLlmAgent("approver", "scripted", "").AddTool(approval)
+	agent.llm = llm
+	next := newEmittingAgent("next", "should wait")
+	
+	session := sessions.NewSession("app", "user", "session", nil)
+	eventChan, err := NewSequentialAgent("workflow", []Agent{agent, next}).RunAsync(context.Background(), &InvocationContext{Session: *session})
+	if err != nil {
+		t.Fatalf("RunAsync should not return error: %v", err)
+	}
+	var received []*events.Event
+	for event := range eventChan {
+		received = append(received, event)
+	}
+	
+	if len(received) != 2 {
+		t.Fatalf("Expected call and pending response events only, got %d events", len(received))
+	}
+	if ids := received[0].LongRunningToolIDs; len(ids) != 1 || ids[0] != "call-1" {
+		t.Errorf("Expected long-running tool IDs [call-1], got %v", ids)
+	}
+	if status := received[1].GetFunctionResponses()[0].Response["status"]; status != "pending" {
+		t.Errorf("Expected pending initial response, got %v", status)
+	}
+	if len(llm.requests) != 1 {
+		t.Errorf("Expected the model not to be called again before the result arrives, got %d calls", len(llm.requests))
+	}
+}
+
+// transferTool is a test tool that records the arguments of its calls
+type transferTool struct {
+	*tools.BaseTool
+	amounts []interface{}
+}
+
+func (tt *transferTool) RunAsync(ctx context.Context, args map[string]interface{}, toolCtx *tools.ToolContext) (interface{}, error) {
+	tt.amounts = append(tt.amounts, args["amount"])
+	return map[string]interface{}{"status": "sent"}, nil
+}
+
+func runAndCollect(t *testing.T, agent Agent, invocationCtx *InvocationContext) []*events.Event {
+	t.Helper()
+	eventChan, err := agent.RunAsync(context.Background(), invocationCtx)
+	if err != nil {
+		t.Fatalf("RunAsync should not return error: %v", err)
+	}
+	var received []*events.Event
+	for event := range eventChan {
+		received = append(received, event)
+	}
+	return received
+}
+
+func TestLlmAgentToolConfirmation(t *testing.T) {
+	transfer := &transferTool{BaseTool: tools.NewBaseTool("send_money", "Sends money", false)}
+	transfer.SetConfirmationPredicate(func(args map[string]interface{}) bool {
+		amount, _ := args["amount"].(int)
+		return amount > 100
+	})
+	
+	call := functionCallContent("call-1", "send_money")
+	call.Parts[0].FunctionCall.Args = map[string]interface{}{"amount": 500}
+	
+	for _, tc := range []struct {
+		confirmation    *tools.ToolConfirmation
+		expectedAmounts []interface{}
+		expectError     bool
+	}{
+		{&tools.ToolConfirmation{Decision: tools.ConfirmationApprove}, []interface{}{500}, false},
+		{&tools.ToolConfirmation{Decision: tools.ConfirmationEdit, Args: map[string]interface{}{"amount": 50}}, []interface{}{50}, false},
+		{&tools.ToolConfirmation{Decision: tools.ConfirmationReject, Reason: "too much"}, nil, true},
+	} {
+		transfer.amounts = nil
+		llm := newScriptedLLM(call, textContent("finished"))
+		agent := NewLlmAgent("banker", "scripted", "").AddTool(transfer)
+		agent.llm = llm
+		
+		// The call pauses on a confirmation request instead of running
+		session := sessions.NewSession("app", "user", "session", nil)
+		received := runAndCollect(t, agent, &InvocationContext{Session: *session})
+		if len(received) != 2 {
+			t.Fatalf("Expected call and confirmation request events, got %d events", len(received))
+		}
+		requests := received[1].GetFunctionCalls()
+		if len(requests) != 1 || requests[0].Name != tools.RequestConfirmationFunctionName {
+			t.Fatalf("Expected a confirmation request, got %+v", requests)
+		}
+		if len(received[1].LongRunningToolIDs) != 1 || received[1].LongRunningToolIDs[0] != requests[0].ID {
+			t.Errorf("Expected the confirmation request to be pending, got %v", received[1].LongRunningToolIDs)
+		}
+		if len(transfer.amounts) != 0 {
+			t.Fatalf("Expected the tool not to run before confirmation, got %v", transfer.amounts)
+		}
+		
+		// The answer resumes the agent
+		answer := &events.Content{Role: "user", Parts: []events.Part{{FunctionResponse: tools.NewConfirmationResponse(requests[0].ID, tc.confirmation)}}}
+		answerEvent := events.NewEvent()
+		answerEvent.Author = "user"
+		answerEvent.Content = answer
+		for _, event := range append(received, answerEvent) {
+			session.AddEvent(event)
+		}
+		received = runAndCollect(t, agent, &InvocationContext{Session: *session, UserContent: answer})
+		
+		if len(transfer.amounts) != len(tc.expectedAmounts) || (len(tc.expectedAmounts) > 0 && transfer.amounts[0] != tc.expectedAmounts[0]) {
+			t.Errorf("Decision %s: expected tool calls with amounts %v, got %v", tc.confirmation.Decision, tc.expectedAmounts, transfer.amounts)
+		}
+		if len(received) != 2 {
+			t.Fatalf("Decision %s: expected tool response and answer events, got %d events", tc.confirmation.Decision, len(received))
+		}
+		response := received[0].GetFunctionResponses()[0]
+		if response.ID != "call-1" {
+			t.Errorf("Decision %s: expected response to call-1, got %s", tc.confirmation.Decision, response.ID)
+		}
+		if _, hasError := response.Response["error"]; hasError != tc.expectError {
+			t.Errorf("Decision %s: expected error in response to be %v, got %+v", tc.confirmation.Decision, tc.expectError, response.Response)
+		}
+		
+		// The model sees the original call and its response, but not the
+		// confirmation exchange
+		for _, content := range llm.requests[1].Contents {
+			for _, part := range content.Parts {
+				if (part.FunctionCall != nil && part.FunctionCall.Name == tools.RequestConfirmationFunctionName) ||
+					(part.FunctionResponse != nil && part.FunctionResponse.Name == tools.RequestConfirmationFunctionName) {
+					t.Errorf("Decision %s: expected confirmation parts to be hidden from the model", tc.confirmation.Decision)
+				}
+			}
+		}
+	}
+}
+
+func TestLlmAgentToolConfirmationReplay(t *testing.T) {
+	transfer := &transferTool{BaseTool: tools.NewBaseTool("send_money", "Sends money", false)}
+	transfer.SetRequireConfirmation(true)
+	call := functionCallContent("call-1", "send_money")
+	call.Parts[0].FunctionCall.Args = map[string]interface{}{"amount": 500}
+	agent := NewLlmAgent("banker", "scripted", "").AddTool(transfer)
+	agent.llm = newScriptedLLM(call, textContent("sent"), textContent("sent again"))
+	
+	session := sessions.NewSession("app", "user", "session", nil)
+	record := func(received []*events.Event, answer *events.Content) {
+		for _, event := range received {
+			session.AddEvent(event)
+		}
+		answerEvent := events.NewEvent()
+		answerEvent.Author = "user"
+		answerEvent.Content = answer
+		session.AddEvent(answerEvent)
+	}
+	received := runAndCollect(t, agent, &InvocationContext{Session: *session})
+	request := received[len(received)-1].GetFunctionCalls()[0]
+	approve := func() events.Part {
+		return events.Part{FunctionResponse: tools.NewConfirmationResponse(request.ID, &tools.ToolConfirmation{Decision: tools.ConfirmationApprove})}
+	}
+	
+	answer := &events.Content{Role: "user", Parts: []events.Part{approve()}}
+	record(received, answer)
+	received = runAndCollect(t, agent, &InvocationContext{Session: *session, UserContent: answer})
+	if len(transfer.amounts) != 1 {
+		t.Fatalf("Expected the approved call to run once, got %v", transfer.amounts)
+	}
+	
+	// Replaying the approval, alone or twice in one message, runs nothing
+	for _, replay := range []*events.Content{
+		{Role: "user", Parts: []events.Part{approve()}},
+		{Role: "user", Parts: []events.Part{approve(), approve()}},
+	} {
+		record(received, replay)
+		received = runAndCollect(t, agent, &InvocationContext{Session: *session, UserContent: replay})
+		if len(transfer.amounts) != 1 {
+			t.Errorf("Expected a replayed approval not to run the call again, got %v", transfer.amounts)
+		}
+	}
+	
+	// An answer repeating an approval is rejected as a whole
+	transfer.amounts = nil
+	agent.llm = newScriptedLLM(call, textContent("sent"))
+	session = sessions.NewSession("app", "user", "other", nil)
+	received = runAndCollect(t, agent, &InvocationContext{Session: *session})
+	request = received[len(received)-1].GetFunctionCalls()[0]
+	answer = &events.Content{Role: "user", Parts: []events.Part{approve(), approve()}}
+	record(received, answer)
+	runAndCollect(t, agent, &InvocationContext{Session: *session, UserContent: answer})
+	if len(transfer.amounts) != 0 {
+		t.Errorf("Expected a repeated approval to be rejected, got %v", transfer.amounts)
+	}
+}
+
+func TestCustomAgent(t *testing.T) {
+	var calls []string
+	worker := NewCustomAgent("worker", "Works", func(ctx context.Context, invocationCtx *InvocationContext, emit EmitFunc) error {
+		event := events.NewEvent()
+		event.Content = textContent("working")
+		return emit(event)
+	})
+	agent := NewCustomAgent("orchestrator", "Orchestrates", nil)
+	agent.AddSubAgent(worker)
+	agent.Run = func(ctx context.Context, invocationCtx *InvocationContext, emit EmitFunc) error {
+		calls = append(calls, "run")
+		if err := emit(events.NewEvent()); err != nil {
+			return err
+		}
+		return agent.RunSubAgent(ctx, invocationCtx, worker, emit)
+	}
+	agent.BeforeAgentCallback = func(ctx *CallbackContext) error {
+		calls = append(calls, "before")
+		return nil
+	}
+	agent.AfterAgentCallback = func(ctx *CallbackContext) error {
+		calls = append(calls, "after")
+		return nil
+	}
+	
+	session := sessions.NewSession("app", "user", "session", nil)
+	received := runAndCollect(t, agent, &InvocationContext{InvocationID: "inv-1", Branch: "main", Session: *session})
+	
+	if strings.Join(calls, ",") != "before,run,after" {
+		t.Errorf("Expected callbacks around the run, got %v", calls)
+	}
+	if len(received) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(received))
+	}
+	for i, expectedAuthor := range []string{"orchestrator", "worker"} {
+		if received[i].Author != expectedAuthor {
+			t.Errorf("Expected event %d author %s, got %s", i, expectedAuthor, received[i].Author)
+		}
+		if received[i].InvocationID != "inv-1" {
+			t.Errorf("Expected event %d invocation ID inv-1, got %s", i, received[i].InvocationID)
+		}
+		if received[i].Branch != "main" {
+			t.Errorf("Expected event %d branch main, got %s", i, received[i].Branch)
+		}
+	}
+}
+
+func TestCustomAgentError(t *testing.T) {
+	afterCalled := false
+	agent := NewCustomAgent("failing", "Fails", func(ctx context.Context, invocationCtx *InvocationContext, emit EmitFunc) error {
+		return errors.New("inventory unavailable")
+	})
+	agent.AfterAgentCallback = func(ctx *CallbackContext) error {
+		afterCalled = true
+		return nil
+	}
+	
+	session := sessions.NewSession("app", "user", "session", nil)
+	received := runAndCollect(t, agent, &InvocationContext{Session: *session})
+	
+	if len(received) != 1 {
+		t.Fatalf("Expected an error event, got %d events", len(received))
+	}
+	if received[0].ErrorCode != CustomAgentErrorCode || received[0].ErrorMessage != "inventory unavailable" {
+		t.Errorf("Expected error event, got code %q and message %q", received[0].ErrorCode, received[0].ErrorMessage)
+	}
+	if received[0].Author != "failing" {
+		t.Errorf("Expected error event author failing, got %s", received[0].Author)
+	}
+	if afterCalled {
+		t.Error("Expected after agent callback to be skipped on error")
+	}
+}
+
+func TestCustomAgentCallbackError(t *testing.T) {
+	ran := false
+	agent := NewCustomAgent("guarded", "Guarded", func(ctx context.Context, invocationCtx *InvocationContext, emit EmitFunc) error {
+		ran = true
+		event := events.NewEvent()
+		event.Content = textContent("working")
+		return emit(event)
+	})
+	session := sessions.NewSession("app", "user", "session", nil)
+	
+	// A failing before callback skips the function
+	agent.BeforeAgentCallback = func(ctx *CallbackContext) error {
+		return errors.New("not allowed")
+	}
+	received := runAndCollect(t, agent, &InvocationContext{Session: *session})
+	if len(received) != 1 || received[0].ErrorCode != CustomAgentErrorCode || received[0].ErrorMessage != "not allowed" || received[0].Author != "guarded" {
+		t.Fatalf("Expected an error event for the before callback, got %+v", received)
+	}
+	if ran {
+		t.Error("Expected the function to be skipped after the before callback failed")
+	}
+	
+	// A failing after callback is reported after the function's events
+	agent.BeforeAgentCallback = nil
+	agent.AfterAgentCallback = func(ctx *CallbackContext) error {
+		return errors.New("audit failed")
+	}
+	received = runAndCollect(t, agent, &InvocationContext{Session: *session})
+	if len(received) != 2 || received[1].ErrorCode != CustomAgentErrorCode || received[1].ErrorMessage != "audit failed" {
+		t.Errorf("Expected the function's event and an error event for the after callback, got %+v", received)
+	}
+}
+
+func TestCustomAgentCancellation(t *testing.T) {
+	emitErr := make(chan error, 1)
+	agent := NewCustomAgent("streamer", "Streams", func(ctx context.Context, invocationCtx *InvocationContext, emit EmitFunc) error {
+		for {
+			if err := emit(events.NewEvent()); err != nil {
+				emitErr <- err
+				return err
+			}
+		}
+	})
+	
+	ctx, cancel := context.WithCancel(context.Background())
+	session := sessions.NewSession("app", "user", "session", nil)
+	eventChan, err := agent.RunAsync(ctx, &InvocationContext{Session: *session})
+	if err != nil {
+		t.Fatalf("RunAsync should not return error: %v", err)
+	}
+	<-eventChan
+	cancel()
+	
+	select {
+	case err := <-emitErr:
+		if err != context.Canceled {
+			t.Errorf("Expected emit to fail with context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected emit to fail once the run is canceled")
+	}
+	for event := range eventChan {
+		if event.ErrorCode != "" {
+			t.Errorf("Expected no error event after cancellation, got %s", event.ErrorCode)
+		}
+	}
+}
+
+func TestLlmAgentRunLive(t *testing.T) {
+	tool := &countingTool{}
+	llm := models.NewFakeLLM("fake-live", func(turn *events.Content) []*events.Content {
+		if turn.Parts[0].FunctionResponse != nil {
+			return []*events.Content{textContent("Counted "), textContent("once")}
+		}
+		return []*events.Content{functionCallContent("call-1", "counting_tool")}
+	})
+	agent := NewLlmAgent("live_counter", "fake-live", "")
+	agent.AddTool(tool)
+	agent.llm = llm
+	
+	// Live mode needs a queue for the caller's input
+	session := sessions.NewSession("app", "user", "session", nil)
+	if _, err := agent.RunLive(context.Background(), &InvocationContext{Session: *session}); err == nil {
+		t.Error("Expected RunLive without a live request queue to fail")
+	}
+	
+	queue := NewLiveRequestQueue()
+	eventChan, err := agent.RunLive(context.Background(), &InvocationContext{InvocationID: "inv-1", Session: *session, LiveRequestQueue: queue})
+	if err != nil {
+		t.Fatalf("RunLive should not return error: %v", err)
+	}
+	queue.SendContent(&events.Content{Role: "user", Parts: []events.Part{{Text: "Count please"}}})
+	
+	var received []*events.Event
+	turns := 0
+	for event := range eventChan {
+		received = append(received, event)
+		if event.TurnComplete {
+			turns++
+			if turns == 2 {
+				queue.Close()
+			}
+		}
+	}
+	
+	if len(received) != 7 {
+		t.Fatalf("Expected user, call, response, 2 partial, full and turn complete events, got %d events", len(received))
+	}
+	if received[0].Author != "user" || received[0].Content.Parts[0].Text != "Count please" {
+		t.Errorf("Expected the user turn first, got %+v", received[0])
+	}
+	if calls := received[1].GetFunctionCalls(); len(calls) != 1 || calls[0].Name != "counting_tool" {
+		t.Errorf("Expected the tool call, got %+v", received[1])
+	}
+	if responses := received[2].GetFunctionResponses(); len(responses) != 1 || responses[0].Response["result"] != 1 {
+		t.Errorf("Expected the tool response, got %+v", received[2])
+	}
+	if !received[3].Partial || !received[4].Partial {
+		t.Error("Expected the answer to be streamed in partial events")
+	}
+	if received[5].Partial || received[5].Content.Parts[0].Text != "Counted once" {
+		t.Errorf("Expected the full answer, got %+v", received[5].Content)
+	}
+	for _, event := range received[1:] {
+		if event.Author != "live_counter" || event.InvocationID != "inv-1" {
+			t.Errorf("Expected model events by live_counter in inv-1, got author %s and invocation %s", event.Author, event.InvocationID)
+		}
+	}
+}
+
+func TestLlmAgentRunLiveErrors(t *testing.T) {
+	transfer := &transferTool{BaseTool: tools.NewBaseTool("send_money", "Sends money", false)}
+	transfer.SetRequireConfirmation(true)
+	llm := models.NewFakeLLM("fake-live", func(turn *events.Content) []*events.Content {
+		if turn.Parts[0].Text == "Send 500" {
+			return []*events.Content{functionCallContent("call-1", "send_money")}
+		}
+		return []*events.Content{functionCallContent("", "counting_tool")}
+	})
+	agent := NewLlmAgent("live_bank", "fake-live", "")
+	agent.AddTool(transfer)
+	agent.AddTool(&countingTool{})
+	agent.llm = llm
+	
+	for _, tc := range []struct {
+		message   string
+		runConfig *RunConfig
+		responses int
+		code      string
+		reason    string
+	}{
+		{"Send 500", nil, 0, LiveErrorCode, "requires confirmation"},
+		{"Count forever", &RunConfig{MaxToolCalls: 1}, 1, ToolCallLimitErrorCode, "tool calls exceeded"},
+	} {
+		session := sessions.NewSession("app", "user", "session", nil)
+		queue := NewLiveRequestQueue()
+		eventChan, err := agent.RunLive(context.Background(), &InvocationContext{InvocationID: "inv-1", Session: *session, LiveRequestQueue: queue, RunConfig: tc.runConfig})
+		if err != nil {
+			t.Fatalf("RunLive should not return error: %v", err)
+		}
+		queue.SendContent(&events.Content{Role: "user", Parts: []events.Part{{Text: tc.message}}})
+		
+		// The session ends without the caller closing the queue
+		var last *events.Event
+		responses := 0
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for event := range eventChan {
+				if len(event.GetFunctionResponses()) > 0 {
+					responses++
+				}
+				last = event
+			}
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("Expected the live session to end after the error")
+		}
+		
+		if responses != tc.responses {
+			t.Errorf("Expected %d tool responses before the error, got %d", tc.responses, responses)
+		}
+		if last == nil || last.ErrorCode != tc.code || !strings.Contains(last.ErrorMessage, tc.reason) || last.InvocationID != "inv-1" {
+			t.Errorf("Expected a %s error event about %q, got %+v", tc.code, tc.reason, last)
+		}
+	}
+}
+
+func TestCallbackStateDelta(t *testing.T) {
+	agent := NewCustomAgent("greeter", "Greets", func(ctx context.Context, invocationCtx *InvocationContext, emit EmitFunc) error {
+		return nil
+	})
+	agent.BeforeAgentCallback = func(ctx *CallbackContext) error {
+		ctx.State().Set("user:greeted", true)
+		return nil
+	}
+	
+	session := sessions.NewSession("app", "user", "session", nil)
+	received := runAndCollect(t, agent, &InvocationContext{Session: *session})
+	
+	if len(received) != 1 {
+		t.Fatalf("Expected an event carrying the state change, got %d events", len(received))
+	}
+	if received[0].Actions.StateDelta["user:greeted"] != true {
+		t.Errorf("Expected user:greeted in the state delta, got %v", received[0].Actions.StateDelta)
+	}
+	if session.State.HasDelta() {
+		t.Error("Expected the pending delta to be attached to the event")
+	}
+}
+
+func TestCallbackStateDeltaParallelBranches(t *testing.T) {
+	// Both branches write before either callback returns
+	var written sync.WaitGroup
+	written.Add(2)
+	branch := func(name string) Agent {
+		agent := NewCustomAgent(name, "", func(ctx context.Context, invocationCtx *InvocationContext, emit EmitFunc) error {
+			return nil
+		})
+		agent.BeforeAgentCallback = func(ctx *CallbackContext) error {
+			ctx.State().Set(name+"_started", true)
+			written.Done()
+			written.Wait()
+			return nil
+		}
+		return agent
+	}
+	parallelAgent := NewParallelAgent("parallel", []Agent{branch("first"), branch("second")})
+	
+	session := sessions.NewSession("app", "user", "session", nil)
+	received := runAndCollect(t, parallelAgent, &InvocationContext{Session: *session})
+	
+	if len(received) != 2 {
+		t.Fatalf("Expected one state event per branch, got %d events", len(received))
+	}
+	for _, event := range received {
+		key := event.Author + "_started"
+		if len(event.Actions.StateDelta) != 1 || event.Actions.StateDelta[key] != true {
+			t.Errorf("Expected %s's event to carry only %s, got %v", event.Author, key, event.Actions.StateDelta)
+		}
+	}
+	if value, _ := session.State.Get("second_started"); value != true {
+		t.Errorf("Expected the branches to share the state's values, got %v", value)
+	}
+}
+
+// conversationSession returns a session with the given number of alternating
+// user and model turns, one second apart
+func conversationSession(turns int) *sessions.Session {
+	session := sessions.NewSession("app", "user", "session", nil)
+	start := time.Now().Add(-time.Hour)
+	for i := 0; i < turns; i++ {
+		event := events.NewEvent()
+		event.Timestamp = start.Add(time.Duration(i) * time.Second)
+		event.Author = "user"
+		role := "user"
+		if i%2 == 1 {
+			event.Author = "assistant"
+			role = "model"
+		}
+		event.Content = &events.Content{Role: role, Parts: []events.Part{{Text: fmt.Sprintf("turn %d", i)}}}
+		session.AddEvent(event)
+	}
+	return session
+}
+
+func contentTexts(contents []*events.Content) []string {
+	var texts []string
+	for _, content := range contents {
+		texts = append(texts, content.Parts[0].Text)
+	}
+	return texts
+}
+
+func TestLlmAgentCompactionSlidingWindow(t *testing.T) {
+	agent := NewLlmAgent("assistant", "fake", "Be helpful")
+	agent.SetCompaction(&CompactionConfig{Strategy: CompactionSlidingWindow, MaxEvents: 6, KeepRecentEvents: 3})
+	
+	session := conversationSession(5)
+	contents := agent.buildContents(&InvocationContext{Session: *session}, nil)
+	if len(contents) != 6 {
+		t.Fatalf("Expected the full history within budget, got %v", contentTexts(contents))
+	}
+	
+	session = conversationSession(8)
+	contents = agent.buildContents(&InvocationContext{Session: *session}, nil)
+	expected := []string{"Be helpful", "turn 5", "turn 6", "turn 7"}
+	texts := contentTexts(contents)
+	if strings.Join(texts, "|") != strings.Join(expected, "|") {
+		t.Errorf("Expected contents %v, got %v", expected, texts)
+	}
+	
+	// A window never starts with a tool result whose call was dropped
+	response := events.NewEvent()
+	response.Timestamp = time.Now()
+	response.Content = &events.Content{Role: "user", Parts: []events.Part{{FunctionResponse: &events.FunctionResponse{ID: "call-1", Name: "lookup", Response: map[string]interface{}{"result": "ok"}}}}}
+	answer := events.NewEvent()
+	answer.Timestamp = time.Now()
+	answer.Content = textContent("done")
+	thanks := events.NewEvent()
+	thanks.Timestamp = time.Now()
+	thanks.Content = textContent("thanks")
+	session.Events = append(session.Events[:5], response, answer, thanks)
+	contents = agent.buildContents(&InvocationContext{Session: *session}, nil)
+	if len(contents) != 3 || contents[1].Parts[0].Text != "done" || contents[2].Parts[0].Text != "thanks" {
+		t.Errorf("Expected the orphaned tool result to be dropped, got %+v", contents)
+	}
+}
+
+func TestLlmAgentCompactionTruncateToolOutputs(t *testing.T) {
+	agent := NewLlmAgent("assistant", "fake", "")
+	agent.SetCompaction(&CompactionConfig{Strategy: CompactionTruncateToolOutputs, MaxTokens: 100, KeepRecentEvents: 2, MaxToolOutputChars: 20})
+	
+	large := map[string]interface{}{"result": strings.Repeat("x", 1000)}
+	session := sessions.NewSession("app", "user", "session", nil)
+	for i := 0; i < 4; i++ {
+		event := events.NewEvent()
+		event.Content = &events.Content{Role: "user", Parts: []events.Part{{FunctionResponse: &events.FunctionResponse{ID: fmt.Sprintf("call-%d", i), Name: "fetch", Response: large}}}}
+		session.AddEvent(event)
+	}
+	
+	contents := agent.buildContents(&InvocationContext{Session: *session}, nil)
+	if len(contents) != 4 {
+		t.Fatalf("Expected 4 contents, got %d", len(contents))
+	}
+	for i, content := range contents {
+		response := content.Parts[0].FunctionResponse
+		truncated, ok := response.Response["truncated_result"].(string)
+		if i < 2 && (!ok || !strings.HasPrefix(truncated, `{"result":"xxxxxxxxx`) || response.ID != fmt.Sprintf("call-%d", i)) {
+			t.Errorf("Expected older tool output %d to be truncated, got %+v", i, response)
+		}
+		if i >= 2 && ok {
+			t.Errorf("Expected recent tool output %d to be kept, got %+v", i, response)
+		}
+	}
+	if _, ok := session.Events[0].Content.Parts[0].FunctionResponse.Response["result"]; !ok {
+		t.Error("Expected the session's events to be left unchanged")
+	}
+}
+
+func TestLlmAgentCompactionTruncateMultibyte(t *testing.T) {
+	response := map[string]interface{}{"result": strings.Repeat("é", 100)}
+	content := &events.Content{Role: "user", Parts: []events.Part{{FunctionResponse: &events.FunctionResponse{Name: "fetch", Response: response}}}}
+	
+	// The limit falls in the middle of a two-byte character
+	truncated := truncateToolOutputs(content, 14).Parts[0].FunctionResponse.Response["truncated_result"].(string)
+	if !utf8.ValidString(truncated) {
+		t.Errorf("Expected truncated output to be valid UTF-8, got %q", truncated)
+	}
+	if !strings.HasPrefix(truncated, `{"result":"é...`) {
+		t.Errorf("Expected output cut before the split character, got %q", truncated)
+	}
+}
+
+func TestLlmAgentCompactionInvalidStrategy(t *testing.T) {
+	for _, config := range []*CompactionConfig{{}, {Strategy: "compress"}, {Strategy: CompactionSlidingWindow, MaxEvents: -1}} {
+		agent := NewLlmAgent("assistant", "fake", "").SetCompaction(config)
+		session := sessions.NewSession("app", "user", "session", nil)
+		if _, err := agent.RunAsync(context.Background(), &InvocationContext{Session: *session}); err == nil {
+			t.Errorf("Expected RunAsync to reject compaction config %+v", config)
+		}
+	}
+}
+
+func TestLlmAgentCompactionSummarize(t *testing.T) {
+	summarizer := newFakeLLM("the user counted turns")
+	llm := newFakeLLM("answer")
+	agent := NewLlmAgent("assistant", "fake", "")
+	agent.llm = llm
+	agent.SetCompaction(&CompactionConfig{Strategy: CompactionSummarize, MaxEvents: 5, KeepRecentEvents: 2, Model: summarizer})
+	
+	session := conversationSession(8)
+	received := runAndCollect(t, agent, &InvocationContext{Session: *session, InvocationID: "inv-1"})
+	if len(received) != 2 {
+		t.Fatalf("Expected compaction and answer events, got %d events", len(received))
+	}
+	compaction := received[0].Actions.Compaction
+	if compaction == nil {
+		t.Fatalf("Expected a compaction event first, got %+v", received[0])
+	}
+	if received[0].Author != "assistant" || received[0].InvocationID != "inv-1" {
+		t.Errorf("Expected the compaction event to be attributed to the agent, got %+v", received[0])
+	}
+	if !compaction.StartTimestamp.Equal(session.Events[0].Timestamp) || !compaction.EndTimestamp.Equal(session.Events[5].Timestamp) {
+		t.Errorf("Expected the compaction to cover turns 0 to 5, got %v to %v", compaction.StartTimestamp, compaction.EndTimestamp)
+	}
+	if text := compaction.CompactedContent.Parts[0].Text; !strings.Contains(text, "the user counted turns") {
+		t.Errorf("Expected the summary in the compacted content, got %q", text)
+	}
+	
+	// The summarizer sees the older turns only
+	if len(summarizer.requests) != 1 {
+		t.Fatalf("Expected 1 summarization call, got %d", len(summarizer.requests))
+	}
+	transcript := summarizer.requests[0].Contents[1].Parts[0].Text
+	if !strings.Contains(transcript, "user: turn 0") || !strings.Contains(transcript, "assistant: turn 5") || strings.Contains(transcript, "turn 6") {
+		t.Errorf("Expected a transcript of turns 0 to 5, got %q", transcript)
+	}
+	
+	// The model gets the summary and the recent turns
+	texts := contentTexts(llm.requests[0].Contents)
+	if len(texts) != 3 || !strings.HasPrefix(texts[0], compactionSummaryPrefix) || texts[1] != "turn 6" || texts[2] != "turn 7" {
+		t.Errorf("Expected the summary and turns 6 and 7, got %v", texts)
+	}
+	
+	// Later runs reuse the stored summary until the budget is exceeded again
+	for _, event := range received {
+		session.AddEvent(event)
+	}
+	runAndCollect(t, agent, &InvocationContext{Session: *session})
+	if len(summarizer.requests) != 1 {
+		t.Errorf("Expected the stored summary to be reused, got %d summarization calls", len(summarizer.requests))
+	}
+	texts = contentTexts(llm.requests[1].Contents)
+	if len(texts) != 4 || !strings.HasPrefix(texts[0], compactionSummaryPrefix) || texts[3] != "answer" {
+		t.Errorf("Expected the summary, turns 6 and 7 and the answer, got %v", texts)
+	}
+}
+
+func TestLlmAgentCompactionSummarizeFailure(t *testing.T) {
+	// A summary that cannot be written falls back to the sliding window
+	llm := newFakeLLM("answer")
+	agent := NewLlmAgent("assistant", "fake", "")
+	agent.llm = llm
+	agent.SetCompaction(&CompactionConfig{Strategy: CompactionSummarize, MaxEvents: 5, KeepRecentEvents: 2, Model: newFakeLLM("")})
+	
+	received := runAndCollect(t, agent, &InvocationContext{Session: *conversationSession(8)})
+	if len(received) != 1 || received[0].Actions.Compaction != nil {
+		t.Fatalf("Expected the answer only, got %d events", len(received))
+	}
+	if texts := contentTexts(llm.requests[0].Contents); len(texts) != 2 || texts[0] != "turn 6" || texts[1] != "turn 7" {
+		t.Errorf("Expected the sliding window of turns 6 and 7, got %v", texts)
+	}
+	
+	// The summarization counts toward the model call budget
+	llm = newFakeLLM("answer")
+	summarizer := newFakeLLM("the user counted turns")
+	agent.llm = llm
+	agent.Compaction.Model = summarizer
+	received = runAndCollect(t, agent, &InvocationContext{Session: *conversationSession(8), RunConfig: &RunConfig{MaxLLMCalls: 1}})
+	if len(summarizer.requests) != 0 || len(llm.requests) != 0 {
+		t.Errorf("Expected the budget of 1 call to stop the run before summarizing, got %d summaries and %d calls", len(summarizer.requests), len(llm.requests))
+	}
+	if len(received) != 1 || received[0].ErrorCode != LLMCallLimitErrorCode {
+		t.Errorf("Expected only a limit error event, got %+v", received)
+	}
+}
+
+func TestCallbackContextSearchMemory(t *testing.T) {
+	memoryService := memory.NewInMemoryMemoryService()
+	remember := func(appName, userID, sessionID, text string) {
+		session := sessions.NewSession(appName, userID, sessionID, nil)
+		event := events.NewEvent()
+		event.Author = "user"
+		event.Content = &events.Content{Role: "user", Parts: []events.Part{{Text: text}}}
+		session.AddEvent(event)
+		if err := memoryService.AddSessionToMemory(context.Background(), session); err != nil {
+			t.Fatalf("AddSessionToMemory should not return error: %v", err)
+		}
+	}
+	remember("app", "user", "food", "I am allergic to peanuts")
+	remember("app", "other_user", "secret", "My peanuts allergy is private")
+	
+	// The search covers the memory of the session's app and user
+	worker := NewLlmAgent("worker", "fake", "")
+	worker.llm = newFakeLLM("done")
+	var response *memory.SearchMemoryResponse
+	var searchErr error
+	worker.BeforeAgentCallback = func(callbackCtx *CallbackContext) error {
+		response, searchErr = callbackCtx.SearchMemory(context.Background(), "Does the user eat peanuts?")
+		return nil
+	}
+	
+	session := sessions.NewSession("app", "user", "today", nil)
+	runAndCollect(t, worker, &InvocationContext{Session: *session, MemoryService: memoryService})
+	if searchErr != nil {
+		t.Fatalf("SearchMemory should not return error: %v", searchErr)
+	}
+	if response == nil || len(response.Results) != 1 || response.Results[0].Metadata["session_id"] != "food" {
+		t.Errorf("Expected the user's peanut memory, got %+v", response)
+	}
+	
+	runAndCollect(t, worker, &InvocationContext{Session: *session})
+	if searchErr == nil {
+		t.Error("Expected an error without a memory service")
+	}
+}
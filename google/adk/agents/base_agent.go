@@ -16,48 +16,50 @@ package agents
 
 import (
 	"context"
+	"errors"
 
+	"github.com/adrienveepee/adk-go/google/adk/agents/invocation"
 	"github.com/adrienveepee/adk-go/google/adk/events"
-	"github.com/adrienveepee/adk-go/google/adk/sessions"
+)
+
+// Error codes of the event an agent emits when its run exceeds a limit set
+// in the RunConfig
+const (
+	// LLMCallLimitErrorCode reports that RunConfig.MaxLLMCalls was exceeded
+	LLMCallLimitErrorCode = "LLM_CALL_LIMIT_EXCEEDED"
+	// ToolCallLimitErrorCode reports that RunConfig.MaxToolCalls was exceeded
+	ToolCallLimitErrorCode = "TOOL_CALL_LIMIT_EXCEEDED"
 )
 
 // InvocationContext provides the context for agent invocation
-type InvocationContext struct {
-	Session sessions.Session
-	// Add other context fields as needed
-}
+type InvocationContext = invocation.Context
+
+// RunConfig configures a single invocation
+type RunConfig = invocation.RunConfig
+
+// ReadonlyContext is a read-only view of an invocation
+type ReadonlyContext = invocation.ReadonlyContext
+
+// CallbackContext is a mutable view of an invocation for callbacks
+type CallbackContext = invocation.CallbackContext
 
 // Agent is the interface that all agents must implement
-type Agent interface {
-	// GetName returns the agent's name
-	GetName() string
-	
-	// GetDescription returns the agent's description
-	GetDescription() string
-	
-	// RunAsync executes the agent asynchronously and returns events
-	RunAsync(ctx context.Context, invocationCtx *InvocationContext) (<-chan *events.Event, error)
-	
-	// RunLive executes the agent in live mode (bidi-streaming)
-	RunLive(ctx context.Context, invocationCtx *InvocationContext) (<-chan *events.Event, error)
-	
-	// GetSubAgents returns the list of sub-agents
-	GetSubAgents() []Agent
-	
-	// FindAgent finds an agent by name in the hierarchy
-	FindAgent(name string) Agent
-	
-	// FindSubAgent finds a direct sub-agent by name
-	FindSubAgent(name string) Agent
-	
-	// GetParentAgent returns the parent agent
-	GetParentAgent() Agent
-	
-	// SetParentAgent sets the parent agent
-	SetParentAgent(parent Agent)
-	
-	// GetRootAgent returns the root agent in the hierarchy
-	GetRootAgent() Agent
+type Agent = invocation.Agent
+
+// LiveRequestQueue carries the caller's input to an agent in live mode
+type LiveRequestQueue = invocation.LiveRequestQueue
+
+// LiveRequest is one input sent to an agent in live mode
+type LiveRequest = invocation.LiveRequest
+
+// NewLiveRequestQueue creates an empty live request queue
+func NewLiveRequestQueue() *LiveRequestQueue {
+	return invocation.NewLiveRequestQueue()
+}
+
+// NewCallbackContext creates a callback context for an invocation
+func NewCallbackContext(invocationCtx *InvocationContext) *CallbackContext {
+	return invocation.NewCallbackContext(invocationCtx)
 }
 
 // BaseAgent provides the base implementation for all agents
@@ -68,8 +70,12 @@ type BaseAgent struct {
 	ParentAgent  Agent   `json:"-"`
 	
 	// Callbacks
-	BeforeAgentCallback func(ctx *InvocationContext) error `json:"-"`
-	AfterAgentCallback  func(ctx *InvocationContext) error `json:"-"`
+	BeforeAgentCallback func(ctx *CallbackContext) error `json:"-"`
+	AfterAgentCallback  func(ctx *CallbackContext) error `json:"-"`
+	
+	// self is the concrete agent embedding this BaseAgent, so that parent
+	// pointers and lookups refer to it rather than to the embedded struct
+	self Agent
 }
 
 // NewBaseAgent creates a new base agent
@@ -81,6 +87,20 @@ func NewBaseAgent(name, description string) *BaseAgent {
 	}
 }
 
+// agent returns the concrete agent embedding this BaseAgent
+func (a *BaseAgent) agent() Agent {
+	if a.self != nil {
+		return a.self
+	}
+	return a
+}
+
+// base returns the BaseAgent itself; embedding agents inherit it, which
+// identifies an agent regardless of the type it is viewed through
+func (a *BaseAgent) base() *BaseAgent {
+	return a
+}
+
 // GetName returns the agent's name
 func (a *BaseAgent) GetName() string {
 	return a.Name
@@ -99,13 +119,13 @@ func (a *BaseAgent) GetSubAgents() []Agent {
 // AddSubAgent adds a sub-agent
 func (a *BaseAgent) AddSubAgent(subAgent Agent) {
 	a.SubAgents = append(a.SubAgents, subAgent)
-	subAgent.SetParentAgent(a)
+	subAgent.SetParentAgent(a.agent())
 }
 
 // FindAgent finds an agent by name in the hierarchy
 func (a *BaseAgent) FindAgent(name string) Agent {
 	if a.Name == name {
-		return a
+		return a.agent()
 	}
 	
 	for _, subAgent := range a.SubAgents {
@@ -140,11 +160,61 @@ func (a *BaseAgent) SetParentAgent(parent Agent) {
 // GetRootAgent returns the root agent in the hierarchy
 func (a *BaseAgent) GetRootAgent() Agent {
 	if a.ParentAgent == nil {
-		return a
+		return a.agent()
 	}
 	return a.ParentAgent.GetRootAgent()
 }
 
+// runCallback runs a callback with a fresh callback context and emits an event
+// carrying the state and artifact changes it recorded, if any
+func (a *BaseAgent) runCallback(callback func(*CallbackContext) error, invocationCtx *InvocationContext, eventChan chan<- *events.Event) error {
+	callbackCtx := NewCallbackContext(invocationCtx)
+	if err := callback(callbackCtx); err != nil {
+		return err
+	}
+
+	// The callback's state changes are recorded in its own actions, so that
+	// changes made meanwhile by parallel branches stay with their events
+	actions := callbackCtx.EventActions
+	if len(actions.StateDelta) == 0 && len(actions.ArtifactDelta) == 0 {
+		return nil
+	}
+	event := events.NewEvent()
+	event.InvocationID = invocationCtx.InvocationID
+	event.Author = a.Name
+	event.Branch = invocationCtx.Branch
+	event.Actions = *actions
+	eventChan <- event
+	return nil
+}
+
+// emitError emits an event reporting an error of the agent with the given
+// code. Exceeding a limit of the run is reported with the limit's code
+// instead, and ends the invocation. Nothing is emitted once the run is
+// canceled, as nobody is listening for the error then.
+func (a *BaseAgent) emitError(ctx context.Context, invocationCtx *InvocationContext, eventChan chan<- *events.Event, code string, err error) {
+	var llmLimit *invocation.LLMCallLimitExceededError
+	var toolLimit *invocation.ToolCallLimitExceededError
+	switch {
+	case errors.As(err, &llmLimit):
+		code = LLMCallLimitErrorCode
+		invocationCtx.EndInvocation()
+	case errors.As(err, &toolLimit):
+		code = ToolCallLimitErrorCode
+		invocationCtx.EndInvocation()
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	event := events.NewEvent()
+	event.InvocationID = invocationCtx.InvocationID
+	event.Author = a.Name
+	event.Branch = invocationCtx.Branch
+	event.ErrorCode = code
+	event.ErrorMessage = err.Error()
+	eventChan <- event
+}
+
 // RunAsync is the base implementation - to be overridden by concrete agents
 func (a *BaseAgent) RunAsync(ctx context.Context, invocationCtx *InvocationContext) (<-chan *events.Event, error) {
 	// Base implementation returns empty channel
@@ -155,6 +225,6 @@ func (a *BaseAgent) RunAsync(ctx context.Context, invocationCtx *InvocationConte
 
 // RunLive is the base implementation for live mode
 func (a *BaseAgent) RunLive(ctx context.Context, invocationCtx *InvocationContext) (<-chan *events.Event, error) {
-	// Default implementation delegates to RunAsync
-	return a.RunAsync(ctx, invocationCtx)
+	// Default implementation delegates to the concrete agent's RunAsync
+	return a.agent().RunAsync(ctx, invocationCtx)
 }
\ No newline at end of file
@@ -0,0 +1,388 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/adrienveepee/adk-go/google/adk/events"
+	"github.com/adrienveepee/adk-go/google/adk/models"
+)
+
+// CompactionStrategy selects how an LlmAgent shortens a conversation history
+// that exceeds its budget
+type CompactionStrategy string
+
+const (
+	// CompactionSummarize replaces older events with a summary written by a
+	// model, stored in the session as a compaction event
+	CompactionSummarize CompactionStrategy = "summarize"
+	// CompactionSlidingWindow sends only the most recent events
+	CompactionSlidingWindow CompactionStrategy = "sliding_window"
+	// CompactionTruncateToolOutputs shortens the tool results of older events
+	CompactionTruncateToolOutputs CompactionStrategy = "truncate_tool_outputs"
+)
+
+// Compaction defaults
+const (
+	DefaultCompactionKeepRecentEvents   = 10
+	DefaultCompactionMaxToolOutputChars = 1000
+	DefaultCompactionInstruction        = "Summarize the conversation below for an assistant that will continue it. " +
+		"Keep the user's goals, decisions made, facts learned, tool results still relevant and open questions. " +
+		"Be concise and write in the third person."
+)
+
+// compactionTimeout bounds the summarization call so that a slow model does
+// not hold up the conversation
+const compactionTimeout = 2 * time.Minute
+
+// compactionSummaryPrefix introduces a summary in the history sent to the
+// model
+const compactionSummaryPrefix = "Summary of the earlier conversation:\n"
+
+// CompactionConfig configures the history compaction of an LlmAgent.
+// Compaction starts once the history sent to the model exceeds MaxEvents
+// events or MaxTokens estimated tokens; a zero budget is not checked.
+type CompactionConfig struct {
+	Strategy  CompactionStrategy `json:"strategy"`
+	MaxEvents int                `json:"max_events,omitempty"`
+	MaxTokens int                `json:"max_tokens,omitempty"`
+
+	// KeepRecentEvents is the number of most recent events always sent as
+	// they are; 0 uses DefaultCompactionKeepRecentEvents
+	KeepRecentEvents int `json:"keep_recent_events,omitempty"`
+
+	// Model writes the summaries; nil uses the agent's model
+	Model models.LLM `json:"-"`
+
+	// Instruction tells the model how to summarize; empty uses
+	// DefaultCompactionInstruction
+	Instruction string `json:"instruction,omitempty"`
+
+	// MaxToolOutputChars is the length tool results are truncated to; 0 uses
+	// DefaultCompactionMaxToolOutputChars
+	MaxToolOutputChars int `json:"max_tool_output_chars,omitempty"`
+}
+
+// Validate checks that the strategy is known and the limits are not negative
+func (c *CompactionConfig) Validate() error {
+	switch c.Strategy {
+	case CompactionSummarize, CompactionSlidingWindow, CompactionTruncateToolOutputs:
+	case "":
+		return errors.New("compaction strategy is not set")
+	default:
+		return fmt.Errorf("unknown compaction strategy %q", c.Strategy)
+	}
+	if c.MaxEvents < 0 || c.MaxTokens < 0 || c.KeepRecentEvents < 0 || c.MaxToolOutputChars < 0 {
+		return errors.New("compaction limits must not be negative")
+	}
+	return nil
+}
+
+// keepRecentEvents returns the number of events kept as they are
+func (c *CompactionConfig) keepRecentEvents() int {
+	if c.KeepRecentEvents > 0 {
+		return c.KeepRecentEvents
+	}
+	return DefaultCompactionKeepRecentEvents
+}
+
+// overBudget reports whether a history exceeds the budget
+func (c *CompactionConfig) overBudget(contents []*events.Content) bool {
+	if c.MaxEvents > 0 && len(contents) > c.MaxEvents {
+		return true
+	}
+	return c.MaxTokens > 0 && estimateTokens(contents) > c.MaxTokens
+}
+
+// SetCompaction sets how the agent compacts long conversation histories. An
+// invalid config is reported by Validate and makes runs of the agent fail.
+func (a *LlmAgent) SetCompaction(config *CompactionConfig) *LlmAgent {
+	a.Compaction = config
+	return a
+}
+
+// conversationHistory returns the events of the invocation's branch that
+// make up the conversation, oldest first, followed by the events of this run
+// that are not in the session yet. Events covered by the latest compaction
+// are left out and its summary is returned instead.
+func (a *LlmAgent) conversationHistory(invocationCtx *InvocationContext, turnEvents []*events.Event) (*events.EventCompaction, []*events.Event) {
+	var compaction *events.EventCompaction
+	var history []*events.Event
+	all := append(append([]*events.Event{}, invocationCtx.Session.Events...), turnEvents...)
+	for _, event := range all {
+		if !event.BelongsToBranch(invocationCtx.Branch) {
+			continue
+		}
+		if event.Actions.Compaction != nil {
+			compaction = event.Actions.Compaction
+			continue
+		}
+		history = append(history, event)
+	}
+	if compaction == nil {
+		return nil, history
+	}
+
+	remaining := make([]*events.Event, 0, len(history))
+	for _, event := range history {
+		if event.Timestamp.Before(compaction.StartTimestamp) || event.Timestamp.After(compaction.EndTimestamp) {
+			remaining = append(remaining, event)
+		}
+	}
+	return compaction, remaining
+}
+
+// historyContents returns the contents of a history to send to the model
+func historyContents(compaction *events.EventCompaction, history []*events.Event) []*events.Content {
+	contents := make([]*events.Content, 0, len(history)+1)
+	if compaction != nil && compaction.CompactedContent != nil {
+		contents = append(contents, compaction.CompactedContent)
+	}
+	for _, event := range history {
+		if content := withoutConfirmations(event.Content); content != nil {
+			contents = append(contents, content)
+		}
+	}
+	return contents
+}
+
+// compactContents applies a sliding window or tool output truncation to
+// history contents over the agent's budget. Summaries are written ahead of
+// the model call by summarizeHistory.
+func (a *LlmAgent) compactContents(contents []*events.Content, strategy CompactionStrategy) []*events.Content {
+	config := a.Compaction
+	if config == nil || !config.overBudget(contents) {
+		return contents
+	}
+
+	keep := config.keepRecentEvents()
+	switch strategy {
+	case CompactionSlidingWindow:
+		if len(contents) <= keep {
+			return contents
+		}
+		window := contents[len(contents)-keep:]
+		// A tool result is meaningless without the call it answers
+		for len(window) > 0 && isFunctionResponse(window[0]) {
+			window = window[1:]
+		}
+		return window
+
+	case CompactionTruncateToolOutputs:
+		maxChars := config.MaxToolOutputChars
+		if maxChars <= 0 {
+			maxChars = DefaultCompactionMaxToolOutputChars
+		}
+		compacted := make([]*events.Content, len(contents))
+		copy(compacted, contents)
+		for i := 0; i < len(compacted)-keep; i++ {
+			compacted[i] = truncateToolOutputs(compacted[i], maxChars)
+		}
+		return compacted
+	}
+	return contents
+}
+
+// summarizeHistory summarizes the older part of a history over budget into
+// a compaction event for the session, or returns nil if the agent does not
+// summarize or the history is within budget. The summarization counts toward
+// the invocation's model call budget.
+func (a *LlmAgent) summarizeHistory(ctx context.Context, invocationCtx *InvocationContext, turnEvents []*events.Event) (*events.Event, error) {
+	config := a.Compaction
+	if config == nil || config.Strategy != CompactionSummarize {
+		return nil, nil
+	}
+	previous, history := a.conversationHistory(invocationCtx, turnEvents)
+	if !config.overBudget(historyContents(previous, history)) {
+		return nil, nil
+	}
+
+	// Keep the recent events, without separating tool results from their
+	// calls
+	split := len(history) - config.keepRecentEvents()
+	for split > 0 && isFunctionResponse(history[split].Content) {
+		split--
+	}
+	if split <= 0 {
+		return nil, nil
+	}
+	older := history[:split]
+
+	llm := config.Model
+	if llm == nil {
+		llm = a.GetCanonicalModel()
+	}
+	if llm == nil {
+		return nil, errors.New("no model to summarize the history with")
+	}
+	if err := invocationCtx.IncrementLLMCallCount(); err != nil {
+		return nil, err
+	}
+	instruction := config.Instruction
+	if instruction == "" {
+		instruction = DefaultCompactionInstruction
+	}
+	request := &models.LLMRequest{
+		Contents: []*events.Content{
+			{Role: "system", Parts: []events.Part{{Text: instruction}}},
+			{Role: "user", Parts: []events.Part{{Text: transcript(previous, older)}}},
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, compactionTimeout)
+	defer cancel()
+	responses, err := llm.GenerateContentAsync(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	var summary strings.Builder
+	for response := range responses {
+		if response.Partial || response.Content == nil {
+			continue
+		}
+		for _, part := range response.Content.Parts {
+			summary.WriteString(part.Text)
+		}
+	}
+	if summary.Len() == 0 {
+		return nil, errors.New("the model returned an empty summary")
+	}
+
+	// The new summary covers the previous one
+	start := older[0].Timestamp
+	if previous != nil {
+		start = previous.StartTimestamp
+	}
+	event := events.NewEvent()
+	event.InvocationID = invocationCtx.InvocationID
+	event.Author = a.Name
+	event.Branch = invocationCtx.Branch
+	event.Actions.Compaction = &events.EventCompaction{
+		StartTimestamp: start,
+		EndTimestamp:   older[len(older)-1].Timestamp,
+		CompactedContent: &events.Content{
+			Role:  "user",
+			Parts: []events.Part{{Text: compactionSummaryPrefix + summary.String()}},
+		},
+	}
+	return event, nil
+}
+
+// transcript renders events as text for the summarizing model
+func transcript(previous *events.EventCompaction, history []*events.Event) string {
+	var text strings.Builder
+	if previous != nil && previous.CompactedContent != nil {
+		for _, part := range previous.CompactedContent.Parts {
+			text.WriteString(part.Text)
+		}
+		text.WriteString("\n\n")
+	}
+	for _, event := range history {
+		content := withoutConfirmations(event.Content)
+		if content == nil {
+			continue
+		}
+		author := event.Author
+		if author == "" {
+			author = content.Role
+		}
+		for _, part := range content.Parts {
+			switch {
+			case part.Text != "":
+				fmt.Fprintf(&text, "%s: %s\n", author, part.Text)
+			case part.FunctionCall != nil:
+				args, _ := json.Marshal(part.FunctionCall.Args)
+				fmt.Fprintf(&text, "%s called %s(%s)\n", author, part.FunctionCall.Name, args)
+			case part.FunctionResponse != nil:
+				response, _ := json.Marshal(part.FunctionResponse.Response)
+				fmt.Fprintf(&text, "%s returned %s\n", part.FunctionResponse.Name, response)
+			}
+		}
+	}
+	return text.String()
+}
+
+// isFunctionResponse reports whether a content carries tool results
+func isFunctionResponse(content *events.Content) bool {
+	if content == nil {
+		return false
+	}
+	for _, part := range content.Parts {
+		if part.FunctionResponse != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// truncateToolOutputs returns the content with tool results longer than
+// maxChars, once encoded, cut to that length
+func truncateToolOutputs(content *events.Content, maxChars int) *events.Content {
+	var parts []events.Part
+	for i, part := range content.Parts {
+		if part.FunctionResponse == nil {
+			continue
+		}
+		encoded, err := json.Marshal(part.FunctionResponse.Response)
+		if err != nil || len(encoded) <= maxChars {
+			continue
+		}
+		if parts == nil {
+			parts = make([]events.Part, len(content.Parts))
+			copy(parts, content.Parts)
+		}
+		// Cut on a character boundary
+		cut := maxChars
+		for cut > 0 && !utf8.RuneStart(encoded[cut]) {
+			cut--
+		}
+		response := *part.FunctionResponse
+		response.Response = map[string]interface{}{
+			"truncated_result": fmt.Sprintf("%s... [%d more bytes truncated]", encoded[:cut], len(encoded)-cut),
+		}
+		parts[i].FunctionResponse = &response
+	}
+	if parts == nil {
+		return content
+	}
+	return &events.Content{Role: content.Role, Parts: parts}
+}
+
+// estimateTokens roughly estimates the tokens of contents at four
+// characters per token
+func estimateTokens(contents []*events.Content) int {
+	chars := 0
+	for _, content := range contents {
+		for _, part := range content.Parts {
+			chars += len(part.Text)
+			if part.FunctionCall != nil {
+				args, _ := json.Marshal(part.FunctionCall.Args)
+				chars += len(part.FunctionCall.Name) + len(args)
+			}
+			if part.FunctionResponse != nil {
+				response, _ := json.Marshal(part.FunctionResponse.Response)
+				chars += len(part.FunctionResponse.Name) + len(response)
+			}
+		}
+	}
+	return chars / 4
+}
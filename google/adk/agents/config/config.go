@@ -0,0 +1,550 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package config loads agent trees from declarative YAML or JSON definitions
+// and exports existing trees back to that format.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/adrienveepee/adk-go/google/adk/agents"
+	"github.com/adrienveepee/adk-go/google/adk/models"
+	"github.com/adrienveepee/adk-go/google/adk/tools"
+)
+
+// Agent types supported in definitions
+const (
+	TypeLlm        = "llm"
+	TypeSequential = "sequential"
+	TypeParallel   = "parallel"
+	TypeLoop       = "loop"
+	TypeRouter     = "router"
+)
+
+// Format is a serialization format for agent definitions
+type Format string
+
+const (
+	FormatYAML Format = "yaml"
+	FormatJSON Format = "json"
+)
+
+// GenerateContentConfig is the declarative form of models.GenerateContentConfig
+type GenerateContentConfig struct {
+	Temperature     *float32 `yaml:"temperature,omitempty" json:"temperature,omitempty"`
+	MaxOutputTokens *int     `yaml:"max_output_tokens,omitempty" json:"max_output_tokens,omitempty"`
+	TopP            *float32 `yaml:"top_p,omitempty" json:"top_p,omitempty"`
+	TopK            *int     `yaml:"top_k,omitempty" json:"top_k,omitempty"`
+}
+
+// AgentConfig is the declarative definition of an agent and its sub-agents
+type AgentConfig struct {
+	Type        string `yaml:"type,omitempty" json:"type,omitempty"`
+	Name        string `yaml:"name,omitempty" json:"name,omitempty"`
+	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+
+	// LLM and router agents
+	Model string `yaml:"model,omitempty" json:"model,omitempty"`
+
+	// LLM agents
+	Instruction              string                 `yaml:"instruction,omitempty" json:"instruction,omitempty"`
+	GlobalInstruction        string                 `yaml:"global_instruction,omitempty" json:"global_instruction,omitempty"`
+	Tools                    []string               `yaml:"tools,omitempty" json:"tools,omitempty"`
+	GenerateContentConfig    *GenerateContentConfig `yaml:"generate_content_config,omitempty" json:"generate_content_config,omitempty"`
+	OutputKey                string                 `yaml:"output_key,omitempty" json:"output_key,omitempty"`
+	IncludeContents          string                 `yaml:"include_contents,omitempty" json:"include_contents,omitempty"`
+	DisallowTransferToParent bool                   `yaml:"disallow_transfer_to_parent,omitempty" json:"disallow_transfer_to_parent,omitempty"`
+	DisallowTransferToPeers  bool                   `yaml:"disallow_transfer_to_peers,omitempty" json:"disallow_transfer_to_peers,omitempty"`
+
+	// Loop agents
+	MaxIterations int `yaml:"max_iterations,omitempty" json:"max_iterations,omitempty"`
+
+	// Router agents
+	Fallback            string              `yaml:"fallback,omitempty" json:"fallback,omitempty"`
+	ConfidenceThreshold float64             `yaml:"confidence_threshold,omitempty" json:"confidence_threshold,omitempty"`
+	Rules               []*agents.RouteRule `yaml:"rules,omitempty" json:"rules,omitempty"`
+
+	SubAgents []*AgentConfig `yaml:"sub_agents,omitempty" json:"sub_agents,omitempty"`
+
+	// ConfigPath loads this agent from another file, relative to the file
+	// containing the reference
+	ConfigPath string `yaml:"config_path,omitempty" json:"config_path,omitempty"`
+
+	// Source position, for error reporting
+	file       string
+	line       int
+	fieldLines map[string]int
+	ruleLines  []int
+}
+
+// agentConfigFields is the set of field names accepted in definitions
+var agentConfigFields = func() map[string]bool {
+	fields := make(map[string]bool)
+	t := reflect.TypeOf(AgentConfig{})
+	for i := 0; i < t.NumField(); i++ {
+		if tag := t.Field(i).Tag.Get("yaml"); tag != "" {
+			fields[strings.Split(tag, ",")[0]] = true
+		}
+	}
+	return fields
+}()
+
+// UnmarshalYAML decodes an agent definition, recording source lines and
+// rejecting unknown fields
+func (c *AgentConfig) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return &ValidationError{Line: node.Line, Message: "agent definition must be a mapping"}
+	}
+
+	fieldLines := make(map[string]int)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := node.Content[i]
+		if !agentConfigFields[key.Value] {
+			return &ValidationError{Line: key.Line, Message: fmt.Sprintf("unknown field %q", key.Value)}
+		}
+		fieldLines[key.Value] = key.Line
+		if key.Value == "rules" && node.Content[i+1].Kind == yaml.SequenceNode {
+			for _, rule := range node.Content[i+1].Content {
+				c.ruleLines = append(c.ruleLines, rule.Line)
+			}
+		}
+	}
+
+	// Decode through an alias type to avoid recursing into this method
+	type plain AgentConfig
+	if err := node.Decode((*plain)(c)); err != nil {
+		return err
+	}
+	c.line = node.Line
+	c.fieldLines = fieldLines
+	return nil
+}
+
+// lineOf returns the source line of a field, or of the agent itself
+func (c *AgentConfig) lineOf(field string) int {
+	if line, exists := c.fieldLines[field]; exists {
+		return line
+	}
+	return c.line
+}
+
+// ruleLine returns the source line of a router rule, or of the rules field
+func (c *AgentConfig) ruleLine(index int) int {
+	if index < len(c.ruleLines) {
+		return c.ruleLines[index]
+	}
+	return c.lineOf("rules")
+}
+
+// ValidationError describes an invalid definition at a source position
+type ValidationError struct {
+	File    string
+	Line    int
+	Message string
+}
+
+// Error formats the error as file:line: message
+func (e *ValidationError) Error() string {
+	position := e.position()
+	if position == "" {
+		return e.Message
+	}
+	return position + ": " + e.Message
+}
+
+// position formats the source position as file:line, or file alone when the
+// line is unknown
+func (e *ValidationError) position() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d", e.File, e.Line)
+	}
+	return e.File
+}
+
+// ValidationErrors collects all problems found in a definition
+type ValidationErrors []*ValidationError
+
+// Error joins all validation errors, one per line
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Parse parses a YAML or JSON definition, resolving config_path references
+// relative to filename. It does not validate the definition.
+func Parse(data []byte, filename string) (*AgentConfig, error) {
+	return parse(data, filename, map[string]bool{})
+}
+
+// parse parses a definition, tracking the files being loaded to reject
+// config_path cycles
+func parse(data []byte, filename string, loading map[string]bool) (*AgentConfig, error) {
+	cfg := &AgentConfig{}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		if validationErr, ok := err.(*ValidationError); ok {
+			validationErr.File = filename
+			return nil, validationErr
+		}
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	if absPath, err := filepath.Abs(filename); err == nil {
+		loading[absPath] = true
+		defer delete(loading, absPath)
+	}
+
+	if err := resolve(cfg, filename, loading); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// resolve records the source file on each definition and replaces
+// config_path references with the referenced definitions
+func resolve(cfg *AgentConfig, filename string, loading map[string]bool) error {
+	cfg.file = filename
+
+	for i, subAgent := range cfg.SubAgents {
+		if subAgent == nil {
+			continue
+		}
+		if subAgent.ConfigPath == "" {
+			if err := resolve(subAgent, filename, loading); err != nil {
+				return err
+			}
+			continue
+		}
+
+		path := subAgent.ConfigPath
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(filename), path)
+		}
+		if absPath, err := filepath.Abs(path); err == nil && loading[absPath] {
+			return &ValidationError{File: filename, Line: subAgent.lineOf("config_path"), Message: fmt.Sprintf("config_path %q forms a cycle", subAgent.ConfigPath)}
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return &ValidationError{File: filename, Line: subAgent.lineOf("config_path"), Message: err.Error()}
+		}
+		referenced, err := parse(data, path, loading)
+		if err != nil {
+			return err
+		}
+		cfg.SubAgents[i] = referenced
+	}
+
+	return nil
+}
+
+// Validate checks a parsed definition and returns all problems found, or nil
+func Validate(cfg *AgentConfig, registry *tools.ToolRegistry) error {
+	var errs ValidationErrors
+	validate(cfg, registry, make(map[string]*AgentConfig), &errs)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validate checks a definition and its sub-agents. names holds the
+// definitions seen so far by name, as names must be unique in the tree.
+func validate(cfg *AgentConfig, registry *tools.ToolRegistry, names map[string]*AgentConfig, errs *ValidationErrors) {
+	report := func(field, format string, args ...interface{}) {
+		*errs = append(*errs, &ValidationError{File: cfg.file, Line: cfg.lineOf(field), Message: fmt.Sprintf(format, args...)})
+	}
+
+	if cfg.Name == "" {
+		report("name", "name is required")
+	} else {
+		if err := agents.ValidateName(cfg.Name); err != nil {
+			report("name", "%v", err)
+		}
+		if first, duplicate := names[cfg.Name]; duplicate {
+			if position := (&ValidationError{File: first.file, Line: first.lineOf("name")}).position(); position != "" {
+				report("name", "agent name %q is already used at %s", cfg.Name, position)
+			} else {
+				report("name", "agent name %q is used more than once", cfg.Name)
+			}
+		} else {
+			names[cfg.Name] = cfg
+		}
+	}
+
+	switch cfg.agentType() {
+	case TypeLlm:
+		if cfg.Model == "" {
+			report("model", "agent %q: model is required for llm agents", cfg.Name)
+		}
+		switch agents.IncludeContents(cfg.IncludeContents) {
+		case "", agents.IncludeContentsDefault, agents.IncludeContentsNone:
+		default:
+			report("include_contents", "agent %q: include_contents must be %q or %q", cfg.Name, agents.IncludeContentsDefault, agents.IncludeContentsNone)
+		}
+		for _, name := range cfg.Tools {
+			if _, exists := registry.Get(name); !exists {
+				report("tools", "agent %q: unknown tool %q", cfg.Name, name)
+			}
+		}
+	case TypeSequential, TypeParallel:
+	case TypeLoop:
+		if cfg.MaxIterations < 0 {
+			report("max_iterations", "agent %q: max_iterations must not be negative", cfg.Name)
+		}
+	case TypeRouter:
+		if cfg.Model == "" {
+			report("model", "agent %q: model is required for router agents", cfg.Name)
+		}
+		if cfg.Fallback != "" && !cfg.hasSubAgent(cfg.Fallback) {
+			report("fallback", "agent %q: fallback %q is not a sub-agent", cfg.Name, cfg.Fallback)
+		}
+		for i, rule := range cfg.Rules {
+			reportRule := func(format string, args ...interface{}) {
+				*errs = append(*errs, &ValidationError{File: cfg.file, Line: cfg.ruleLine(i), Message: fmt.Sprintf(format, args...)})
+			}
+			if rule == nil || !cfg.hasSubAgent(rule.Agent) {
+				reportRule("agent %q: rule targets unknown sub-agent", cfg.Name)
+				continue
+			}
+			if rule.Pattern != "" {
+				if _, err := regexp.Compile(rule.Pattern); err != nil {
+					reportRule("agent %q: invalid pattern for rule %q: %v", cfg.Name, rule.Agent, err)
+				}
+			}
+		}
+	default:
+		report("type", "agent %q: unknown type %q", cfg.Name, cfg.Type)
+	}
+
+	if cfg.agentType() != TypeLlm {
+		for _, field := range []string{"instruction", "global_instruction", "tools", "generate_content_config", "output_key", "include_contents"} {
+			if _, set := cfg.fieldLines[field]; set {
+				report(field, "agent %q: %s is only valid for llm agents", cfg.Name, field)
+			}
+		}
+	}
+
+	for _, subAgent := range cfg.SubAgents {
+		if subAgent == nil {
+			report("sub_agents", "agent %q: empty sub-agent definition", cfg.Name)
+			continue
+		}
+		validate(subAgent, registry, names, errs)
+	}
+}
+
+// agentType returns the agent type, defaulting to an LLM agent
+func (c *AgentConfig) agentType() string {
+	if c.Type == "" {
+		return TypeLlm
+	}
+	return c.Type
+}
+
+// hasSubAgent reports whether a direct sub-agent has the given name
+func (c *AgentConfig) hasSubAgent(name string) bool {
+	for _, subAgent := range c.SubAgents {
+		if subAgent != nil && subAgent.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Build validates a definition and builds the agent tree it describes
+func Build(cfg *AgentConfig, registry *tools.ToolRegistry) (agents.Agent, error) {
+	if err := Validate(cfg, registry); err != nil {
+		return nil, err
+	}
+	return build(cfg, registry), nil
+}
+
+// build builds a validated definition
+func build(cfg *AgentConfig, registry *tools.ToolRegistry) agents.Agent {
+	subAgents := make([]agents.Agent, 0, len(cfg.SubAgents))
+	for _, subAgent := range cfg.SubAgents {
+		subAgents = append(subAgents, build(subAgent, registry))
+	}
+
+	var agent agents.Agent
+	switch cfg.agentType() {
+	case TypeSequential:
+		agent = agents.NewSequentialAgent(cfg.Name, subAgents)
+	case TypeParallel:
+		agent = agents.NewParallelAgent(cfg.Name, subAgents)
+	case TypeLoop:
+		agent = agents.NewLoopAgent(cfg.Name, subAgents, cfg.MaxIterations)
+	case TypeRouter:
+		router := agents.NewRouterAgent(cfg.Name, cfg.Model, subAgents).
+			SetFallback(cfg.Fallback).
+			SetConfidenceThreshold(cfg.ConfidenceThreshold)
+		for _, rule := range cfg.Rules {
+			router.Rules = append(router.Rules, &agents.RouteRule{Agent: rule.Agent, Keywords: rule.Keywords, Pattern: rule.Pattern})
+		}
+		agent = router
+	default:
+		llmAgent := agents.NewLlmAgent(cfg.Name, cfg.Model, cfg.Instruction)
+		llmAgent.GlobalInstruction = cfg.GlobalInstruction
+		llmAgent.OutputKey = cfg.OutputKey
+		llmAgent.DisallowTransferToParent = cfg.DisallowTransferToParent
+		llmAgent.DisallowTransferToPeers = cfg.DisallowTransferToPeers
+		if cfg.IncludeContents != "" {
+			llmAgent.IncludeContents = agents.IncludeContents(cfg.IncludeContents)
+		}
+		if gc := cfg.GenerateContentConfig; gc != nil {
+			llmAgent.GenerateContentConfig = &models.GenerateContentConfig{
+				Temperature:     gc.Temperature,
+				MaxOutputTokens: gc.MaxOutputTokens,
+				TopP:            gc.TopP,
+				TopK:            gc.TopK,
+			}
+		}
+		for _, name := range cfg.Tools {
+			tool, _ := registry.Get(name)
+			llmAgent.AddTool(tool)
+		}
+		for _, subAgent := range subAgents {
+			llmAgent.AddSubAgent(subAgent)
+		}
+		agent = llmAgent
+	}
+
+	if cfg.Description != "" {
+		setDescription(agent, cfg.Description)
+	}
+	return agent
+}
+
+// setDescription overrides the default description of a built agent
+func setDescription(agent agents.Agent, description string) {
+	switch a := agent.(type) {
+	case *agents.LlmAgent:
+		a.Description = description
+	case *agents.SequentialAgent:
+		a.Description = description
+	case *agents.ParallelAgent:
+		a.Description = description
+	case *agents.LoopAgent:
+		a.Description = description
+	case *agents.RouterAgent:
+		a.Description = description
+	}
+}
+
+// Load parses, validates and builds an agent tree from a YAML or JSON
+// definition. filename is used for error positions and config_path lookups.
+func Load(data []byte, filename string, registry *tools.ToolRegistry) (agents.Agent, error) {
+	cfg, err := Parse(data, filename)
+	if err != nil {
+		return nil, err
+	}
+	return Build(cfg, registry)
+}
+
+// LoadFile loads an agent tree from a YAML or JSON file
+func LoadFile(path string, registry *tools.ToolRegistry) (agents.Agent, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read agent definition: %w", err)
+	}
+	return Load(data, path, registry)
+}
+
+// FromAgent converts an agent tree back into a definition. Callbacks, loop
+// conditions and other Go-only settings are not representable and are
+// dropped.
+func FromAgent(agent agents.Agent) (*AgentConfig, error) {
+	cfg := &AgentConfig{
+		Name:        agent.GetName(),
+		Description: agent.GetDescription(),
+	}
+
+	switch a := agent.(type) {
+	case *agents.LlmAgent:
+		cfg.Model = a.Model
+		cfg.Instruction = a.Instruction
+		cfg.GlobalInstruction = a.GlobalInstruction
+		cfg.OutputKey = a.OutputKey
+		cfg.DisallowTransferToParent = a.DisallowTransferToParent
+		cfg.DisallowTransferToPeers = a.DisallowTransferToPeers
+		if a.IncludeContents != agents.IncludeContentsDefault {
+			cfg.IncludeContents = string(a.IncludeContents)
+		}
+		if gc := a.GenerateContentConfig; gc != nil {
+			cfg.GenerateContentConfig = &GenerateContentConfig{
+				Temperature:     gc.Temperature,
+				MaxOutputTokens: gc.MaxOutputTokens,
+				TopP:            gc.TopP,
+				TopK:            gc.TopK,
+			}
+		}
+		for _, tool := range a.Tools {
+			cfg.Tools = append(cfg.Tools, tool.GetName())
+		}
+	case *agents.SequentialAgent:
+		cfg.Type = TypeSequential
+	case *agents.ParallelAgent:
+		cfg.Type = TypeParallel
+	case *agents.LoopAgent:
+		cfg.Type = TypeLoop
+		cfg.MaxIterations = a.MaxIterations
+	case *agents.RouterAgent:
+		cfg.Type = TypeRouter
+		cfg.Model = a.Model
+		cfg.Fallback = a.Fallback
+		cfg.ConfidenceThreshold = a.ConfidenceThreshold
+		cfg.Rules = a.Rules
+	default:
+		return nil, fmt.Errorf("agent %s: unsupported agent type %T", agent.GetName(), agent)
+	}
+
+	for _, subAgent := range agent.GetSubAgents() {
+		subCfg, err := FromAgent(subAgent)
+		if err != nil {
+			return nil, err
+		}
+		cfg.SubAgents = append(cfg.SubAgents, subCfg)
+	}
+
+	return cfg, nil
+}
+
+// Export serializes an agent tree as a definition in the given format
+func Export(agent agents.Agent, format Format) ([]byte, error) {
+	cfg, err := FromAgent(agent)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case FormatYAML:
+		return yaml.Marshal(cfg)
+	case FormatJSON:
+		return json.MarshalIndent(cfg, "", "  ")
+	default:
+		return nil, fmt.Errorf("unsupported format: %s", format)
+	}
+}
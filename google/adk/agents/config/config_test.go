@@ -0,0 +1,309 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/adrienveepee/adk-go/google/adk/agents"
+	"github.com/adrienveepee/adk-go/google/adk/tools"
+)
+
+const pipelineYAML = `name: pipeline
+type: sequential
+description: Research then write
+sub_agents:
+  - name: researcher
+    model: gemini-2.0-flash
+    instruction: Research the topic.
+    tools: [lookup]
+    output_key: research
+    generate_content_config:
+      temperature: 0.2
+      max_output_tokens: 512
+  - name: refine
+    type: loop
+    max_iterations: 3
+    sub_agents:
+      - name: writer
+        model: gemini-2.0-flash
+        instruction: Write the article.
+        tools: [exit_loop]
+        include_contents: none
+`
+
+func newTestRegistry() *tools.ToolRegistry {
+	registry := tools.NewToolRegistry()
+	registry.Register(tools.NewBaseTool("lookup", "Look things up", false))
+	return registry
+}
+
+// findAgent walks the tree for an agent by name
+func findAgent(agent agents.Agent, name string) agents.Agent {
+	if agent.GetName() == name {
+		return agent
+	}
+	for _, subAgent := range agent.GetSubAgents() {
+		if found := findAgent(subAgent, name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func TestLoadYAML(t *testing.T) {
+	agent, err := Load([]byte(pipelineYAML), "pipeline.yaml", newTestRegistry())
+	if err != nil {
+		t.Fatalf("Load should not return error: %v", err)
+	}
+
+	pipeline, ok := agent.(*agents.SequentialAgent)
+	if !ok {
+		t.Fatalf("Expected *agents.SequentialAgent, got %T", agent)
+	}
+	if pipeline.GetDescription() != "Research then write" {
+		t.Errorf("Expected description to be set, got %q", pipeline.GetDescription())
+	}
+
+	researcher, ok := findAgent(pipeline, "researcher").(*agents.LlmAgent)
+	if !ok {
+		t.Fatal("Expected researcher to be an LLM agent")
+	}
+	if researcher.OutputKey != "research" || len(researcher.Tools) != 1 || researcher.Tools[0].GetName() != "lookup" {
+		t.Errorf("Researcher not configured as expected: %+v", researcher)
+	}
+	if researcher.GenerateContentConfig == nil || *researcher.GenerateContentConfig.MaxOutputTokens != 512 {
+		t.Error("Expected generate content config to be set")
+	}
+
+	loop, ok := findAgent(pipeline, "refine").(*agents.LoopAgent)
+	if !ok || loop.MaxIterations != 3 {
+		t.Fatal("Expected refine to be a loop agent with 3 iterations")
+	}
+
+	writer := findAgent(loop, "writer").(*agents.LlmAgent)
+	if writer.IncludeContents != agents.IncludeContentsNone {
+		t.Errorf("Expected writer include contents to be none, got %s", writer.IncludeContents)
+	}
+}
+
+func TestLoadJSON(t *testing.T) {
+	data := `{
+  "name": "router",
+  "type": "router",
+  "model": "gemini-2.0-flash",
+  "fallback": "general",
+  "rules": [{"agent": "billing", "keywords": ["invoice"]}],
+  "sub_agents": [
+    {"name": "billing", "model": "gemini-2.0-flash"},
+    {"name": "general", "model": "gemini-2.0-flash"}
+  ]
+}`
+	agent, err := Load([]byte(data), "router.json", newTestRegistry())
+	if err != nil {
+		t.Fatalf("Load should not return error: %v", err)
+	}
+
+	router, ok := agent.(*agents.RouterAgent)
+	if !ok {
+		t.Fatalf("Expected *agents.RouterAgent, got %T", agent)
+	}
+	if router.Fallback != "general" || len(router.Rules) != 1 || router.Rules[0].Keywords[0] != "invoice" {
+		t.Errorf("Router not configured as expected: %+v", router)
+	}
+}
+
+func TestLoadValidationErrors(t *testing.T) {
+	data := `name: root
+model: gemini-2.0-flash
+tools:
+  - missing_tool
+sub_agents:
+  - name: child
+    type: loop
+    instruction: loops cannot have instructions
+  - type: teleport
+`
+	_, err := Load([]byte(data), "root.yaml", newTestRegistry())
+	if err == nil {
+		t.Fatal("Expected validation errors")
+	}
+
+	validationErrs, ok := err.(ValidationErrors)
+	if !ok {
+		t.Fatalf("Expected ValidationErrors, got %T: %v", err, err)
+	}
+
+	expected := []string{
+		`root.yaml:3: agent "root": unknown tool "missing_tool"`,
+		`root.yaml:8: agent "child": instruction is only valid for llm agents`,
+		`root.yaml:9: name is required`,
+		`root.yaml:9: agent "": unknown type "teleport"`,
+	}
+	if len(validationErrs) != len(expected) {
+		t.Fatalf("Expected %d errors, got:\n%v", len(expected), err)
+	}
+	for i, message := range expected {
+		if validationErrs[i].Error() != message {
+			t.Errorf("Expected error %d to be %q, got %q", i, message, validationErrs[i].Error())
+		}
+	}
+}
+
+func TestLoadAgentNameErrors(t *testing.T) {
+	data := `name: root
+model: gemini-2.0-flash
+sub_agents:
+  - name: helper
+    model: gemini-2.0-flash
+  - name: helper
+    model: gemini-2.0-flash
+  - name: user
+    model: gemini-2.0-flash
+  - name: two words
+    model: gemini-2.0-flash
+`
+	_, err := Load([]byte(data), "root.yaml", newTestRegistry())
+	validationErrs, ok := err.(ValidationErrors)
+	if !ok {
+		t.Fatalf("Expected ValidationErrors, got %T: %v", err, err)
+	}
+
+	expected := []string{
+		`root.yaml:6: agent name "helper" is already used at root.yaml:4`,
+		`root.yaml:8: agent name "user" is reserved`,
+		`root.yaml:10: agent name "two words" is not a valid identifier`,
+	}
+	if len(validationErrs) != len(expected) {
+		t.Fatalf("Expected %d errors, got:\n%v", len(expected), err)
+	}
+	for i, message := range expected {
+		if validationErrs[i].Error() != message {
+			t.Errorf("Expected error %d to be %q, got %q", i, message, validationErrs[i].Error())
+		}
+	}
+}
+
+func TestLoadRouterRuleErrors(t *testing.T) {
+	data := `name: router
+type: router
+model: gemini-2.0-flash
+rules:
+  - agent: billing
+    keywords: [invoice]
+  - agent: billing
+    pattern: "(unclosed"
+  - agent: missing
+    keywords: [help]
+sub_agents:
+  - name: billing
+    model: gemini-2.0-flash
+`
+	_, err := Load([]byte(data), "router.yaml", newTestRegistry())
+	validationErrs, ok := err.(ValidationErrors)
+	if !ok {
+		t.Fatalf("Expected ValidationErrors, got %T: %v", err, err)
+	}
+
+	expected := []string{
+		"router.yaml:7: agent \"router\": invalid pattern for rule \"billing\": error parsing regexp: missing closing ): `(unclosed`",
+		`router.yaml:9: agent "router": rule targets unknown sub-agent`,
+	}
+	if len(validationErrs) != len(expected) {
+		t.Fatalf("Expected %d errors, got:\n%v", len(expected), err)
+	}
+	for i, message := range expected {
+		if validationErrs[i].Error() != message {
+			t.Errorf("Expected error %d to be %q, got %q", i, message, validationErrs[i].Error())
+		}
+	}
+}
+
+func TestLoadUnknownField(t *testing.T) {
+	data := "name: root\nmodel: gemini-2.0-flash\ninstructions: typo\n"
+	_, err := Load([]byte(data), "root.yaml", newTestRegistry())
+	if err == nil || err.Error() != `root.yaml:3: unknown field "instructions"` {
+		t.Errorf("Expected unknown field error, got %v", err)
+	}
+}
+
+func TestLoadFileConfigPath(t *testing.T) {
+	dir := t.TempDir()
+	root := "name: root\ntype: sequential\nsub_agents:\n  - config_path: agents/child.yaml\n"
+	child := "name: child\nmodel: gemini-2.0-flash\ninstruction: From another file.\n"
+
+	if err := os.MkdirAll(filepath.Join(dir, "agents"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "root.yaml"), []byte(root), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "agents", "child.yaml"), []byte(child), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	agent, err := LoadFile(filepath.Join(dir, "root.yaml"), newTestRegistry())
+	if err != nil {
+		t.Fatalf("LoadFile should not return error: %v", err)
+	}
+	childAgent, ok := findAgent(agent, "child").(*agents.LlmAgent)
+	if !ok || childAgent.Instruction != "From another file." {
+		t.Error("Expected child to be loaded from config_path")
+	}
+
+	// Errors in referenced files point to the referenced file
+	if err := os.WriteFile(filepath.Join(dir, "agents", "child.yaml"), []byte("name: child\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadFile(filepath.Join(dir, "root.yaml"), newTestRegistry())
+	if err == nil || !strings.Contains(err.Error(), filepath.Join("agents", "child.yaml")+":1:") {
+		t.Errorf("Expected error in child.yaml, got %v", err)
+	}
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	registry := newTestRegistry()
+	original, err := Load([]byte(pipelineYAML), "pipeline.yaml", registry)
+	if err != nil {
+		t.Fatalf("Load should not return error: %v", err)
+	}
+
+	for _, format := range []Format{FormatYAML, FormatJSON} {
+		data, err := Export(original, format)
+		if err != nil {
+			t.Fatalf("Export(%s) should not return error: %v", format, err)
+		}
+
+		reloaded, err := Load(data, "exported."+string(format), registry)
+		if err != nil {
+			t.Fatalf("Reloading %s export should not return error: %v\n%s", format, err, data)
+		}
+
+		again, err := Export(reloaded, format)
+		if err != nil {
+			t.Fatalf("Export(%s) should not return error: %v", format, err)
+		}
+		if string(again) != string(data) {
+			t.Errorf("Expected %s round trip to be stable:\n%s\nvs\n%s", format, data, again)
+		}
+	}
+
+	if _, err := Export(agents.NewBaseAgent("custom", ""), FormatYAML); err == nil {
+		t.Error("Expected error exporting unsupported agent type")
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agents
+
+import (
+	"context"
+
+	"github.com/adrienveepee/adk-go/google/adk/events"
+)
+
+// CustomAgentErrorCode is the error code of the event emitted when a custom
+// agent's function fails
+const CustomAgentErrorCode = "CUSTOM_AGENT_ERROR"
+
+// EmitFunc emits an event from a custom agent. It fails once the run's
+// context is canceled, and the agent should then return.
+type EmitFunc func(event *events.Event) error
+
+// CustomAgentFunc implements the logic of a custom agent in plain Go
+type CustomAgentFunc func(ctx context.Context, invocationCtx *InvocationContext, emit EmitFunc) error
+
+// CustomAgent runs a CustomAgentFunc as an agent. The agent callbacks run
+// around the function; events it emits are stamped with the invocation ID,
+// the agent as author and the current branch unless already set. An error
+// returned by the function or a callback is reported as an error event; a
+// failing function or before callback skips the rest of the run.
+type CustomAgent struct {
+	*BaseAgent
+	Run CustomAgentFunc `json:"-"`
+}
+
+// NewCustomAgent creates a new custom agent
+func NewCustomAgent(name, description string, run CustomAgentFunc) *CustomAgent {
+	agent := &CustomAgent{
+		BaseAgent: NewBaseAgent(name, description),
+		Run:       run,
+	}
+	agent.self = agent
+	return agent
+}
+
+// RunAsync executes the custom agent's function
+func (a *CustomAgent) RunAsync(ctx context.Context, invocationCtx *InvocationContext) (<-chan *events.Event, error) {
+	invocationCtx = invocationCtx.WithAgent(a)
+	eventChan := make(chan *events.Event)
+
+	go func() {
+		defer close(eventChan)
+
+		// Execute before agent callback
+		if a.BeforeAgentCallback != nil {
+			if err := a.runCallback(a.BeforeAgentCallback, invocationCtx, eventChan); err != nil {
+				a.emitError(ctx, invocationCtx, eventChan, CustomAgentErrorCode, err)
+				return
+			}
+		}
+
+		emit := func(event *events.Event) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			a.stamp(invocationCtx, event)
+			select {
+			case eventChan <- event:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		if a.Run != nil {
+			if err := a.Run(ctx, invocationCtx, emit); err != nil {
+				a.emitError(ctx, invocationCtx, eventChan, CustomAgentErrorCode, err)
+				return
+			}
+		}
+
+		// Execute after agent callback
+		if a.AfterAgentCallback != nil {
+			if err := a.runCallback(a.AfterAgentCallback, invocationCtx, eventChan); err != nil {
+				a.emitError(ctx, invocationCtx, eventChan, CustomAgentErrorCode, err)
+			}
+		}
+	}()
+
+	return eventChan, nil
+}
+
+// RunSubAgent runs a sub-agent and emits its events, returning once the
+// sub-agent is done or the run is canceled
+func (a *CustomAgent) RunSubAgent(ctx context.Context, invocationCtx *InvocationContext, subAgent Agent, emit EmitFunc) error {
+	subEventChan, err := subAgent.RunAsync(ctx, invocationCtx)
+	if err != nil {
+		return err
+	}
+
+	var emitErr error
+	for event := range subEventChan {
+		// Keep draining so that the sub-agent can finish
+		if emitErr == nil {
+			emitErr = emit(event)
+		}
+	}
+	return emitErr
+}
+
+// stamp fills in the invocation ID, author and branch of an emitted event
+func (a *CustomAgent) stamp(invocationCtx *InvocationContext, event *events.Event) {
+	if event.InvocationID == "" {
+		event.InvocationID = invocationCtx.InvocationID
+	}
+	if event.Author == "" {
+		event.Author = a.Name
+	}
+	if event.Branch == "" {
+		event.Branch = invocationCtx.Branch
+	}
+}
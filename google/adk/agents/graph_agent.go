@@ -0,0 +1,416 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agents
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/adrienveepee/adk-go/google/adk/events"
+)
+
+// DefaultGraphMaxIterations is the default cap on graph execution steps
+const DefaultGraphMaxIterations = 100
+
+// GraphAgentErrorCode is the error code of the event emitted when a graph
+// node fails
+const GraphAgentErrorCode = "GRAPH_AGENT_ERROR"
+
+// GraphMaxIterationsErrorCode is the error code of the event emitted when a
+// graph is stopped by MaxIterations before its frontier is exhausted
+const GraphMaxIterationsErrorCode = "GRAPH_MAX_ITERATIONS_EXCEEDED"
+
+// errMaxIterations is returned by run when MaxIterations stops the graph
+var errMaxIterations = errors.New("maximum iterations exceeded")
+
+// NodeFunc is a Go function executed as a graph node. It may return an event
+// to emit; a nil event emits nothing.
+type NodeFunc func(ctx context.Context, invocationCtx *InvocationContext) (*events.Event, error)
+
+// EdgeCondition decides whether an edge is followed, based on session state
+// or the last event emitted by the edge's source node (nil if it emitted none)
+type EdgeCondition func(invocationCtx *InvocationContext, lastEvent *events.Event) bool
+
+// GraphNode is a node of a GraphAgent, backed by either an agent or a function
+type GraphNode struct {
+	Name  string   `json:"name"`
+	Agent Agent    `json:"-"`
+	Func  NodeFunc `json:"-"`
+
+	// Join nodes wait until all their predecessors have completed
+	Join bool `json:"join,omitempty"`
+}
+
+// GraphEdge connects two graph nodes, optionally guarded by a condition
+type GraphEdge struct {
+	From      string        `json:"from"`
+	To        string        `json:"to"`
+	Condition EdgeCondition `json:"-"`
+}
+
+// GraphAgent executes nodes along the edges of a directed graph. After a node
+// runs, every outgoing edge whose condition holds is followed; when several
+// edges are followed, their targets run in parallel, each in its own branch.
+// Cycles are allowed and bounded by MaxIterations.
+type GraphAgent struct {
+	*BaseAgent
+	Nodes         []*GraphNode `json:"nodes"`
+	Edges         []*GraphEdge `json:"edges"`
+	Start         string       `json:"start"`
+	MaxIterations int          `json:"max_iterations"`
+}
+
+// NewGraphAgent creates a new graph agent
+func NewGraphAgent(name string) *GraphAgent {
+	agent := &GraphAgent{
+		BaseAgent:     NewBaseAgent(name, "Graph execution agent"),
+		Nodes:         make([]*GraphNode, 0),
+		Edges:         make([]*GraphEdge, 0),
+		MaxIterations: DefaultGraphMaxIterations,
+	}
+	agent.self = agent
+	return agent
+}
+
+// AddNode adds an agent node named after the agent
+func (a *GraphAgent) AddNode(agent Agent) *GraphAgent {
+	a.Nodes = append(a.Nodes, &GraphNode{Name: agent.GetName(), Agent: agent})
+	a.AddSubAgent(agent)
+	return a
+}
+
+// AddFuncNode adds a function node
+func (a *GraphAgent) AddFuncNode(name string, fn NodeFunc) *GraphAgent {
+	a.Nodes = append(a.Nodes, &GraphNode{Name: name, Func: fn})
+	return a
+}
+
+// SetJoin marks a node as a join node
+func (a *GraphAgent) SetJoin(name string) *GraphAgent {
+	if node := a.node(name); node != nil {
+		node.Join = true
+	}
+	return a
+}
+
+// AddEdge adds an unconditional edge
+func (a *GraphAgent) AddEdge(from, to string) *GraphAgent {
+	return a.AddConditionalEdge(from, to, nil)
+}
+
+// AddConditionalEdge adds an edge followed only when the condition holds
+func (a *GraphAgent) AddConditionalEdge(from, to string, condition EdgeCondition) *GraphAgent {
+	a.Edges = append(a.Edges, &GraphEdge{From: from, To: to, Condition: condition})
+	return a
+}
+
+// SetStart sets the entry node; defaults to the first node added
+func (a *GraphAgent) SetStart(name string) *GraphAgent {
+	a.Start = name
+	return a
+}
+
+// SetMaxIterations sets the cap on execution steps; 0 means unbounded
+func (a *GraphAgent) SetMaxIterations(maxIterations int) *GraphAgent {
+	a.MaxIterations = maxIterations
+	return a
+}
+
+// StateEquals returns an edge condition that holds when the session state
+// value for key equals value
+func StateEquals(key string, value interface{}) EdgeCondition {
+	return func(invocationCtx *InvocationContext, lastEvent *events.Event) bool {
+		actual, exists := invocationCtx.Session.State.Get(key)
+		return exists && reflect.DeepEqual(actual, value)
+	}
+}
+
+// Validate checks that the graph is well formed: node names are unique, each
+// node has exactly one of an agent or a function, edges reference existing
+// nodes and every node is reachable from the start node.
+func (a *GraphAgent) Validate() error {
+	if len(a.Nodes) == 0 {
+		return fmt.Errorf("graph agent %s has no nodes", a.Name)
+	}
+
+	seen := make(map[string]bool)
+	for _, node := range a.Nodes {
+		if seen[node.Name] {
+			return fmt.Errorf("graph agent %s: duplicate node %q", a.Name, node.Name)
+		}
+		seen[node.Name] = true
+
+		if (node.Agent == nil) == (node.Func == nil) {
+			return fmt.Errorf("graph agent %s: node %q must have exactly one of an agent or a function", a.Name, node.Name)
+		}
+	}
+
+	if !seen[a.startNode()] {
+		return fmt.Errorf("graph agent %s: start node %q does not exist", a.Name, a.startNode())
+	}
+
+	for _, edge := range a.Edges {
+		if !seen[edge.From] {
+			return fmt.Errorf("graph agent %s: edge source %q does not exist", a.Name, edge.From)
+		}
+		if !seen[edge.To] {
+			return fmt.Errorf("graph agent %s: edge target %q does not exist", a.Name, edge.To)
+		}
+	}
+
+	// Walk the graph from the start node to find unreachable nodes
+	reachable := map[string]bool{a.startNode(): true}
+	queue := []string{a.startNode()}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, edge := range a.Edges {
+			if edge.From == current && !reachable[edge.To] {
+				reachable[edge.To] = true
+				queue = append(queue, edge.To)
+			}
+		}
+	}
+	for _, node := range a.Nodes {
+		if !reachable[node.Name] {
+			return fmt.Errorf("graph agent %s: node %q is unreachable from start node %q", a.Name, node.Name, a.startNode())
+		}
+	}
+
+	return nil
+}
+
+// RunAsync executes the graph starting from the start node
+func (a *GraphAgent) RunAsync(ctx context.Context, invocationCtx *InvocationContext) (<-chan *events.Event, error) {
+	if err := a.Validate(); err != nil {
+		return nil, err
+	}
+
+	invocationCtx = invocationCtx.WithAgent(a)
+	eventChan := make(chan *events.Event)
+
+	go func() {
+		defer close(eventChan)
+
+		// Execute before agent callback
+		if a.BeforeAgentCallback != nil {
+			if err := a.runCallback(a.BeforeAgentCallback, invocationCtx, eventChan); err != nil {
+				return
+			}
+		}
+
+		if err := a.run(ctx, invocationCtx, eventChan); err != nil {
+			code := GraphAgentErrorCode
+			if errors.Is(err, errMaxIterations) {
+				code = GraphMaxIterationsErrorCode
+			}
+			a.emitError(ctx, invocationCtx, eventChan, code, err)
+			return
+		}
+
+		// Execute after agent callback
+		if a.AfterAgentCallback != nil {
+			if err := a.runCallback(a.AfterAgentCallback, invocationCtx, eventChan); err != nil {
+				return
+			}
+		}
+	}()
+
+	return eventChan, nil
+}
+
+// run executes the graph step by step. Each step runs every node in the
+// frontier, then follows the satisfied outgoing edges to build the next one.
+// A failing node ends the graph with its error, and so does reaching
+// MaxIterations while nodes are still scheduled.
+func (a *GraphAgent) run(ctx context.Context, invocationCtx *InvocationContext, eventChan chan<- *events.Event) error {
+	frontier := []string{a.startNode()}
+
+	// Completed predecessors per waiting join node
+	arrivals := make(map[string]map[string]bool)
+
+	for iteration := 0; len(frontier) > 0; iteration++ {
+		if a.MaxIterations > 0 && iteration >= a.MaxIterations {
+			return fmt.Errorf("graph agent %s: %w after %d steps", a.Name, errMaxIterations, a.MaxIterations)
+		}
+		if ctx.Err() != nil || invocationCtx.InvocationEnded() {
+			return nil
+		}
+
+		lastEvents, err := a.runStep(ctx, invocationCtx, frontier, eventChan)
+		if err != nil {
+			return err
+		}
+
+		var next []string
+		scheduled := make(map[string]bool)
+		for _, name := range frontier {
+			for _, edge := range a.Edges {
+				if edge.From != name {
+					continue
+				}
+				if edge.Condition != nil && !edge.Condition(invocationCtx, lastEvents[name]) {
+					continue
+				}
+
+				if a.node(edge.To).Join {
+					if arrivals[edge.To] == nil {
+						arrivals[edge.To] = make(map[string]bool)
+					}
+					arrivals[edge.To][name] = true
+					if len(arrivals[edge.To]) < len(a.predecessors(edge.To)) {
+						continue
+					}
+					delete(arrivals, edge.To)
+				}
+
+				if !scheduled[edge.To] {
+					scheduled[edge.To] = true
+					next = append(next, edge.To)
+				}
+			}
+		}
+
+		// Nothing else can reach the waiting join nodes, so release them
+		// with whichever predecessors have completed
+		if len(next) == 0 {
+			for _, node := range a.Nodes {
+				if arrivals[node.Name] != nil {
+					delete(arrivals, node.Name)
+					next = append(next, node.Name)
+				}
+			}
+		}
+
+		frontier = next
+	}
+	return nil
+}
+
+// runStep runs the frontier nodes, in parallel branches when there are
+// several, and returns the last event emitted by each node
+func (a *GraphAgent) runStep(ctx context.Context, invocationCtx *InvocationContext, frontier []string, eventChan chan<- *events.Event) (map[string]*events.Event, error) {
+	lastEvents := make(map[string]*events.Event)
+
+	if len(frontier) == 1 {
+		last, err := a.runNode(ctx, invocationCtx, a.node(frontier[0]), eventChan)
+		lastEvents[frontier[0]] = last
+		return lastEvents, err
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for _, name := range frontier {
+		wg.Add(1)
+		go func(node *GraphNode) {
+			defer wg.Done()
+
+			branchCtx := invocationCtx.WithBranch(a.branchFor(invocationCtx, node.Name))
+			last, err := a.runNode(ctx, branchCtx, node, eventChan)
+
+			mu.Lock()
+			defer mu.Unlock()
+			lastEvents[node.Name] = last
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}(a.node(name))
+	}
+	wg.Wait()
+
+	return lastEvents, firstErr
+}
+
+// runNode runs a single node, forwards its events and returns the last one
+func (a *GraphAgent) runNode(ctx context.Context, invocationCtx *InvocationContext, node *GraphNode, eventChan chan<- *events.Event) (*events.Event, error) {
+	if node.Func != nil {
+		event, err := node.Func(ctx, invocationCtx)
+		if err != nil {
+			return nil, fmt.Errorf("graph node %s: %w", node.Name, err)
+		}
+		if event != nil {
+			if event.InvocationID == "" {
+				event.InvocationID = invocationCtx.InvocationID
+			}
+			if event.Author == "" {
+				event.Author = a.Name
+			}
+			if event.Branch == "" {
+				event.Branch = invocationCtx.Branch
+			}
+			eventChan <- event
+		}
+		return event, nil
+	}
+
+	subEventChan, err := node.Agent.RunAsync(ctx, invocationCtx)
+	if err != nil {
+		return nil, fmt.Errorf("graph node %s: %w", node.Name, err)
+	}
+
+	var last *events.Event
+	for event := range subEventChan {
+		if event.Branch == "" {
+			event.Branch = invocationCtx.Branch
+		}
+		eventChan <- event
+		last = event
+	}
+	return last, nil
+}
+
+// branchFor returns the branch path a parallel node runs in, extending the
+// current branch when the graph is nested in another agent's branch
+func (a *GraphAgent) branchFor(invocationCtx *InvocationContext, nodeName string) string {
+	if invocationCtx.Branch != "" {
+		return invocationCtx.Branch + "." + a.Name + "." + nodeName
+	}
+	return a.Name + "." + nodeName
+}
+
+// startNode returns the entry node name
+func (a *GraphAgent) startNode() string {
+	if a.Start == "" && len(a.Nodes) > 0 {
+		return a.Nodes[0].Name
+	}
+	return a.Start
+}
+
+// node finds a node by name
+func (a *GraphAgent) node(name string) *GraphNode {
+	for _, node := range a.Nodes {
+		if node.Name == name {
+			return node
+		}
+	}
+	return nil
+}
+
+// predecessors returns the distinct source nodes of edges into a node
+func (a *GraphAgent) predecessors(name string) map[string]bool {
+	sources := make(map[string]bool)
+	for _, edge := range a.Edges {
+		if edge.To == name {
+			sources[edge.From] = true
+		}
+	}
+	return sources
+}
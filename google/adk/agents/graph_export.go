@@ -0,0 +1,316 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agents
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// GraphFormat is a diagram format for ExportGraph
+type GraphFormat string
+
+const (
+	GraphFormatDOT     GraphFormat = "dot"
+	GraphFormatMermaid GraphFormat = "mermaid"
+)
+
+// diagramEdgeStyle distinguishes structural, tool and transfer edges
+type diagramEdgeStyle int
+
+const (
+	edgeStructure diagramEdgeStyle = iota
+	edgeTool
+	edgeTransfer
+)
+
+// diagramNode is a node of an exported diagram
+type diagramNode struct {
+	id    string
+	label string
+	kind  string
+}
+
+// diagramEdge is an edge of an exported diagram
+type diagramEdge struct {
+	from  string
+	to    string
+	label string
+	style diagramEdgeStyle
+}
+
+// diagram is the format-independent model of an agent tree diagram
+type diagram struct {
+	nodes []*diagramNode
+	edges []*diagramEdge
+}
+
+// nonIdentifierChars matches characters not allowed in diagram node IDs
+var nonIdentifierChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// ExportGraph renders an agent tree as a Graphviz DOT or Mermaid diagram.
+// Workflow agents are drawn with their semantics: numbered steps for
+// sequential and loop agents, fan-out for parallel agents, a back-edge for
+// loops, routes for router agents and the node graph of graph agents. Each
+// LLM agent's tools and the transfers it is allowed to make are shown too.
+func ExportGraph(root Agent, format GraphFormat) (string, error) {
+	d := &diagram{}
+	d.addAgent(root)
+
+	switch format {
+	case GraphFormatDOT:
+		return d.renderDOT(), nil
+	case GraphFormatMermaid:
+		return d.renderMermaid(), nil
+	default:
+		return "", fmt.Errorf("unsupported graph format: %s", format)
+	}
+}
+
+// nodeID returns the diagram node ID of an agent
+func nodeID(name string) string {
+	return nonIdentifierChars.ReplaceAllString(name, "_")
+}
+
+// agentKind returns a short label for an agent's type
+func agentKind(agent Agent) string {
+	switch agent.(type) {
+	case *LlmAgent:
+		return "llm"
+	case *SequentialAgent:
+		return "sequential"
+	case *ParallelAgent:
+		return "parallel"
+	case *LoopAgent:
+		return "loop"
+	case *RouterAgent:
+		return "router"
+	case *GraphAgent:
+		return "graph"
+	default:
+		return "custom"
+	}
+}
+
+// addAgent adds an agent, its tools and its subtree to the diagram
+func (d *diagram) addAgent(agent Agent) {
+	id := nodeID(agent.GetName())
+	d.nodes = append(d.nodes, &diagramNode{id: id, label: agent.GetName(), kind: agentKind(agent)})
+
+	subAgents := agent.GetSubAgents()
+	switch a := agent.(type) {
+	case *SequentialAgent:
+		for i, subAgent := range subAgents {
+			d.addEdge(id, subAgent, fmt.Sprintf("%d", i+1), edgeStructure)
+		}
+	case *ParallelAgent:
+		for _, subAgent := range subAgents {
+			d.addEdge(id, subAgent, "parallel", edgeStructure)
+		}
+	case *LoopAgent:
+		for i, subAgent := range subAgents {
+			d.addEdge(id, subAgent, fmt.Sprintf("%d", i+1), edgeStructure)
+		}
+		if len(subAgents) > 0 {
+			label := "repeat"
+			if a.MaxIterations > 0 {
+				label = fmt.Sprintf("repeat (max %d)", a.MaxIterations)
+			}
+			d.edges = append(d.edges, &diagramEdge{
+				from:  nodeID(subAgents[len(subAgents)-1].GetName()),
+				to:    nodeID(subAgents[0].GetName()),
+				label: label,
+				style: edgeStructure,
+			})
+		}
+	case *RouterAgent:
+		for _, subAgent := range subAgents {
+			label := "route"
+			if subAgent.GetName() == a.Fallback {
+				label = "fallback"
+			}
+			d.addEdge(id, subAgent, label, edgeStructure)
+		}
+	case *GraphAgent:
+		d.addGraphNodes(a)
+	case *LlmAgent:
+		for _, tool := range a.Tools {
+			toolID := id + "__tool__" + nodeID(tool.GetName())
+			d.nodes = append(d.nodes, &diagramNode{id: toolID, label: tool.GetName(), kind: "tool"})
+			d.edges = append(d.edges, &diagramEdge{from: id, to: toolID, style: edgeTool})
+		}
+		for _, subAgent := range subAgents {
+			d.addEdge(id, subAgent, "sub-agent", edgeStructure)
+		}
+		d.addTransfers(a)
+	default:
+		for _, subAgent := range subAgents {
+			d.addEdge(id, subAgent, "", edgeStructure)
+		}
+	}
+
+	for _, subAgent := range subAgents {
+		d.addAgent(subAgent)
+	}
+}
+
+// addEdge adds an edge from a node to an agent
+func (d *diagram) addEdge(from string, to Agent, label string, style diagramEdgeStyle) {
+	d.edges = append(d.edges, &diagramEdge{from: from, to: nodeID(to.GetName()), label: label, style: style})
+}
+
+// addGraphNodes adds the nodes and edges of a graph agent. Agent nodes are
+// added with the sub-agents; function nodes get IDs scoped to the graph.
+func (d *diagram) addGraphNodes(graph *GraphAgent) {
+	id := nodeID(graph.Name)
+	graphNodeID := func(name string) string {
+		if node := graph.node(name); node != nil && node.Agent != nil {
+			return nodeID(node.Agent.GetName())
+		}
+		return id + "__" + nodeID(name)
+	}
+
+	for _, node := range graph.Nodes {
+		if node.Func != nil {
+			d.nodes = append(d.nodes, &diagramNode{id: graphNodeID(node.Name), label: node.Name, kind: "function"})
+		}
+	}
+	if start := graph.startNode(); start != "" {
+		d.edges = append(d.edges, &diagramEdge{from: id, to: graphNodeID(start), label: "start", style: edgeStructure})
+	}
+	for _, edge := range graph.Edges {
+		label := ""
+		if edge.Condition != nil {
+			label = "if"
+		}
+		if target := graph.node(edge.To); target != nil && target.Join {
+			label = strings.TrimSpace(label + " join")
+		}
+		d.edges = append(d.edges, &diagramEdge{from: graphNodeID(edge.From), to: graphNodeID(edge.To), label: label, style: edgeStructure})
+	}
+}
+
+// addTransfers adds the transfers an LLM agent may make to its parent and
+// peers; transfers to sub-agents are implied by the sub-agent edges
+func (d *diagram) addTransfers(agent *LlmAgent) {
+	parent := agent.GetParentAgent()
+	if parent == nil {
+		return
+	}
+	id := nodeID(agent.Name)
+	if !agent.DisallowTransferToParent {
+		d.addEdge(id, parent, "transfer", edgeTransfer)
+	}
+	if !agent.DisallowTransferToPeers {
+		for _, peer := range parent.GetSubAgents() {
+			if !sameAgent(peer, agent) {
+				d.addEdge(id, peer, "transfer", edgeTransfer)
+			}
+		}
+	}
+}
+
+// renderDOT renders the diagram in Graphviz DOT syntax
+func (d *diagram) renderDOT() string {
+	var sb strings.Builder
+	sb.WriteString("digraph agents {\n")
+	sb.WriteString("  rankdir=TB;\n")
+	sb.WriteString("  node [fontname=\"Helvetica\"];\n")
+	sb.WriteString("  edge [fontname=\"Helvetica\", fontsize=10];\n")
+
+	for _, node := range d.nodes {
+		shape := "box"
+		label := fmt.Sprintf("%s\\n(%s)", dotEscape(node.label), node.kind)
+		switch node.kind {
+		case "tool":
+			shape = "note"
+			label = dotEscape(node.label)
+		case "function":
+			shape = "cds"
+			label = dotEscape(node.label)
+		case "llm":
+			shape = "ellipse"
+		}
+		sb.WriteString(fmt.Sprintf("  %q [label=\"%s\", shape=%s];\n", node.id, label, shape))
+	}
+
+	for _, edge := range d.edges {
+		attrs := make([]string, 0, 2)
+		if edge.label != "" {
+			attrs = append(attrs, fmt.Sprintf("label=\"%s\"", dotEscape(edge.label)))
+		}
+		switch edge.style {
+		case edgeTool:
+			attrs = append(attrs, "style=dashed", "arrowhead=none")
+		case edgeTransfer:
+			attrs = append(attrs, "style=dotted")
+		}
+		sb.WriteString(fmt.Sprintf("  %q -> %q", edge.from, edge.to))
+		if len(attrs) > 0 {
+			sb.WriteString(" [" + strings.Join(attrs, ", ") + "]")
+		}
+		sb.WriteString(";\n")
+	}
+
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+// renderMermaid renders the diagram as a Mermaid flowchart
+func (d *diagram) renderMermaid() string {
+	var sb strings.Builder
+	sb.WriteString("flowchart TD\n")
+
+	for _, node := range d.nodes {
+		switch node.kind {
+		case "tool":
+			sb.WriteString(fmt.Sprintf("  %s[/\"%s\"/]\n", node.id, mermaidEscape(node.label)))
+		case "function":
+			sb.WriteString(fmt.Sprintf("  %s{{\"%s\"}}\n", node.id, mermaidEscape(node.label)))
+		case "llm":
+			sb.WriteString(fmt.Sprintf("  %s([\"%s<br/>(%s)\"])\n", node.id, mermaidEscape(node.label), node.kind))
+		default:
+			sb.WriteString(fmt.Sprintf("  %s[\"%s<br/>(%s)\"]\n", node.id, mermaidEscape(node.label), node.kind))
+		}
+	}
+
+	for _, edge := range d.edges {
+		arrow := "-->"
+		switch edge.style {
+		case edgeTool:
+			arrow = "-.-"
+		case edgeTransfer:
+			arrow = "-.->"
+		}
+		if edge.label != "" {
+			sb.WriteString(fmt.Sprintf("  %s %s|\"%s\"| %s\n", edge.from, arrow, mermaidEscape(edge.label), edge.to))
+		} else {
+			sb.WriteString(fmt.Sprintf("  %s %s %s\n", edge.from, arrow, edge.to))
+		}
+	}
+
+	return sb.String()
+}
+
+// dotEscape escapes a string for use inside a quoted DOT label
+func dotEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
+
+// mermaidEscape escapes a string for use inside a quoted Mermaid label
+func mermaidEscape(s string) string {
+	return strings.ReplaceAll(s, `"`, "#quot;")
+}
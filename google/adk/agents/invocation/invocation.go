@@ -0,0 +1,394 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package invocation defines the context an agent invocation runs in and the
+// views of it handed to callbacks and tools. It sits below the agents and
+// tools packages so that both can refer to it without an import cycle.
+package invocation
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/adrienveepee/adk-go/google/adk/artifacts"
+	"github.com/adrienveepee/adk-go/google/adk/events"
+	"github.com/adrienveepee/adk-go/google/adk/memory"
+	"github.com/adrienveepee/adk-go/google/adk/models"
+	"github.com/adrienveepee/adk-go/google/adk/sessions"
+	"github.com/google/uuid"
+)
+
+// Agent is the interface that all agents must implement
+type Agent interface {
+	// GetName returns the agent's name
+	GetName() string
+
+	// GetDescription returns the agent's description
+	GetDescription() string
+
+	// RunAsync executes the agent asynchronously and returns events
+	RunAsync(ctx context.Context, invocationCtx *Context) (<-chan *events.Event, error)
+
+	// RunLive executes the agent in live mode (bidi-streaming)
+	RunLive(ctx context.Context, invocationCtx *Context) (<-chan *events.Event, error)
+
+	// GetSubAgents returns the list of sub-agents
+	GetSubAgents() []Agent
+
+	// FindAgent finds an agent by name in the hierarchy
+	FindAgent(name string) Agent
+
+	// FindSubAgent finds a direct sub-agent by name
+	FindSubAgent(name string) Agent
+
+	// GetParentAgent returns the parent agent
+	GetParentAgent() Agent
+
+	// SetParentAgent sets the parent agent
+	SetParentAgent(parent Agent)
+
+	// GetRootAgent returns the root agent in the hierarchy
+	GetRootAgent() Agent
+}
+
+// StreamingMode selects how model responses are streamed
+type StreamingMode string
+
+const (
+	// StreamingModeNone returns each model response in one piece
+	StreamingModeNone StreamingMode = "none"
+	// StreamingModeSSE streams partial model responses
+	StreamingModeSSE StreamingMode = "sse"
+	// StreamingModeBidi streams in both directions, as in live mode
+	StreamingModeBidi StreamingMode = "bidi"
+)
+
+// RunConfig configures a single invocation
+type RunConfig struct {
+	StreamingMode StreamingMode `json:"streaming_mode,omitempty"`
+
+	// MaxLLMCalls bounds the number of model calls in the invocation;
+	// 0 or less means unbounded
+	MaxLLMCalls int `json:"max_llm_calls,omitempty"`
+
+	// MaxToolCalls bounds the number of tool calls in the invocation;
+	// 0 or less means unbounded
+	MaxToolCalls int `json:"max_tool_calls,omitempty"`
+
+	// Timeout is the overall deadline of the invocation; 0 means none
+	Timeout time.Duration `json:"timeout,omitempty"`
+
+	// SaveInputBlobsAsArtifacts saves inline data of the user message as
+	// artifacts and replaces it with a reference to the artifact
+	SaveInputBlobsAsArtifacts bool `json:"save_input_blobs_as_artifacts,omitempty"`
+
+	// ResponseModalities and SpeechConfig are passed on to the model
+	ResponseModalities []string             `json:"response_modalities,omitempty"`
+	SpeechConfig       *models.SpeechConfig `json:"speech_config,omitempty"`
+}
+
+// LLMCallLimitExceededError is returned when an invocation makes more model
+// calls than RunConfig.MaxLLMCalls allows
+type LLMCallLimitExceededError struct {
+	Limit int
+}
+
+// Error describes the exceeded limit
+func (e *LLMCallLimitExceededError) Error() string {
+	return fmt.Sprintf("max number of llm calls exceeded: %d", e.Limit)
+}
+
+// ToolCallLimitExceededError is returned when an invocation makes more tool
+// calls than RunConfig.MaxToolCalls allows
+type ToolCallLimitExceededError struct {
+	Limit int
+}
+
+// Error describes the exceeded limit
+func (e *ToolCallLimitExceededError) Error() string {
+	return fmt.Sprintf("max number of tool calls exceeded: %d", e.Limit)
+}
+
+// Context provides the context for agent invocation. Copies made with
+// WithBranch or WithAgent share the invocation-wide end flag and counters.
+type Context struct {
+	// InvocationID identifies the invocation; it is set on emitted events
+	InvocationID string
+
+	// Agent is the agent currently running
+	Agent Agent
+
+	// Branch is the dot-separated path of the agent branch this invocation
+	// runs in, e.g. "parent.child". Empty for the root branch.
+	Branch string
+
+	// UserContent is the user message that started the invocation
+	UserContent *events.Content
+
+	Session sessions.Session
+
+	// Services available to agents, callbacks and tools
+	SessionService  sessions.SessionService
+	ArtifactService artifacts.ArtifactService
+	MemoryService   memory.MemoryService
+
+	RunConfig *RunConfig
+
+	// LiveRequestQueue carries the caller's input in live mode
+	LiveRequestQueue *LiveRequestQueue
+
+	shared *sharedState
+}
+
+// sharedState is the invocation-wide state shared by all context copies
+type sharedState struct {
+	mu            sync.Mutex
+	endInvocation bool
+	llmCalls      int
+	toolCalls     int
+}
+
+// NewInvocationID generates a new invocation ID
+func NewInvocationID() string {
+	return "e-" + uuid.New().String()
+}
+
+// state returns the shared state, creating it on first use
+func (c *Context) state() *sharedState {
+	if c.shared == nil {
+		c.shared = &sharedState{}
+	}
+	return c.shared
+}
+
+// WithBranch returns a shallow copy of the invocation context running in the
+// given branch
+func (c *Context) WithBranch(branch string) *Context {
+	c.state()
+	branched := *c
+	branched.Branch = branch
+	return &branched
+}
+
+// WithAgent returns a shallow copy of the invocation context for the given
+// agent
+func (c *Context) WithAgent(agent Agent) *Context {
+	c.state()
+	scoped := *c
+	scoped.Agent = agent
+	return &scoped
+}
+
+// EndInvocation marks the invocation as ended; agents stop at the next
+// opportunity
+func (c *Context) EndInvocation() {
+	shared := c.state()
+	shared.mu.Lock()
+	defer shared.mu.Unlock()
+	shared.endInvocation = true
+}
+
+// InvocationEnded reports whether the invocation has been ended
+func (c *Context) InvocationEnded() bool {
+	shared := c.state()
+	shared.mu.Lock()
+	defer shared.mu.Unlock()
+	return shared.endInvocation
+}
+
+// IncrementLLMCallCount records a model call and returns an
+// LLMCallLimitExceededError once RunConfig.MaxLLMCalls is exceeded
+func (c *Context) IncrementLLMCallCount() error {
+	shared := c.state()
+	shared.mu.Lock()
+	defer shared.mu.Unlock()
+	shared.llmCalls++
+	if c.RunConfig != nil && c.RunConfig.MaxLLMCalls > 0 && shared.llmCalls > c.RunConfig.MaxLLMCalls {
+		return &LLMCallLimitExceededError{Limit: c.RunConfig.MaxLLMCalls}
+	}
+	return nil
+}
+
+// LLMCallCount returns the number of model calls made in the invocation
+func (c *Context) LLMCallCount() int {
+	shared := c.state()
+	shared.mu.Lock()
+	defer shared.mu.Unlock()
+	return shared.llmCalls
+}
+
+// IncrementToolCallCount records a tool call and returns a
+// ToolCallLimitExceededError once RunConfig.MaxToolCalls is exceeded
+func (c *Context) IncrementToolCallCount() error {
+	shared := c.state()
+	shared.mu.Lock()
+	defer shared.mu.Unlock()
+	shared.toolCalls++
+	if c.RunConfig != nil && c.RunConfig.MaxToolCalls > 0 && shared.toolCalls > c.RunConfig.MaxToolCalls {
+		return &ToolCallLimitExceededError{Limit: c.RunConfig.MaxToolCalls}
+	}
+	return nil
+}
+
+// ToolCallCount returns the number of tool calls made in the invocation
+func (c *Context) ToolCallCount() int {
+	shared := c.state()
+	shared.mu.Lock()
+	defer shared.mu.Unlock()
+	return shared.toolCalls
+}
+
+// StreamingMode returns the invocation's streaming mode
+func (c *Context) StreamingMode() StreamingMode {
+	if c.RunConfig == nil || c.RunConfig.StreamingMode == "" {
+		return StreamingModeNone
+	}
+	return c.RunConfig.StreamingMode
+}
+
+// ReadonlyContext is a read-only view of an invocation, e.g. for instruction
+// providers
+type ReadonlyContext struct {
+	invocationCtx *Context
+}
+
+// NewReadonlyContext creates a read-only view of an invocation context
+func NewReadonlyContext(invocationCtx *Context) *ReadonlyContext {
+	return &ReadonlyContext{invocationCtx: invocationCtx}
+}
+
+// InvocationID returns the invocation ID
+func (c *ReadonlyContext) InvocationID() string {
+	return c.invocationCtx.InvocationID
+}
+
+// AgentName returns the name of the running agent
+func (c *ReadonlyContext) AgentName() string {
+	if c.invocationCtx.Agent == nil {
+		return ""
+	}
+	return c.invocationCtx.Agent.GetName()
+}
+
+// Branch returns the branch the agent runs in
+func (c *ReadonlyContext) Branch() string {
+	return c.invocationCtx.Branch
+}
+
+// UserContent returns the user message that started the invocation
+func (c *ReadonlyContext) UserContent() *events.Content {
+	return c.invocationCtx.UserContent
+}
+
+// State returns a copy of the session state
+func (c *ReadonlyContext) State() map[string]interface{} {
+	if c.invocationCtx.Session.State == nil {
+		return map[string]interface{}{}
+	}
+	return c.invocationCtx.Session.State.ToDict()
+}
+
+// UserID returns the ID of the user owning the session
+func (c *ReadonlyContext) UserID() string {
+	return c.invocationCtx.Session.UserID
+}
+
+// SessionID returns the session ID
+func (c *ReadonlyContext) SessionID() string {
+	return c.invocationCtx.Session.ID
+}
+
+// CallbackContext is a mutable view of an invocation for agent and model
+// callbacks. Saved artifacts are recorded in EventActions.
+type CallbackContext struct {
+	*ReadonlyContext
+	EventActions *events.EventActions
+}
+
+// NewCallbackContext creates a mutable view of an invocation context
+func NewCallbackContext(invocationCtx *Context) *CallbackContext {
+	return &CallbackContext{
+		ReadonlyContext: NewReadonlyContext(invocationCtx),
+		EventActions:    &events.EventActions{},
+	}
+}
+
+// State returns the mutable session state. Changes are recorded in
+// EventActions.StateDelta, so that they are persisted with the event of this
+// callback or tool even when other branches change the state concurrently;
+// use the sessions.AppPrefix, UserPrefix and TempPrefix key prefixes to
+// change their scope.
+func (c *CallbackContext) State() *sessions.State {
+	if c.invocationCtx.Session.State == nil {
+		c.invocationCtx.Session.State = sessions.NewState()
+	}
+	if c.EventActions.StateDelta == nil {
+		c.EventActions.StateDelta = make(map[string]interface{})
+	}
+	return c.invocationCtx.Session.State.WithDelta(c.EventActions.StateDelta)
+}
+
+// EndInvocation ends the invocation once the current agent returns
+func (c *CallbackContext) EndInvocation() {
+	c.invocationCtx.EndInvocation()
+}
+
+// SaveArtifact saves an artifact and records it in the artifact delta
+func (c *CallbackContext) SaveArtifact(ctx context.Context, key string, data []byte, metadata map[string]interface{}) error {
+	service := c.invocationCtx.ArtifactService
+	if service == nil {
+		return fmt.Errorf("artifact service is not initialized")
+	}
+	if err := service.SaveArtifact(ctx, key, data, metadata); err != nil {
+		return err
+	}
+	versions, err := service.ListVersions(ctx, key)
+	if err != nil {
+		return err
+	}
+	if c.EventActions.ArtifactDelta == nil {
+		c.EventActions.ArtifactDelta = make(map[string]interface{})
+	}
+	c.EventActions.ArtifactDelta[key] = len(versions)
+	return nil
+}
+
+// LoadArtifact loads the latest version of an artifact
+func (c *CallbackContext) LoadArtifact(ctx context.Context, key string) ([]byte, error) {
+	service := c.invocationCtx.ArtifactService
+	if service == nil {
+		return nil, fmt.Errorf("artifact service is not initialized")
+	}
+	return service.LoadArtifact(ctx, key)
+}
+
+// ListArtifacts lists the keys of all artifacts
+func (c *CallbackContext) ListArtifacts(ctx context.Context) ([]string, error) {
+	service := c.invocationCtx.ArtifactService
+	if service == nil {
+		return nil, fmt.Errorf("artifact service is not initialized")
+	}
+	return service.ListArtifactKeys(ctx)
+}
+
+// SearchMemory searches the memory of the session's user
+func (c *CallbackContext) SearchMemory(ctx context.Context, query string) (*memory.SearchMemoryResponse, error) {
+	service := c.invocationCtx.MemoryService
+	if service == nil {
+		return nil, fmt.Errorf("memory service is not initialized")
+	}
+	return service.SearchMemory(ctx, c.invocationCtx.Session.AppName, c.invocationCtx.Session.UserID, query)
+}
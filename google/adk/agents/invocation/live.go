@@ -0,0 +1,127 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package invocation
+
+import (
+	"context"
+	"sync"
+
+	"github.com/adrienveepee/adk-go/google/adk/events"
+)
+
+// LiveRequest is one input sent to an agent in live mode. Exactly one of its
+// fields is expected to be set.
+type LiveRequest struct {
+	// Content is a complete user turn, e.g. text
+	Content *events.Content `json:"content,omitempty"`
+
+	// Blob is a chunk of realtime input such as audio or an image frame
+	Blob *events.Blob `json:"blob,omitempty"`
+
+	// ActivityStart and ActivityEnd mark the start and end of user activity,
+	// e.g. speech; starting an activity interrupts the model
+	ActivityStart bool `json:"activity_start,omitempty"`
+	ActivityEnd   bool `json:"activity_end,omitempty"`
+
+	// Close ends the live session
+	Close bool `json:"close,omitempty"`
+}
+
+// LiveRequestQueue carries the caller's input to an agent running in live
+// mode. Sending never blocks; requests sent after Close are dropped.
+type LiveRequestQueue struct {
+	mu      sync.Mutex
+	pending []*LiveRequest
+	closed  bool
+	ready   chan struct{}
+}
+
+// NewLiveRequestQueue creates an empty live request queue
+func NewLiveRequestQueue() *LiveRequestQueue {
+	return &LiveRequestQueue{
+		ready: make(chan struct{}, 1),
+	}
+}
+
+// Send queues a request
+func (q *LiveRequestQueue) Send(request *LiveRequest) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return
+	}
+	if request.Close {
+		q.closed = true
+	}
+	q.pending = append(q.pending, request)
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// SendContent queues a complete user turn
+func (q *LiveRequestQueue) SendContent(content *events.Content) {
+	q.Send(&LiveRequest{Content: content})
+}
+
+// SendRealtime queues a chunk of realtime input
+func (q *LiveRequestQueue) SendRealtime(blob *events.Blob) {
+	q.Send(&LiveRequest{Blob: blob})
+}
+
+// SendActivityStart signals that the user started speaking or acting
+func (q *LiveRequestQueue) SendActivityStart() {
+	q.Send(&LiveRequest{ActivityStart: true})
+}
+
+// SendActivityEnd signals that the user stopped speaking or acting
+func (q *LiveRequestQueue) SendActivityEnd() {
+	q.Send(&LiveRequest{ActivityEnd: true})
+}
+
+// Close ends the live session once the requests sent before are handled
+func (q *LiveRequestQueue) Close() {
+	q.Send(&LiveRequest{Close: true})
+}
+
+// Get returns the next request, waiting for one until the context is done
+func (q *LiveRequestQueue) Get(ctx context.Context) (*LiveRequest, error) {
+	for {
+		q.mu.Lock()
+		if len(q.pending) > 0 {
+			request := q.pending[0]
+			q.pending = q.pending[1:]
+			if len(q.pending) > 0 {
+				// Keep the signal for the remaining requests
+				select {
+				case q.ready <- struct{}{}:
+				default:
+				}
+			}
+			q.mu.Unlock()
+			return request, nil
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
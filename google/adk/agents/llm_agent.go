@@ -16,10 +16,15 @@ package agents
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sync"
 
+	"github.com/adrienveepee/adk-go/google/adk/agents/invocation"
 	"github.com/adrienveepee/adk-go/google/adk/events"
 	"github.com/adrienveepee/adk-go/google/adk/models"
 	"github.com/adrienveepee/adk-go/google/adk/tools"
+	"github.com/google/uuid"
 )
 
 // IncludeContents determines how conversation history is included
@@ -30,6 +35,14 @@ const (
 	IncludeContentsNone    IncludeContents = "none"
 )
 
+// LiveErrorCode is the error code of the event emitted when a live session
+// ends because of an error
+const LiveErrorCode = "LIVE_ERROR"
+
+// LlmAgentErrorCode is the error code of the event emitted when an LLM
+// agent's run fails, other than by exceeding a limit of the run
+const LlmAgentErrorCode = "LLM_AGENT_ERROR"
+
 // LlmAgent represents an agent powered by a Large Language Model
 type LlmAgent struct {
 	*BaseAgent
@@ -56,11 +69,14 @@ type LlmAgent struct {
 	DisallowTransferToParent bool `json:"disallow_transfer_to_parent,omitempty"`
 	DisallowTransferToPeers  bool `json:"disallow_transfer_to_peers,omitempty"`
 	
+	// History compaction for long conversations
+	Compaction *CompactionConfig `json:"compaction,omitempty"`
+	
 	// Callbacks
-	BeforeModelCallback func(*InvocationContext) error `json:"-"`
-	AfterModelCallback  func(*InvocationContext) error `json:"-"`
-	BeforeToolCallback  func(*InvocationContext) error `json:"-"`
-	AfterToolCallback   func(*InvocationContext) error `json:"-"`
+	BeforeModelCallback func(*CallbackContext) error    `json:"-"`
+	AfterModelCallback  func(*CallbackContext) error    `json:"-"`
+	BeforeToolCallback  func(*tools.ToolContext) error `json:"-"`
+	AfterToolCallback   func(*tools.ToolContext) error `json:"-"`
 	
 	// Internal
 	llm models.LLM `json:"-"`
@@ -68,7 +84,7 @@ type LlmAgent struct {
 
 // NewLlmAgent creates a new LLM agent
 func NewLlmAgent(name, model, instruction string) *LlmAgent {
-	return &LlmAgent{
+	agent := &LlmAgent{
 		BaseAgent:       NewBaseAgent(name, ""),
 		Model:           model,
 		Instruction:     instruction,
@@ -76,6 +92,8 @@ func NewLlmAgent(name, model, instruction string) *LlmAgent {
 		Tools:           make([]tools.Tool, 0),
 		Examples:        make([]interface{}, 0),
 	}
+	agent.self = agent
+	return agent
 }
 
 // NewAgent is an alias for NewLlmAgent for convenience
@@ -133,15 +151,20 @@ func (a *LlmAgent) SetIncludeContents(includeContents IncludeContents) *LlmAgent
 
 // GetCanonicalModel returns the resolved LLM model
 func (a *LlmAgent) GetCanonicalModel() models.LLM {
+	llm, _ := a.resolveModel()
+	return llm
+}
+
+// resolveModel resolves the agent's model, reporting why it cannot be
+func (a *LlmAgent) resolveModel() (models.LLM, error) {
 	if a.llm == nil {
 		llm, err := models.Resolve(a.Model)
 		if err != nil {
-			// TODO: Better error handling
-			return nil
+			return nil, fmt.Errorf("agent %s: %w", a.Name, err)
 		}
 		a.llm = llm
 	}
-	return a.llm
+	return a.llm, nil
 }
 
 // GetCanonicalInstruction returns the complete instruction including global instruction
@@ -169,6 +192,11 @@ func (a *LlmAgent) GetCanonicalTools() []tools.Tool {
 
 // RunAsync executes the LLM agent asynchronously
 func (a *LlmAgent) RunAsync(ctx context.Context, invocationCtx *InvocationContext) (<-chan *events.Event, error) {
+	if err := a.validate(); err != nil {
+		return nil, err
+	}
+	
+	invocationCtx = invocationCtx.WithAgent(a)
 	eventChan := make(chan *events.Event)
 	
 	go func() {
@@ -176,74 +204,267 @@ func (a *LlmAgent) RunAsync(ctx context.Context, invocationCtx *InvocationContex
 		
 		// Execute before agent callback
 		if a.BeforeAgentCallback != nil {
-			if err := a.BeforeAgentCallback(invocationCtx); err != nil {
-				// TODO: Better error handling
-				return
-			}
-		}
-		
-		// Execute before model callback
-		if a.BeforeModelCallback != nil {
-			if err := a.BeforeModelCallback(invocationCtx); err != nil {
-				// TODO: Better error handling
+			if err := a.runCallback(a.BeforeAgentCallback, invocationCtx, eventChan); err != nil {
+				a.emitError(ctx, invocationCtx, eventChan, LlmAgentErrorCode, err)
 				return
 			}
 		}
 		
 		// Get LLM model
-		llm := a.GetCanonicalModel()
-		if llm == nil {
-			// TODO: Better error handling
+		llm, err := a.resolveModel()
+		if err != nil {
+			a.emitError(ctx, invocationCtx, eventChan, LlmAgentErrorCode, err)
 			return
 		}
 		
-		// Build LLM request
-		request := a.buildLLMRequest(invocationCtx)
+		// Call the model until it answers without requesting tools. Events
+		// of this run are not in the session yet, so they are carried along.
+		var turnEvents []*events.Event
 		
-		// Generate content
-		responseEventChan, err := llm.GenerateContentAsync(ctx, request)
+		// Run or reject the tool calls the user has answered confirmation
+		// requests for
+		confirmedEvent, err := a.processConfirmations(ctx, invocationCtx)
 		if err != nil {
-			// TODO: Better error handling
+			a.emitError(ctx, invocationCtx, eventChan, LlmAgentErrorCode, err)
 			return
 		}
+		if confirmedEvent != nil {
+			eventChan <- confirmedEvent
+			turnEvents = append(turnEvents, confirmedEvent)
+		}
 		
-		// Process events and handle tool calls
-		for event := range responseEventChan {
-			// Process tool calls if any
-			if a.hasToolCalls(event) {
-				toolEvents, err := a.processToolCalls(ctx, event, invocationCtx)
-				if err != nil {
-					// TODO: Better error handling
-					continue
-				}
-				
-				// Forward tool events
-				for _, toolEvent := range toolEvents {
-					eventChan <- toolEvent
-				}
-			} else {
-				// Handle output key storage
-				if a.OutputKey != "" && event.IsFinalResponse && event.Content != nil {
-					a.storeOutputInSession(event, invocationCtx)
+		for {
+			functionCallEvent, produced, err := a.callModel(ctx, llm, invocationCtx, turnEvents, eventChan)
+			if err != nil {
+				a.emitError(ctx, invocationCtx, eventChan, LlmAgentErrorCode, err)
+				return
+			}
+			turnEvents = append(turnEvents, produced...)
+			if functionCallEvent == nil {
+				break
+			}
+			
+			responseEvent, confirmationEvent, err := a.processToolCalls(ctx, functionCallEvent, invocationCtx)
+			if err != nil {
+				a.emitError(ctx, invocationCtx, eventChan, LlmAgentErrorCode, err)
+				return
+			}
+			if responseEvent != nil {
+				eventChan <- responseEvent
+				turnEvents = append(turnEvents, responseEvent)
+			}
+			
+			// Long-running tools and confirmation requests pause the
+			// invocation until the runner resumes it with their results
+			if confirmationEvent != nil {
+				eventChan <- confirmationEvent
+			}
+			if len(functionCallEvent.LongRunningToolIDs) > 0 || confirmationEvent != nil {
+				invocationCtx.EndInvocation()
+				break
+			}
+			if responseEvent == nil {
+				break
+			}
+			if responseEvent.Actions.SkipSummarization || responseEvent.Actions.TransferToAgent != "" {
+				break
+			}
+		}
+		
+		// Execute after agent callback
+		if a.AfterAgentCallback != nil {
+			if err := a.runCallback(a.AfterAgentCallback, invocationCtx, eventChan); err != nil {
+				a.emitError(ctx, invocationCtx, eventChan, LlmAgentErrorCode, err)
+				return
+			}
+		}
+	}()
+	
+	return eventChan, nil
+}
+
+// validate checks the agent's compaction config
+func (a *LlmAgent) validate() error {
+	if a.Compaction != nil {
+		if err := a.Compaction.Validate(); err != nil {
+			return fmt.Errorf("agent %s: %w", a.Name, err)
+		}
+	}
+	return nil
+}
+
+// callModel makes one model call and forwards its events. It returns the
+// event requesting tool calls, if any, and all events the model produced.
+func (a *LlmAgent) callModel(ctx context.Context, llm models.LLM, invocationCtx *InvocationContext, turnEvents []*events.Event, eventChan chan<- *events.Event) (*events.Event, []*events.Event, error) {
+	// Execute before model callback
+	if a.BeforeModelCallback != nil {
+		if err := a.runCallback(a.BeforeModelCallback, invocationCtx, eventChan); err != nil {
+			return nil, nil, err
+		}
+	}
+	
+	// A callback may have ended the invocation, or its deadline passed
+	if invocationCtx.InvocationEnded() {
+		return nil, nil, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	
+	// Enforce the invocation's model call budget
+	if err := invocationCtx.IncrementLLMCallCount(); err != nil {
+		return nil, nil, err
+	}
+	
+	// Summarize older history into the session when it is over budget. The
+	// summary counts as a model call, so running out of calls ends the run.
+	var produced []*events.Event
+	compactionEvent, summaryErr := a.summarizeHistory(ctx, invocationCtx, turnEvents)
+	var limitExceeded *invocation.LLMCallLimitExceededError
+	if errors.As(summaryErr, &limitExceeded) {
+		return nil, nil, summaryErr
+	}
+	if compactionEvent != nil {
+		eventChan <- compactionEvent
+		produced = append(produced, compactionEvent)
+		turnEvents = append(turnEvents, compactionEvent)
+	}
+	
+	// Build LLM request; without a summary, send the most recent events
+	// rather than the whole history
+	request := a.buildLLMRequest(invocationCtx, turnEvents)
+	if summaryErr != nil && a.IncludeContents == IncludeContentsDefault {
+		request.Contents = a.compactedContents(invocationCtx, turnEvents, CompactionSlidingWindow)
+	}
+	
+	// Generate content
+	responseEventChan, err := llm.GenerateContentAsync(ctx, request)
+	if err != nil {
+		return nil, nil, err
+	}
+	
+	// Process events and handle tool calls
+	var functionCallEvent *events.Event
+	for event := range responseEventChan {
+		event.InvocationID = invocationCtx.InvocationID
+		event.Author = a.Name
+		event.Branch = invocationCtx.Branch
+		
+		if a.hasToolCalls(event) {
+			// Responses are matched to calls by ID, so every call needs one
+			for _, call := range event.GetFunctionCalls() {
+				if call.ID == "" {
+					call.ID = "adk-" + uuid.New().String()
 				}
-				
-				// Forward the event
-				eventChan <- event
 			}
+			event.LongRunningToolIDs = a.longRunningCallIDs(event)
+			functionCallEvent = event
+		} else if a.OutputKey != "" && event.IsFinalResponse && event.Content != nil {
+			// Handle output key storage
+			a.storeOutputInSession(event, invocationCtx)
+		}
+		
+		// Forward the event
+		eventChan <- event
+		produced = append(produced, event)
+	}
+	
+	// Execute after model callback
+	if a.AfterModelCallback != nil {
+		if err := a.runCallback(a.AfterModelCallback, invocationCtx, eventChan); err != nil {
+			return nil, nil, err
+		}
+	}
+	
+	return functionCallEvent, produced, nil
+}
+
+// RunLive executes the LLM agent over a live connection to the model. The
+// caller's input is read from the invocation's LiveRequestQueue until it is
+// closed; tool calls are answered as they stream in and partial responses
+// are forwarded as they arrive. Tools requiring confirmation are not
+// supported: calling one ends the session with an error event, as does a
+// failing tool call.
+func (a *LlmAgent) RunLive(ctx context.Context, invocationCtx *InvocationContext) (<-chan *events.Event, error) {
+	queue := invocationCtx.LiveRequestQueue
+	if queue == nil {
+		return nil, fmt.Errorf("agent %s: live mode requires a live request queue", a.Name)
+	}
+	if err := a.validate(); err != nil {
+		return nil, err
+	}
+	
+	invocationCtx = invocationCtx.WithAgent(a)
+	eventChan := make(chan *events.Event)
+	
+	go func() {
+		defer close(eventChan)
+		
+		// Execute before agent callback
+		if a.BeforeAgentCallback != nil {
+			if err := a.runCallback(a.BeforeAgentCallback, invocationCtx, eventChan); err != nil {
+				a.emitError(ctx, invocationCtx, eventChan, LiveErrorCode, err)
+				return
+			}
+		}
+		
+		// Get LLM model
+		llm, err := a.resolveModel()
+		if err != nil {
+			a.emitError(ctx, invocationCtx, eventChan, LiveErrorCode, err)
+			return
 		}
 		
-		// Execute after model callback
-		if a.AfterModelCallback != nil {
-			if err := a.AfterModelCallback(invocationCtx); err != nil {
-				// TODO: Better error handling
+		// Execute before model callback
+		if a.BeforeModelCallback != nil {
+			if err := a.runCallback(a.BeforeModelCallback, invocationCtx, eventChan); err != nil {
+				a.emitError(ctx, invocationCtx, eventChan, LiveErrorCode, err)
 				return
 			}
 		}
+		if invocationCtx.InvocationEnded() {
+			return
+		}
+		
+		// The live session counts as a single model call
+		if err := invocationCtx.IncrementLLMCallCount(); err != nil {
+			a.emitError(ctx, invocationCtx, eventChan, LiveErrorCode, err)
+			return
+		}
+		
+		request := a.buildLLMRequest(invocationCtx, nil)
+		connection, err := llm.Connect(ctx, request)
+		if err != nil {
+			a.emitError(ctx, invocationCtx, eventChan, LiveErrorCode, err)
+			return
+		}
+		defer connection.Close()
+		
+		if err := connection.SendHistory(ctx, request.Contents); err != nil {
+			a.emitError(ctx, invocationCtx, eventChan, LiveErrorCode, err)
+			return
+		}
+		
+		// Forward the caller's input while receiving the model's output
+		sendCtx, stopSending := context.WithCancel(ctx)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.sendLiveRequests(sendCtx, queue, connection, invocationCtx, eventChan)
+		}()
+		
+		err = a.receiveLive(ctx, connection, invocationCtx, eventChan)
+		stopSending()
+		wg.Wait()
+		if err != nil {
+			return
+		}
 		
 		// Execute after agent callback
 		if a.AfterAgentCallback != nil {
-			if err := a.AfterAgentCallback(invocationCtx); err != nil {
-				// TODO: Better error handling
+			if err := a.runCallback(a.AfterAgentCallback, invocationCtx, eventChan); err != nil {
+				a.emitError(ctx, invocationCtx, eventChan, LiveErrorCode, err)
 				return
 			}
 		}
@@ -252,15 +473,148 @@ func (a *LlmAgent) RunAsync(ctx context.Context, invocationCtx *InvocationContex
 	return eventChan, nil
 }
 
-// buildLLMRequest builds the LLM request from the agent configuration
-func (a *LlmAgent) buildLLMRequest(invocationCtx *InvocationContext) *models.LLMRequest {
+// sendLiveRequests forwards requests from the queue to the model until the
+// queue is closed. User turns are emitted as events so that they are
+// recorded in the session.
+func (a *LlmAgent) sendLiveRequests(ctx context.Context, queue *LiveRequestQueue, connection models.LLMConnection, invocationCtx *InvocationContext, eventChan chan<- *events.Event) {
+	for {
+		request, err := queue.Get(ctx)
+		if err != nil {
+			return
+		}
+		
+		switch {
+		case request.Close:
+			connection.Close()
+			return
+		case request.ActivityStart:
+			err = connection.SendActivityStart(ctx)
+		case request.ActivityEnd:
+			err = connection.SendActivityEnd(ctx)
+		case request.Blob != nil:
+			err = connection.SendRealtime(ctx, request.Blob)
+		case request.Content != nil:
+			userEvent := events.NewEvent()
+			userEvent.InvocationID = invocationCtx.InvocationID
+			userEvent.Author = "user"
+			userEvent.Branch = invocationCtx.Branch
+			userEvent.Content = request.Content
+			select {
+			case eventChan <- userEvent:
+			case <-ctx.Done():
+				return
+			}
+			err = connection.SendContent(ctx, request.Content)
+		}
+		
+		if err != nil {
+			a.emitError(ctx, invocationCtx, eventChan, LiveErrorCode, err)
+			connection.Close()
+			return
+		}
+	}
+}
+
+// receiveLive forwards the model's output until the connection closes,
+// running the tools it calls and sending their responses back. A failure is
+// reported in an error event and closes the connection; the rest of the
+// model's output is then dropped and the error returned.
+func (a *LlmAgent) receiveLive(ctx context.Context, connection models.LLMConnection, invocationCtx *InvocationContext, eventChan chan<- *events.Event) error {
+	fail := func(err error) error {
+		a.emitError(ctx, invocationCtx, eventChan, LiveErrorCode, err)
+		connection.Close()
+		for range connection.Receive() {
+		}
+		return err
+	}
+	
+	for event := range connection.Receive() {
+		event.InvocationID = invocationCtx.InvocationID
+		event.Author = a.Name
+		event.Branch = invocationCtx.Branch
+		
+		hasToolCalls := !event.Partial && a.hasToolCalls(event)
+		if hasToolCalls {
+			// Responses are matched to calls by ID, so every call needs one
+			for _, call := range event.GetFunctionCalls() {
+				if call.ID == "" {
+					call.ID = "adk-" + uuid.New().String()
+				}
+			}
+		} else if a.OutputKey != "" && event.IsFinalResponse && event.Content != nil {
+			// Handle output key storage
+			a.storeOutputInSession(event, invocationCtx)
+		}
+		
+		// Forward the event
+		eventChan <- event
+		
+		if event.TurnComplete && a.AfterModelCallback != nil {
+			if err := a.runCallback(a.AfterModelCallback, invocationCtx, eventChan); err != nil {
+				return fail(err)
+			}
+		}
+		
+		if !hasToolCalls {
+			continue
+		}
+		
+		// Confirmations are answered through the session, which a live
+		// session does not read back
+		for _, call := range event.GetFunctionCalls() {
+			if a.requiresConfirmation(call) {
+				return fail(fmt.Errorf("agent %s: tool %s requires confirmation, which live mode does not support", a.Name, call.Name))
+			}
+		}
+		
+		responseEvent, _, err := a.processToolCalls(ctx, event, invocationCtx)
+		if err != nil {
+			return fail(err)
+		}
+		if responseEvent == nil {
+			continue
+		}
+		eventChan <- responseEvent
+		
+		// A transfer hands the conversation over, ending this live session
+		if responseEvent.Actions.TransferToAgent != "" {
+			connection.Close()
+			continue
+		}
+		if err := connection.SendContent(ctx, responseEvent.Content); err != nil {
+			return fail(err)
+		}
+	}
+	return nil
+}
+
+// buildLLMRequest builds the LLM request from the agent configuration and
+// the events of the current run not yet in the session
+func (a *LlmAgent) buildLLMRequest(invocationCtx *InvocationContext, turnEvents []*events.Event) *models.LLMRequest {
 	request := &models.LLMRequest{
 		Config: a.GenerateContentConfig,
+		Stream: invocationCtx.StreamingMode() == invocation.StreamingModeSSE,
+	}
+	
+	// Apply the invocation's output settings without changing the agent's
+	// own configuration
+	if runConfig := invocationCtx.RunConfig; runConfig != nil && (len(runConfig.ResponseModalities) > 0 || runConfig.SpeechConfig != nil) {
+		config := models.GenerateContentConfig{}
+		if a.GenerateContentConfig != nil {
+			config = *a.GenerateContentConfig
+		}
+		if len(runConfig.ResponseModalities) > 0 {
+			config.ResponseModalities = runConfig.ResponseModalities
+		}
+		if runConfig.SpeechConfig != nil {
+			config.SpeechConfig = runConfig.SpeechConfig
+		}
+		request.Config = &config
 	}
 	
 	// Add conversation history if requested
 	if a.IncludeContents == IncludeContentsDefault {
-		request.Contents = a.buildContents(invocationCtx)
+		request.Contents = a.buildContents(invocationCtx, turnEvents)
 	} else {
 		// Include only the instruction as system message
 		request.Contents = []*events.Content{
@@ -271,6 +625,11 @@ func (a *LlmAgent) buildLLMRequest(invocationCtx *InvocationContext) *models.LLM
 				},
 			},
 		}
+		for _, event := range turnEvents {
+			if event.Content != nil {
+				request.Contents = append(request.Contents, event.Content)
+			}
+		}
 	}
 	
 	// Add tools
@@ -283,8 +642,19 @@ func (a *LlmAgent) buildLLMRequest(invocationCtx *InvocationContext) *models.LLM
 	return request
 }
 
-// buildContents builds the conversation contents from session history
-func (a *LlmAgent) buildContents(invocationCtx *InvocationContext) []*events.Content {
+// buildContents builds the conversation contents from session history and
+// the events of the current run, compacted if over the agent's budget
+func (a *LlmAgent) buildContents(invocationCtx *InvocationContext, turnEvents []*events.Event) []*events.Content {
+	var strategy CompactionStrategy
+	if a.Compaction != nil {
+		strategy = a.Compaction.Strategy
+	}
+	return a.compactedContents(invocationCtx, turnEvents, strategy)
+}
+
+// compactedContents builds the conversation contents, compacting them with
+// the given strategy if over the agent's budget
+func (a *LlmAgent) compactedContents(invocationCtx *InvocationContext, turnEvents []*events.Event, strategy CompactionStrategy) []*events.Content {
 	contents := make([]*events.Content, 0)
 	
 	// Add system instruction
@@ -297,30 +667,293 @@ func (a *LlmAgent) buildContents(invocationCtx *InvocationContext) []*events.Con
 		})
 	}
 	
-	// Add session events as conversation history
-	for _, event := range invocationCtx.Session.Events {
-		if event.Content != nil {
-			contents = append(contents, event.Content)
+	// Add session events as conversation history, skipping events produced
+	// in sibling branches and those replaced by a summary
+	compaction, history := a.conversationHistory(invocationCtx, turnEvents)
+	contents = append(contents, a.compactContents(historyContents(compaction, history), strategy)...)
+	
+	return contents
+}
+
+// withoutConfirmations returns the content without confirmation requests and
+// answers, which are between the agent and the user, or nil if nothing is left
+func withoutConfirmations(content *events.Content) *events.Content {
+	if content == nil {
+		return nil
+	}
+	
+	parts := make([]events.Part, 0, len(content.Parts))
+	for _, part := range content.Parts {
+		if part.FunctionCall != nil && part.FunctionCall.Name == tools.RequestConfirmationFunctionName {
+			continue
+		}
+		if part.FunctionResponse != nil && part.FunctionResponse.Name == tools.RequestConfirmationFunctionName {
+			continue
 		}
+		parts = append(parts, part)
 	}
 	
-	return contents
+	if len(parts) == len(content.Parts) {
+		return content
+	}
+	if len(parts) == 0 {
+		return nil
+	}
+	return &events.Content{Role: content.Role, Parts: parts}
 }
 
 // hasToolCalls checks if an event contains tool calls
 func (a *LlmAgent) hasToolCalls(event *events.Event) bool {
-	// TODO: Implement tool call detection
+	return len(event.GetFunctionCalls()) > 0
+}
+
+// findTool finds a canonical tool by name
+func (a *LlmAgent) findTool(name string) tools.Tool {
+	for _, tool := range a.GetCanonicalTools() {
+		if tool.GetName() == name {
+			return tool
+		}
+	}
+	return nil
+}
+
+// longRunningCallIDs returns the IDs of the calls in an event that invoke
+// long-running tools
+func (a *LlmAgent) longRunningCallIDs(event *events.Event) []string {
+	var ids []string
+	for _, call := range event.GetFunctionCalls() {
+		if tool := a.findTool(call.Name); tool != nil && tool.IsLongRunning() {
+			ids = append(ids, call.ID)
+		}
+	}
+	return ids
+}
+
+// processToolCalls runs the tools called in an event and returns a single
+// event holding their responses and the actions they requested, or nil if no
+// tool ran. Calls that need confirmation are not run; they are returned in a
+// confirmation request event instead. Tool errors are reported to the model
+// in the response rather than returned.
+func (a *LlmAgent) processToolCalls(ctx context.Context, event *events.Event, invocationCtx *InvocationContext) (*events.Event, *events.Event, error) {
+	responseEvent := a.newToolEvent(invocationCtx, "user")
+	confirmationEvent := a.newToolEvent(invocationCtx, "model")
+	
+	for _, call := range event.GetFunctionCalls() {
+		if a.requiresConfirmation(call) {
+			confirmationCall := &events.FunctionCall{
+				ID:   "adk-" + uuid.New().String(),
+				Name: tools.RequestConfirmationFunctionName,
+				Args: map[string]interface{}{
+					"original_function_call": map[string]interface{}{
+						"id":   call.ID,
+						"name": call.Name,
+						"args": call.Args,
+					},
+				},
+			}
+			confirmationEvent.Content.Parts = append(confirmationEvent.Content.Parts, events.Part{FunctionCall: confirmationCall})
+			confirmationEvent.LongRunningToolIDs = append(confirmationEvent.LongRunningToolIDs, confirmationCall.ID)
+			continue
+		}
+		
+		if err := a.runToolCall(ctx, call, invocationCtx, responseEvent); err != nil {
+			return nil, nil, err
+		}
+	}
+	
+	if len(responseEvent.Content.Parts) == 0 {
+		responseEvent = nil
+	}
+	if len(confirmationEvent.Content.Parts) == 0 {
+		confirmationEvent = nil
+	}
+	return responseEvent, confirmationEvent, nil
+}
+
+// processConfirmations runs or rejects the tool calls whose confirmation
+// requests are answered in the user content, and returns an event holding
+// their responses, or nil if there are none. Answering a request again is an
+// error.
+func (a *LlmAgent) processConfirmations(ctx context.Context, invocationCtx *InvocationContext) (*events.Event, error) {
+	if invocationCtx.UserContent == nil {
+		return nil, nil
+	}
+	
+	// Check all answers before running anything, so that a replayed answer
+	// never runs a tool again
+	type confirmedToolCall struct {
+		call         *events.FunctionCall
+		confirmation *tools.ToolConfirmation
+	}
+	var confirmed []confirmedToolCall
+	answered := make(map[string]bool)
+	for _, part := range invocationCtx.UserContent.Parts {
+		answer := part.FunctionResponse
+		if answer == nil || answer.Name != tools.RequestConfirmationFunctionName {
+			continue
+		}
+		
+		call := a.confirmedCall(invocationCtx, answer.ID)
+		if call == nil {
+			return nil, fmt.Errorf("confirmation request %s not found", answer.ID)
+		}
+		if answered[call.ID] || hasFunctionResponse(invocationCtx.Session.Events, call.ID) {
+			return nil, fmt.Errorf("confirmation request %s was already answered", answer.ID)
+		}
+		answered[call.ID] = true
+		confirmation, err := tools.ParseToolConfirmation(answer)
+		if err != nil {
+			return nil, err
+		}
+		confirmed = append(confirmed, confirmedToolCall{call: call, confirmation: confirmation})
+	}
+	
+	responseEvent := a.newToolEvent(invocationCtx, "user")
+	for _, answer := range confirmed {
+		call := answer.call
+		switch answer.confirmation.Decision {
+		case tools.ConfirmationReject:
+			// Report the rejection to the model as the tool's response
+			message := "The user rejected this tool call"
+			if answer.confirmation.Reason != "" {
+				message += ": " + answer.confirmation.Reason
+			}
+			responseEvent.Content.Parts = append(responseEvent.Content.Parts, events.Part{
+				FunctionResponse: &events.FunctionResponse{ID: call.ID, Name: call.Name, Response: map[string]interface{}{"error": message}},
+			})
+			continue
+		case tools.ConfirmationEdit:
+			call.Args = answer.confirmation.Args
+		}
+		
+		if err := a.runToolCall(ctx, call, invocationCtx, responseEvent); err != nil {
+			return nil, err
+		}
+	}
+	
+	if len(responseEvent.Content.Parts) == 0 {
+		return nil, nil
+	}
+	return responseEvent, nil
+}
+
+// confirmedCall finds the tool call a confirmation request was made for
+func (a *LlmAgent) confirmedCall(invocationCtx *InvocationContext, requestID string) *events.FunctionCall {
+	for _, event := range invocationCtx.Session.Events {
+		for _, call := range event.GetFunctionCalls() {
+			if call.ID != requestID || call.Name != tools.RequestConfirmationFunctionName {
+				continue
+			}
+			original, _ := call.Args["original_function_call"].(map[string]interface{})
+			id, _ := original["id"].(string)
+			name, _ := original["name"].(string)
+			args, _ := original["args"].(map[string]interface{})
+			return &events.FunctionCall{ID: id, Name: name, Args: args}
+		}
+	}
+	return nil
+}
+
+// hasFunctionResponse reports whether any of the events responds to the
+// function call with the given ID
+func hasFunctionResponse(sessionEvents []*events.Event, callID string) bool {
+	for _, event := range sessionEvents {
+		for _, response := range event.GetFunctionResponses() {
+			if response.ID == callID {
+				return true
+			}
+		}
+	}
 	return false
 }
 
-// processToolCalls processes tool calls in an event
-func (a *LlmAgent) processToolCalls(ctx context.Context, event *events.Event, invocationCtx *InvocationContext) ([]*events.Event, error) {
-	// TODO: Implement tool call processing
-	// For now, create a mock tool context
-	toolCtx := tools.NewToolContext(invocationCtx, "mock-function-call-id")
-	_ = toolCtx // Use the variable to avoid unused variable error
+// requiresConfirmation reports whether a tool call must be confirmed first
+func (a *LlmAgent) requiresConfirmation(call *events.FunctionCall) bool {
+	confirmable, ok := a.findTool(call.Name).(tools.ConfirmableTool)
+	return ok && confirmable.RequiresConfirmation(call.Args)
+}
+
+// runToolCall runs a tool call and adds its response and actions to the
+// response event
+func (a *LlmAgent) runToolCall(ctx context.Context, call *events.FunctionCall, invocationCtx *InvocationContext, responseEvent *events.Event) error {
+	// Enforce the invocation's tool call budget
+	if err := invocationCtx.IncrementToolCallCount(); err != nil {
+		return err
+	}
+	
+	toolCtx := tools.NewToolContext(invocationCtx, call.ID)
+	response := a.callTool(ctx, call, toolCtx)
+	
+	responseEvent.Content.Parts = append(responseEvent.Content.Parts, events.Part{
+		FunctionResponse: &events.FunctionResponse{ID: call.ID, Name: call.Name, Response: response},
+	})
+	mergeActions(&responseEvent.Actions, toolCtx.EventActions)
+	return nil
+}
+
+// newToolEvent creates an empty event for tool responses or requests
+func (a *LlmAgent) newToolEvent(invocationCtx *InvocationContext, role string) *events.Event {
+	event := events.NewEvent()
+	event.InvocationID = invocationCtx.InvocationID
+	event.Author = a.Name
+	event.Branch = invocationCtx.Branch
+	event.Content = &events.Content{Role: role}
+	return event
+}
+
+// callTool runs a single tool call with the tool callbacks and returns the
+// response for the model
+func (a *LlmAgent) callTool(ctx context.Context, call *events.FunctionCall, toolCtx *tools.ToolContext) map[string]interface{} {
+	tool := a.findTool(call.Name)
+	if tool == nil {
+		return map[string]interface{}{"error": fmt.Sprintf("tool %s not found", call.Name)}
+	}
+	
+	// Execute before tool callback
+	if a.BeforeToolCallback != nil {
+		if err := a.BeforeToolCallback(toolCtx); err != nil {
+			return map[string]interface{}{"error": err.Error()}
+		}
+	}
+	
+	result, err := tool.RunAsync(ctx, call.Args, toolCtx)
+	if err != nil {
+		return map[string]interface{}{"error": err.Error()}
+	}
 	
-	return []*events.Event{}, nil
+	// Execute after tool callback
+	if a.AfterToolCallback != nil {
+		if err := a.AfterToolCallback(toolCtx); err != nil {
+			return map[string]interface{}{"error": err.Error()}
+		}
+	}
+	
+	if response, ok := result.(map[string]interface{}); ok {
+		return response
+	}
+	return map[string]interface{}{"result": result}
+}
+
+// mergeActions merges the actions requested by a tool into an event's actions
+func mergeActions(dst *events.EventActions, src *events.EventActions) {
+	if src.TransferToAgent != "" {
+		dst.TransferToAgent = src.TransferToAgent
+	}
+	dst.Escalate = dst.Escalate || src.Escalate
+	dst.SkipSummarization = dst.SkipSummarization || src.SkipSummarization
+	for key, value := range src.StateDelta {
+		if dst.StateDelta == nil {
+			dst.StateDelta = make(map[string]interface{})
+		}
+		dst.StateDelta[key] = value
+	}
+	for key, value := range src.ArtifactDelta {
+		if dst.ArtifactDelta == nil {
+			dst.ArtifactDelta = make(map[string]interface{})
+		}
+		dst.ArtifactDelta[key] = value
+	}
+	dst.RequestedAuthConfigs = append(dst.RequestedAuthConfigs, src.RequestedAuthConfigs...)
 }
 
 // storeOutputInSession stores the agent output in session state
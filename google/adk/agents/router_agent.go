@@ -0,0 +1,406 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/adrienveepee/adk-go/google/adk/events"
+	"github.com/adrienveepee/adk-go/google/adk/models"
+)
+
+// RoutingDecisionMetadataKey is the event custom metadata key holding the
+// RoutingDecision value of a router agent
+const RoutingDecisionMetadataKey = "routing_decision"
+
+// RouterAgentErrorCode is the error code of the event emitted when a router
+// agent cannot run a sub-agent for the message
+const RouterAgentErrorCode = "ROUTER_AGENT_ERROR"
+
+// Routing methods recorded in a RoutingDecision
+const (
+	RoutingMethodRule     = "rule"
+	RoutingMethodModel    = "model"
+	RoutingMethodFallback = "fallback"
+)
+
+// RoutingDecision records which sub-agent a router picked and why
+type RoutingDecision struct {
+	Agent      string  `json:"agent"`
+	Method     string  `json:"method"`
+	Confidence float64 `json:"confidence"`
+	Reason     string  `json:"reason"`
+}
+
+// RouteRule routes messages matching any keyword or the pattern to an agent
+// without calling the model
+type RouteRule struct {
+	Agent    string   `json:"agent"`
+	Keywords []string `json:"keywords,omitempty"`
+	Pattern  string   `json:"pattern,omitempty"`
+}
+
+// RouterAgent classifies the user message into one of its sub-agents before
+// any of them speaks, then runs the chosen sub-agent. Rules are tried first;
+// otherwise the model picks a sub-agent from their descriptions. Unknown or
+// low-confidence choices go to the fallback agent.
+type RouterAgent struct {
+	*BaseAgent
+	Model               string       `json:"model"`
+	Fallback            string       `json:"fallback,omitempty"`
+	ConfidenceThreshold float64      `json:"confidence_threshold,omitempty"`
+	Rules               []*RouteRule `json:"rules,omitempty"`
+
+	// Internal
+	llm models.LLM `json:"-"`
+
+	// patterns holds the compiled rule patterns by source. The map is
+	// replaced rather than changed, so that concurrent runs can share it.
+	patterns atomic.Pointer[map[string]*regexp.Regexp]
+}
+
+// NewRouterAgent creates a new router agent
+func NewRouterAgent(name, model string, subAgents []Agent) *RouterAgent {
+	agent := &RouterAgent{
+		BaseAgent: NewBaseAgent(name, "Routing agent"),
+		Model:     model,
+		Rules:     make([]*RouteRule, 0),
+	}
+	agent.self = agent
+
+	for _, subAgent := range subAgents {
+		agent.AddSubAgent(subAgent)
+	}
+
+	return agent
+}
+
+// SetFallback sets the agent used when no confident route is found
+func (a *RouterAgent) SetFallback(agentName string) *RouterAgent {
+	a.Fallback = agentName
+	return a
+}
+
+// SetConfidenceThreshold sets the minimum model confidence to accept a route
+func (a *RouterAgent) SetConfidenceThreshold(threshold float64) *RouterAgent {
+	a.ConfidenceThreshold = threshold
+	return a
+}
+
+// AddKeywordRoute routes messages containing any of the keywords
+// (case-insensitive) to the given agent
+func (a *RouterAgent) AddKeywordRoute(agentName string, keywords ...string) *RouterAgent {
+	a.Rules = append(a.Rules, &RouteRule{Agent: agentName, Keywords: keywords})
+	return a
+}
+
+// AddPatternRoute routes messages matching the regular expression to the
+// given agent; invalid patterns are reported by Validate
+func (a *RouterAgent) AddPatternRoute(agentName, pattern string) *RouterAgent {
+	a.Rules = append(a.Rules, &RouteRule{Agent: agentName, Pattern: pattern})
+	a.compilePatterns()
+	return a
+}
+
+// compiledPatterns returns the compiled patterns of the rules, compiling
+// them again only if the rules have changed since
+func (a *RouterAgent) compiledPatterns() (map[string]*regexp.Regexp, error) {
+	if patterns := a.patterns.Load(); patterns != nil {
+		current := true
+		for _, rule := range a.Rules {
+			if _, ok := (*patterns)[rule.Pattern]; !ok && rule.Pattern != "" {
+				current = false
+				break
+			}
+		}
+		if current {
+			return *patterns, nil
+		}
+	}
+	return a.compilePatterns()
+}
+
+// compilePatterns compiles the patterns of the current rules, replacing
+// those compiled before
+func (a *RouterAgent) compilePatterns() (map[string]*regexp.Regexp, error) {
+	patterns := make(map[string]*regexp.Regexp)
+	for _, rule := range a.Rules {
+		if rule.Pattern == "" {
+			continue
+		}
+		compiled, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("router agent %s: invalid pattern for agent %q: %w", a.Name, rule.Agent, err)
+		}
+		patterns[rule.Pattern] = compiled
+	}
+	a.patterns.Store(&patterns)
+	return patterns, nil
+}
+
+// GetCanonicalModel returns the resolved classification model
+func (a *RouterAgent) GetCanonicalModel() models.LLM {
+	if a.llm == nil {
+		llm, err := models.Resolve(a.Model)
+		if err != nil {
+			// TODO: Better error handling
+			return nil
+		}
+		a.llm = llm
+	}
+	return a.llm
+}
+
+// RunAsync routes the user message and runs the chosen sub-agent
+func (a *RouterAgent) RunAsync(ctx context.Context, invocationCtx *InvocationContext) (<-chan *events.Event, error) {
+	if err := a.validate(); err != nil {
+		return nil, err
+	}
+
+	invocationCtx = invocationCtx.WithAgent(a)
+	eventChan := make(chan *events.Event)
+
+	go func() {
+		defer close(eventChan)
+
+		// Execute before agent callback
+		if a.BeforeAgentCallback != nil {
+			if err := a.runCallback(a.BeforeAgentCallback, invocationCtx, eventChan); err != nil {
+				return
+			}
+		}
+
+		decision, err := a.route(ctx, invocationCtx, a.userMessage(invocationCtx))
+		if err != nil {
+			a.emitError(ctx, invocationCtx, eventChan, RouterAgentErrorCode, err)
+			return
+		}
+
+		// Record the decision before the chosen agent speaks
+		routingEvent := events.NewEvent()
+		routingEvent.InvocationID = invocationCtx.InvocationID
+		routingEvent.Author = a.Name
+		routingEvent.Branch = invocationCtx.Branch
+		routingEvent.CustomMetadata = map[string]interface{}{
+			RoutingDecisionMetadataKey: *decision,
+		}
+		eventChan <- routingEvent
+
+		if err := a.runTarget(ctx, invocationCtx, decision, eventChan); err != nil {
+			a.emitError(ctx, invocationCtx, eventChan, RouterAgentErrorCode, err)
+			return
+		}
+
+		// Execute after agent callback
+		if a.AfterAgentCallback != nil {
+			if err := a.runCallback(a.AfterAgentCallback, invocationCtx, eventChan); err != nil {
+				return
+			}
+		}
+	}()
+
+	return eventChan, nil
+}
+
+// runTarget runs the chosen sub-agent and forwards its events
+func (a *RouterAgent) runTarget(ctx context.Context, invocationCtx *InvocationContext, decision *RoutingDecision, eventChan chan<- *events.Event) error {
+	target := a.FindSubAgent(decision.Agent)
+	if target == nil {
+		if decision.Agent == "" {
+			return fmt.Errorf("router agent %s: no agent to route to: %s", a.Name, decision.Reason)
+		}
+		return fmt.Errorf("router agent %s: agent %q is not a sub-agent", a.Name, decision.Agent)
+	}
+
+	subEventChan, err := target.RunAsync(ctx, invocationCtx)
+	if err != nil {
+		return fmt.Errorf("router agent %s: %w", a.Name, err)
+	}
+	for event := range subEventChan {
+		eventChan <- event
+	}
+	return nil
+}
+
+// validate checks that rules and the fallback reference sub-agents and that
+// rule patterns compile. Concurrent runs may call it: it only replaces the
+// compiled patterns, and only when the rules have changed.
+func (a *RouterAgent) validate() error {
+	if a.Fallback != "" && a.FindSubAgent(a.Fallback) == nil {
+		return fmt.Errorf("router agent %s: fallback agent %q is not a sub-agent", a.Name, a.Fallback)
+	}
+
+	for _, rule := range a.Rules {
+		if a.FindSubAgent(rule.Agent) == nil {
+			return fmt.Errorf("router agent %s: rule agent %q is not a sub-agent", a.Name, rule.Agent)
+		}
+	}
+	if _, err := a.compiledPatterns(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// route picks the sub-agent for the message. A failed classification falls
+// back, but exceeding the model call budget is returned as an error.
+func (a *RouterAgent) route(ctx context.Context, invocationCtx *InvocationContext, message string) (*RoutingDecision, error) {
+	if decision := a.matchRules(message); decision != nil {
+		return decision, nil
+	}
+
+	// The classification counts toward the model call budget
+	if err := invocationCtx.IncrementLLMCallCount(); err != nil {
+		return nil, err
+	}
+	decision, err := a.classify(ctx, message)
+	if err != nil {
+		return a.fallback(err.Error()), nil
+	}
+	if a.FindSubAgent(decision.Agent) == nil {
+		return a.fallback(fmt.Sprintf("model chose unknown agent %q", decision.Agent)), nil
+	}
+	if decision.Confidence < a.ConfidenceThreshold {
+		return a.fallback(fmt.Sprintf("model confidence %.2f for %q is below threshold %.2f", decision.Confidence, decision.Agent, a.ConfidenceThreshold)), nil
+	}
+
+	return decision, nil
+}
+
+// matchRules returns the decision of the first matching rule, if any
+func (a *RouterAgent) matchRules(message string) *RoutingDecision {
+	lowered := strings.ToLower(message)
+	patterns, _ := a.compiledPatterns()
+
+	for _, rule := range a.Rules {
+		for _, keyword := range rule.Keywords {
+			if keyword != "" && strings.Contains(lowered, strings.ToLower(keyword)) {
+				return &RoutingDecision{
+					Agent:      rule.Agent,
+					Method:     RoutingMethodRule,
+					Confidence: 1,
+					Reason:     fmt.Sprintf("matched keyword %q", keyword),
+				}
+			}
+		}
+		if compiled := patterns[rule.Pattern]; compiled != nil && compiled.MatchString(message) {
+			return &RoutingDecision{
+				Agent:      rule.Agent,
+				Method:     RoutingMethodRule,
+				Confidence: 1,
+				Reason:     fmt.Sprintf("matched pattern %q", rule.Pattern),
+			}
+		}
+	}
+
+	return nil
+}
+
+// classify asks the model to pick a sub-agent for the message
+func (a *RouterAgent) classify(ctx context.Context, message string) (*RoutingDecision, error) {
+	llm := a.GetCanonicalModel()
+	if llm == nil {
+		return nil, fmt.Errorf("model %q could not be resolved", a.Model)
+	}
+
+	request := &models.LLMRequest{
+		Contents: []*events.Content{
+			{
+				Role:  "system",
+				Parts: []events.Part{{Text: a.classificationInstruction()}},
+			},
+			{
+				Role:  "user",
+				Parts: []events.Part{{Text: message}},
+			},
+		},
+	}
+
+	responseEventChan, err := llm.GenerateContentAsync(ctx, request)
+	if err != nil {
+		return nil, fmt.Errorf("model call failed: %w", err)
+	}
+
+	var response strings.Builder
+	for event := range responseEventChan {
+		if event.Content != nil {
+			for _, part := range event.Content.Parts {
+				response.WriteString(part.Text)
+			}
+		}
+	}
+
+	// Tolerate prose or code fences around the JSON object
+	text := response.String()
+	start := strings.Index(text, "{")
+	end := strings.LastIndex(text, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("model response is not a routing decision: %q", text)
+	}
+
+	decision := &RoutingDecision{}
+	if err := json.Unmarshal([]byte(text[start:end+1]), decision); err != nil {
+		return nil, fmt.Errorf("model response is not a routing decision: %w", err)
+	}
+	decision.Method = RoutingMethodModel
+
+	return decision, nil
+}
+
+// classificationInstruction builds the system instruction listing the
+// candidate sub-agents
+func (a *RouterAgent) classificationInstruction() string {
+	var sb strings.Builder
+	sb.WriteString("Route the user message to the single most appropriate agent below.\n\n")
+	for _, subAgent := range a.SubAgents {
+		sb.WriteString(fmt.Sprintf("- %s: %s\n", subAgent.GetName(), subAgent.GetDescription()))
+	}
+	sb.WriteString("\nRespond only with a JSON object of the form ")
+	sb.WriteString(`{"agent": "<agent name>", "confidence": <0 to 1>, "reason": "<short reason>"}`)
+	return sb.String()
+}
+
+// fallback returns a decision routing to the fallback agent, or to no agent
+// when none is configured
+func (a *RouterAgent) fallback(reason string) *RoutingDecision {
+	return &RoutingDecision{
+		Agent:  a.Fallback,
+		Method: RoutingMethodFallback,
+		Reason: reason,
+	}
+}
+
+// userMessage returns the text of the latest user event visible from the
+// current branch
+func (a *RouterAgent) userMessage(invocationCtx *InvocationContext) string {
+	sessionEvents := invocationCtx.Session.Events
+	for i := len(sessionEvents) - 1; i >= 0; i-- {
+		event := sessionEvents[i]
+		if event.Author != "user" || event.Content == nil || !event.BelongsToBranch(invocationCtx.Branch) {
+			continue
+		}
+		texts := make([]string, 0, len(event.Content.Parts))
+		for _, part := range event.Content.Parts {
+			texts = append(texts, part.Text)
+		}
+		return strings.Join(texts, "\n")
+	}
+	return ""
+}
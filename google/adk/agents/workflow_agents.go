@@ -31,6 +31,7 @@ func NewSequentialAgent(name string, subAgents []Agent) *SequentialAgent {
 	agent := &SequentialAgent{
 		BaseAgent: NewBaseAgent(name, "Sequential execution agent"),
 	}
+	agent.self = agent
 	
 	for _, subAgent := range subAgents {
 		agent.AddSubAgent(subAgent)
@@ -41,6 +42,7 @@ func NewSequentialAgent(name string, subAgents []Agent) *SequentialAgent {
 
 // RunAsync executes sub-agents sequentially
 func (a *SequentialAgent) RunAsync(ctx context.Context, invocationCtx *InvocationContext) (<-chan *events.Event, error) {
+	invocationCtx = invocationCtx.WithAgent(a)
 	eventChan := make(chan *events.Event)
 	
 	go func() {
@@ -48,13 +50,19 @@ func (a *SequentialAgent) RunAsync(ctx context.Context, invocationCtx *Invocatio
 		
 		// Execute before agent callback
 		if a.BeforeAgentCallback != nil {
-			if err := a.BeforeAgentCallback(invocationCtx); err != nil {
+			if err := a.runCallback(a.BeforeAgentCallback, invocationCtx, eventChan); err != nil {
 				return
 			}
 		}
 		
 		// Execute each sub-agent sequentially
 		for _, subAgent := range a.SubAgents {
+			// Stop once a sub-agent or callback has ended the invocation, or
+			// its deadline has passed
+			if invocationCtx.InvocationEnded() || ctx.Err() != nil {
+				break
+			}
+			
 			subEventChan, err := subAgent.RunAsync(ctx, invocationCtx)
 			if err != nil {
 				// TODO: Better error handling
@@ -69,7 +77,7 @@ func (a *SequentialAgent) RunAsync(ctx context.Context, invocationCtx *Invocatio
 		
 		// Execute after agent callback
 		if a.AfterAgentCallback != nil {
-			if err := a.AfterAgentCallback(invocationCtx); err != nil {
+			if err := a.runCallback(a.AfterAgentCallback, invocationCtx, eventChan); err != nil {
 				return
 			}
 		}
@@ -88,6 +96,7 @@ func NewParallelAgent(name string, subAgents []Agent) *ParallelAgent {
 	agent := &ParallelAgent{
 		BaseAgent: NewBaseAgent(name, "Parallel execution agent"),
 	}
+	agent.self = agent
 	
 	for _, subAgent := range subAgents {
 		agent.AddSubAgent(subAgent)
@@ -98,6 +107,7 @@ func NewParallelAgent(name string, subAgents []Agent) *ParallelAgent {
 
 // RunAsync executes sub-agents in parallel
 func (a *ParallelAgent) RunAsync(ctx context.Context, invocationCtx *InvocationContext) (<-chan *events.Event, error) {
+	invocationCtx = invocationCtx.WithAgent(a)
 	eventChan := make(chan *events.Event)
 	
 	go func() {
@@ -105,7 +115,7 @@ func (a *ParallelAgent) RunAsync(ctx context.Context, invocationCtx *InvocationC
 		
 		// Execute before agent callback
 		if a.BeforeAgentCallback != nil {
-			if err := a.BeforeAgentCallback(invocationCtx); err != nil {
+			if err := a.runCallback(a.BeforeAgentCallback, invocationCtx, eventChan); err != nil {
 				return
 			}
 		}
@@ -119,13 +129,20 @@ func (a *ParallelAgent) RunAsync(ctx context.Context, invocationCtx *InvocationC
 			go func(agent Agent) {
 				defer wg.Done()
 				
-				subEventChan, err := agent.RunAsync(ctx, invocationCtx)
+				// Isolate each sub-agent in its own branch so that siblings
+				// do not see each other's conversation history
+				branchCtx := invocationCtx.WithBranch(a.branchFor(invocationCtx, agent))
+				
+				subEventChan, err := agent.RunAsync(ctx, branchCtx)
 				if err != nil {
 					return
 				}
 				
 				// Forward all events from the sub-agent
 				for event := range subEventChan {
+					if event.Branch == "" {
+						event.Branch = branchCtx.Branch
+					}
 					eventChan <- event
 				}
 			}(subAgent)
@@ -136,7 +153,7 @@ func (a *ParallelAgent) RunAsync(ctx context.Context, invocationCtx *InvocationC
 		
 		// Execute after agent callback
 		if a.AfterAgentCallback != nil {
-			if err := a.AfterAgentCallback(invocationCtx); err != nil {
+			if err := a.runCallback(a.AfterAgentCallback, invocationCtx, eventChan); err != nil {
 				return
 			}
 		}
@@ -145,10 +162,31 @@ func (a *ParallelAgent) RunAsync(ctx context.Context, invocationCtx *InvocationC
 	return eventChan, nil
 }
 
-// LoopAgent executes sub-agents in a loop with configurable iterations
+// branchFor returns the branch path a sub-agent runs in, e.g. "parent.child",
+// extending the current branch when the agent is nested in another one
+func (a *ParallelAgent) branchFor(invocationCtx *InvocationContext, subAgent Agent) string {
+	if invocationCtx.Branch != "" {
+		return invocationCtx.Branch + "." + a.Name + "." + subAgent.GetName()
+	}
+	return a.Name + "." + subAgent.GetName()
+}
+
+// LoopIterationStateKeySuffix is appended to a loop agent's name to form the
+// session state key holding the current iteration number
+const LoopIterationStateKeySuffix = "_iteration"
+
+// LoopAgent executes sub-agents in a loop with configurable iterations.
+// The loop ends when MaxIterations is reached, when a sub-agent emits an
+// event with Actions.Escalate set (e.g. via the exit_loop tool), or when
+// Condition returns false between iterations.
 type LoopAgent struct {
 	*BaseAgent
+	// MaxIterations bounds the number of iterations; 0 means unbounded
 	MaxIterations int `json:"max_iterations"`
+	
+	// Condition, if set, is evaluated before every iteration after the
+	// first; the loop stops as soon as it returns false
+	Condition func(*InvocationContext) bool `json:"-"`
 }
 
 // NewLoopAgent creates a new loop agent
@@ -157,6 +195,7 @@ func NewLoopAgent(name string, subAgents []Agent, maxIterations int) *LoopAgent
 		BaseAgent:     NewBaseAgent(name, "Loop execution agent"),
 		MaxIterations: maxIterations,
 	}
+	agent.self = agent
 	
 	for _, subAgent := range subAgents {
 		agent.AddSubAgent(subAgent)
@@ -165,8 +204,21 @@ func NewLoopAgent(name string, subAgents []Agent, maxIterations int) *LoopAgent
 	return agent
 }
 
+// SetCondition sets the condition evaluated between iterations
+func (a *LoopAgent) SetCondition(condition func(*InvocationContext) bool) *LoopAgent {
+	a.Condition = condition
+	return a
+}
+
+// IterationStateKey returns the session state key holding the current
+// 1-based iteration number
+func (a *LoopAgent) IterationStateKey() string {
+	return a.Name + LoopIterationStateKeySuffix
+}
+
 // RunAsync executes sub-agents in a loop
 func (a *LoopAgent) RunAsync(ctx context.Context, invocationCtx *InvocationContext) (<-chan *events.Event, error) {
+	invocationCtx = invocationCtx.WithAgent(a)
 	eventChan := make(chan *events.Event)
 	
 	go func() {
@@ -174,20 +226,30 @@ func (a *LoopAgent) RunAsync(ctx context.Context, invocationCtx *InvocationConte
 		
 		// Execute before agent callback
 		if a.BeforeAgentCallback != nil {
-			if err := a.BeforeAgentCallback(invocationCtx); err != nil {
+			if err := a.runCallback(a.BeforeAgentCallback, invocationCtx, eventChan); err != nil {
 				return
 			}
 		}
 		
 		// Execute loop iterations
-		for iteration := 0; iteration < a.MaxIterations; iteration++ {
-			// Check if we should exit the loop early
-			if a.shouldExitLoop(invocationCtx) {
+		for iteration := 0; a.MaxIterations == 0 || iteration < a.MaxIterations; iteration++ {
+			// Check if we should exit the loop before the next iteration
+			if iteration > 0 && !a.shouldContinue(invocationCtx) {
+				break
+			}
+			
+			if ctx.Err() != nil || invocationCtx.InvocationEnded() {
 				break
 			}
 			
+			invocationCtx.Session.State.Set(a.IterationStateKey(), iteration+1)
+			
 			// Execute each sub-agent sequentially in this iteration
 			for _, subAgent := range a.SubAgents {
+				if invocationCtx.InvocationEnded() {
+					goto exitLoop
+				}
+				
 				subEventChan, err := subAgent.RunAsync(ctx, invocationCtx)
 				if err != nil {
 					// TODO: Better error handling
@@ -195,21 +257,27 @@ func (a *LoopAgent) RunAsync(ctx context.Context, invocationCtx *InvocationConte
 				}
 				
 				// Forward all events from the sub-agent
+				escalated := false
 				for event := range subEventChan {
 					eventChan <- event
 					
 					// Check if the event indicates we should exit the loop
 					if a.shouldExitLoopFromEvent(event) {
-						goto exitLoop
+						escalated = true
 					}
 				}
+				
+				// Stop once the escalating sub-agent has finished
+				if escalated {
+					goto exitLoop
+				}
 			}
 		}
 		
 	exitLoop:
 		// Execute after agent callback
 		if a.AfterAgentCallback != nil {
-			if err := a.AfterAgentCallback(invocationCtx); err != nil {
+			if err := a.runCallback(a.AfterAgentCallback, invocationCtx, eventChan); err != nil {
 				return
 			}
 		}
@@ -218,19 +286,15 @@ func (a *LoopAgent) RunAsync(ctx context.Context, invocationCtx *InvocationConte
 	return eventChan, nil
 }
 
-// shouldExitLoop checks if the loop should be exited based on session state
-func (a *LoopAgent) shouldExitLoop(invocationCtx *InvocationContext) bool {
-	// Check for exit loop flag in session state
-	if exitFlag, exists := invocationCtx.Session.State.Get("exit_loop"); exists {
-		if exit, ok := exitFlag.(bool); ok && exit {
-			return true
-		}
+// shouldContinue evaluates the loop condition between iterations
+func (a *LoopAgent) shouldContinue(invocationCtx *InvocationContext) bool {
+	if a.Condition == nil {
+		return true
 	}
-	return false
+	return a.Condition(invocationCtx)
 }
 
 // shouldExitLoopFromEvent checks if an event indicates the loop should be exited
 func (a *LoopAgent) shouldExitLoopFromEvent(event *events.Event) bool {
-	// Check if the event has an exit loop action
-	return event.Actions.SkipSummarization // Using this as a proxy for exit loop
-}
\ No newline at end of file
+	return event.Actions.Escalate
+}
@@ -0,0 +1,109 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifacts
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/adrienveepee/adk-go/google/adk/encryption"
+)
+
+// EncryptedArtifactService wraps an artifact service and encrypts artifact
+// data with an app's key before it reaches it. Keys, versions, timestamps and
+// metadata are stored in clear. Data found in clear is rejected unless
+// SetAllowPlaintext allows it.
+type EncryptedArtifactService struct {
+	inner          ArtifactService
+	keys           encryption.KeyProvider
+	appName        string
+	allowPlaintext bool
+}
+
+// NewEncryptedArtifactService creates an artifact service storing the given
+// app's artifacts encrypted in the given service
+func NewEncryptedArtifactService(inner ArtifactService, keys encryption.KeyProvider, appName string) *EncryptedArtifactService {
+	return &EncryptedArtifactService{inner: inner, keys: keys, appName: appName}
+}
+
+// SetAllowPlaintext sets whether data stored in clear, e.g. before encryption
+// was enabled, is read as it is instead of failing; it is off by default
+func (e *EncryptedArtifactService) SetAllowPlaintext(allow bool) *EncryptedArtifactService {
+	e.allowPlaintext = allow
+	return e
+}
+
+// SaveArtifact encrypts and saves an artifact
+func (e *EncryptedArtifactService) SaveArtifact(ctx context.Context, key string, data []byte, metadata map[string]interface{}) error {
+	encrypted, err := encryption.Encrypt(ctx, e.keys, e.appName, data, e.additionalData(key))
+	if err != nil {
+		return err
+	}
+	return e.inner.SaveArtifact(ctx, key, encrypted, metadata)
+}
+
+// LoadArtifact loads and decrypts the latest version of an artifact
+func (e *EncryptedArtifactService) LoadArtifact(ctx context.Context, key string) ([]byte, error) {
+	data, err := e.inner.LoadArtifact(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return e.decrypt(ctx, key, data)
+}
+
+// DeleteArtifact deletes an artifact by key
+func (e *EncryptedArtifactService) DeleteArtifact(ctx context.Context, key string) error {
+	return e.inner.DeleteArtifact(ctx, key)
+}
+
+// ListArtifactKeys lists all artifact keys
+func (e *EncryptedArtifactService) ListArtifactKeys(ctx context.Context) ([]string, error) {
+	return e.inner.ListArtifactKeys(ctx)
+}
+
+// ListVersions lists all versions of an artifact with their data decrypted
+func (e *EncryptedArtifactService) ListVersions(ctx context.Context, key string) ([]*ArtifactVersion, error) {
+	versions, err := e.inner.ListVersions(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted := make([]*ArtifactVersion, len(versions))
+	for i, version := range versions {
+		copied := *version
+		if copied.Data, err = e.decrypt(ctx, key, version.Data); err != nil {
+			return nil, err
+		}
+		decrypted[i] = &copied
+	}
+	return decrypted, nil
+}
+
+// additionalData binds encrypted artifact data to its key
+func (e *EncryptedArtifactService) additionalData(key string) []byte {
+	return []byte(e.appName + "\x00artifact\x00" + key)
+}
+
+// decrypt decrypts artifact data; data stored in clear is returned as it is
+// if allowed
+func (e *EncryptedArtifactService) decrypt(ctx context.Context, key string, data []byte) ([]byte, error) {
+	if !encryption.IsEncrypted(data) {
+		if !e.allowPlaintext {
+			return nil, fmt.Errorf("artifact %s is not encrypted", key)
+		}
+		return data, nil
+	}
+	return encryption.Decrypt(ctx, e.keys, e.appName, data, e.additionalData(key))
+}
@@ -0,0 +1,207 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// envelopePrefix starts every value encrypted with AES-GCM; the ID of the
+// key, so that keys can be rotated while older data stays readable, and the
+// base64 encoded nonce and ciphertext follow, separated by colons
+const envelopePrefix = "adkenc:v1:"
+
+// KeyProvider supplies the AES keys that an app's data is encrypted with.
+// Keys must be 16, 24 or 32 bytes long.
+type KeyProvider interface {
+	// CurrentKey returns the key new data of an app is encrypted with, and
+	// its ID
+	CurrentKey(ctx context.Context, appName string) (keyID string, key []byte, err error)
+
+	// Key returns a key of an app by ID, to decrypt data encrypted with it
+	Key(ctx context.Context, appName, keyID string) ([]byte, error)
+}
+
+// Encrypt encrypts plaintext with the app's current key. The additional
+// data is authenticated but not stored; the same value must be given to
+// Decrypt, which binds the ciphertext to where it is stored.
+func Encrypt(ctx context.Context, keys KeyProvider, appName string, plaintext, additionalData []byte) ([]byte, error) {
+	keyID, key, err := keys.CurrentKey(ctx, appName)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key %s: %w", keyID, err)
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := gcm.Seal(nonce, nonce, plaintext, additionalData)
+
+	return []byte(envelopePrefix + keyID + ":" + base64.RawStdEncoding.EncodeToString(sealed)), nil
+}
+
+// Decrypt decrypts data written by Encrypt with the key it names
+func Decrypt(ctx context.Context, keys KeyProvider, appName string, envelope, additionalData []byte) ([]byte, error) {
+	keyID, encoded, ok := parseEnvelope(envelope)
+	if !ok {
+		return nil, errors.New("data is not encrypted")
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(string(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("malformed encrypted data: %w", err)
+	}
+
+	key, err := keys.Key(ctx, appName, keyID)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key %s: %w", keyID, err)
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("malformed encrypted data")
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data with key %s: %w", keyID, err)
+	}
+	return plaintext, nil
+}
+
+// IsEncrypted reports whether data was written by Encrypt
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(envelopePrefix))
+}
+
+// KeyID returns the ID of the key data was encrypted with, e.g. to find
+// data still encrypted with a retired key
+func KeyID(envelope []byte) (string, bool) {
+	keyID, _, ok := parseEnvelope(envelope)
+	return keyID, ok
+}
+
+// parseEnvelope splits encrypted data into its key ID and encoded
+// ciphertext. Key IDs may contain colons; base64 never does.
+func parseEnvelope(envelope []byte) (string, []byte, bool) {
+	if !IsEncrypted(envelope) {
+		return "", nil, false
+	}
+	body := envelope[len(envelopePrefix):]
+	separator := bytes.LastIndexByte(body, ':')
+	if separator < 0 {
+		return "", nil, false
+	}
+	return string(body[:separator]), body[separator+1:], true
+}
+
+// newGCM returns an AES-GCM cipher for a key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// KeyRing is an in-memory KeyProvider. Each app has its own keys; the keys
+// added under the empty app name serve apps without keys of their own. The
+// last key added for an app is its current key, so adding a key rotates it.
+type KeyRing struct {
+	mu   sync.RWMutex
+	apps map[string]*appKeys
+}
+
+// appKeys holds the keys of an app
+type appKeys struct {
+	current string
+	keys    map[string][]byte
+}
+
+// NewKeyRing creates an empty key ring
+func NewKeyRing() *KeyRing {
+	return &KeyRing{apps: make(map[string]*appKeys)}
+}
+
+// AddKey adds a key for an app and makes it the app's current key. Keys
+// added before stay available to decrypt existing data.
+func (r *KeyRing) AddKey(appName, keyID string, key []byte) error {
+	if keyID == "" {
+		return errors.New("key ID must not be empty")
+	}
+	if _, err := aes.NewCipher(key); err != nil {
+		return fmt.Errorf("invalid key %s: %w", keyID, err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	app, exists := r.apps[appName]
+	if !exists {
+		app = &appKeys{keys: make(map[string][]byte)}
+		r.apps[appName] = app
+	}
+	if existing, exists := app.keys[keyID]; exists && !bytes.Equal(existing, key) {
+		return fmt.Errorf("key %s already exists", keyID)
+	}
+	app.keys[keyID] = append([]byte(nil), key...)
+	app.current = keyID
+	return nil
+}
+
+// CurrentKey returns the app's current key
+func (r *KeyRing) CurrentKey(ctx context.Context, appName string) (string, []byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	app := r.lookup(appName)
+	if app == nil {
+		return "", nil, fmt.Errorf("no encryption key for app %s", appName)
+	}
+	return app.current, app.keys[app.current], nil
+}
+
+// Key returns a key of the app by ID
+func (r *KeyRing) Key(ctx context.Context, appName, keyID string) ([]byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if app := r.lookup(appName); app != nil {
+		if key, exists := app.keys[keyID]; exists {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("encryption key %s not found for app %s", keyID, appName)
+}
+
+// lookup returns the keys serving an app; the caller holds the lock
+func (r *KeyRing) lookup(appName string) *appKeys {
+	if app, exists := r.apps[appName]; exists {
+		return app
+	}
+	return r.apps[""]
+}
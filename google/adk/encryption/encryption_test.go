@@ -0,0 +1,107 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	ctx := context.Background()
+	keys := NewKeyRing()
+	if err := keys.AddKey("clinic", "k1", testKey(1)); err != nil {
+		t.Fatalf("AddKey should not return error: %v", err)
+	}
+
+	plaintext := []byte("blood pressure 120/80")
+	envelope, err := Encrypt(ctx, keys, "clinic", plaintext, []byte("state/vitals"))
+	if err != nil {
+		t.Fatalf("Encrypt should not return error: %v", err)
+	}
+	if !IsEncrypted(envelope) || bytes.Contains(envelope, plaintext) {
+		t.Errorf("Expected an encrypted envelope, got %q", envelope)
+	}
+	if keyID, ok := KeyID(envelope); !ok || keyID != "k1" {
+		t.Errorf("Expected key ID k1, got %q", keyID)
+	}
+
+	decrypted, err := Decrypt(ctx, keys, "clinic", envelope, []byte("state/vitals"))
+	if err != nil {
+		t.Fatalf("Decrypt should not return error: %v", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("Expected %q, got %q", plaintext, decrypted)
+	}
+
+	if _, err := Decrypt(ctx, keys, "clinic", envelope, []byte("state/other")); err == nil {
+		t.Error("Expected decryption with other additional data to fail")
+	}
+	tampered := append([]byte(nil), envelope...)
+	tampered[len(tampered)-2] ^= 1
+	if _, err := Decrypt(ctx, keys, "clinic", tampered, []byte("state/vitals")); err == nil {
+		t.Error("Expected decryption of tampered data to fail")
+	}
+	if _, err := Decrypt(ctx, keys, "clinic", plaintext, nil); err == nil {
+		t.Error("Expected decryption of plain data to fail")
+	}
+}
+
+func TestKeyRingRotation(t *testing.T) {
+	ctx := context.Background()
+	keys := NewKeyRing()
+	keys.AddKey("", "default", testKey(1))
+	keys.AddKey("clinic", "2024", testKey(2))
+
+	old, _ := Encrypt(ctx, keys, "clinic", []byte("old"), nil)
+	if err := keys.AddKey("clinic", "2025", testKey(3)); err != nil {
+		t.Fatalf("AddKey should not return error: %v", err)
+	}
+	current, _ := Encrypt(ctx, keys, "clinic", []byte("new"), nil)
+
+	if keyID, _ := KeyID(current); keyID != "2025" {
+		t.Errorf("Expected new data to use the rotated key, got %q", keyID)
+	}
+	if decrypted, err := Decrypt(ctx, keys, "clinic", old, nil); err != nil || string(decrypted) != "old" {
+		t.Errorf("Expected data under the previous key to stay readable, got %q, %v", decrypted, err)
+	}
+
+	// Apps without keys of their own use the default keys, never another app's
+	other, err := Encrypt(ctx, keys, "pharmacy", []byte("other"), nil)
+	if err != nil {
+		t.Fatalf("Encrypt should not return error: %v", err)
+	}
+	if keyID, _ := KeyID(other); keyID != "default" {
+		t.Errorf("Expected the default key, got %q", keyID)
+	}
+	if _, err := Decrypt(ctx, keys, "pharmacy", current, nil); err == nil {
+		t.Error("Expected another app's key to be unavailable")
+	}
+
+	if err := keys.AddKey("clinic", "short", []byte("too short")); err == nil {
+		t.Error("Expected an invalid key length to be rejected")
+	}
+	if err := keys.AddKey("clinic", "2025", testKey(4)); err == nil {
+		t.Error("Expected a different key under an existing ID to be rejected")
+	}
+	if _, _, err := NewKeyRing().CurrentKey(ctx, "clinic"); err == nil {
+		t.Error("Expected an error without keys")
+	}
+}
@@ -157,7 +157,7 @@ func (e *AgentEvaluator) evaluateTestCase(ctx context.Context, runner *runners.R
 	sessionID := fmt.Sprintf("eval_session_%d_%d", time.Now().Unix(), index)
 	
 	// Run the agent
-	eventChan, err := runner.RunAsync(timeoutCtx, "eval_user", sessionID, testCase.Input)
+	eventChan, err := runner.RunAsync(timeoutCtx, "eval_user", sessionID, testCase.Input, nil)
 	if err != nil {
 		result.Error = err.Error()
 		result.Score = 0.0
@@ -15,6 +15,7 @@
 package events
 
 import (
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -28,8 +29,33 @@ type Content struct {
 
 // Part represents a part of content (text, image, etc.)
 type Part struct {
-	Text string `json:"text,omitempty"`
-	// Could be extended for images, files, etc.
+	Text       string `json:"text,omitempty"`
+	InlineData *Blob  `json:"inline_data,omitempty"`
+	
+	// Tool calls requested by the model and their results
+	FunctionCall     *FunctionCall     `json:"function_call,omitempty"`
+	FunctionResponse *FunctionResponse `json:"function_response,omitempty"`
+	// Could be extended for files, etc.
+}
+
+// FunctionCall is a tool call requested by the model
+type FunctionCall struct {
+	ID   string                 `json:"id,omitempty"`
+	Name string                 `json:"name"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+// FunctionResponse is the result of a tool call, matched to the call by ID
+type FunctionResponse struct {
+	ID       string                 `json:"id,omitempty"`
+	Name     string                 `json:"name"`
+	Response map[string]interface{} `json:"response"`
+}
+
+// Blob is inline binary data such as an image or audio clip
+type Blob struct {
+	MimeType string `json:"mime_type"`
+	Data     []byte `json:"data"`
 }
 
 // EventActions represents actions that can be taken with an event
@@ -40,6 +66,15 @@ type EventActions struct {
 	StateDelta              map[string]interface{} `json:"state_delta,omitempty"`
 	ArtifactDelta           map[string]interface{} `json:"artifact_delta,omitempty"`
 	RequestedAuthConfigs    []interface{}          `json:"requested_auth_configs,omitempty"`
+	Compaction              *EventCompaction       `json:"compaction,omitempty"`
+}
+
+// EventCompaction replaces the events of a branch between two timestamps,
+// inclusive, with a summary of them when building model requests
+type EventCompaction struct {
+	StartTimestamp   time.Time `json:"start_timestamp"`
+	EndTimestamp     time.Time `json:"end_timestamp"`
+	CompactedContent *Content  `json:"compacted_content"`
 }
 
 // Event represents a single event in the ADK system
@@ -53,6 +88,16 @@ type Event struct {
 	IsFinalResponse       bool         `json:"is_final_response"`
 	Actions               EventActions `json:"actions,omitempty"`
 	LongRunningToolIDs    []string     `json:"long_running_tool_ids,omitempty"`
+	CustomMetadata        map[string]interface{} `json:"custom_metadata,omitempty"`
+	ErrorCode             string       `json:"error_code,omitempty"`
+	ErrorMessage          string       `json:"error_message,omitempty"`
+	
+	// Streaming flags: Partial marks a chunk of a response still being
+	// generated, TurnComplete the end of a model turn in live mode and
+	// Interrupted a response cut short by the user
+	Partial               bool         `json:"partial,omitempty"`
+	TurnComplete          bool         `json:"turn_complete,omitempty"`
+	Interrupted           bool         `json:"interrupted,omitempty"`
 }
 
 // NewEvent creates a new event with a unique ID and current timestamp
@@ -78,16 +123,42 @@ func (e *Event) IsFinalized() bool {
 	return e.IsFinalResponse
 }
 
+// BelongsToBranch reports whether the event is visible from the given branch.
+// An event is visible from its own branch and from all descendant branches;
+// events without a branch are visible everywhere.
+func (e *Event) BelongsToBranch(branch string) bool {
+	if branch == "" || e.Branch == "" {
+		return true
+	}
+	return branch == e.Branch || strings.HasPrefix(branch, e.Branch+".")
+}
+
 // GetFunctionCalls extracts function calls from the event content
-func (e *Event) GetFunctionCalls() []interface{} {
-	// TODO: Implement function call extraction
-	return nil
+func (e *Event) GetFunctionCalls() []*FunctionCall {
+	if e.Content == nil {
+		return nil
+	}
+	var calls []*FunctionCall
+	for _, part := range e.Content.Parts {
+		if part.FunctionCall != nil {
+			calls = append(calls, part.FunctionCall)
+		}
+	}
+	return calls
 }
 
 // GetFunctionResponses extracts function responses from the event content
-func (e *Event) GetFunctionResponses() []interface{} {
-	// TODO: Implement function response extraction
-	return nil
+func (e *Event) GetFunctionResponses() []*FunctionResponse {
+	if e.Content == nil {
+		return nil
+	}
+	var responses []*FunctionResponse
+	for _, part := range e.Content.Parts {
+		if part.FunctionResponse != nil {
+			responses = append(responses, part.FunctionResponse)
+		}
+	}
+	return responses
 }
 
 // HasTrailingCodeExecutionResult checks if the event has code execution results
@@ -154,4 +154,58 @@ func TestContentMultipleParts(t *testing.T) {
 	if content.Parts[1].Text != "Part 2" {
 		t.Errorf("Expected second part text to be 'Part 2', got %s", content.Parts[1].Text)
 	}
-}
\ No newline at end of file
+}
+
+func TestEventBelongsToBranch(t *testing.T) {
+	event := NewEvent()
+	
+	// Events without a branch are visible everywhere
+	if !event.BelongsToBranch("parallel.researcher") {
+		t.Error("Event without branch should be visible from any branch")
+	}
+	
+	event.Branch = "parallel.researcher"
+	
+	testCases := []struct {
+		branch   string
+		expected bool
+	}{
+		{"", true},
+		{"parallel.researcher", true},
+		{"parallel.researcher.fact_checker", true},
+		{"parallel.writer", false},
+		{"parallel.researcher_2", false},
+		{"parallel", false},
+	}
+	
+	for _, tc := range testCases {
+		if got := event.BelongsToBranch(tc.branch); got != tc.expected {
+			t.Errorf("BelongsToBranch(%q) = %v, expected %v", tc.branch, got, tc.expected)
+		}
+	}
+}
+
+func TestEventFunctionCallsAndResponses(t *testing.T) {
+	event := NewEvent()
+	if len(event.GetFunctionCalls()) != 0 || len(event.GetFunctionResponses()) != 0 {
+		t.Error("Event without content should have no function calls or responses")
+	}
+	
+	event.Content = &Content{
+		Role: "model",
+		Parts: []Part{
+			{Text: "Checking the weather"},
+			{FunctionCall: &FunctionCall{ID: "call-1", Name: "get_weather", Args: map[string]interface{}{"city": "Paris"}}},
+			{FunctionResponse: &FunctionResponse{ID: "call-0", Name: "get_time", Response: map[string]interface{}{"time": "noon"}}},
+		},
+	}
+	
+	calls := event.GetFunctionCalls()
+	if len(calls) != 1 || calls[0].ID != "call-1" || calls[0].Args["city"] != "Paris" {
+		t.Errorf("Expected one get_weather call, got %+v", calls)
+	}
+	responses := event.GetFunctionResponses()
+	if len(responses) != 1 || responses[0].Name != "get_time" {
+		t.Errorf("Expected one get_time response, got %+v", responses)
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// BM25 parameters: k1 saturates the weight of repeated terms and b sets how
+// much longer documents are penalized
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// memoryDocument is the indexed text of one event
+type memoryDocument struct {
+	sessionID string
+	eventID   string
+	author    string
+	timestamp time.Time
+	text      string
+	terms     map[string]int
+	length    int
+}
+
+// bm25Index is an inverted index over the documents of one app and user,
+// ranked with Okapi BM25
+type bm25Index struct {
+	documents   map[int]*memoryDocument
+	postings    map[string]map[int]int // term -> document -> term frequency
+	sessions    map[string][]int       // session ID -> documents
+	totalLength int
+	nextID      int
+}
+
+// newBM25Index creates an empty index
+func newBM25Index() *bm25Index {
+	return &bm25Index{
+		documents: make(map[int]*memoryDocument),
+		postings:  make(map[string]map[int]int),
+		sessions:  make(map[string][]int),
+	}
+}
+
+// add indexes a document; documents without terms are skipped
+func (x *bm25Index) add(document *memoryDocument) {
+	document.terms = make(map[string]int)
+	for _, term := range tokenize(document.text) {
+		document.terms[term]++
+		document.length++
+	}
+	if document.length == 0 {
+		return
+	}
+
+	id := x.nextID
+	x.nextID++
+	x.documents[id] = document
+	x.sessions[document.sessionID] = append(x.sessions[document.sessionID], id)
+	x.totalLength += document.length
+	for term, frequency := range document.terms {
+		if x.postings[term] == nil {
+			x.postings[term] = make(map[int]int)
+		}
+		x.postings[term][id] = frequency
+	}
+}
+
+// removeSession removes the documents of a session
+func (x *bm25Index) removeSession(sessionID string) {
+	for _, id := range x.sessions[sessionID] {
+		document := x.documents[id]
+		for term := range document.terms {
+			delete(x.postings[term], id)
+			if len(x.postings[term]) == 0 {
+				delete(x.postings, term)
+			}
+		}
+		x.totalLength -= document.length
+		delete(x.documents, id)
+	}
+	delete(x.sessions, sessionID)
+}
+
+// scoredDocument is a document matching a query
+type scoredDocument struct {
+	document *memoryDocument
+	score    float64
+}
+
+// search returns the documents matching any query term, best first, at most
+// limit of them if limit is positive
+func (x *bm25Index) search(query string, limit int) []scoredDocument {
+	if len(x.documents) == 0 {
+		return nil
+	}
+	count := float64(len(x.documents))
+	averageLength := float64(x.totalLength) / count
+
+	scores := make(map[int]float64)
+	seen := make(map[string]bool)
+	for _, term := range tokenize(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+
+		postings := x.postings[term]
+		if len(postings) == 0 {
+			continue
+		}
+		matching := float64(len(postings))
+		idf := math.Log(1 + (count-matching+0.5)/(matching+0.5))
+		for id, frequency := range postings {
+			tf := float64(frequency)
+			norm := 1 - bm25B + bm25B*float64(x.documents[id].length)/averageLength
+			scores[id] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm)
+		}
+	}
+
+	results := make([]scoredDocument, 0, len(scores))
+	for id, score := range scores {
+		results = append(results, scoredDocument{document: x.documents[id], score: score})
+	}
+	// Equal scores favour recent memories
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score > results[j].score
+		}
+		if !results[i].document.timestamp.Equal(results[j].document.timestamp) {
+			return results[i].document.timestamp.After(results[j].document.timestamp)
+		}
+		return results[i].document.eventID < results[j].document.eventID
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// tokenize splits text into lowercase words and numbers
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
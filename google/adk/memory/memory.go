@@ -16,9 +16,11 @@ package memory
 
 import (
 	"context"
+	"strings"
 	"sync"
 
 	"github.com/adrienveepee/adk-go/google/adk/events"
+	"github.com/adrienveepee/adk-go/google/adk/sessions"
 )
 
 // SearchMemoryResponse represents a memory search result
@@ -36,54 +38,120 @@ type MemoryResult struct {
 // MemoryService interface for managing memory operations
 type MemoryService interface {
 	// AddSessionToMemory adds a session's events to memory
-	AddSessionToMemory(ctx context.Context, appName, userID, sessionID string) error
+	AddSessionToMemory(ctx context.Context, session *sessions.Session) error
 	
-	// SearchMemory searches memory for relevant content
-	SearchMemory(ctx context.Context, query string, userID string) (*SearchMemoryResponse, error)
+	// SearchMemory searches the memory of a user of an app for relevant content
+	SearchMemory(ctx context.Context, appName, userID, query string) (*SearchMemoryResponse, error)
 }
 
-// InMemoryMemoryService provides an in-memory implementation of MemoryService
+// DefaultMaxResults is the number of results a memory search returns by
+// default
+const DefaultMaxResults = 10
+
+// InMemoryMemoryService provides an in-memory implementation of MemoryService.
+// The text of each event is indexed per app and user, and searches rank it
+// with BM25 keyword scoring.
 type InMemoryMemoryService struct {
-	mu           sync.RWMutex
-	sessionEvents map[string][]*events.Event // Key: userID, Value: events
-	memories     map[string][]MemoryResult   // Key: userID, Value: memory results
+	mu         sync.RWMutex
+	indexes    map[indexKey]*bm25Index
+	maxResults int
+}
+
+// indexKey identifies the index of a user of an app
+type indexKey struct {
+	appName string
+	userID  string
 }
 
 // NewInMemoryMemoryService creates a new in-memory memory service
 func NewInMemoryMemoryService() *InMemoryMemoryService {
 	return &InMemoryMemoryService{
-		sessionEvents: make(map[string][]*events.Event),
-		memories:     make(map[string][]MemoryResult),
+		indexes:    make(map[indexKey]*bm25Index),
+		maxResults: DefaultMaxResults,
 	}
 }
 
-// AddSessionToMemory adds a session's events to memory
-func (m *InMemoryMemoryService) AddSessionToMemory(ctx context.Context, appName, userID, sessionID string) error {
+// SetMaxResults sets the number of results a search returns at most; 0
+// returns all matches
+func (m *InMemoryMemoryService) SetMaxResults(maxResults int) *InMemoryMemoryService {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.maxResults = maxResults
+	return m
+}
+
+// AddSessionToMemory indexes the text of a session's events. Adding a
+// session again replaces what was indexed for it before.
+func (m *InMemoryMemoryService) AddSessionToMemory(ctx context.Context, session *sessions.Session) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	
-	// TODO: In a real implementation, we would:
-	// 1. Retrieve session events
-	// 2. Process and embed the content
-	// 3. Store in memory with appropriate indexing
+	key := indexKey{appName: session.AppName, userID: session.UserID}
+	index, exists := m.indexes[key]
+	if !exists {
+		index = newBM25Index()
+		m.indexes[key] = index
+	}
+	
+	index.removeSession(session.ID)
+	for _, event := range session.Events {
+		if event.Partial {
+			continue
+		}
+		index.add(&memoryDocument{
+			sessionID: session.ID,
+			eventID:   event.ID,
+			author:    event.Author,
+			timestamp: event.Timestamp,
+			text:      eventText(event),
+		})
+	}
 	
 	return nil
 }
 
-// SearchMemory searches memory for relevant content
-func (m *InMemoryMemoryService) SearchMemory(ctx context.Context, query string, userID string) (*SearchMemoryResponse, error) {
+// SearchMemory returns the indexed events of a user of an app that share
+// words with the query, best match first
+func (m *InMemoryMemoryService) SearchMemory(ctx context.Context, appName, userID, query string) (*SearchMemoryResponse, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	
-	// TODO: In a real implementation, we would:
-	// 1. Embed the query
-	// 2. Perform similarity search against stored memories
-	// 3. Return ranked results
-	
-	// For now, return empty results
-	return &SearchMemoryResponse{
+	response := &SearchMemoryResponse{
 		Results: []MemoryResult{},
-	}, nil
+	}
+	index, exists := m.indexes[indexKey{appName: appName, userID: userID}]
+	if !exists {
+		return response, nil
+	}
+	
+	for _, match := range index.search(query, m.maxResults) {
+		response.Results = append(response.Results, MemoryResult{
+			Content: match.document.text,
+			Score:   match.score,
+			Metadata: map[string]interface{}{
+				"author":     match.document.author,
+				"timestamp":  match.document.timestamp,
+				"session_id": match.document.sessionID,
+				"event_id":   match.document.eventID,
+			},
+		})
+	}
+	
+	return response, nil
+}
+
+// eventText joins the text parts of an event's content
+func eventText(event *events.Event) string {
+	if event.Content == nil {
+		return ""
+	}
+	var texts []string
+	for _, part := range event.Content.Parts {
+		if part.Text != "" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
 }
 
 // VertexAiRagMemoryService provides a Vertex AI RAG implementation of MemoryService
@@ -97,13 +165,13 @@ func NewVertexAiRagMemoryService() *VertexAiRagMemoryService {
 }
 
 // AddSessionToMemory adds a session's events to Vertex AI RAG memory
-func (v *VertexAiRagMemoryService) AddSessionToMemory(ctx context.Context, appName, userID, sessionID string) error {
+func (v *VertexAiRagMemoryService) AddSessionToMemory(ctx context.Context, session *sessions.Session) error {
 	// TODO: Implement Vertex AI RAG integration
 	return nil
 }
 
 // SearchMemory searches Vertex AI RAG memory for relevant content
-func (v *VertexAiRagMemoryService) SearchMemory(ctx context.Context, query string, userID string) (*SearchMemoryResponse, error) {
+func (v *VertexAiRagMemoryService) SearchMemory(ctx context.Context, appName, userID, query string) (*SearchMemoryResponse, error) {
 	// TODO: Implement Vertex AI RAG search
 	return &SearchMemoryResponse{
 		Results: []MemoryResult{},
@@ -0,0 +1,143 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/adrienveepee/adk-go/google/adk/events"
+	"github.com/adrienveepee/adk-go/google/adk/sessions"
+)
+
+// remember adds a session of user messages to memory
+func remember(t *testing.T, service MemoryService, appName, userID, sessionID string, texts ...string) {
+	t.Helper()
+	session := sessions.NewSession(appName, userID, sessionID, nil)
+	for i, text := range texts {
+		event := events.NewEvent()
+		event.Author = "user"
+		event.Timestamp = time.Date(2025, 1, 1, 0, 0, i, 0, time.UTC)
+		event.Content = &events.Content{Role: "user", Parts: []events.Part{{Text: text}}}
+		session.AddEvent(event)
+	}
+	if err := service.AddSessionToMemory(context.Background(), session); err != nil {
+		t.Fatalf("AddSessionToMemory should not return error: %v", err)
+	}
+}
+
+// search searches memory and fails the test on error
+func search(t *testing.T, service MemoryService, appName, userID, query string) []MemoryResult {
+	t.Helper()
+	response, err := service.SearchMemory(context.Background(), appName, userID, query)
+	if err != nil {
+		t.Fatalf("SearchMemory should not return error: %v", err)
+	}
+	return response.Results
+}
+
+func TestInMemoryMemoryServiceSearch(t *testing.T) {
+	service := NewInMemoryMemoryService()
+	remember(t, service, "app", "user", "trip", "I am flying to Lisbon in May", "Book a window seat please")
+	remember(t, service, "app", "user", "food", "I am allergic to peanuts", "Peanuts make me sick, no peanuts at all")
+	remember(t, service, "app", "other_user", "secret", "My peanuts allergy is private")
+	remember(t, service, "other_app", "user", "elsewhere", "peanuts everywhere")
+
+	results := search(t, service, "app", "user", "Does the user eat peanuts?")
+	if len(results) != 2 {
+		t.Fatalf("Expected the 2 peanut memories of the user, got %+v", results)
+	}
+	best := results[0]
+	if best.Content != "Peanuts make me sick, no peanuts at all" || best.Score <= results[1].Score {
+		t.Errorf("Expected the memory mentioning peanuts most to rank first, got %+v", results)
+	}
+	if best.Metadata["session_id"] != "food" || best.Metadata["author"] != "user" || best.Metadata["timestamp"] != time.Date(2025, 1, 1, 0, 0, 1, 0, time.UTC) {
+		t.Errorf("Expected the memory's session, author and timestamp, got %v", best.Metadata)
+	}
+
+	if results := search(t, service, "app", "user", "quantum physics"); len(results) != 0 {
+		t.Errorf("Expected no memories without shared words, got %+v", results)
+	}
+	if results := search(t, service, "unknown_app", "user", "peanuts"); len(results) != 0 {
+		t.Errorf("Expected no memories for an unknown app, got %+v", results)
+	}
+}
+
+func TestInMemoryMemoryServiceReplacesSessions(t *testing.T) {
+	service := NewInMemoryMemoryService()
+	remember(t, service, "app", "user", "trip", "I am flying to Lisbon in May", "Book a window seat please")
+	remember(t, service, "app", "user", "trip", "I am flying to Lisbon in May", "Book an aisle seat please")
+
+	results := search(t, service, "app", "user", "seat")
+	if len(results) != 1 || results[0].Content != "Book an aisle seat please" {
+		t.Errorf("Expected only the re-added seat memory, got %+v", results)
+	}
+	if results := search(t, service, "app", "user", "window"); len(results) != 0 {
+		t.Errorf("Expected the replaced memory to be gone, got %+v", results)
+	}
+}
+
+func TestInMemoryMemoryServiceSeparatesUsers(t *testing.T) {
+	service := NewInMemoryMemoryService()
+
+	// Names containing the separator of a joined key must not collide
+	remember(t, service, "a:b", "c", "first", "peanuts")
+	remember(t, service, "a", "b:c", "second", "peanuts")
+
+	for _, user := range []struct{ appName, userID, sessionID string }{
+		{"a:b", "c", "first"},
+		{"a", "b:c", "second"},
+	} {
+		results := search(t, service, user.appName, user.userID, "peanuts")
+		if len(results) != 1 || results[0].Metadata["session_id"] != user.sessionID {
+			t.Errorf("Expected only session %s for app %q and user %q, got %+v", user.sessionID, user.appName, user.userID, results)
+		}
+	}
+}
+
+func TestInMemoryMemoryServiceMaxResults(t *testing.T) {
+	service := NewInMemoryMemoryService()
+	texts := make([]string, DefaultMaxResults+2)
+	for i := range texts {
+		texts[i] = "peanuts"
+	}
+	remember(t, service, "app", "user", "many", texts...)
+
+	if results := search(t, service, "app", "user", "peanuts"); len(results) != DefaultMaxResults {
+		t.Errorf("Expected %d results by default, got %d", DefaultMaxResults, len(results))
+	}
+	// Equal scores favour recent memories
+	if results := search(t, service.SetMaxResults(1), "app", "user", "peanuts"); len(results) != 1 || results[0].Metadata["timestamp"] != time.Date(2025, 1, 1, 0, 0, len(texts)-1, 0, time.UTC) {
+		t.Errorf("Expected the most recent memory only, got %+v", results)
+	}
+	if results := search(t, service.SetMaxResults(0), "app", "user", "peanuts"); len(results) != len(texts) {
+		t.Errorf("Expected all %d matches without a limit, got %d", len(texts), len(results))
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	got := tokenize("Book 2 seats, near the WINDOW-side!")
+	want := []string{"book", "2", "seats", "near", "the", "window", "side"}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Expected %v, got %v", want, got)
+			break
+		}
+	}
+}
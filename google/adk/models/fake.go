@@ -0,0 +1,71 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+
+	"github.com/adrienveepee/adk-go/google/adk/events"
+)
+
+// FakeLLM is a local model for tests. It answers each user turn with the
+// contents returned by Respond, both in GenerateContentAsync and over live
+// connections. Live, text contents are streamed as partial events followed by
+// the full text and a turn complete event; a content starting with a function
+// call ends the turn until the tool responses are sent. User input arriving
+// while a response streams interrupts it.
+type FakeLLM struct {
+	*BaseLLM
+	Respond func(turn *events.Content) []*events.Content `json:"-"`
+}
+
+// NewFakeLLM creates a new fake LLM
+func NewFakeLLM(modelName string, respond func(turn *events.Content) []*events.Content) *FakeLLM {
+	return &FakeLLM{
+		BaseLLM: NewBaseLLM(modelName),
+		Respond: respond,
+	}
+}
+
+// Connect opens a live connection to the fake model
+func (f *FakeLLM) Connect(ctx context.Context, request *LLMRequest) (LLMConnection, error) {
+	return newFakeConnection(ctx, f.Respond), nil
+}
+
+// GenerateContentAsync answers the last content of the request
+func (f *FakeLLM) GenerateContentAsync(ctx context.Context, request *LLMRequest) (<-chan *events.Event, error) {
+	var turn *events.Content
+	if len(request.Contents) > 0 {
+		turn = request.Contents[len(request.Contents)-1]
+	}
+	contents := f.Respond(turn)
+
+	eventChan := make(chan *events.Event, len(contents))
+	for i, content := range contents {
+		event := events.NewEvent()
+		event.Author = f.ModelName
+		event.Content = content
+		event.IsFinalResponse = i == len(contents)-1
+		eventChan <- event
+	}
+	close(eventChan)
+
+	return eventChan, nil
+}
+
+// SupportedModels returns the fake model's name
+func (f *FakeLLM) SupportedModels() []string {
+	return []string{f.ModelName}
+}
@@ -0,0 +1,330 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package models
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/adrienveepee/adk-go/google/adk/events"
+)
+
+// ErrConnectionClosed is returned when sending on a closed live connection
+var ErrConnectionClosed = errors.New("live connection is closed")
+
+// LLMConnection is a live, bidirectional session with a model. Input can be
+// sent while the model is responding; the model's output streams from
+// Receive as partial events, complete responses, tool calls, turn complete
+// and interrupted markers.
+type LLMConnection interface {
+	// SendHistory primes the model with the conversation so far
+	SendHistory(ctx context.Context, history []*events.Content) error
+
+	// SendContent sends a complete turn, such as user text or tool responses
+	SendContent(ctx context.Context, content *events.Content) error
+
+	// SendRealtime sends a chunk of realtime input such as audio
+	SendRealtime(ctx context.Context, blob *events.Blob) error
+
+	// SendActivityStart and SendActivityEnd mark user activity, e.g.
+	// speech; starting an activity interrupts the model's response
+	SendActivityStart(ctx context.Context) error
+	SendActivityEnd(ctx context.Context) error
+
+	// Receive returns the model's output; it is closed with the connection
+	Receive() <-chan *events.Event
+
+	// Close ends the session
+	Close() error
+}
+
+// fakeConnection is a local live connection that answers each turn with the
+// contents returned by respond. Text is streamed as partial events followed
+// by the full text; realtime input is buffered until the activity ends.
+type fakeConnection struct {
+	respond   func(turn *events.Content) []*events.Content
+	responses chan *events.Event
+
+	mu         sync.Mutex
+	history    []*events.Content
+	turns      []*events.Content
+	realtime   []events.Part
+	responding bool
+
+	wake      chan struct{}
+	interrupt chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// newFakeConnection opens a fake connection that lives until it is closed or
+// the context is done
+func newFakeConnection(ctx context.Context, respond func(turn *events.Content) []*events.Content) *fakeConnection {
+	c := &fakeConnection{
+		respond:   respond,
+		responses: make(chan *events.Event),
+		wake:      make(chan struct{}, 1),
+		interrupt: make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+	go c.serve(ctx)
+	return c
+}
+
+// SendHistory records the conversation so far
+func (c *fakeConnection) SendHistory(ctx context.Context, history []*events.Content) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed() {
+		return ErrConnectionClosed
+	}
+	c.history = append(c.history, history...)
+	return nil
+}
+
+// SendContent queues a turn, interrupting the current response
+func (c *fakeConnection) SendContent(ctx context.Context, content *events.Content) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.queueTurn(content)
+}
+
+// SendRealtime buffers realtime input until the activity ends
+func (c *fakeConnection) SendRealtime(ctx context.Context, blob *events.Blob) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed() {
+		return ErrConnectionClosed
+	}
+	c.realtime = append(c.realtime, events.Part{InlineData: blob})
+	return nil
+}
+
+// SendActivityStart interrupts the current response
+func (c *fakeConnection) SendActivityStart(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed() {
+		return ErrConnectionClosed
+	}
+	c.interruptResponse()
+	return nil
+}
+
+// SendActivityEnd turns the buffered realtime input into a turn
+func (c *fakeConnection) SendActivityEnd(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.realtime) == 0 {
+		return nil
+	}
+	turn := &events.Content{Role: "user", Parts: c.realtime}
+	c.realtime = nil
+	return c.queueTurn(turn)
+}
+
+// Receive returns the model's output
+func (c *fakeConnection) Receive() <-chan *events.Event {
+	return c.responses
+}
+
+// Close ends the session
+func (c *fakeConnection) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+	return nil
+}
+
+// closed reports whether the connection was closed
+func (c *fakeConnection) closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// queueTurn queues a turn; the caller holds the lock
+func (c *fakeConnection) queueTurn(turn *events.Content) error {
+	if c.closed() {
+		return ErrConnectionClosed
+	}
+	c.interruptResponse()
+	c.turns = append(c.turns, turn)
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// interruptResponse signals the response in progress, if any, to stop; the
+// caller holds the lock
+func (c *fakeConnection) interruptResponse() {
+	if !c.responding {
+		return
+	}
+	select {
+	case c.interrupt <- struct{}{}:
+	default:
+	}
+}
+
+// serve answers turns until the connection is closed
+func (c *fakeConnection) serve(ctx context.Context) {
+	defer close(c.responses)
+
+	for {
+		turn, ok := c.nextTurn(ctx)
+		if !ok {
+			return
+		}
+		if !c.respondTo(ctx, turn) {
+			return
+		}
+	}
+}
+
+// nextTurn waits for the next queued turn
+func (c *fakeConnection) nextTurn(ctx context.Context) (*events.Content, bool) {
+	for {
+		c.mu.Lock()
+		if len(c.turns) > 0 {
+			turn := c.turns[0]
+			c.turns = c.turns[1:]
+
+			// Start the response before releasing the lock so that input
+			// arriving from now on interrupts it
+			c.responding = true
+			select {
+			case <-c.interrupt:
+			default:
+			}
+			c.mu.Unlock()
+			return turn, true
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-c.wake:
+		case <-c.done:
+			return nil, false
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+// respondTo streams the response to a turn. It reports false once the
+// connection is closed.
+func (c *fakeConnection) respondTo(ctx context.Context, turn *events.Content) bool {
+	var text strings.Builder
+
+	for _, content := range c.respond(turn) {
+		select {
+		case <-c.interrupt:
+			c.endResponse()
+			return c.sendText(ctx, &text) && c.send(ctx, &events.Event{Interrupted: true})
+		default:
+		}
+
+		// A tool call ends the turn until the tool responses are sent
+		if len(content.Parts) > 0 && content.Parts[0].FunctionCall != nil {
+			if !c.sendText(ctx, &text) {
+				return false
+			}
+			c.endResponse()
+			return c.send(ctx, &events.Event{Content: content, TurnComplete: true})
+		}
+
+		if isText(content) {
+			for _, part := range content.Parts {
+				text.WriteString(part.Text)
+			}
+			if !c.send(ctx, &events.Event{Content: content, Partial: true}) {
+				return false
+			}
+			continue
+		}
+
+		if !c.sendText(ctx, &text) || !c.send(ctx, &events.Event{Content: content}) {
+			return false
+		}
+	}
+
+	c.endResponse()
+	return c.sendText(ctx, &text) && c.send(ctx, &events.Event{TurnComplete: true})
+}
+
+// endResponse marks the response as over, so that input no longer
+// interrupts it
+func (c *fakeConnection) endResponse() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.responding = false
+}
+
+// sendText sends the text streamed so far as one complete response
+func (c *fakeConnection) sendText(ctx context.Context, text *strings.Builder) bool {
+	if text.Len() == 0 {
+		return true
+	}
+	event := &events.Event{
+		Content:         &events.Content{Role: "model", Parts: []events.Part{{Text: text.String()}}},
+		IsFinalResponse: true,
+	}
+	text.Reset()
+	return c.send(ctx, event)
+}
+
+// send sends one event of the model's output
+func (c *fakeConnection) send(ctx context.Context, event *events.Event) bool {
+	stamped := events.NewEvent()
+	stamped.Content = event.Content
+	stamped.IsFinalResponse = event.IsFinalResponse
+	stamped.Partial = event.Partial
+	stamped.TurnComplete = event.TurnComplete
+	stamped.Interrupted = event.Interrupted
+
+	select {
+	case c.responses <- stamped:
+		return true
+	case <-c.done:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// isText reports whether a content holds only text
+func isText(content *events.Content) bool {
+	for _, part := range content.Parts {
+		if part.Text == "" || part.InlineData != nil || part.FunctionCall != nil || part.FunctionResponse != nil {
+			return false
+		}
+	}
+	return len(content.Parts) > 0
+}
@@ -28,6 +28,17 @@ type GenerateContentConfig struct {
 	MaxOutputTokens *int     `json:"max_output_tokens,omitempty"`
 	TopP            *float32 `json:"top_p,omitempty"`
 	TopK            *int     `json:"top_k,omitempty"`
+	
+	// Output modalities such as "TEXT" or "AUDIO", and the voice used for
+	// audio output
+	ResponseModalities []string      `json:"response_modalities,omitempty"`
+	SpeechConfig       *SpeechConfig `json:"speech_config,omitempty"`
+}
+
+// SpeechConfig configures synthesized speech output
+type SpeechConfig struct {
+	VoiceName    string `json:"voice_name,omitempty"`
+	LanguageCode string `json:"language_code,omitempty"`
 }
 
 // LLMRequest represents a request to an LLM
@@ -35,6 +46,9 @@ type LLMRequest struct {
 	Contents []*events.Content       `json:"contents"`
 	Config   *GenerateContentConfig  `json:"config,omitempty"`
 	Tools    []interface{}           `json:"tools,omitempty"`
+	
+	// Stream requests partial responses as they are generated
+	Stream bool `json:"-"`
 	// Add other fields as needed
 }
 
@@ -49,8 +63,9 @@ type LLM interface {
 	// GetModelName returns the model name
 	GetModelName() string
 	
-	// Connect establishes connection to the LLM service
-	Connect(ctx context.Context) error
+	// Connect opens a live, bidirectional connection to the model
+	// configured by the request's config and tools
+	Connect(ctx context.Context, request *LLMRequest) (LLMConnection, error)
 	
 	// GenerateContentAsync generates content asynchronously
 	GenerateContentAsync(ctx context.Context, request *LLMRequest) (<-chan *events.Event, error)
@@ -89,10 +104,16 @@ func NewGeminiLLM(modelName string) *GeminiLLM {
 	}
 }
 
-// Connect establishes connection to the Gemini service
-func (g *GeminiLLM) Connect(ctx context.Context) error {
-	// TODO: Implement Gemini client initialization
-	return nil
+// Connect opens a live connection to the Gemini service
+func (g *GeminiLLM) Connect(ctx context.Context, request *LLMRequest) (LLMConnection, error) {
+	// TODO: Implement the Gemini live API
+	// For now, answer every turn with a mock response
+	return newFakeConnection(ctx, func(turn *events.Content) []*events.Content {
+		return []*events.Content{{
+			Role:  "model",
+			Parts: []events.Part{{Text: "Mock response from " + g.ModelName}},
+		}}
+	}), nil
 }
 
 // GenerateContentAsync generates content asynchronously using Gemini
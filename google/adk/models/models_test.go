@@ -46,10 +46,14 @@ func TestNewGeminiLLM(t *testing.T) {
 func TestGeminiLLMConnect(t *testing.T) {
 	llm := NewGeminiLLM("gemini-2.0-flash")
 	
-	err := llm.Connect(context.Background())
+	connection, err := llm.Connect(context.Background(), &LLMRequest{})
 	if err != nil {
 		t.Errorf("Connect should not return error: %v", err)
 	}
+	if connection == nil {
+		t.Fatal("Connect should return a connection")
+	}
+	connection.Close()
 }
 
 func TestGeminiLLMGenerateContentAsync(t *testing.T) {
@@ -272,4 +276,124 @@ func TestRegistryInstanceCaching(t *testing.T) {
 	if llm1 != llm2 {
 		t.Error("Registry should return the same instance for the same model")
 	}
-}
\ No newline at end of file
+}
+
+func textTurn(text string) *events.Content {
+	return &events.Content{Role: "model", Parts: []events.Part{{Text: text}}}
+}
+
+func TestFakeLLMLive(t *testing.T) {
+	turns := make(chan *events.Content, 10)
+	llm := NewFakeLLM("fake-live", func(turn *events.Content) []*events.Content {
+		turns <- turn
+		if turn.Parts[0].FunctionResponse != nil {
+			return []*events.Content{textTurn("Done")}
+		}
+		if turn.Parts[0].InlineData != nil {
+			return []*events.Content{{Role: "model", Parts: []events.Part{{FunctionCall: &events.FunctionCall{ID: "call-1", Name: "lookup"}}}}}
+		}
+		return []*events.Content{textTurn("Hel"), textTurn("lo")}
+	})
+	
+	ctx := context.Background()
+	connection, err := llm.Connect(ctx, &LLMRequest{})
+	if err != nil {
+		t.Fatalf("Connect should not return error: %v", err)
+	}
+	defer connection.Close()
+	
+	// Text is streamed, then completed
+	if err := connection.SendContent(ctx, &events.Content{Role: "user", Parts: []events.Part{{Text: "Hi"}}}); err != nil {
+		t.Fatalf("SendContent should not return error: %v", err)
+	}
+	var received []*events.Event
+	for event := range connection.Receive() {
+		received = append(received, event)
+		if event.TurnComplete {
+			break
+		}
+	}
+	if len(received) != 4 {
+		t.Fatalf("Expected 2 partial, 1 full and 1 turn complete events, got %d", len(received))
+	}
+	if !received[0].Partial || !received[1].Partial || received[2].Partial {
+		t.Error("Expected only the streamed chunks to be partial")
+	}
+	if received[2].Content.Parts[0].Text != "Hello" || !received[2].IsFinalResponse {
+		t.Errorf("Expected full text Hello, got %+v", received[2].Content)
+	}
+	
+	// Realtime input becomes a turn once the activity ends
+	connection.SendActivityStart(ctx)
+	connection.SendRealtime(ctx, &events.Blob{MimeType: "audio/pcm", Data: []byte{1}})
+	connection.SendRealtime(ctx, &events.Blob{MimeType: "audio/pcm", Data: []byte{2}})
+	connection.SendActivityEnd(ctx)
+	<-turns
+	if turn := <-turns; len(turn.Parts) != 2 || turn.Parts[1].InlineData.Data[0] != 2 {
+		t.Errorf("Expected the buffered audio as one turn, got %+v", turn)
+	}
+	
+	// A tool call ends the turn until its response is sent
+	callEvent := <-connection.Receive()
+	if len(callEvent.GetFunctionCalls()) != 1 || !callEvent.TurnComplete {
+		t.Fatalf("Expected a tool call ending the turn, got %+v", callEvent)
+	}
+	connection.SendContent(ctx, &events.Content{Role: "user", Parts: []events.Part{{FunctionResponse: &events.FunctionResponse{ID: "call-1", Name: "lookup"}}}})
+	if event := <-connection.Receive(); !event.Partial || event.Content.Parts[0].Text != "Done" {
+		t.Errorf("Expected the answer to the tool response, got %+v", event)
+	}
+	
+	connection.Close()
+	for range connection.Receive() {
+	}
+	if err := connection.SendContent(ctx, textTurn("late")); err != ErrConnectionClosed {
+		t.Errorf("Expected ErrConnectionClosed, got %v", err)
+	}
+}
+
+func TestFakeLLMLiveInterruption(t *testing.T) {
+	llm := NewFakeLLM("fake-live", func(turn *events.Content) []*events.Content {
+		return []*events.Content{textTurn("one "), textTurn("two "), textTurn("three "), textTurn("four")}
+	})
+	
+	ctx := context.Background()
+	connection, _ := llm.Connect(ctx, &LLMRequest{})
+	defer connection.Close()
+	
+	connection.SendContent(ctx, textTurn("Count"))
+	<-connection.Receive()
+	connection.SendActivityStart(ctx)
+	
+	var full *events.Event
+	for event := range connection.Receive() {
+		if event.TurnComplete {
+			t.Fatal("Expected the interrupted turn not to complete")
+		}
+		if !event.Partial && event.Content != nil {
+			full = event
+		}
+		if event.Interrupted {
+			break
+		}
+	}
+	if full == nil || full.Content.Parts[0].Text == "one two three four" {
+		t.Errorf("Expected the text streamed before the interruption, got %+v", full)
+	}
+}
+
+func TestFakeLLMGenerateContentAsync(t *testing.T) {
+	llm := NewFakeLLM("fake", func(turn *events.Content) []*events.Content {
+		return []*events.Content{textTurn("Echo: " + turn.Parts[0].Text)}
+	})
+	
+	eventChan, err := llm.GenerateContentAsync(context.Background(), &LLMRequest{
+		Contents: []*events.Content{{Role: "user", Parts: []events.Part{{Text: "ping"}}}},
+	})
+	if err != nil {
+		t.Fatalf("GenerateContentAsync should not return error: %v", err)
+	}
+	event := <-eventChan
+	if event.Content.Parts[0].Text != "Echo: ping" || !event.IsFinalResponse {
+		t.Errorf("Expected final response Echo: ping, got %+v", event.Content)
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runners
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/adrienveepee/adk-go/google/adk/events"
+	"github.com/adrienveepee/adk-go/google/adk/sessions"
+)
+
+// PendingToolCallsStateKey is the session state key holding the long-running
+// tool calls that await their result, by function call ID
+const PendingToolCallsStateKey = "_adk_pending_tool_calls"
+
+// PendingToolCall is a long-running tool call awaiting its result
+type PendingToolCall struct {
+	ID           string `json:"id"`
+	Agent        string `json:"agent"`
+	Tool         string `json:"tool"`
+	Branch       string `json:"branch,omitempty"`
+	InvocationID string `json:"invocation_id"`
+}
+
+// PendingToolCalls returns the long-running tool calls of a session that
+// await their result
+func (r *Runner) PendingToolCalls(userID, sessionID string) ([]*PendingToolCall, error) {
+	session, err := r.SessionService.GetSession(r.AppName, userID, sessionID, nil)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil {
+		return nil, fmt.Errorf("session %s not found", sessionID)
+	}
+
+	pending := pendingToolCalls(session)
+	calls := make([]*PendingToolCall, 0, len(pending))
+	for _, call := range pending {
+		calls = append(calls, call)
+	}
+	return calls, nil
+}
+
+// ResumeAsync continues an invocation paused on long-running tool calls. The
+// function responses, matched to the pending calls by ID, are appended to the
+// session and the agent that made the calls runs again to use them. A nil
+// runConfig uses the defaults.
+func (r *Runner) ResumeAsync(ctx context.Context, userID, sessionID string, functionResponses []*events.FunctionResponse, runConfig *RunConfig) (_ <-chan *events.Event, err error) {
+	if len(functionResponses) == 0 {
+		return nil, fmt.Errorf("no function responses to resume with")
+	}
+
+	// Wait for or reject concurrent runs on the session
+	release, err := r.acquireSession(ctx, userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			release()
+		}
+	}()
+
+	session, err := r.SessionService.GetSession(r.AppName, userID, sessionID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	if session == nil {
+		return nil, fmt.Errorf("session %s not found", sessionID)
+	}
+
+	return r.resume(ctx, release, session, functionResponses, runConfig)
+}
+
+// resume appends the function responses to the session and continues the
+// agent that made the calls; the session must already be acquired
+func (r *Runner) resume(ctx context.Context, release func(), session *sessions.Session, functionResponses []*events.FunctionResponse, runConfig *RunConfig) (<-chan *events.Event, error) {
+	// Match the responses to the pending calls of a single agent
+	pending := pendingToolCalls(session)
+	var paused *PendingToolCall
+	content := &events.Content{Role: "user"}
+	for _, response := range functionResponses {
+		call, exists := pending[response.ID]
+		if !exists {
+			return nil, fmt.Errorf("tool call %s is not pending", response.ID)
+		}
+		if paused != nil && (call.Agent != paused.Agent || call.Branch != paused.Branch) {
+			return nil, fmt.Errorf("tool calls %s and %s were made by different agents", paused.ID, call.ID)
+		}
+		paused = call
+		delete(pending, response.ID)
+
+		resolved := *response
+		if resolved.Name == "" {
+			resolved.Name = call.Tool
+		}
+		content.Parts = append(content.Parts, events.Part{FunctionResponse: &resolved})
+	}
+
+	agent := r.Agent.FindAgent(paused.Agent)
+	if agent == nil {
+		return nil, fmt.Errorf("agent %s that made tool call %s not found", paused.Agent, paused.ID)
+	}
+
+	// Create invocation context in the branch the calls were made in
+	invocationCtx := r.newInvocationContext(session, content, runConfig)
+	invocationCtx.Branch = paused.Branch
+
+	// Persist the results and drop them from the pending calls
+	responseEvent := events.NewEvent()
+	responseEvent.InvocationID = invocationCtx.InvocationID
+	responseEvent.Author = "user"
+	responseEvent.Branch = paused.Branch
+	responseEvent.Content = content
+	responseEvent.Actions.StateDelta = map[string]interface{}{
+		PendingToolCallsStateKey: pendingToolCallsValue(pending),
+	}
+	if err := r.SessionService.AppendEvent(session, responseEvent); err != nil {
+		return nil, fmt.Errorf("failed to append function responses: %w", err)
+	}
+	invocationCtx.Session = *session
+
+	// Continue the agent that made the calls
+	runCtx, cancel := withTimeout(ctx, invocationCtx.RunConfig)
+	eventChan, err := agent.RunAsync(runCtx, invocationCtx)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to resume agent: %w", err)
+	}
+
+	return r.persistEvents(ctx, runCtx, cancel, release, invocationCtx, session, eventChan), nil
+}
+
+// pendingResponses returns the function responses of a message if it only
+// answers pending tool calls, e.g. confirmation requests, or nil otherwise
+func pendingResponses(session *sessions.Session, message *events.Content) []*events.FunctionResponse {
+	if message == nil || len(message.Parts) == 0 {
+		return nil
+	}
+
+	pending := pendingToolCalls(session)
+	responses := make([]*events.FunctionResponse, 0, len(message.Parts))
+	for _, part := range message.Parts {
+		if part.FunctionResponse == nil || pending[part.FunctionResponse.ID] == nil {
+			return nil
+		}
+		responses = append(responses, part.FunctionResponse)
+	}
+	return responses
+}
+
+// recordPendingToolCalls adds the long-running calls of an event to the
+// pending calls, through the event's state delta
+func recordPendingToolCalls(session *sessions.Session, event *events.Event) {
+	pending := pendingToolCalls(session)
+	longRunning := make(map[string]bool, len(event.LongRunningToolIDs))
+	for _, id := range event.LongRunningToolIDs {
+		longRunning[id] = true
+	}
+
+	for _, call := range event.GetFunctionCalls() {
+		if longRunning[call.ID] {
+			pending[call.ID] = &PendingToolCall{
+				ID:           call.ID,
+				Agent:        event.Author,
+				Tool:         call.Name,
+				Branch:       event.Branch,
+				InvocationID: event.InvocationID,
+			}
+		}
+	}
+
+	if event.Actions.StateDelta == nil {
+		event.Actions.StateDelta = make(map[string]interface{})
+	}
+	event.Actions.StateDelta[PendingToolCallsStateKey] = pendingToolCallsValue(pending)
+}
+
+// pendingToolCalls reads the pending calls from the session state. They are
+// stored as plain maps so that they survive serialization of the state.
+func pendingToolCalls(session *sessions.Session) map[string]*PendingToolCall {
+	pending := make(map[string]*PendingToolCall)
+	if session.State == nil {
+		return pending
+	}
+	value, _ := session.State.Get(PendingToolCallsStateKey)
+	stored, _ := value.(map[string]interface{})
+	for id, entry := range stored {
+		fields, _ := entry.(map[string]interface{})
+		field := func(name string) string {
+			text, _ := fields[name].(string)
+			return text
+		}
+		pending[id] = &PendingToolCall{
+			ID:           id,
+			Agent:        field("agent"),
+			Tool:         field("tool"),
+			Branch:       field("branch"),
+			InvocationID: field("invocation_id"),
+		}
+	}
+	return pending
+}
+
+// pendingToolCallsValue converts pending calls to their state value
+func pendingToolCallsValue(pending map[string]*PendingToolCall) map[string]interface{} {
+	value := make(map[string]interface{}, len(pending))
+	for id, call := range pending {
+		value[id] = map[string]interface{}{
+			"agent":         call.Agent,
+			"tool":          call.Tool,
+			"branch":        call.Branch,
+			"invocation_id": call.InvocationID,
+		}
+	}
+	return value
+}
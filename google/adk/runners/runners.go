@@ -16,15 +16,49 @@ package runners
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/adrienveepee/adk-go/google/adk/agents"
+	"github.com/adrienveepee/adk-go/google/adk/agents/invocation"
 	"github.com/adrienveepee/adk-go/google/adk/artifacts"
 	"github.com/adrienveepee/adk-go/google/adk/events"
 	"github.com/adrienveepee/adk-go/google/adk/memory"
 	"github.com/adrienveepee/adk-go/google/adk/sessions"
 )
 
+// RunConfig configures a single run: streaming mode, limits on model and
+// tool calls, an overall deadline, input blob handling and output modalities
+type RunConfig = agents.RunConfig
+
+// StreamingMode selects how model responses are streamed
+type StreamingMode = invocation.StreamingMode
+
+// Streaming modes
+const (
+	StreamingModeNone = invocation.StreamingModeNone
+	StreamingModeSSE  = invocation.StreamingModeSSE
+	StreamingModeBidi = invocation.StreamingModeBidi
+)
+
+// Error codes of the event a runner emits when an event of the run cannot be
+// appended to the session, or when the run's deadline passes; either ends the
+// run. These events are not recorded in the session.
+const (
+	// SessionConflictErrorCode reports that the session was modified by
+	// another writer; the error is a *sessions.ConflictError
+	SessionConflictErrorCode = "SESSION_CONFLICT"
+	// PersistErrorCode reports any other failure of the session service
+	PersistErrorCode = "PERSIST_ERROR"
+	// DeadlineExceededErrorCode reports that the run took longer than
+	// RunConfig.Timeout; the events emitted past it are dropped
+	DeadlineExceededErrorCode = "DEADLINE_EXCEEDED"
+)
+
+// ErrorMetadataKey is the event custom metadata key holding the error of a
+// runner error event
+const ErrorMetadataKey = "error"
+
 // Runner orchestrates agent execution with sessions and services
 type Runner struct {
 	Agent           agents.Agent
@@ -32,6 +66,12 @@ type Runner struct {
 	SessionService  sessions.SessionService
 	MemoryService   memory.MemoryService
 	ArtifactService artifacts.ArtifactService
+	
+	// SessionConcurrency decides what happens to a run on a session that
+	// already has a run in progress: it waits by default
+	SessionConcurrency SessionConcurrency
+	
+	locks sessionLocks
 }
 
 // NewRunner creates a new runner instance
@@ -46,9 +86,16 @@ func NewRunner(agent agents.Agent, appName string, sessionService sessions.Sessi
 	}
 }
 
-// Run executes an agent synchronously and returns the final response
-func (r *Runner) Run(ctx context.Context, userID, sessionID string, newMessage *events.Content) (*events.Event, error) {
-	eventChan, err := r.RunAsync(ctx, userID, sessionID, newMessage)
+// Validate checks the runner's agent tree with agents.Validate. Call it once
+// after building the tree; runs do not validate it again.
+func (r *Runner) Validate() error {
+	return agents.Validate(r.Agent)
+}
+
+// Run executes an agent synchronously and returns the final response. A nil
+// runConfig uses the defaults.
+func (r *Runner) Run(ctx context.Context, userID, sessionID string, newMessage *events.Content, runConfig *RunConfig) (*events.Event, error) {
+	eventChan, err := r.RunAsync(ctx, userID, sessionID, newMessage, runConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -61,56 +108,85 @@ func (r *Runner) Run(ctx context.Context, userID, sessionID string, newMessage *
 	return finalEvent, nil
 }
 
-// RunAsync executes an agent asynchronously and returns a channel of events
-func (r *Runner) RunAsync(ctx context.Context, userID, sessionID string, newMessage *events.Content) (<-chan *events.Event, error) {
+// RunAsync executes an agent asynchronously and returns a channel of events.
+// A nil runConfig uses the defaults. Runs on the same session are serialized;
+// the channel must be drained, or ctx canceled, for the next run to start. A message made only
+// of function responses to pending tool calls, such as answers to
+// confirmation requests, resumes the paused agent like ResumeAsync.
+func (r *Runner) RunAsync(ctx context.Context, userID, sessionID string, newMessage *events.Content, runConfig *RunConfig) (_ <-chan *events.Event, err error) {
+	// Wait for or reject concurrent runs on the session
+	release, err := r.acquireSession(ctx, userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			release()
+		}
+	}()
+	
 	// Get or create session
 	session, err := r.getOrCreateSession(userID, sessionID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get or create session: %w", err)
 	}
 	
+	// A message answering pending tool calls resumes the paused agent
+	if responses := pendingResponses(session, newMessage); responses != nil {
+		return r.resume(ctx, release, session, responses, runConfig)
+	}
+	
+	// Create invocation context
+	invocationCtx := r.newInvocationContext(session, newMessage, runConfig)
+	
 	// Add user message to session if provided
 	if newMessage != nil {
+		if invocationCtx.RunConfig.SaveInputBlobsAsArtifacts {
+			newMessage, err = r.saveInputBlobs(ctx, invocationCtx, newMessage)
+			if err != nil {
+				return nil, fmt.Errorf("failed to save input blobs: %w", err)
+			}
+			invocationCtx.UserContent = newMessage
+		}
+		
 		userEvent := events.NewEvent()
+		userEvent.InvocationID = invocationCtx.InvocationID
 		userEvent.Author = "user"
 		userEvent.Content = newMessage
-		session.AddEvent(userEvent)
 		
 		// Persist the event
-		r.SessionService.AppendEvent(r.AppName, userID, sessionID, userEvent)
-	}
-	
-	// Create invocation context
-	invocationCtx := &agents.InvocationContext{
-		Session: *session,
+		if err := r.SessionService.AppendEvent(session, userEvent); err != nil {
+			return nil, fmt.Errorf("failed to append user message: %w", err)
+		}
+		invocationCtx.Session = *session
 	}
 	
 	// Execute agent
-	eventChan, err := r.Agent.RunAsync(ctx, invocationCtx)
+	runCtx, cancel := withTimeout(ctx, invocationCtx.RunConfig)
+	eventChan, err := r.Agent.RunAsync(runCtx, invocationCtx)
 	if err != nil {
+		cancel()
 		return nil, fmt.Errorf("failed to run agent: %w", err)
 	}
 	
-	// Create output channel that persists events
-	outputChan := make(chan *events.Event)
-	
-	go func() {
-		defer close(outputChan)
-		
-		for event := range eventChan {
-			// Persist event to session
-			r.SessionService.AppendEvent(r.AppName, userID, sessionID, event)
-			
-			// Forward event to output channel
-			outputChan <- event
+	return r.persistEvents(ctx, runCtx, cancel, release, invocationCtx, session, eventChan), nil
+}
+
+// RunLive executes an agent in live mode (bidi-streaming), reading the
+// caller's input from the queue until it is closed. A nil runConfig uses the
+// defaults. Runs on the same session are serialized.
+func (r *Runner) RunLive(ctx context.Context, userID, sessionID string, queue *agents.LiveRequestQueue, runConfig *RunConfig) (_ <-chan *events.Event, err error) {
+	// Wait for or reject concurrent runs on the session
+	release, err := r.acquireSession(ctx, userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			release()
 		}
 	}()
 	
-	return outputChan, nil
-}
-
-// RunLive executes an agent in live mode (bidi-streaming)
-func (r *Runner) RunLive(ctx context.Context, userID, sessionID string) (<-chan *events.Event, error) {
 	// Get or create session
 	session, err := r.getOrCreateSession(userID, sessionID)
 	if err != nil {
@@ -118,32 +194,206 @@ func (r *Runner) RunLive(ctx context.Context, userID, sessionID string) (<-chan
 	}
 	
 	// Create invocation context
-	invocationCtx := &agents.InvocationContext{
-		Session: *session,
+	if runConfig == nil {
+		runConfig = &RunConfig{}
+	}
+	if runConfig.StreamingMode == "" {
+		liveConfig := *runConfig
+		liveConfig.StreamingMode = StreamingModeBidi
+		runConfig = &liveConfig
 	}
+	invocationCtx := r.newInvocationContext(session, nil, runConfig)
+	invocationCtx.LiveRequestQueue = queue
 	
 	// Execute agent in live mode
-	eventChan, err := r.Agent.RunLive(ctx, invocationCtx)
+	runCtx, cancel := withTimeout(ctx, invocationCtx.RunConfig)
+	eventChan, err := r.Agent.RunLive(runCtx, invocationCtx)
 	if err != nil {
+		cancel()
 		return nil, fmt.Errorf("failed to run agent in live mode: %w", err)
 	}
 	
+	return r.persistEvents(ctx, runCtx, cancel, release, invocationCtx, session, eventChan), nil
+}
+
+// persistEvents appends the agent's events to the session and forwards them.
+// If an event cannot be appended, e.g. because the session was changed by
+// another writer, an error event is forwarded and the invocation is ended;
+// so is one when the run's deadline passes. The agent runs with runCtx, the
+// caller's ctx bounded by that deadline. Once the agent is done, runCtx is
+// canceled and the session released.
+func (r *Runner) persistEvents(ctx, runCtx context.Context, cancel context.CancelFunc, release func(), invocationCtx *agents.InvocationContext, session *sessions.Session, eventChan <-chan *events.Event) <-chan *events.Event {
 	// Create output channel that persists events
 	outputChan := make(chan *events.Event)
 	
 	go func() {
+		defer release()
 		defer close(outputChan)
+		defer cancel()
+		
+		// A caller that stops reading cancels ctx, which must not leave the
+		// session held
+		send := func(event *events.Event) {
+			select {
+			case outputChan <- event:
+			case <-ctx.Done():
+			}
+		}
 		
 		for event := range eventChan {
+			// Past the deadline, drain the agent without recording its events
+			if runCtx.Err() != nil {
+				continue
+			}
+			
+			if event.InvocationID == "" {
+				event.InvocationID = invocationCtx.InvocationID
+			}
+			
+			// Partial responses are followed by the complete one, which is
+			// the one recorded
+			if event.Partial {
+				send(event)
+				continue
+			}
+			
+			// Attach the state changes made since the previous event
+			attachStateDelta(invocationCtx, event)
+			
+			// Record long-running tool calls so that the invocation can be
+			// resumed with their results
+			if len(event.LongRunningToolIDs) > 0 {
+				recordPendingToolCalls(session, event)
+			}
+			
 			// Persist event to session
-			r.SessionService.AppendEvent(r.AppName, userID, sessionID, event)
+			if err := r.SessionService.AppendEvent(session, event); err != nil {
+				send(r.persistErrorEvent(invocationCtx, err))
+				invocationCtx.EndInvocation()
+				cancel()
+				continue
+			}
 			
 			// Forward event to output channel
-			outputChan <- event
+			send(event)
+		}
+		
+		// Report a run cut off by its own deadline rather than the caller's
+		if errors.Is(runCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+			send(r.deadlineErrorEvent(invocationCtx))
+			return
+		}
+		
+		// Persist state changes made after the agent's last event
+		if runCtx.Err() == nil && invocationCtx.Session.State.HasDelta() {
+			event := events.NewEvent()
+			event.InvocationID = invocationCtx.InvocationID
+			event.Author = r.Agent.GetName()
+			attachStateDelta(invocationCtx, event)
+			if err := r.SessionService.AppendEvent(session, event); err != nil {
+				send(r.persistErrorEvent(invocationCtx, err))
+			} else {
+				send(event)
+			}
 		}
 	}()
 	
-	return outputChan, nil
+	return outputChan
+}
+
+// persistErrorEvent creates the event reporting that an event of the run
+// could not be appended to the session. It is not recorded in the session.
+func (r *Runner) persistErrorEvent(invocationCtx *agents.InvocationContext, err error) *events.Event {
+	event := events.NewEvent()
+	event.InvocationID = invocationCtx.InvocationID
+	event.Author = r.Agent.GetName()
+	event.ErrorCode = PersistErrorCode
+	var conflict *sessions.ConflictError
+	if errors.As(err, &conflict) {
+		event.ErrorCode = SessionConflictErrorCode
+	}
+	event.ErrorMessage = err.Error()
+	event.CustomMetadata = map[string]interface{}{ErrorMetadataKey: err}
+	return event
+}
+
+// deadlineErrorEvent creates the event reporting that the run took longer
+// than its timeout. It is not recorded in the session.
+func (r *Runner) deadlineErrorEvent(invocationCtx *agents.InvocationContext) *events.Event {
+	err := fmt.Errorf("run exceeded its timeout of %s: %w", invocationCtx.RunConfig.Timeout, context.DeadlineExceeded)
+	event := events.NewEvent()
+	event.InvocationID = invocationCtx.InvocationID
+	event.Author = r.Agent.GetName()
+	event.ErrorCode = DeadlineExceededErrorCode
+	event.ErrorMessage = err.Error()
+	event.CustomMetadata = map[string]interface{}{ErrorMetadataKey: err}
+	return event
+}
+
+// attachStateDelta moves the pending state changes of the invocation onto an
+// event; values the event already sets take precedence
+func attachStateDelta(invocationCtx *agents.InvocationContext, event *events.Event) {
+	delta := invocationCtx.Session.State.TakeDelta()
+	if len(delta) == 0 {
+		return
+	}
+	if event.Actions.StateDelta == nil {
+		event.Actions.StateDelta = make(map[string]interface{})
+	}
+	for key, value := range delta {
+		if _, exists := event.Actions.StateDelta[key]; !exists {
+			event.Actions.StateDelta[key] = value
+		}
+	}
+}
+
+// newInvocationContext creates the context for a new invocation on a session
+func (r *Runner) newInvocationContext(session *sessions.Session, userContent *events.Content, runConfig *RunConfig) *agents.InvocationContext {
+	if runConfig == nil {
+		runConfig = &RunConfig{}
+	}
+	return &agents.InvocationContext{
+		InvocationID:    invocation.NewInvocationID(),
+		Agent:           r.Agent,
+		UserContent:     userContent,
+		Session:         *session,
+		SessionService:  r.SessionService,
+		ArtifactService: r.ArtifactService,
+		MemoryService:   r.MemoryService,
+		RunConfig:       runConfig,
+	}
+}
+
+// withTimeout applies the run config's deadline to the context
+func withTimeout(ctx context.Context, runConfig *RunConfig) (context.Context, context.CancelFunc) {
+	if runConfig.Timeout > 0 {
+		return context.WithTimeout(ctx, runConfig.Timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// saveInputBlobs saves the inline data of a user message as artifacts and
+// returns a copy of the message referencing them instead
+func (r *Runner) saveInputBlobs(ctx context.Context, invocationCtx *agents.InvocationContext, message *events.Content) (*events.Content, error) {
+	if r.ArtifactService == nil {
+		return nil, fmt.Errorf("artifact service is not initialized")
+	}
+	
+	saved := &events.Content{Role: message.Role, Parts: make([]events.Part, len(message.Parts))}
+	for i, part := range message.Parts {
+		if part.InlineData == nil {
+			saved.Parts[i] = part
+			continue
+		}
+		
+		key := fmt.Sprintf("artifact_%s_%d", invocationCtx.InvocationID, i)
+		metadata := map[string]interface{}{"mime_type": part.InlineData.MimeType}
+		if err := r.ArtifactService.SaveArtifact(ctx, key, part.InlineData.Data, metadata); err != nil {
+			return nil, err
+		}
+		saved.Parts[i] = events.Part{Text: fmt.Sprintf("Uploaded file: %s. It is saved into artifacts", key)}
+	}
+	return saved, nil
 }
 
 // CloseSession closes a session
@@ -154,7 +404,7 @@ func (r *Runner) CloseSession(userID, sessionID string) error {
 // getOrCreateSession retrieves an existing session or creates a new one
 func (r *Runner) getOrCreateSession(userID, sessionID string) (*sessions.Session, error) {
 	// Try to get existing session
-	session, err := r.SessionService.GetSession(r.AppName, userID, sessionID)
+	session, err := r.SessionService.GetSession(r.AppName, userID, sessionID, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,379 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runners
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adrienveepee/adk-go/google/adk/agents"
+	"github.com/adrienveepee/adk-go/google/adk/events"
+	"github.com/adrienveepee/adk-go/google/adk/sessions"
+)
+
+func textMessage(text string) *events.Content {
+	return &events.Content{Role: "user", Parts: []events.Part{{Text: text}}}
+}
+
+func textEvent(text string) *events.Event {
+	event := events.NewEvent()
+	event.Content = &events.Content{Role: "model", Parts: []events.Part{{Text: text}}}
+	return event
+}
+
+func collect(eventChan <-chan *events.Event) []*events.Event {
+	var collected []*events.Event
+	for event := range eventChan {
+		collected = append(collected, event)
+	}
+	return collected
+}
+
+func TestRunnerSessionConflict(t *testing.T) {
+	var service sessions.SessionService
+	agent := agents.NewCustomAgent("agent", "", func(ctx context.Context, invocationCtx *agents.InvocationContext, emit agents.EmitFunc) error {
+		// Another writer appends to the session while the agent runs
+		other, err := service.GetSession("app", "user", "session", nil)
+		if err != nil {
+			return err
+		}
+		otherEvent := events.NewEvent()
+		otherEvent.Author = "other"
+		if err := service.AppendEvent(other, otherEvent); err != nil {
+			return err
+		}
+
+		if err := emit(textEvent("first")); err != nil {
+			return nil
+		}
+		emit(textEvent("second"))
+		return nil
+	})
+	runner := NewInMemoryRunner(agent, "app")
+	service = runner.SessionService
+
+	eventChan, err := runner.RunAsync(context.Background(), "user", "session", textMessage("hello"), nil)
+	if err != nil {
+		t.Fatalf("RunAsync should not return error: %v", err)
+	}
+	collected := collect(eventChan)
+
+	if len(collected) != 1 {
+		t.Fatalf("Expected only the error event, got %d events", len(collected))
+	}
+	errorEvent := collected[0]
+	if errorEvent.ErrorCode != SessionConflictErrorCode || errorEvent.ErrorMessage == "" {
+		t.Errorf("Expected a session conflict error event, got %+v", errorEvent)
+	}
+	var conflict *sessions.ConflictError
+	if err, _ := errorEvent.CustomMetadata[ErrorMetadataKey].(error); !errors.As(err, &conflict) {
+		t.Errorf("Expected the error event to hold a ConflictError, got %v", errorEvent.CustomMetadata[ErrorMetadataKey])
+	}
+
+	session, _ := service.GetSession("app", "user", "session", nil)
+	for _, event := range session.Events {
+		if event.ErrorCode != "" || (event.Content != nil && event.Content.Parts[0].Text == "second") {
+			t.Errorf("Expected no event of the ended run to be recorded, got %+v", event)
+		}
+	}
+}
+
+func TestRunnerSessionLock(t *testing.T) {
+	started := make(chan struct{}, 2)
+	proceed := make(chan struct{})
+	agent := agents.NewCustomAgent("agent", "", func(ctx context.Context, invocationCtx *agents.InvocationContext, emit agents.EmitFunc) error {
+		started <- struct{}{}
+		<-proceed
+		return emit(textEvent("done"))
+	})
+	runner := NewInMemoryRunner(agent, "app")
+	runner.SessionConcurrency = SessionConcurrencyReject
+
+	first, err := runner.RunAsync(context.Background(), "user", "session", textMessage("one"), nil)
+	if err != nil {
+		t.Fatalf("RunAsync should not return error: %v", err)
+	}
+	<-started
+
+	// A second run on the session is rejected while the first is in progress
+	var busy *SessionBusyError
+	if _, err := runner.RunAsync(context.Background(), "user", "session", textMessage("two"), nil); !errors.As(err, &busy) {
+		t.Errorf("Expected SessionBusyError, got %v", err)
+	}
+
+	// Other sessions are not affected
+	other, err := runner.RunAsync(context.Background(), "user", "other", textMessage("three"), nil)
+	if err != nil {
+		t.Fatalf("Expected a run on another session to start, got %v", err)
+	}
+	<-started
+
+	// Queued runs wait for the run in progress
+	runner.SessionConcurrency = SessionConcurrencyQueue
+	queued := make(chan (<-chan *events.Event), 1)
+	go func() {
+		eventChan, err := runner.RunAsync(context.Background(), "user", "session", textMessage("four"), nil)
+		if err != nil {
+			t.Errorf("Queued RunAsync should not return error: %v", err)
+			close(queued)
+			return
+		}
+		queued <- eventChan
+	}()
+	select {
+	case <-started:
+		t.Error("Queued run should not start before the run in progress ends")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(proceed)
+	collect(first)
+	collect(other)
+	if eventChan, ok := <-queued; ok {
+		collect(eventChan)
+	}
+
+	session, _ := runner.SessionService.GetSession("app", "user", "session", nil)
+	var texts []string
+	for _, event := range session.Events {
+		texts = append(texts, event.Content.Parts[0].Text)
+	}
+	if len(texts) != 4 || texts[0] != "one" || texts[1] != "done" || texts[2] != "four" || texts[3] != "done" {
+		t.Errorf("Expected the runs to be serialized, got %v", texts)
+	}
+}
+
+func TestRunnerResume(t *testing.T) {
+	agent := agents.NewCustomAgent("agent", "", func(ctx context.Context, invocationCtx *agents.InvocationContext, emit agents.EmitFunc) error {
+		// Resumed with the result of the long-running call
+		if parts := invocationCtx.UserContent.Parts; parts[0].FunctionResponse != nil {
+			return emit(textEvent("approved: " + parts[0].FunctionResponse.Response["status"].(string)))
+		}
+
+		call := events.NewEvent()
+		call.Content = &events.Content{Role: "model", Parts: []events.Part{{FunctionCall: &events.FunctionCall{ID: "call-1", Name: "approve"}}}}
+		call.LongRunningToolIDs = []string{"call-1"}
+		return emit(call)
+	})
+	runner := NewInMemoryRunner(agent, "app")
+
+	eventChan, err := runner.RunAsync(context.Background(), "user", "session", textMessage("refund"), nil)
+	if err != nil {
+		t.Fatalf("RunAsync should not return error: %v", err)
+	}
+	collect(eventChan)
+
+	pending, err := runner.PendingToolCalls("user", "session")
+	if err != nil {
+		t.Fatalf("PendingToolCalls should not return error: %v", err)
+	}
+	if len(pending) != 1 || pending[0].ID != "call-1" || pending[0].Tool != "approve" || pending[0].Agent != "agent" {
+		t.Fatalf("Expected the long-running call to be pending, got %+v", pending)
+	}
+
+	// A message answering the pending call resumes the agent
+	answer := &events.Content{Role: "user", Parts: []events.Part{{FunctionResponse: &events.FunctionResponse{
+		ID:       "call-1",
+		Response: map[string]interface{}{"status": "yes"},
+	}}}}
+	eventChan, err = runner.RunAsync(context.Background(), "user", "session", answer, nil)
+	if err != nil {
+		t.Fatalf("RunAsync should not return error: %v", err)
+	}
+	collected := collect(eventChan)
+	if len(collected) != 1 || collected[0].Content.Parts[0].Text != "approved: yes" {
+		t.Errorf("Expected the agent to resume with the result, got %v", collected)
+	}
+
+	if pending, _ := runner.PendingToolCalls("user", "session"); len(pending) != 0 {
+		t.Errorf("Expected no pending calls after resuming, got %+v", pending)
+	}
+	if _, err := runner.ResumeAsync(context.Background(), "user", "session", []*events.FunctionResponse{{ID: "call-1"}}, nil); err == nil {
+		t.Error("Expected error resuming a call that is no longer pending")
+	}
+}
+
+func TestRunnerTimeout(t *testing.T) {
+	agent := agents.NewCustomAgent("slow", "", func(ctx context.Context, invocationCtx *agents.InvocationContext, emit agents.EmitFunc) error {
+		if err := emit(textEvent("first")); err != nil {
+			return err
+		}
+		<-ctx.Done()
+		return emit(textEvent("late"))
+	})
+	runner := NewInMemoryRunner(agent, "app")
+
+	eventChan, err := runner.RunAsync(context.Background(), "user", "session", textMessage("hello"), &RunConfig{Timeout: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("RunAsync should not return error: %v", err)
+	}
+	collected := collect(eventChan)
+
+	if len(collected) != 2 || collected[0].Content.Parts[0].Text != "first" {
+		t.Fatalf("Expected the first event and a deadline error event, got %v", collected)
+	}
+	errorEvent := collected[1]
+	if errorEvent.ErrorCode != DeadlineExceededErrorCode || errorEvent.Author != "slow" || errorEvent.ErrorMessage == "" {
+		t.Errorf("Expected a deadline error event, got %+v", errorEvent)
+	}
+	if err, _ := errorEvent.CustomMetadata[ErrorMetadataKey].(error); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the error event to hold context.DeadlineExceeded, got %v", errorEvent.CustomMetadata[ErrorMetadataKey])
+	}
+
+	session, _ := runner.SessionService.GetSession("app", "user", "session", nil)
+	if len(session.Events) != 2 {
+		t.Errorf("Expected only the user message and the first event to be recorded, got %d events", len(session.Events))
+	}
+
+	// A run canceled by the caller is not reported as timed out
+	ctx, cancel := context.WithCancel(context.Background())
+	eventChan, err = runner.RunAsync(ctx, "user", "session", textMessage("hello"), &RunConfig{Timeout: time.Minute})
+	if err != nil {
+		t.Fatalf("RunAsync should not return error: %v", err)
+	}
+	<-eventChan
+	cancel()
+	for event := range eventChan {
+		if event.ErrorCode == DeadlineExceededErrorCode {
+			t.Errorf("Expected no deadline error after cancellation, got %+v", event)
+		}
+	}
+}
+
+func TestRunnerCallLimits(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		runConfig *RunConfig
+		code      string
+	}{
+		{"model calls", &RunConfig{MaxLLMCalls: 2}, agents.LLMCallLimitErrorCode},
+		{"tool calls", &RunConfig{MaxToolCalls: 2}, agents.ToolCallLimitErrorCode},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// The agent calls a model and a tool until a limit stops it
+			agent := agents.NewCustomAgent("looping", "", func(ctx context.Context, invocationCtx *agents.InvocationContext, emit agents.EmitFunc) error {
+				for {
+					if err := invocationCtx.IncrementLLMCallCount(); err != nil {
+						return err
+					}
+					if err := invocationCtx.IncrementToolCallCount(); err != nil {
+						return err
+					}
+					if err := emit(textEvent("again")); err != nil {
+						return err
+					}
+				}
+			})
+			runner := NewInMemoryRunner(agent, "app")
+
+			eventChan, err := runner.RunAsync(context.Background(), "user", "session", textMessage("loop"), tc.runConfig)
+			if err != nil {
+				t.Fatalf("RunAsync should not return error: %v", err)
+			}
+			collected := collect(eventChan)
+
+			if len(collected) != 3 {
+				t.Fatalf("Expected 2 events and an error event, got %d events", len(collected))
+			}
+			if last := collected[2]; last.ErrorCode != tc.code || !strings.Contains(last.ErrorMessage, "exceeded: 2") {
+				t.Errorf("Expected a %s error event, got %+v", tc.code, last)
+			}
+		})
+	}
+}
+
+func TestRunnerSaveInputBlobsAsArtifacts(t *testing.T) {
+	var userContent *events.Content
+	agent := agents.NewCustomAgent("reader", "", func(ctx context.Context, invocationCtx *agents.InvocationContext, emit agents.EmitFunc) error {
+		userContent = invocationCtx.UserContent
+		return nil
+	})
+	runner := NewInMemoryRunner(agent, "app")
+
+	message := &events.Content{Role: "user", Parts: []events.Part{
+		{Text: "Summarize this"},
+		{InlineData: &events.Blob{MimeType: "application/pdf", Data: []byte("%PDF")}},
+	}}
+	eventChan, err := runner.RunAsync(context.Background(), "user", "session", message, &RunConfig{SaveInputBlobsAsArtifacts: true})
+	if err != nil {
+		t.Fatalf("RunAsync should not return error: %v", err)
+	}
+	collect(eventChan)
+
+	session, _ := runner.SessionService.GetSession("app", "user", "session", nil)
+	key := "artifact_" + session.Events[0].InvocationID + "_1"
+	data, err := runner.ArtifactService.LoadArtifact(context.Background(), key)
+	if err != nil || string(data) != "%PDF" {
+		t.Errorf("Expected the blob to be saved as artifact %s, got %q (%v)", key, data, err)
+	}
+
+	// The agent and the session see a reference instead of the blob
+	for _, content := range []*events.Content{userContent, session.Events[0].Content} {
+		if content == nil || len(content.Parts) != 2 || content.Parts[0].Text != "Summarize this" {
+			t.Fatalf("Expected the text and a reference, got %+v", content)
+		}
+		if content.Parts[1].InlineData != nil || !strings.Contains(content.Parts[1].Text, key) {
+			t.Errorf("Expected a reference to %s instead of the blob, got %+v", key, content.Parts[1])
+		}
+	}
+	if message.Parts[1].InlineData == nil {
+		t.Error("Expected the caller's message to be left unchanged")
+	}
+}
+
+func TestRunnerAbandonedRun(t *testing.T) {
+	agent := agents.NewCustomAgent("talkative", "", func(ctx context.Context, invocationCtx *agents.InvocationContext, emit agents.EmitFunc) error {
+		for i := 0; i < 10; i++ {
+			if err := emit(textEvent("more")); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	runner := NewInMemoryRunner(agent, "app")
+	runner.SessionConcurrency = SessionConcurrencyQueue
+
+	// The caller reads one event, then cancels and stops reading
+	ctx, cancel := context.WithCancel(context.Background())
+	abandoned, err := runner.RunAsync(ctx, "user", "session", textMessage("one"), nil)
+	if err != nil {
+		t.Fatalf("RunAsync should not return error: %v", err)
+	}
+	<-abandoned
+	cancel()
+
+	// The session is released for the next run
+	done := make(chan []*events.Event)
+	go func() {
+		eventChan, err := runner.RunAsync(context.Background(), "user", "session", textMessage("two"), nil)
+		if err != nil {
+			t.Errorf("RunAsync should not return error: %v", err)
+			close(done)
+			return
+		}
+		done <- collect(eventChan)
+	}()
+	select {
+	case collected := <-done:
+		if len(collected) != 10 {
+			t.Errorf("Expected the next run to complete, got %d events", len(collected))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the abandoned run to release the session")
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runners
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// SessionConcurrency decides how a runner handles a run on a session that
+// already has a run in progress
+type SessionConcurrency int
+
+const (
+	// SessionConcurrencyQueue waits for the run in progress to finish
+	SessionConcurrencyQueue SessionConcurrency = iota
+	// SessionConcurrencyReject fails with a SessionBusyError
+	SessionConcurrencyReject
+)
+
+// SessionBusyError is returned when a run is rejected because the session
+// already has a run in progress
+type SessionBusyError struct {
+	AppName   string
+	UserID    string
+	SessionID string
+}
+
+// Error describes the busy session
+func (e *SessionBusyError) Error() string {
+	return fmt.Sprintf("session %s of user %s in app %s is busy with another run", e.SessionID, e.UserID, e.AppName)
+}
+
+// sessionLocks holds one lock per session with a run in progress or waiting
+type sessionLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sessionLock
+}
+
+// sessionLock is held by the run in progress on a session
+type sessionLock struct {
+	held chan struct{}
+
+	// refs counts the holder and waiters, so that idle locks are dropped
+	refs int
+}
+
+// acquireSession waits for, or with SessionConcurrencyReject rejects, other
+// runs on the session and returns a function releasing it
+func (r *Runner) acquireSession(ctx context.Context, userID, sessionID string) (func(), error) {
+	key := r.AppName + ":" + userID + ":" + sessionID
+	lock := r.locks.ref(key)
+
+	select {
+	case lock.held <- struct{}{}:
+	default:
+		if r.SessionConcurrency == SessionConcurrencyReject {
+			r.locks.unref(key)
+			return nil, &SessionBusyError{AppName: r.AppName, UserID: userID, SessionID: sessionID}
+		}
+		select {
+		case lock.held <- struct{}{}:
+		case <-ctx.Done():
+			r.locks.unref(key)
+			return nil, ctx.Err()
+		}
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			<-lock.held
+			r.locks.unref(key)
+		})
+	}, nil
+}
+
+// ref returns the lock of a session, creating it if needed
+func (l *sessionLocks) ref(key string) *sessionLock {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.locks == nil {
+		l.locks = make(map[string]*sessionLock)
+	}
+	lock, exists := l.locks[key]
+	if !exists {
+		lock = &sessionLock{held: make(chan struct{}, 1)}
+		l.locks[key] = lock
+	}
+	lock.refs++
+	return lock
+}
+
+// unref drops a reference to a session lock, removing it once unused
+func (l *sessionLocks) unref(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lock := l.locks[key]
+	lock.refs--
+	if lock.refs == 0 {
+		delete(l.locks, key)
+	}
+}
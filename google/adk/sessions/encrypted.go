@@ -0,0 +1,473 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sessions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/adrienveepee/adk-go/google/adk/encryption"
+	"github.com/adrienveepee/adk-go/google/adk/events"
+	"github.com/google/uuid"
+)
+
+// EncryptedSessionService wraps a session service and encrypts event
+// contents, error messages, compaction summaries and state values with the
+// app's key before they reach it. Session and event IDs, authors, branches,
+// timestamps and state keys are stored in clear, so that sessions can still
+// be listed and their events selected. Ciphertext is bound to where it
+// belongs: events and session values to their user and session, user values
+// to their user and app values to their app. Data found in clear is rejected
+// unless SetAllowPlaintext allows it.
+type EncryptedSessionService struct {
+	inner          SessionService
+	keys           encryption.KeyProvider
+	allowPlaintext bool
+}
+
+// NewEncryptedSessionService creates a session service storing its data
+// encrypted in the given service
+func NewEncryptedSessionService(inner SessionService, keys encryption.KeyProvider) *EncryptedSessionService {
+	return &EncryptedSessionService{inner: inner, keys: keys}
+}
+
+// SetAllowPlaintext sets whether data stored in clear, e.g. before encryption
+// was enabled, is read as it is instead of failing; it is off by default, so
+// that data replaced by someone without the keys is not trusted
+func (s *EncryptedSessionService) SetAllowPlaintext(allow bool) *EncryptedSessionService {
+	s.allowPlaintext = allow
+	return s
+}
+
+// CreateSession creates a new session with encrypted initial values
+func (s *EncryptedSessionService) CreateSession(appName, userID, sessionID string, initialState map[string]interface{}) (*Session, error) {
+	if sessionID == "" {
+		sessionID = uuid.New().String()
+	}
+	ctx := context.Background()
+	encrypted, err := s.encryptState(ctx, sessionScope{appName, userID, sessionID}, initialState)
+	if err != nil {
+		return nil, err
+	}
+	session, err := s.inner.CreateSession(appName, userID, sessionID, encrypted)
+	if err != nil {
+		return nil, err
+	}
+	return s.decryptSession(ctx, session)
+}
+
+// GetSession retrieves and decrypts a session
+func (s *EncryptedSessionService) GetSession(appName, userID, sessionID string, config *GetSessionConfig) (*Session, error) {
+	session, err := s.inner.GetSession(appName, userID, sessionID, config)
+	if err != nil || session == nil {
+		return nil, err
+	}
+	return s.decryptSession(context.Background(), session)
+}
+
+// DeleteSession deletes a session
+func (s *EncryptedSessionService) DeleteSession(appName, userID, sessionID string) error {
+	return s.inner.DeleteSession(appName, userID, sessionID)
+}
+
+// ListSessions lists a page of a user's sessions with their decrypted state
+func (s *EncryptedSessionService) ListSessions(appName, userID string, config *ListSessionsConfig) (*ListSessionsResponse, error) {
+	response, err := s.inner.ListSessions(appName, userID, config)
+	if err != nil {
+		return nil, err
+	}
+	for i, session := range response.Sessions {
+		if response.Sessions[i], err = s.decryptSession(context.Background(), session); err != nil {
+			return nil, err
+		}
+	}
+	return response, nil
+}
+
+// AppendEvent encrypts an event and adds it to a session. The given session
+// and event are updated as by the wrapped service, with their values in
+// clear.
+func (s *EncryptedSessionService) AppendEvent(session *Session, event *events.Event) error {
+	encrypted, err := s.encryptEvent(context.Background(), scopeOf(session), event)
+	if err != nil {
+		return err
+	}
+
+	// The wrapped service updates a copy of the session holding ciphertext
+	stored := *session
+	stored.State = NewState()
+	stored.Events = nil
+	if err := s.inner.AppendEvent(&stored, encrypted); err != nil {
+		return err
+	}
+	if stored.Version == session.Version {
+		return nil // Session not found
+	}
+
+	session.State.applyDelta(event.Actions.StateDelta)
+	trimTempDelta(event)
+	session.Events = append(session.Events, event)
+	session.LastUpdateTime = stored.LastUpdateTime
+	session.Version = stored.Version
+	return nil
+}
+
+// ListEvents lists and decrypts the events of a session
+func (s *EncryptedSessionService) ListEvents(appName, userID, sessionID string, config *GetSessionConfig) ([]*events.Event, error) {
+	stored, err := s.inner.ListEvents(appName, userID, sessionID, config)
+	if err != nil {
+		return nil, err
+	}
+	return s.decryptEvents(context.Background(), sessionScope{appName, userID, sessionID}, stored)
+}
+
+// CloseSession closes a session
+func (s *EncryptedSessionService) CloseSession(appName, userID, sessionID string) error {
+	return s.inner.CloseSession(appName, userID, sessionID)
+}
+
+// RewindSession rewinds a session and returns it decrypted
+func (s *EncryptedSessionService) RewindSession(appName, userID, sessionID, toEventID string) (*Session, error) {
+	session, err := s.inner.RewindSession(appName, userID, sessionID, toEventID)
+	if err != nil {
+		return nil, err
+	}
+	return s.decryptSession(context.Background(), session)
+}
+
+// ForkSession forks a session and returns the fork decrypted. The copied
+// events are bound to the source session, so the fork is rebuilt with them
+// encrypted for it: it is created with the values of the wrapped service's
+// fork that its events do not set, then its events are appended again
+// without their app and user values.
+func (s *EncryptedSessionService) ForkSession(appName, userID, sessionID, toEventID, newSessionID string) (*Session, error) {
+	ctx := context.Background()
+	copied, err := s.inner.ForkSession(appName, userID, sessionID, toEventID, newSessionID)
+	if err != nil {
+		return nil, err
+	}
+	newSessionID = copied.ID
+
+	// Read the copy as the source session's data, then replace it
+	source := *copied
+	source.ID = sessionID
+	decrypted, err := s.decryptSession(ctx, &source)
+	if err != nil {
+		s.inner.DeleteSession(appName, userID, newSessionID)
+		return nil, err
+	}
+	if err := s.inner.DeleteSession(appName, userID, newSessionID); err != nil {
+		return nil, err
+	}
+
+	set := make(map[string]bool)
+	for _, event := range decrypted.Events {
+		for key := range event.Actions.StateDelta {
+			set[key] = true
+		}
+	}
+	initialState := make(map[string]interface{})
+	for key, value := range splitStateDelta(decrypted.State.ToDict()).session {
+		if !set[key] {
+			initialState[key] = value
+		}
+	}
+
+	fork, err := s.CreateSession(appName, userID, newSessionID, initialState)
+	if err != nil {
+		return nil, err
+	}
+	for _, event := range decrypted.Events {
+		event.Actions.StateDelta = splitStateDelta(event.Actions.StateDelta).session
+		if err := s.AppendEvent(fork, event); err != nil {
+			s.inner.DeleteSession(appName, userID, newSessionID)
+			return nil, err
+		}
+	}
+	return fork, nil
+}
+
+// Subscribe follows the changes made to a session, decrypted. A change that
+// cannot be decrypted ends the subscription with a ChangeFailed change.
+func (s *EncryptedSessionService) Subscribe(appName, userID, sessionID string) (<-chan SessionChange, func()) {
+	stored, cancelInner := s.inner.Subscribe(appName, userID, sessionID)
+	changes := make(chan SessionChange)
+	done := make(chan struct{})
+	var stop sync.Once
+
+	go func() {
+		defer close(changes)
+		for change := range stored {
+			decrypted, err := s.decryptChange(context.Background(), change)
+			if err != nil {
+				cancelInner()
+				decrypted = SessionChange{
+					Type:      ChangeFailed,
+					AppName:   change.AppName,
+					UserID:    change.UserID,
+					SessionID: change.SessionID,
+					Version:   change.Version,
+					Err:       err,
+				}
+			}
+			select {
+			case changes <- decrypted:
+			case <-done:
+				return
+			}
+			if decrypted.Type == ChangeFailed {
+				return
+			}
+		}
+	}()
+
+	return changes, func() {
+		stop.Do(func() { close(done) })
+		cancelInner()
+	}
+}
+
+// sessionScope identifies the session encrypted data belongs to
+type sessionScope struct {
+	appName   string
+	userID    string
+	sessionID string
+}
+
+// scopeOf returns the scope of a session's data
+func scopeOf(session *Session) sessionScope {
+	return sessionScope{session.AppName, session.UserID, session.ID}
+}
+
+// stateAdditionalData binds an encrypted state value to its key and to the
+// app, user or session sharing it, so that values cannot be swapped between
+// keys or moved to another user's or session's state
+func stateAdditionalData(scope sessionScope, key string) []byte {
+	switch {
+	case strings.HasPrefix(key, AppPrefix):
+		return []byte(scope.appName + "\x00app\x00" + key)
+	case strings.HasPrefix(key, UserPrefix):
+		return []byte(scope.appName + "\x00user\x00" + scope.userID + "\x00" + key)
+	default:
+		return []byte(scope.appName + "\x00session\x00" + scope.userID + "\x00" + scope.sessionID + "\x00" + key)
+	}
+}
+
+// eventAdditionalData binds an encrypted event field to its event and session
+func eventAdditionalData(scope sessionScope, eventID, field string) []byte {
+	return []byte(scope.appName + "\x00event\x00" + scope.userID + "\x00" + scope.sessionID + "\x00" + eventID + "\x00" + field)
+}
+
+// encryptState returns a copy of state values with each value encrypted
+func (s *EncryptedSessionService) encryptState(ctx context.Context, scope sessionScope, values map[string]interface{}) (map[string]interface{}, error) {
+	if values == nil {
+		return nil, nil
+	}
+	encrypted := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		plaintext, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode state value %s: %w", key, err)
+		}
+		envelope, err := encryption.Encrypt(ctx, s.keys, scope.appName, plaintext, stateAdditionalData(scope, key))
+		if err != nil {
+			return nil, err
+		}
+		encrypted[key] = string(envelope)
+	}
+	return encrypted, nil
+}
+
+// decryptState returns a copy of state values with each value decrypted
+func (s *EncryptedSessionService) decryptState(ctx context.Context, scope sessionScope, values map[string]interface{}) (map[string]interface{}, error) {
+	if values == nil {
+		return nil, nil
+	}
+	decrypted := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		text, ok := value.(string)
+		if !ok || !encryption.IsEncrypted([]byte(text)) {
+			if !s.allowPlaintext {
+				return nil, fmt.Errorf("state value %s is not encrypted", key)
+			}
+			decrypted[key] = value
+			continue
+		}
+		plaintext, err := encryption.Decrypt(ctx, s.keys, scope.appName, []byte(text), stateAdditionalData(scope, key))
+		if err != nil {
+			return nil, fmt.Errorf("state value %s: %w", key, err)
+		}
+		var decoded interface{}
+		if err := json.Unmarshal(plaintext, &decoded); err != nil {
+			return nil, fmt.Errorf("failed to decode state value %s: %w", key, err)
+		}
+		decrypted[key] = decoded
+	}
+	return decrypted, nil
+}
+
+// encryptContent returns a content holding the encrypted content as its
+// only text part
+func (s *EncryptedSessionService) encryptContent(ctx context.Context, appName string, content *events.Content, additionalData []byte) (*events.Content, error) {
+	if content == nil {
+		return nil, nil
+	}
+	plaintext, err := json.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+	envelope, err := encryption.Encrypt(ctx, s.keys, appName, plaintext, additionalData)
+	if err != nil {
+		return nil, err
+	}
+	return &events.Content{Role: content.Role, Parts: []events.Part{{Text: string(envelope)}}}, nil
+}
+
+// decryptContent returns the content held by an encrypted content
+func (s *EncryptedSessionService) decryptContent(ctx context.Context, appName string, content *events.Content, additionalData []byte) (*events.Content, error) {
+	if content == nil {
+		return nil, nil
+	}
+	if len(content.Parts) != 1 || !encryption.IsEncrypted([]byte(content.Parts[0].Text)) {
+		if !s.allowPlaintext {
+			return nil, errors.New("content is not encrypted")
+		}
+		return content, nil
+	}
+	plaintext, err := encryption.Decrypt(ctx, s.keys, appName, []byte(content.Parts[0].Text), additionalData)
+	if err != nil {
+		return nil, err
+	}
+	decrypted := &events.Content{}
+	if err := json.Unmarshal(plaintext, decrypted); err != nil {
+		return nil, fmt.Errorf("failed to decode content: %w", err)
+	}
+	return decrypted, nil
+}
+
+// encryptEvent returns a copy of an event with its sensitive fields
+// encrypted
+func (s *EncryptedSessionService) encryptEvent(ctx context.Context, scope sessionScope, event *events.Event) (*events.Event, error) {
+	encrypted := *event
+	var err error
+	if encrypted.Content, err = s.encryptContent(ctx, scope.appName, event.Content, eventAdditionalData(scope, event.ID, "content")); err != nil {
+		return nil, err
+	}
+	if event.ErrorMessage != "" {
+		envelope, err := encryption.Encrypt(ctx, s.keys, scope.appName, []byte(event.ErrorMessage), eventAdditionalData(scope, event.ID, "error_message"))
+		if err != nil {
+			return nil, err
+		}
+		encrypted.ErrorMessage = string(envelope)
+	}
+	if encrypted.Actions.StateDelta, err = s.encryptState(ctx, scope, event.Actions.StateDelta); err != nil {
+		return nil, err
+	}
+	if compaction := event.Actions.Compaction; compaction != nil {
+		copied := *compaction
+		if copied.CompactedContent, err = s.encryptContent(ctx, scope.appName, compaction.CompactedContent, eventAdditionalData(scope, event.ID, "compaction")); err != nil {
+			return nil, err
+		}
+		encrypted.Actions.Compaction = &copied
+	}
+	return &encrypted, nil
+}
+
+// decryptEvent returns a copy of an event with its sensitive fields
+// decrypted
+func (s *EncryptedSessionService) decryptEvent(ctx context.Context, scope sessionScope, event *events.Event) (*events.Event, error) {
+	decrypted := *event
+	var err error
+	if decrypted.Content, err = s.decryptContent(ctx, scope.appName, event.Content, eventAdditionalData(scope, event.ID, "content")); err != nil {
+		return nil, fmt.Errorf("event %s: %w", event.ID, err)
+	}
+	switch {
+	case event.ErrorMessage == "":
+	case encryption.IsEncrypted([]byte(event.ErrorMessage)):
+		plaintext, err := encryption.Decrypt(ctx, s.keys, scope.appName, []byte(event.ErrorMessage), eventAdditionalData(scope, event.ID, "error_message"))
+		if err != nil {
+			return nil, fmt.Errorf("event %s: %w", event.ID, err)
+		}
+		decrypted.ErrorMessage = string(plaintext)
+	case !s.allowPlaintext:
+		return nil, fmt.Errorf("event %s: error message is not encrypted", event.ID)
+	}
+	if decrypted.Actions.StateDelta, err = s.decryptState(ctx, scope, event.Actions.StateDelta); err != nil {
+		return nil, fmt.Errorf("event %s: %w", event.ID, err)
+	}
+	if compaction := event.Actions.Compaction; compaction != nil {
+		copied := *compaction
+		if copied.CompactedContent, err = s.decryptContent(ctx, scope.appName, compaction.CompactedContent, eventAdditionalData(scope, event.ID, "compaction")); err != nil {
+			return nil, fmt.Errorf("event %s: %w", event.ID, err)
+		}
+		decrypted.Actions.Compaction = &copied
+	}
+	return &decrypted, nil
+}
+
+// decryptEvents returns decrypted copies of events
+func (s *EncryptedSessionService) decryptEvents(ctx context.Context, scope sessionScope, stored []*events.Event) ([]*events.Event, error) {
+	if stored == nil {
+		return nil, nil
+	}
+	decrypted := make([]*events.Event, len(stored))
+	for i, event := range stored {
+		var err error
+		if decrypted[i], err = s.decryptEvent(ctx, scope, event); err != nil {
+			return nil, err
+		}
+	}
+	return decrypted, nil
+}
+
+// decryptSession decrypts the state and events of a session returned by the
+// wrapped service
+func (s *EncryptedSessionService) decryptSession(ctx context.Context, session *Session) (*Session, error) {
+	state, err := s.decryptState(ctx, scopeOf(session), session.State.ToDict())
+	if err != nil {
+		return nil, fmt.Errorf("session %s: %w", session.ID, err)
+	}
+	decryptedEvents, err := s.decryptEvents(ctx, scopeOf(session), session.Events)
+	if err != nil {
+		return nil, fmt.Errorf("session %s: %w", session.ID, err)
+	}
+
+	decrypted := *session
+	decrypted.State = NewStateWithData(state)
+	decrypted.Events = decryptedEvents
+	return &decrypted, nil
+}
+
+// decryptChange decrypts the event and values of a session change
+func (s *EncryptedSessionService) decryptChange(ctx context.Context, change SessionChange) (SessionChange, error) {
+	scope := sessionScope{change.AppName, change.UserID, change.SessionID}
+	var err error
+	if change.Event != nil {
+		if change.Event, err = s.decryptEvent(ctx, scope, change.Event); err != nil {
+			return change, err
+		}
+	}
+	if change.StateDelta, err = s.decryptState(ctx, scope, change.StateDelta); err != nil {
+		return change, err
+	}
+	if change.State, err = s.decryptState(ctx, scope, change.State); err != nil {
+		return change, err
+	}
+	return change, nil
+}
@@ -0,0 +1,131 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sessions
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ExpiryPolicy sets when the sessions of an app expire. A zero duration
+// disables that limit.
+type ExpiryPolicy struct {
+	// IdleTTL expires sessions that have not been read or written for the
+	// given duration
+	IdleTTL time.Duration
+
+	// MaxAge expires sessions older than the given duration, however active
+	MaxAge time.Duration
+}
+
+// expired reports whether a session created and last used at the given times
+// is expired, and why
+func (p ExpiryPolicy) expired(createTime, lastAccess, now time.Time) (ExpiryReason, bool) {
+	if p.MaxAge > 0 && !createTime.IsZero() && now.Sub(createTime) >= p.MaxAge {
+		return ExpiredMaxAge, true
+	}
+	if p.IdleTTL > 0 && now.Sub(lastAccess) >= p.IdleTTL {
+		return ExpiredIdle, true
+	}
+	return 0, false
+}
+
+// ExpiryReason tells why a session is removed
+type ExpiryReason int
+
+const (
+	// ExpiredIdle marks a session unused for longer than its idle TTL
+	ExpiredIdle ExpiryReason = iota
+	// ExpiredMaxAge marks a session older than its maximum age
+	ExpiredMaxAge
+	// Evicted marks the least recently used session removed to make room for
+	// a new one
+	Evicted
+	// Closed marks a session ended by CloseSession
+	Closed
+)
+
+// String returns the reason's name
+func (r ExpiryReason) String() string {
+	switch r {
+	case ExpiredIdle:
+		return "idle"
+	case ExpiredMaxAge:
+		return "max_age"
+	case Evicted:
+		return "evicted"
+	case Closed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// ExpiryHook is called with a snapshot of a session that is being removed,
+// e.g. to add it to long-term memory first
+type ExpiryHook func(ctx context.Context, session *Session, reason ExpiryReason) error
+
+// Sweeper is implemented by session services that can remove their expired
+// sessions
+type Sweeper interface {
+	// Sweep removes the expired sessions and returns how many were removed
+	Sweep(ctx context.Context) (int, error)
+}
+
+// Janitor sweeps a session service for expired sessions in the background
+type Janitor struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// StartJanitor starts sweeping the given service at every interval until
+// the janitor is stopped. Sweep errors are passed to onError, which may be
+// nil; the failed sessions are retried at the next sweep.
+func StartJanitor(sweeper Sweeper, interval time.Duration, onError func(err error)) (*Janitor, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("janitor interval must be positive, got %v", interval)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	janitor := &Janitor{cancel: cancel, done: make(chan struct{})}
+
+	go func() {
+		defer close(janitor.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// A sweep cut short by Stop is not a failure
+				if _, err := sweeper.Sweep(ctx); err != nil && ctx.Err() == nil && onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+
+	return janitor, nil
+}
+
+// Stop stops the janitor and waits for a sweep in progress to finish. It is
+// safe to call more than once.
+func (j *Janitor) Stop() {
+	j.cancel()
+	<-j.done
+}
@@ -0,0 +1,238 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sessions
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/adrienveepee/adk-go/google/adk/events"
+)
+
+// ExportSchemaVersion is the version of the session export format written by
+// Export. Import reads this version and migrates older ones.
+const ExportSchemaVersion = 1
+
+// SessionExport is the portable form of a session
+type SessionExport struct {
+	SchemaVersion  int                 `json:"schema_version"`
+	ExportedAt     time.Time           `json:"exported_at"`
+	ID             string              `json:"id"`
+	AppName        string              `json:"app_name"`
+	UserID         string              `json:"user_id"`
+	LastUpdateTime time.Time           `json:"last_update_time"`
+	State          ExportedState       `json:"state"`
+	Events         []*events.Event     `json:"events"`
+	Artifacts      []ArtifactReference `json:"artifacts,omitempty"`
+}
+
+// ExportedState holds a session's state by scope. Temporary values are never
+// exported.
+type ExportedState struct {
+	App     map[string]interface{} `json:"app,omitempty"`
+	User    map[string]interface{} `json:"user,omitempty"`
+	Session map[string]interface{} `json:"session,omitempty"`
+}
+
+// ArtifactReference names an artifact saved during a session and its latest
+// version at export time. Artifact data is not exported.
+type ArtifactReference struct {
+	Filename string `json:"filename"`
+	Version  int    `json:"version"`
+}
+
+// exportMigrations upgrade an export document; migration i turns schema
+// version i into version i+1
+var exportMigrations = []func(data []byte) ([]byte, error){
+	migrateExportV0,
+}
+
+// NewSessionExport returns the portable form of a session
+func NewSessionExport(session *Session) *SessionExport {
+	scoped := splitStateDelta(session.State.ToDict())
+	export := &SessionExport{
+		SchemaVersion:  ExportSchemaVersion,
+		ExportedAt:     time.Now(),
+		ID:             session.ID,
+		AppName:        session.AppName,
+		UserID:         session.UserID,
+		LastUpdateTime: session.LastUpdateTime,
+		State: ExportedState{
+			App:     scoped.app,
+			User:    scoped.user,
+			Session: scoped.session,
+		},
+		Events:    session.Events,
+		Artifacts: artifactReferences(session.Events),
+	}
+	if export.Events == nil {
+		export.Events = make([]*events.Event, 0)
+	}
+	return export
+}
+
+// Session returns a detached session holding the exported state and events
+func (e *SessionExport) Session() *Session {
+	state := make(map[string]interface{})
+	for _, scope := range []map[string]interface{}{e.State.Session, e.State.App, e.State.User} {
+		for key, value := range scope {
+			state[key] = value
+		}
+	}
+
+	session := NewSession(e.AppName, e.UserID, e.ID, state)
+	session.Events = append(session.Events, e.Events...)
+	session.LastUpdateTime = e.LastUpdateTime
+	session.Version = int64(len(e.Events))
+	return session
+}
+
+// Export writes a session as JSON in the current export schema
+func Export(session *Session, w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(NewSessionExport(session)); err != nil {
+		return fmt.Errorf("failed to export session %s: %w", session.ID, err)
+	}
+	return nil
+}
+
+// Import reads a session written by Export, migrating older schema versions.
+// Use its Session method for a detached session, or Restore to store it in a
+// session service.
+func Import(r io.Reader) (*SessionExport, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var header struct {
+		SchemaVersion int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, fmt.Errorf("invalid session export: %w", err)
+	}
+	if header.SchemaVersion > ExportSchemaVersion {
+		return nil, fmt.Errorf("session export schema version %d is newer than the supported version %d", header.SchemaVersion, ExportSchemaVersion)
+	}
+	if header.SchemaVersion < 0 {
+		return nil, fmt.Errorf("invalid session export schema version %d", header.SchemaVersion)
+	}
+	for version := header.SchemaVersion; version < ExportSchemaVersion; version++ {
+		data, err = exportMigrations[version](data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to migrate session export from schema version %d: %w", version, err)
+		}
+	}
+
+	export := &SessionExport{}
+	if err := json.Unmarshal(data, export); err != nil {
+		return nil, fmt.Errorf("invalid session export: %w", err)
+	}
+	if export.ID == "" || export.AppName == "" || export.UserID == "" {
+		return nil, fmt.Errorf("invalid session export: missing session ID, app name or user ID")
+	}
+	if export.Events == nil {
+		export.Events = make([]*events.Event, 0)
+	}
+	return export, nil
+}
+
+// Restore stores an imported session in a session service under its own app,
+// user and session ID, which must be free. The session is created with the
+// values no event sets, then its events are appended so that their state
+// deltas rebuild the rest of the state in order. Exported app and user values
+// overwrite the service's.
+func Restore(service SessionService, export *SessionExport) (*Session, error) {
+	existing, err := service.GetSession(export.AppName, export.UserID, export.ID, &GetSessionConfig{NumRecentEvents: 1})
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("session %s already exists", export.ID)
+	}
+
+	initialState := export.Session().State.ToDict()
+	for _, event := range export.Events {
+		for key := range event.Actions.StateDelta {
+			delete(initialState, key)
+		}
+	}
+
+	restored, err := service.CreateSession(export.AppName, export.UserID, export.ID, initialState)
+	if err != nil {
+		return nil, err
+	}
+	for _, event := range export.Events {
+		// Appending trims temporary values from the delta, which must not
+		// change the export
+		copied := *event
+		if event.Actions.StateDelta != nil {
+			copied.Actions.StateDelta = make(map[string]interface{}, len(event.Actions.StateDelta))
+			for key, value := range event.Actions.StateDelta {
+				copied.Actions.StateDelta[key] = value
+			}
+		}
+		if err := service.AppendEvent(restored, &copied); err != nil {
+			return nil, err
+		}
+	}
+	return restored, nil
+}
+
+// artifactReferences collects the artifacts saved by a session's events, at
+// their latest version
+func artifactReferences(sessionEvents []*events.Event) []ArtifactReference {
+	versions := make(map[string]int)
+	for _, event := range sessionEvents {
+		for filename, version := range event.Actions.ArtifactDelta {
+			switch v := version.(type) {
+			case int:
+				versions[filename] = v
+			case int64:
+				versions[filename] = int(v)
+			case float64:
+				versions[filename] = int(v)
+			default:
+				versions[filename] = 0
+			}
+		}
+	}
+
+	references := make([]ArtifactReference, 0, len(versions))
+	for filename, version := range versions {
+		references = append(references, ArtifactReference{Filename: filename, Version: version})
+	}
+	sort.Slice(references, func(i, j int) bool {
+		return references[i].Filename < references[j].Filename
+	})
+	return references
+}
+
+// migrateExportV0 migrates a plain JSON encoding of a Session, such as a file
+// session service snapshot, to schema version 1
+func migrateExportV0(data []byte) ([]byte, error) {
+	session := &Session{}
+	if err := json.Unmarshal(data, session); err != nil {
+		return nil, err
+	}
+	if session.State == nil {
+		session.State = NewState()
+	}
+	return json.Marshal(NewSessionExport(session))
+}
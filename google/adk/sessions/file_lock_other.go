@@ -0,0 +1,33 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd || windows)
+
+package sessions
+
+import "os"
+
+// fileLocking reports whether lockFile excludes other processes; without
+// file locks only single-process use is safe
+const fileLocking = false
+
+// lockFile is a no-op on platforms without file locks
+func lockFile(file *os.File, exclusive bool) error {
+	return nil
+}
+
+// unlockFile is a no-op on platforms without file locks
+func unlockFile(file *os.File) error {
+	return nil
+}
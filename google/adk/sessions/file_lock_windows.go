@@ -0,0 +1,66 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows
+
+package sessions
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// fileLocking reports whether lockFile excludes other processes
+const fileLocking = true
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+const (
+	// lockfileExclusiveLock requests an exclusive lock from LockFileEx
+	lockfileExclusiveLock = 0x2
+	// lockRange is the low and high half of the number of bytes locked,
+	// covering the whole file
+	lockRange = 0xFFFFFFFF
+)
+
+// lockFile locks a whole open file, waiting for conflicting locks held by
+// other processes to be released. Windows locks are mandatory, so only the
+// dedicated lock files are locked, never the files holding data.
+func lockFile(file *os.File, exclusive bool) error {
+	var flags uintptr
+	if exclusive {
+		flags = lockfileExclusiveLock
+	}
+	overlapped := new(syscall.Overlapped)
+	result, _, err := procLockFileEx.Call(file.Fd(), flags, 0, lockRange, lockRange, uintptr(unsafe.Pointer(overlapped)))
+	if result == 0 {
+		return err
+	}
+	return nil
+}
+
+// unlockFile releases the lock placed by lockFile
+func unlockFile(file *os.File) error {
+	overlapped := new(syscall.Overlapped)
+	result, _, err := procUnlockFileEx.Call(file.Fd(), 0, lockRange, lockRange, uintptr(unsafe.Pointer(overlapped)))
+	if result == 0 {
+		return err
+	}
+	return nil
+}
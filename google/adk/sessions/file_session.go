@@ -0,0 +1,897 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sessions
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adrienveepee/adk-go/google/adk/events"
+	"github.com/google/uuid"
+)
+
+// Files of a session directory, and of the app and user directories holding
+// shared state. Shared state files start with a dot, which escaped names never
+// do, so that they cannot clash with user or session directories.
+const (
+	fileSnapshotName     = "snapshot.json"
+	fileLogName          = "events.jsonl"
+	fileLockName         = "lock"
+	fileInitialStateName = "initial_state.json"
+	fileAppStateName     = ".app_state.json"
+	fileUserStateName    = ".user_state.json"
+)
+
+// DefaultCompactionThreshold is the number of logged events after which the
+// file session service compacts a session's log into its snapshot
+const DefaultCompactionThreshold = 1000
+
+// SyncMode controls when the file session service flushes writes to disk
+type SyncMode int
+
+const (
+	// SyncAlways flushes every write to disk before returning, so that
+	// appended events survive a machine crash
+	SyncAlways SyncMode = iota
+	// SyncNever leaves flushing to the operating system; events survive a
+	// process crash but recent ones may be lost if the machine crashes
+	SyncNever
+)
+
+// FileSessionService stores sessions under a root directory, one directory
+// per session at app/user/session. Each session has a JSON snapshot and an
+// append-only JSONL log of the events appended since; the log is compacted
+// into the snapshot once it grows past the compaction threshold. A partially
+// written last line, left by a crash, is discarded on the next write.
+//
+// Sessions are locked with advisory file locks, so several processes can
+// share a root directory on platforms that support flock. State values must
+// be JSON-serializable and are read back as their JSON types.
+type FileSessionService struct {
+	root                string
+	syncMode            SyncMode
+	compactionThreshold int
+	pollInterval        time.Duration
+
+	mu    sync.Mutex
+	cache map[string]*fileSessionEntry
+}
+
+// fileSessionEntry caches a loaded session together with the position in its
+// files it was loaded up to
+type fileSessionEntry struct {
+	session       *Session
+	snapshotSize  int64
+	snapshotMtime time.Time
+	logOffset     int64
+	logRecords    int
+}
+
+// fileEventRecord is one line of a session's event log
+type fileEventRecord struct {
+	Version int64         `json:"version"`
+	Event   *events.Event `json:"event"`
+}
+
+// ErrFileLockingUnsupported is returned by NewFileSessionService on platforms
+// without file locks, where processes sharing a root would corrupt it
+var ErrFileLockingUnsupported = errors.New("file locks are not supported on this platform; use NewSingleProcessFileSessionService")
+
+// NewFileSessionService creates a file session service rooted at the given
+// directory, creating it if needed. Several processes may share the root.
+func NewFileSessionService(root string) (*FileSessionService, error) {
+	if !fileLocking {
+		return nil, ErrFileLockingUnsupported
+	}
+	return NewSingleProcessFileSessionService(root)
+}
+
+// NewSingleProcessFileSessionService creates a file session service like
+// NewFileSessionService, also on platforms without file locks. Only one
+// process may then use the root at a time.
+func NewSingleProcessFileSessionService(root string) (*FileSessionService, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create session root: %w", err)
+	}
+	return &FileSessionService{
+		root:                root,
+		syncMode:            SyncAlways,
+		compactionThreshold: DefaultCompactionThreshold,
+		pollInterval:        DefaultPollInterval,
+		cache:               make(map[string]*fileSessionEntry),
+	}, nil
+}
+
+// SetSyncMode sets when writes are flushed to disk
+func (s *FileSessionService) SetSyncMode(mode SyncMode) *FileSessionService {
+	s.syncMode = mode
+	return s
+}
+
+// SetCompactionThreshold sets the number of logged events after which a
+// session's log is compacted; 0 disables automatic compaction
+func (s *FileSessionService) SetCompactionThreshold(threshold int) *FileSessionService {
+	s.compactionThreshold = threshold
+	return s
+}
+
+// SetPollInterval sets how often subscribed sessions are checked for changes
+func (s *FileSessionService) SetPollInterval(interval time.Duration) *FileSessionService {
+	s.pollInterval = interval
+	return s
+}
+
+// CreateSession creates a new session. Initial app and user values are
+// shared with the app's and user's other sessions.
+func (s *FileSessionService) CreateSession(appName, userID, sessionID string, initialState map[string]interface{}) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sessionID == "" {
+		sessionID = uuid.New().String()
+	}
+	dir, err := s.sessionDir(appName, userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create session directory: %w", err)
+	}
+
+	var session *Session
+	err = s.withLock(filepath.Join(dir, fileLockName), true, func() error {
+		if _, err := os.Stat(filepath.Join(dir, fileSnapshotName)); err == nil {
+			return fmt.Errorf("session %s already exists", sessionID)
+		}
+
+		scoped := splitStateDelta(initialState)
+		if err := s.applySharedDelta(appName, userID, scoped); err != nil {
+			return err
+		}
+
+		session = NewSession(appName, userID, sessionID, scoped.session)
+		return s.writeNewSession(dir, session, scoped.session)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.view(session)
+}
+
+// writeNewSession writes the files of a new session, the snapshot last since
+// it marks the session as existing; the caller holds the session's lock
+func (s *FileSessionService) writeNewSession(dir string, session *Session, initialState map[string]interface{}) error {
+	data, err := json.Marshal(initialState)
+	if err != nil {
+		return fmt.Errorf("failed to encode initial state: %w", err)
+	}
+	if err := s.writeFileAtomic(filepath.Join(dir, fileInitialStateName), data); err != nil {
+		return err
+	}
+
+	entry := &fileSessionEntry{session: session}
+	if err := s.writeSnapshot(dir, entry); err != nil {
+		return err
+	}
+	s.cache[dir] = entry
+	return nil
+}
+
+// readInitialState reads the session values a session was created with
+func (s *FileSessionService) readInitialState(dir string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(filepath.Join(dir, fileInitialStateName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var initialState map[string]interface{}
+	if err := json.Unmarshal(data, &initialState); err != nil {
+		return nil, fmt.Errorf("failed to read initial state: %w", err)
+	}
+	return initialState, nil
+}
+
+// GetSession retrieves a snapshot of a session by ID. Its state merges the
+// session's values with the app and user values.
+func (s *FileSessionService) GetSession(appName, userID, sessionID string, config *GetSessionConfig) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, err := s.loadSession(appName, userID, sessionID)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	session, err := s.view(entry.session)
+	if err != nil {
+		return nil, err
+	}
+	session.Events = filterEvents(session.Events, config)
+	return session, nil
+}
+
+// DeleteSession deletes a session and its files
+func (s *FileSessionService) DeleteSession(appName, userID, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir, err := s.sessionDir(appName, userID, sessionID)
+	if err != nil {
+		return err
+	}
+	delete(s.cache, dir)
+
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+	return s.withLock(filepath.Join(dir, fileLockName), true, func() error {
+		return os.RemoveAll(dir)
+	})
+}
+
+// ListSessions lists a page of a user's sessions, without their events
+func (s *FileSessionService) ListSessions(appName, userID string, config *ListSessionsConfig) (*ListSessionsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	userDir, err := s.userDir(appName, userID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(userDir)
+	if os.IsNotExist(err) {
+		return paginateSessions(nil, config)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []*Session
+	for _, dirEntry := range entries {
+		if !dirEntry.IsDir() || strings.HasPrefix(dirEntry.Name(), ".") {
+			continue
+		}
+		sessionID, err := url.PathUnescape(dirEntry.Name())
+		if err != nil {
+			continue
+		}
+		entry, err := s.loadSession(appName, userID, sessionID)
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			continue
+		}
+		session, err := s.view(entry.session)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return paginateSessions(sessions, config)
+}
+
+// AppendEvent appends an event to a session's log and updates the given
+// session to match. The event's state delta is applied by scope; temporary
+// values are removed from it and never persisted.
+func (s *FileSessionService) AppendEvent(session *Session, event *events.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir, err := s.sessionDir(session.AppName, session.UserID, session.ID)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(dir, fileSnapshotName)); os.IsNotExist(err) {
+		return nil // Session not found
+	}
+
+	return s.withLock(filepath.Join(dir, fileLockName), true, func() error {
+		entry, err := s.load(dir, true)
+		if err != nil {
+			return err
+		}
+		stored := entry.session
+
+		// Reject writers working from an outdated copy of the session
+		if stored.Version != session.Version {
+			return &ConflictError{
+				SessionID:       session.ID,
+				ExpectedVersion: session.Version,
+				ActualVersion:   stored.Version,
+			}
+		}
+
+		// Write the app and user values first, then log the event without
+		// its temporary values; the writer keeps the temporary values for the
+		// rest of its invocation
+		delta := make(map[string]interface{}, len(event.Actions.StateDelta))
+		for key, value := range event.Actions.StateDelta {
+			delta[key] = value
+		}
+		scoped := splitStateDelta(delta)
+		if err := s.applySharedDelta(session.AppName, session.UserID, scoped); err != nil {
+			return err
+		}
+		trimTempDelta(event)
+
+		if err := s.appendRecord(dir, entry, &fileEventRecord{Version: stored.Version + 1, Event: event}); err != nil {
+			// The log may hold part of the record; reload it on next use
+			delete(s.cache, dir)
+			return err
+		}
+
+		// Only update the cached and the given session once the event is
+		// stored, so that a failed append leaves them as they were
+		stored.State.applyDelta(scoped.session)
+		session.State.applyDelta(delta)
+		stored.Events = append(stored.Events, event)
+		stored.LastUpdateTime = event.Timestamp
+		stored.Version++
+
+		session.Events = append(session.Events, event)
+		session.LastUpdateTime = stored.LastUpdateTime
+		session.Version = stored.Version
+
+		if s.compactionThreshold > 0 && entry.logRecords >= s.compactionThreshold {
+			return s.compact(dir, entry)
+		}
+		return nil
+	})
+}
+
+// ListEvents lists the events of a session selected by the config
+func (s *FileSessionService) ListEvents(appName, userID, sessionID string, config *GetSessionConfig) ([]*events.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, err := s.loadSession(appName, userID, sessionID)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	return filterEvents(entry.session.snapshot().GetEvents(), config), nil
+}
+
+// CloseSession drops the session from the service's cache
+func (s *FileSessionService) CloseSession(appName, userID, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir, err := s.sessionDir(appName, userID, sessionID)
+	if err != nil {
+		return err
+	}
+	delete(s.cache, dir)
+	return nil
+}
+
+// Compact folds a session's event log into its snapshot
+func (s *FileSessionService) Compact(appName, userID, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir, err := s.sessionDir(appName, userID, sessionID)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(dir, fileSnapshotName)); os.IsNotExist(err) {
+		return fmt.Errorf("session %s not found", sessionID)
+	}
+
+	return s.withLock(filepath.Join(dir, fileLockName), true, func() error {
+		entry, err := s.load(dir, true)
+		if err != nil {
+			return err
+		}
+		return s.compact(dir, entry)
+	})
+}
+
+// RewindSession removes the events after the given event and recomputes the
+// session's own state from the remaining events. The session is rewritten as
+// a new snapshot with an empty log.
+func (s *FileSessionService) RewindSession(appName, userID, sessionID, toEventID string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir, err := s.sessionDir(appName, userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(dir, fileSnapshotName)); os.IsNotExist(err) {
+		return nil, fmt.Errorf("session %s not found", sessionID)
+	}
+
+	var session *Session
+	err = s.withLock(filepath.Join(dir, fileLockName), true, func() error {
+		entry, err := s.load(dir, true)
+		if err != nil {
+			return err
+		}
+		stored := entry.session
+		index, err := eventIndex(stored.Events, sessionID, toEventID)
+		if err != nil {
+			return err
+		}
+		initialState, err := s.readInitialState(dir)
+		if err != nil {
+			return err
+		}
+
+		kept := make([]*events.Event, index+1)
+		copy(kept, stored.Events)
+		stored.Events = kept
+		stored.State = NewStateWithData(replayState(initialState, kept))
+		stored.LastUpdateTime = time.Now()
+		stored.Version++
+
+		// Log records past the snapshot's events would be replayed, so the
+		// log is emptied along with the snapshot write
+		if err := s.compact(dir, entry); err != nil {
+			delete(s.cache, dir)
+			return err
+		}
+		session = stored
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.view(session)
+}
+
+// ForkSession copies a session up to and including the given event into a
+// new session
+func (s *FileSessionService) ForkSession(appName, userID, sessionID, toEventID, newSessionID string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, err := s.loadSession(appName, userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, fmt.Errorf("session %s not found", sessionID)
+	}
+	source := entry.session
+	index, err := eventIndex(source.Events, sessionID, toEventID)
+	if err != nil {
+		return nil, err
+	}
+	sourceDir, err := s.sessionDir(appName, userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	initialState, err := s.readInitialState(sourceDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if newSessionID == "" {
+		newSessionID = uuid.New().String()
+	}
+	dir, err := s.sessionDir(appName, userID, newSessionID)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create session directory: %w", err)
+	}
+
+	kept := make([]*events.Event, index+1)
+	copy(kept, source.Events)
+	fork := NewSession(appName, userID, newSessionID, replayState(initialState, kept))
+	fork.Events = kept
+	fork.Version = int64(len(kept))
+
+	err = s.withLock(filepath.Join(dir, fileLockName), true, func() error {
+		if _, err := os.Stat(filepath.Join(dir, fileSnapshotName)); err == nil {
+			return fmt.Errorf("session %s already exists", newSessionID)
+		}
+		return s.writeNewSession(dir, fork, initialState)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.view(fork)
+}
+
+// Subscribe follows the changes made to a session from then on, including
+// by other processes sharing the root directory. The session is checked for
+// changes at every poll interval, so changes made in quick succession may be
+// reported together as their net effect.
+func (s *FileSessionService) Subscribe(appName, userID, sessionID string) (<-chan SessionChange, func()) {
+	sub := newSubscription(appName, userID, sessionID)
+	cancel := pollSubscription(sub, s.pollInterval, func(config *GetSessionConfig) (*Session, error) {
+		return s.GetSession(appName, userID, sessionID, config)
+	})
+	return sub.changes, cancel
+}
+
+// loadSession loads a session under a shared lock, or returns nil if it does
+// not exist; the caller holds the mutex
+func (s *FileSessionService) loadSession(appName, userID, sessionID string) (*fileSessionEntry, error) {
+	dir, err := s.sessionDir(appName, userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(dir, fileSnapshotName)); os.IsNotExist(err) {
+		return nil, nil // Session not found
+	}
+
+	var entry *fileSessionEntry
+	err = s.withLock(filepath.Join(dir, fileLockName), false, func() error {
+		entry, err = s.load(dir, false)
+		return err
+	})
+	return entry, err
+}
+
+// load brings the cached session of a directory up to date with its files,
+// replaying only the log records written since it was last loaded. With
+// repair set, which requires the exclusive lock, a partially written last
+// record is truncated from the log.
+func (s *FileSessionService) load(dir string, repair bool) (*fileSessionEntry, error) {
+	snapshotPath := filepath.Join(dir, fileSnapshotName)
+	info, err := os.Stat(snapshotPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reload from the snapshot if it was rewritten, e.g. by a compaction in
+	// another process
+	entry := s.cache[dir]
+	if entry == nil || entry.snapshotSize != info.Size() || !entry.snapshotMtime.Equal(info.ModTime()) {
+		data, err := os.ReadFile(snapshotPath)
+		if err != nil {
+			return nil, err
+		}
+		session := &Session{}
+		if err := json.Unmarshal(data, session); err != nil {
+			return nil, fmt.Errorf("failed to read session snapshot %s: %w", snapshotPath, err)
+		}
+		if session.State == nil {
+			session.State = NewState()
+		}
+		if session.Events == nil {
+			session.Events = make([]*events.Event, 0)
+		}
+		entry = &fileSessionEntry{
+			session:       session,
+			snapshotSize:  info.Size(),
+			snapshotMtime: info.ModTime(),
+		}
+		s.cache[dir] = entry
+	}
+
+	if err := s.replayLog(dir, entry, repair); err != nil {
+		delete(s.cache, dir)
+		return nil, err
+	}
+	return entry, nil
+}
+
+// replayLog applies the log records past the entry's offset
+func (s *FileSessionService) replayLog(dir string, entry *fileSessionEntry, repair bool) error {
+	logPath := filepath.Join(dir, fileLogName)
+	file, err := os.Open(logPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(entry.logOffset, io.SeekStart); err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// A last line without a newline was cut short by a crash
+			if len(line) > 0 && repair {
+				return os.Truncate(logPath, entry.logOffset)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		record := &fileEventRecord{}
+		if err := json.Unmarshal(bytes.TrimSpace(line), record); err != nil {
+			return fmt.Errorf("corrupt record at offset %d of %s: %w", entry.logOffset, logPath, err)
+		}
+
+		// Records already folded into the snapshot are skipped, which makes
+		// an interrupted compaction harmless
+		session := entry.session
+		if record.Version > session.Version {
+			if record.Version != session.Version+1 || record.Event == nil {
+				return fmt.Errorf("unexpected record version %d at offset %d of %s", record.Version, entry.logOffset, logPath)
+			}
+			session.State.applyDelta(splitStateDelta(record.Event.Actions.StateDelta).session)
+			session.Events = append(session.Events, record.Event)
+			session.LastUpdateTime = record.Event.Timestamp
+			session.Version = record.Version
+		}
+		entry.logOffset += int64(len(line))
+		entry.logRecords++
+	}
+}
+
+// appendRecord writes a record at the end of a session's log
+func (s *FileSessionService) appendRecord(dir string, entry *fileSessionEntry, record *fileEventRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	data = append(data, '\n')
+
+	file, err := os.OpenFile(filepath.Join(dir, fileLogName), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(data); err != nil {
+		return err
+	}
+	if s.syncMode == SyncAlways {
+		if err := file.Sync(); err != nil {
+			return err
+		}
+	}
+
+	entry.logOffset += int64(len(data))
+	entry.logRecords++
+	return nil
+}
+
+// compact writes the session to its snapshot and empties its log
+func (s *FileSessionService) compact(dir string, entry *fileSessionEntry) error {
+	if err := s.writeSnapshot(dir, entry); err != nil {
+		return err
+	}
+
+	logPath := filepath.Join(dir, fileLogName)
+	if err := os.Truncate(logPath, 0); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	entry.logOffset = 0
+	entry.logRecords = 0
+	return nil
+}
+
+// writeSnapshot atomically replaces a session's snapshot
+func (s *FileSessionService) writeSnapshot(dir string, entry *fileSessionEntry) error {
+	data, err := json.Marshal(entry.session)
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %w", err)
+	}
+
+	path := filepath.Join(dir, fileSnapshotName)
+	if err := s.writeFileAtomic(path, data); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	entry.snapshotSize = info.Size()
+	entry.snapshotMtime = info.ModTime()
+	return nil
+}
+
+// writeFileAtomic replaces a file through a temporary file and a rename, so
+// that readers see either the old or the new content
+func (s *FileSessionService) writeFileAtomic(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if s.syncMode == SyncAlways {
+		if err := file.Sync(); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+
+	if s.syncMode == SyncAlways {
+		// Persist the rename itself
+		if dir, err := os.Open(filepath.Dir(path)); err == nil {
+			dir.Sync()
+			dir.Close()
+		}
+	}
+	return nil
+}
+
+// view returns a snapshot of a stored session whose state merges in the app
+// and user values
+func (s *FileSessionService) view(session *Session) (*Session, error) {
+	appPath, userPath, err := s.sharedStatePaths(session.AppName, session.UserID)
+	if err != nil {
+		return nil, err
+	}
+	appState, err := s.readStateFile(appPath)
+	if err != nil {
+		return nil, err
+	}
+	userState, err := s.readStateFile(userPath)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := session.snapshot()
+	snapshot.State = mergeState(appState, userState, session.State.ToDict())
+	return snapshot, nil
+}
+
+// applySharedDelta stores the app and user values of a delta
+func (s *FileSessionService) applySharedDelta(appName, userID string, delta scopedDelta) error {
+	appPath, userPath, err := s.sharedStatePaths(appName, userID)
+	if err != nil {
+		return err
+	}
+	if err := s.updateStateFile(appPath, delta.app); err != nil {
+		return err
+	}
+	return s.updateStateFile(userPath, delta.user)
+}
+
+// sharedStatePaths returns the files holding the app's and the user's state
+func (s *FileSessionService) sharedStatePaths(appName, userID string) (string, string, error) {
+	userDir, err := s.userDir(appName, userID)
+	if err != nil {
+		return "", "", err
+	}
+	return filepath.Join(filepath.Dir(userDir), fileAppStateName), filepath.Join(userDir, fileUserStateName), nil
+}
+
+// readStateFile reads a shared state file under a shared lock
+func (s *FileSessionService) readStateFile(path string) (map[string]interface{}, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	var values map[string]interface{}
+	err := s.withLock(path+".lock", false, func() error {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(data, &values)
+	})
+	return values, err
+}
+
+// updateStateFile merges values into a shared state file under an exclusive
+// lock
+func (s *FileSessionService) updateStateFile(path string, updates map[string]interface{}) error {
+	if len(updates) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	return s.withLock(path+".lock", true, func() error {
+		values := make(map[string]interface{})
+		data, err := os.ReadFile(path)
+		if err == nil {
+			if err := json.Unmarshal(data, &values); err != nil {
+				return fmt.Errorf("failed to read shared state %s: %w", path, err)
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+
+		for key, value := range updates {
+			values[key] = value
+		}
+		data, err = json.Marshal(values)
+		if err != nil {
+			return fmt.Errorf("failed to encode shared state: %w", err)
+		}
+		return s.writeFileAtomic(path, data)
+	})
+}
+
+// withLock runs fn holding a file lock, shared or exclusive
+func (s *FileSessionService) withLock(path string, exclusive bool, fn func() error) error {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := lockFile(file, exclusive); err != nil {
+		return fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	defer unlockFile(file)
+
+	return fn()
+}
+
+// userDir returns the directory holding a user's sessions
+func (s *FileSessionService) userDir(appName, userID string) (string, error) {
+	app, err := escapePathComponent(appName)
+	if err != nil {
+		return "", err
+	}
+	user, err := escapePathComponent(userID)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(s.root, app, user), nil
+}
+
+// sessionDir returns the directory holding a session's files
+func (s *FileSessionService) sessionDir(appName, userID, sessionID string) (string, error) {
+	userDir, err := s.userDir(appName, userID)
+	if err != nil {
+		return "", err
+	}
+	session, err := escapePathComponent(sessionID)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(userDir, session), nil
+}
+
+// escapePathComponent turns a name into a single, safe path component
+func escapePathComponent(name string) (string, error) {
+	if name == "" {
+		return "", errors.New("empty name in session path")
+	}
+	escaped := url.PathEscape(name)
+	escaped = strings.ReplaceAll(escaped, "\\", "%5C")
+	if strings.HasPrefix(escaped, ".") {
+		escaped = "%2E" + escaped[1:]
+	}
+	return escaped, nil
+}
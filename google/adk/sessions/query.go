@@ -0,0 +1,198 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sessions
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/adrienveepee/adk-go/google/adk/events"
+)
+
+// GetSessionConfig narrows the events loaded with a session. A nil config
+// loads all events. The filters are applied first, then NumRecentEvents keeps
+// the most recent of the matching events.
+type GetSessionConfig struct {
+	// NumRecentEvents keeps only the given number of most recent events; 0
+	// keeps all of them
+	NumRecentEvents int
+
+	// AfterTimestamp keeps only events newer than the given time, unless it
+	// is zero
+	AfterTimestamp time.Time
+
+	// Authors keeps only events by one of the given authors, unless empty
+	Authors []string
+
+	// Branch keeps only events on the given branch or on branches nested
+	// under it, unless empty
+	Branch string
+}
+
+// matches reports whether an event passes the config's filters
+func (c *GetSessionConfig) matches(event *events.Event) bool {
+	if !c.AfterTimestamp.IsZero() && !event.Timestamp.After(c.AfterTimestamp) {
+		return false
+	}
+	if len(c.Authors) > 0 {
+		found := false
+		for _, author := range c.Authors {
+			if event.Author == author {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if c.Branch != "" && event.Branch != c.Branch && !strings.HasPrefix(event.Branch, c.Branch+".") {
+		return false
+	}
+	return true
+}
+
+// filterEvents returns the events selected by a config, oldest first
+func filterEvents(sessionEvents []*events.Event, config *GetSessionConfig) []*events.Event {
+	if config == nil {
+		return sessionEvents
+	}
+
+	filtered := make([]*events.Event, 0, len(sessionEvents))
+	for _, event := range sessionEvents {
+		if config.matches(event) {
+			filtered = append(filtered, event)
+		}
+	}
+	if config.NumRecentEvents > 0 && len(filtered) > config.NumRecentEvents {
+		filtered = filtered[len(filtered)-config.NumRecentEvents:]
+	}
+	return filtered
+}
+
+// SessionOrder is the order in which sessions are listed
+type SessionOrder int
+
+const (
+	// OrderByID lists sessions by ascending ID
+	OrderByID SessionOrder = iota
+	// OrderByLastUpdateTime lists the most recently updated sessions first
+	OrderByLastUpdateTime
+)
+
+// ListSessionsConfig selects a page of sessions. A nil config lists all
+// sessions by ID.
+type ListSessionsConfig struct {
+	// PageSize is the maximum number of sessions returned; 0 returns all of
+	// them
+	PageSize int
+
+	// PageToken continues the listing after a previous page; empty starts
+	// from the beginning
+	PageToken string
+
+	// OrderBy sets the order of the sessions
+	OrderBy SessionOrder
+}
+
+// ListSessionsResponse is a page of sessions. The sessions carry their state
+// but no events.
+type ListSessionsResponse struct {
+	Sessions []*Session
+
+	// NextPageToken continues the listing; empty on the last page
+	NextPageToken string
+}
+
+// sessionPageToken is the position after the last session of a page
+type sessionPageToken struct {
+	OrderBy    SessionOrder `json:"order_by"`
+	UpdateTime int64        `json:"update_time"`
+	ID         string       `json:"id"`
+}
+
+// encodePageToken returns the token continuing after a session
+func encodePageToken(order SessionOrder, session *Session) string {
+	data, _ := json.Marshal(&sessionPageToken{OrderBy: order, UpdateTime: session.LastUpdateTime.UnixNano(), ID: session.ID})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodePageToken parses a page token, which must come from a listing with
+// the same order
+func decodePageToken(token string, order SessionOrder) (*sessionPageToken, error) {
+	if token == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid page token: %w", err)
+	}
+	position := &sessionPageToken{}
+	if err := json.Unmarshal(data, position); err != nil {
+		return nil, fmt.Errorf("invalid page token: %w", err)
+	}
+	if position.OrderBy != order {
+		return nil, fmt.Errorf("page token was issued for another order")
+	}
+	return position, nil
+}
+
+// sessionBefore reports whether session a is listed before session b
+func sessionBefore(order SessionOrder, a, b *sessionPageToken) bool {
+	if order == OrderByLastUpdateTime && a.UpdateTime != b.UpdateTime {
+		return a.UpdateTime > b.UpdateTime
+	}
+	return a.ID < b.ID
+}
+
+// paginateSessions sorts sessions and returns the page selected by a config.
+// The sessions' events are dropped.
+func paginateSessions(sessions []*Session, config *ListSessionsConfig) (*ListSessionsResponse, error) {
+	if config == nil {
+		config = &ListSessionsConfig{}
+	}
+	if config.PageSize < 0 {
+		return nil, fmt.Errorf("page size must not be negative, got %d", config.PageSize)
+	}
+	after, err := decodePageToken(config.PageToken, config.OrderBy)
+	if err != nil {
+		return nil, err
+	}
+
+	position := func(session *Session) *sessionPageToken {
+		return &sessionPageToken{UpdateTime: session.LastUpdateTime.UnixNano(), ID: session.ID}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessionBefore(config.OrderBy, position(sessions[i]), position(sessions[j]))
+	})
+
+	response := &ListSessionsResponse{Sessions: make([]*Session, 0)}
+	for _, session := range sessions {
+		if after != nil && !sessionBefore(config.OrderBy, after, position(session)) {
+			continue
+		}
+		if config.PageSize > 0 && len(response.Sessions) == config.PageSize {
+			response.NextPageToken = encodePageToken(config.OrderBy, response.Sessions[len(response.Sessions)-1])
+			break
+		}
+		session.Events = make([]*events.Event, 0)
+		response.Sessions = append(response.Sessions, session)
+	}
+	return response, nil
+}
@@ -0,0 +1,47 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sessions
+
+import (
+	"fmt"
+
+	"github.com/adrienveepee/adk-go/google/adk/events"
+)
+
+// eventIndex returns the position of an event in a session's events
+func eventIndex(sessionEvents []*events.Event, sessionID, eventID string) (int, error) {
+	for i, event := range sessionEvents {
+		if event.ID == eventID {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("event %s not found in session %s", eventID, sessionID)
+}
+
+// replayState returns a session's own state after the given events, starting
+// from the state it was created with. App and user values are shared with
+// other sessions and not part of it.
+func replayState(initialState map[string]interface{}, sessionEvents []*events.Event) map[string]interface{} {
+	state := make(map[string]interface{}, len(initialState))
+	for key, value := range initialState {
+		state[key] = value
+	}
+	for _, event := range sessionEvents {
+		for key, value := range splitStateDelta(event.Actions.StateDelta).session {
+			state[key] = value
+		}
+	}
+	return state
+}
@@ -15,6 +15,12 @@
 package sessions
 
 import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -22,16 +28,35 @@ import (
 	"github.com/google/uuid"
 )
 
-// State represents session state with key-value storage
+// State key prefixes selecting the scope of a value. Unprefixed keys belong
+// to the session.
+const (
+	// AppPrefix marks values shared by all users and sessions of the app
+	AppPrefix = "app:"
+	// UserPrefix marks values shared by all sessions of the user
+	UserPrefix = "user:"
+	// TempPrefix marks values that live for the current invocation only and
+	// are never persisted
+	TempPrefix = "temp:"
+)
+
+// State represents session state with key-value storage. Changes made with
+// Set and Update are also recorded in a pending delta, which the runner
+// attaches to the next persisted event.
 type State struct {
-	mu   sync.RWMutex
-	data map[string]interface{}
+	mu    sync.RWMutex
+	data  map[string]interface{}
+	delta map[string]interface{}
+	
+	// shared is the state a view made by WithDelta reads and writes
+	shared *State
 }
 
 // NewState creates a new state instance
 func NewState() *State {
 	return &State{
-		data: make(map[string]interface{}),
+		data:  make(map[string]interface{}),
+		delta: make(map[string]interface{}),
 	}
 }
 
@@ -42,49 +67,182 @@ func NewStateWithData(data map[string]interface{}) *State {
 		stateCopy[k] = v
 	}
 	return &State{
-		data: stateCopy,
+		data:  stateCopy,
+		delta: make(map[string]interface{}),
+	}
+}
+
+// WithDelta returns a view of the state that reads and writes the state's
+// values but records its changes in the given delta instead of the state's
+// pending one. Each callback or tool writes through its own view, so that
+// concurrent branches sharing the state do not take each other's changes.
+func (s *State) WithDelta(delta map[string]interface{}) *State {
+	return &State{delta: delta, shared: s.values()}
+}
+
+// values returns the state holding the values and the lock
+func (s *State) values() *State {
+	if s.shared != nil {
+		return s.shared
 	}
+	return s
 }
 
 // Get retrieves a value from the state
 func (s *State) Get(key string) (interface{}, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	value, exists := s.data[key]
+	v := s.values()
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	value, exists := v.data[key]
 	return value, exists
 }
 
 // Set stores a value in the state
 func (s *State) Set(key string, value interface{}) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.data[key] = value
+	v := s.values()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.data[key] = value
+	s.delta[key] = value
 }
 
 // Update updates the state with a map of key-value pairs
 func (s *State) Update(updates map[string]interface{}) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	v := s.values()
+	v.mu.Lock()
+	defer v.mu.Unlock()
 	for key, value := range updates {
-		s.data[key] = value
+		v.data[key] = value
+		s.delta[key] = value
 	}
 }
 
 // ToDict returns a copy of the state as a map
 func (s *State) ToDict() map[string]interface{} {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+	v := s.values()
+	v.mu.RLock()
+	defer v.mu.RUnlock()
 	result := make(map[string]interface{})
-	for k, v := range s.data {
-		result[k] = v
+	for k, value := range v.data {
+		result[k] = value
 	}
 	return result
 }
 
-// HasDelta checks if the state has changes
+// MarshalJSON encodes the state's values; the pending delta is not encoded
+func (s *State) MarshalJSON() ([]byte, error) {
+	v := s.values()
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return json.Marshal(v.data)
+}
+
+// UnmarshalJSON decodes the state's values
+func (s *State) UnmarshalJSON(data []byte) error {
+	values := make(map[string]interface{})
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data = values
+	s.delta = make(map[string]interface{})
+	s.shared = nil
+	return nil
+}
+
+// HasDelta checks if the state has changes not yet attached to an event
 func (s *State) HasDelta() bool {
-	// TODO: Implement delta tracking
-	return false
+	v := s.values()
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return len(s.delta) > 0
+}
+
+// Delta returns a copy of the pending changes
+func (s *State) Delta() map[string]interface{} {
+	v := s.values()
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	result := make(map[string]interface{})
+	for k, value := range s.delta {
+		result[k] = value
+	}
+	return result
+}
+
+// TakeDelta returns the pending changes and clears them, or nil if there
+// are none
+func (s *State) TakeDelta() map[string]interface{} {
+	v := s.values()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if len(s.delta) == 0 {
+		return nil
+	}
+	delta := s.delta
+	s.delta = make(map[string]interface{})
+	return delta
+}
+
+// applyDelta stores changes that are already persisted, without recording
+// them as pending
+func (s *State) applyDelta(delta map[string]interface{}) {
+	v := s.values()
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for key, value := range delta {
+		v.data[key] = value
+	}
+}
+
+// scopedDelta is a state delta split by scope, without temporary values
+type scopedDelta struct {
+	app     map[string]interface{}
+	user    map[string]interface{}
+	session map[string]interface{}
+}
+
+// splitStateDelta splits a delta by the scope of its keys, dropping
+// temporary values
+func splitStateDelta(delta map[string]interface{}) scopedDelta {
+	split := scopedDelta{
+		app:     make(map[string]interface{}),
+		user:    make(map[string]interface{}),
+		session: make(map[string]interface{}),
+	}
+	for key, value := range delta {
+		switch {
+		case strings.HasPrefix(key, AppPrefix):
+			split.app[key] = value
+		case strings.HasPrefix(key, UserPrefix):
+			split.user[key] = value
+		case strings.HasPrefix(key, TempPrefix):
+			// Never persisted
+		default:
+			split.session[key] = value
+		}
+	}
+	return split
+}
+
+// trimTempDelta removes temporary values from an event's state delta before
+// it is persisted
+func trimTempDelta(event *events.Event) {
+	for key := range event.Actions.StateDelta {
+		if strings.HasPrefix(key, TempPrefix) {
+			delete(event.Actions.StateDelta, key)
+		}
+	}
+}
+
+// mergeState returns a state holding the session's own values and the app
+// and user values shared with it
+func mergeState(appState, userState, sessionState map[string]interface{}) *State {
+	merged := NewStateWithData(sessionState)
+	merged.applyDelta(appState)
+	merged.applyDelta(userState)
+	return merged
 }
 
 // Session represents a user session
@@ -94,7 +252,25 @@ type Session struct {
 	UserID         string         `json:"user_id"`
 	State          *State         `json:"state"`
 	Events         []*events.Event `json:"events"`
+	CreateTime     time.Time      `json:"create_time"`
 	LastUpdateTime time.Time      `json:"last_update_time"`
+	
+	// Version is incremented by the session service on every appended
+	// event; writers holding an older version get a ConflictError
+	Version int64 `json:"version"`
+}
+
+// ConflictError is returned by AppendEvent when the session was modified
+// after the caller loaded it
+type ConflictError struct {
+	SessionID       string
+	ExpectedVersion int64
+	ActualVersion   int64
+}
+
+// Error describes the conflicting versions
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("session %s was modified concurrently: expected version %d, found %d", e.SessionID, e.ExpectedVersion, e.ActualVersion)
 }
 
 // NewSession creates a new session
@@ -110,13 +286,15 @@ func NewSession(appName, userID, sessionID string, initialState map[string]inter
 		state = NewState()
 	}
 	
+	now := time.Now()
 	return &Session{
 		ID:             sessionID,
 		AppName:        appName,
 		UserID:         userID,
 		State:          state,
 		Events:         make([]*events.Event, 0),
-		LastUpdateTime: time.Now(),
+		CreateTime:     now,
+		LastUpdateTime: now,
 	}
 }
 
@@ -126,6 +304,15 @@ func (s *Session) AddEvent(event *events.Event) {
 	s.LastUpdateTime = time.Now()
 }
 
+// snapshot returns a copy of the session with its own event list and state
+func (s *Session) snapshot() *Session {
+	copied := *s
+	copied.Events = make([]*events.Event, len(s.Events))
+	copy(copied.Events, s.Events)
+	copied.State = NewStateWithData(s.State.ToDict())
+	return &copied
+}
+
 // GetEvents returns all events in the session
 func (s *Session) GetEvents() []*events.Event {
 	return s.Events
@@ -136,35 +323,273 @@ type SessionService interface {
 	// CreateSession creates a new session
 	CreateSession(appName, userID, sessionID string, initialState map[string]interface{}) (*Session, error)
 	
-	// GetSession retrieves a session by ID
-	GetSession(appName, userID, sessionID string) (*Session, error)
+	// GetSession retrieves a session by ID with the events selected by the
+	// config; a nil config loads all events
+	GetSession(appName, userID, sessionID string, config *GetSessionConfig) (*Session, error)
 	
 	// DeleteSession deletes a session
 	DeleteSession(appName, userID, sessionID string) error
 	
-	// ListSessions lists all sessions for a user
-	ListSessions(appName, userID string) ([]*Session, error)
+	// ListSessions lists a page of a user's sessions, without their events
+	ListSessions(appName, userID string, config *ListSessionsConfig) (*ListSessionsResponse, error)
 	
-	// AppendEvent adds an event to a session
-	AppendEvent(appName, userID, sessionID string, event *events.Event) error
+	// AppendEvent adds an event to a session and updates the given session
+	// to match. It returns a ConflictError if the session was modified since
+	// the caller loaded it.
+	AppendEvent(session *Session, event *events.Event) error
 	
-	// ListEvents lists events for a session
-	ListEvents(appName, userID, sessionID string) ([]*events.Event, error)
+	// ListEvents lists the events of a session selected by the config; a
+	// nil config lists all events
+	ListEvents(appName, userID, sessionID string, config *GetSessionConfig) ([]*events.Event, error)
 	
 	// CloseSession closes a session
 	CloseSession(appName, userID, sessionID string) error
+	
+	// RewindSession removes the events after the given event and recomputes
+	// the session's state by replaying the remaining events over the state
+	// it was created with. App and user values are shared with other
+	// sessions and left unchanged.
+	RewindSession(appName, userID, sessionID, toEventID string) (*Session, error)
+	
+	// ForkSession copies a session's events up to and including the given
+	// event into a new session, whose state is replayed from those events.
+	// An empty newSessionID generates one.
+	ForkSession(appName, userID, sessionID, toEventID, newSessionID string) (*Session, error)
+	
+	// Subscribe follows the changes made to a session from then on, whether
+	// or not it exists yet, until cancel is called. The channel is closed on
+	// cancel.
+	Subscribe(appName, userID, sessionID string) (<-chan SessionChange, func())
 }
 
 // InMemorySessionService provides an in-memory implementation of SessionService
 type InMemorySessionService struct {
 	mu       sync.RWMutex
 	sessions map[string]*Session
+	
+	// Session values each session was created with, by session key
+	initialStates map[string]map[string]interface{}
+	
+	// Values shared across sessions, by app and by app and user
+	appState  map[string]map[string]interface{}
+	userState map[userKey]map[string]interface{}
+	
+	// Expiry policies by app, the empty name holding the default, and the
+	// hooks run on removal
+	policies    map[string]ExpiryPolicy
+	expiryHooks []ExpiryHook
+	
+	// Called with the hook errors of evicted sessions, which no caller sees
+	onExpiryError func(err error)
+	
+	// Maximum number of sessions, 0 for no limit, and their use from most to
+	// least recent. The use order is guarded by its own mutex so that reads
+	// can record it under the read lock.
+	capacity   int
+	lruMu      sync.Mutex
+	lru        *list.List
+	lruEntries map[string]*list.Element
+	
+	// Subscriptions to session changes
+	subscriptions map[*subscription]struct{}
+	
+	now func() time.Time
+}
+
+// lruEntry records when a session was last read or written
+type lruEntry struct {
+	key        string
+	lastAccess time.Time
 }
 
 // NewInMemorySessionService creates a new in-memory session service
 func NewInMemorySessionService() *InMemorySessionService {
 	return &InMemorySessionService{
-		sessions: make(map[string]*Session),
+		sessions:      make(map[string]*Session),
+		initialStates: make(map[string]map[string]interface{}),
+		appState:      make(map[string]map[string]interface{}),
+		userState:     make(map[userKey]map[string]interface{}),
+		policies:      make(map[string]ExpiryPolicy),
+		lru:           list.New(),
+		lruEntries:    make(map[string]*list.Element),
+		subscriptions: make(map[*subscription]struct{}),
+		now:           time.Now,
+	}
+}
+
+// SetExpiryPolicy sets when the sessions of an app expire; the empty app
+// name sets the default for apps without a policy of their own. Expired
+// sessions are removed by Sweep.
+func (s *InMemorySessionService) SetExpiryPolicy(appName string, policy ExpiryPolicy) *InMemorySessionService {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policies[appName] = policy
+	return s
+}
+
+// SetCapacity bounds the number of sessions kept; creating a session beyond
+// it evicts the least recently used one. 0 removes the bound.
+func (s *InMemorySessionService) SetCapacity(capacity int) *InMemorySessionService {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.capacity = capacity
+	return s
+}
+
+// OnExpire adds a hook run for every expired or evicted session
+func (s *InMemorySessionService) OnExpire(hook ExpiryHook) *InMemorySessionService {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expiryHooks = append(s.expiryHooks, hook)
+	return s
+}
+
+// OnExpiryError sets a function called when an expiry hook fails for an
+// evicted session. Evictions happen while creating or forking sessions, so
+// their hook errors are not returned to the caller; Sweep and CloseSession
+// return theirs.
+func (s *InMemorySessionService) OnExpiryError(handler func(err error)) *InMemorySessionService {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onExpiryError = handler
+	return s
+}
+
+// Sweep removes the sessions whose expiry policy has run out. The expiry
+// hooks run first; a session is kept if a hook fails, to be retried on the
+// next sweep, or if it was written to while the hooks ran.
+func (s *InMemorySessionService) Sweep(ctx context.Context) (int, error) {
+	type candidate struct {
+		key     string
+		session *Session
+		reason  ExpiryReason
+	}
+	
+	s.mu.RLock()
+	now := s.now()
+	var candidates []candidate
+	for key, session := range s.sessions {
+		policy, exists := s.policies[session.AppName]
+		if !exists {
+			policy = s.policies[""]
+		}
+		if reason, expired := policy.expired(session.CreateTime, s.lastAccess(key), now); expired {
+			candidates = append(candidates, candidate{key: key, session: s.view(session), reason: reason})
+		}
+	}
+	hooks := s.expiryHooks
+	s.mu.RUnlock()
+	
+	removed := 0
+	var errs []error
+	for _, c := range candidates {
+		if err := ctx.Err(); err != nil {
+			return removed, err
+		}
+		if err := runExpiryHooks(ctx, hooks, c.session, c.reason); err != nil {
+			errs = append(errs, fmt.Errorf("session %s: %w", c.session.ID, err))
+			continue
+		}
+		
+		s.mu.Lock()
+		if stored, exists := s.sessions[c.key]; exists && stored.Version == c.session.Version {
+			s.remove(c.key)
+			removed++
+		}
+		s.mu.Unlock()
+	}
+	return removed, errors.Join(errs...)
+}
+
+// runExpiryHooks runs the hooks in order, stopping at the first failure
+func runExpiryHooks(ctx context.Context, hooks []ExpiryHook, session *Session, reason ExpiryReason) error {
+	for _, hook := range hooks {
+		if err := hook(ctx, session, reason); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// touch records a use of a session; the caller holds the lock, shared or
+// exclusive
+func (s *InMemorySessionService) touch(key string) {
+	s.lruMu.Lock()
+	defer s.lruMu.Unlock()
+	
+	if element, exists := s.lruEntries[key]; exists {
+		element.Value.(*lruEntry).lastAccess = s.now()
+		s.lru.MoveToFront(element)
+		return
+	}
+	s.lruEntries[key] = s.lru.PushFront(&lruEntry{key: key, lastAccess: s.now()})
+}
+
+// lastAccess returns when a session was last used; the caller holds the lock
+func (s *InMemorySessionService) lastAccess(key string) time.Time {
+	s.lruMu.Lock()
+	defer s.lruMu.Unlock()
+	
+	if element, exists := s.lruEntries[key]; exists {
+		return element.Value.(*lruEntry).lastAccess
+	}
+	return time.Time{}
+}
+
+// remove deletes a stored session; the caller holds the exclusive lock
+func (s *InMemorySessionService) remove(key string) {
+	if session, exists := s.sessions[key]; exists {
+		s.publish(key, SessionChange{Type: ChangeDeleted, Version: session.Version})
+	}
+	delete(s.sessions, key)
+	delete(s.initialStates, key)
+	
+	s.lruMu.Lock()
+	defer s.lruMu.Unlock()
+	if element, exists := s.lruEntries[key]; exists {
+		s.lru.Remove(element)
+		delete(s.lruEntries, key)
+	}
+}
+
+// evictOverCapacity removes the least recently used sessions until the
+// capacity is respected and returns snapshots of them; the caller holds the
+// exclusive lock
+func (s *InMemorySessionService) evictOverCapacity() []*Session {
+	var evicted []*Session
+	for s.capacity > 0 && len(s.sessions) > s.capacity {
+		s.lruMu.Lock()
+		element := s.lru.Back()
+		s.lruMu.Unlock()
+		if element == nil {
+			break
+		}
+		
+		key := element.Value.(*lruEntry).key
+		if session, exists := s.sessions[key]; exists {
+			evicted = append(evicted, s.view(session))
+		}
+		s.remove(key)
+	}
+	return evicted
+}
+
+// notifyEvicted runs the expiry hooks for evicted sessions; the caller must
+// not hold the lock, since hooks may use the service
+func (s *InMemorySessionService) notifyEvicted(evicted []*Session) {
+	if len(evicted) == 0 {
+		return
+	}
+	s.mu.RLock()
+	hooks := s.expiryHooks
+	onError := s.onExpiryError
+	s.mu.RUnlock()
+	
+	for _, session := range evicted {
+		// The session is already gone, so the error can only be reported
+		if err := runExpiryHooks(context.Background(), hooks, session, Evicted); err != nil && onError != nil {
+			onError(fmt.Errorf("session %s: %w", session.ID, err))
+		}
 	}
 }
 
@@ -173,20 +598,75 @@ func (s *InMemorySessionService) sessionKey(appName, userID, sessionID string) s
 	return appName + ":" + userID + ":" + sessionID
 }
 
-// CreateSession creates a new session
+// userKey identifies a user of an app
+type userKey struct {
+	appName string
+	userID  string
+}
+
+// view returns a snapshot of a stored session whose state merges in the app
+// and user values; the caller holds the lock
+func (s *InMemorySessionService) view(session *Session) *Session {
+	snapshot := session.snapshot()
+	snapshot.State = mergeState(
+		s.appState[session.AppName],
+		s.userState[userKey{appName: session.AppName, userID: session.UserID}],
+		session.State.ToDict(),
+	)
+	return snapshot
+}
+
+// applySharedDelta stores the app and user values of a delta; the caller
+// holds the lock
+func (s *InMemorySessionService) applySharedDelta(appName, userID string, delta scopedDelta) {
+	if len(delta.app) > 0 {
+		if s.appState[appName] == nil {
+			s.appState[appName] = make(map[string]interface{})
+		}
+		for key, value := range delta.app {
+			s.appState[appName][key] = value
+		}
+	}
+	if len(delta.user) > 0 {
+		user := userKey{appName: appName, userID: userID}
+		if s.userState[user] == nil {
+			s.userState[user] = make(map[string]interface{})
+		}
+		for key, value := range delta.user {
+			s.userState[user][key] = value
+		}
+	}
+}
+
+// CreateSession creates a new session. Initial app and user values are
+// shared with the app's and user's other sessions. If the service is at
+// capacity, the least recently used session is evicted.
 func (s *InMemorySessionService) CreateSession(appName, userID, sessionID string, initialState map[string]interface{}) (*Session, error) {
+	var evicted []*Session
+	defer func() { s.notifyEvicted(evicted) }()
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	
-	session := NewSession(appName, userID, sessionID, initialState)
-	key := s.sessionKey(appName, userID, sessionID)
+	scoped := splitStateDelta(initialState)
+	s.applySharedDelta(appName, userID, scoped)
+	
+	session := NewSession(appName, userID, sessionID, scoped.session)
+	key := s.sessionKey(appName, userID, session.ID)
 	s.sessions[key] = session
+	s.initialStates[key] = session.State.ToDict()
+	s.touch(key)
+	evicted = s.evictOverCapacity()
 	
-	return session, nil
+	created := s.view(session)
+	s.publish(key, SessionChange{Type: ChangeCreated, Version: created.Version, State: created.State.ToDict()})
+	s.publishSharedDelta(key, appName, userID, scoped)
+	return created, nil
 }
 
-// GetSession retrieves a session by ID
-func (s *InMemorySessionService) GetSession(appName, userID, sessionID string) (*Session, error) {
+// GetSession retrieves a snapshot of a session by ID. Its state merges the
+// session's values with the app and user values. Changes made later are not
+// visible in the snapshot.
+func (s *InMemorySessionService) GetSession(appName, userID, sessionID string, config *GetSessionConfig) (*Session, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	
@@ -195,8 +675,11 @@ func (s *InMemorySessionService) GetSession(appName, userID, sessionID string) (
 	if !exists {
 		return nil, nil // Session not found
 	}
+	s.touch(key)
 	
-	return session, nil
+	snapshot := s.view(session)
+	snapshot.Events = filterEvents(snapshot.Events, config)
+	return snapshot, nil
 }
 
 // DeleteSession deletes a session
@@ -204,14 +687,13 @@ func (s *InMemorySessionService) DeleteSession(appName, userID, sessionID string
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	
-	key := s.sessionKey(appName, userID, sessionID)
-	delete(s.sessions, key)
+	s.remove(s.sessionKey(appName, userID, sessionID))
 	
 	return nil
 }
 
-// ListSessions lists all sessions for a user
-func (s *InMemorySessionService) ListSessions(appName, userID string) ([]*Session, error) {
+// ListSessions lists a page of a user's sessions, without their events
+func (s *InMemorySessionService) ListSessions(appName, userID string, config *ListSessionsConfig) (*ListSessionsResponse, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	
@@ -220,30 +702,66 @@ func (s *InMemorySessionService) ListSessions(appName, userID string) ([]*Sessio
 	
 	for key, session := range s.sessions {
 		if len(key) > len(prefix) && key[:len(prefix)] == prefix {
-			sessions = append(sessions, session)
+			sessions = append(sessions, s.view(session))
 		}
 	}
 	
-	return sessions, nil
+	return paginateSessions(sessions, config)
 }
 
-// AppendEvent adds an event to a session
-func (s *InMemorySessionService) AppendEvent(appName, userID, sessionID string, event *events.Event) error {
+// AppendEvent adds an event to a session and updates the given session to
+// match. The event's state delta is applied by scope; temporary values are
+// removed from it and never persisted.
+func (s *InMemorySessionService) AppendEvent(session *Session, event *events.Event) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	
-	key := s.sessionKey(appName, userID, sessionID)
-	session, exists := s.sessions[key]
+	key := s.sessionKey(session.AppName, session.UserID, session.ID)
+	stored, exists := s.sessions[key]
 	if !exists {
 		return nil // Session not found
 	}
+	s.touch(key)
 	
-	session.AddEvent(event)
+	// Reject writers working from an outdated copy of the session
+	if stored.Version != session.Version {
+		return &ConflictError{
+			SessionID:       session.ID,
+			ExpectedVersion: session.Version,
+			ActualVersion:   stored.Version,
+		}
+	}
+	
+	// Apply the state changes recorded on the event; the writer keeps the
+	// temporary values for the rest of its invocation
+	var scoped scopedDelta
+	if len(event.Actions.StateDelta) > 0 {
+		session.State.applyDelta(event.Actions.StateDelta)
+		scoped = splitStateDelta(event.Actions.StateDelta)
+		stored.State.applyDelta(scoped.session)
+		s.applySharedDelta(session.AppName, session.UserID, scoped)
+		trimTempDelta(event)
+	}
+	
+	stored.AddEvent(event)
+	stored.Version++
+	
+	s.publish(key, SessionChange{
+		Type:       ChangeEventAppended,
+		Version:    stored.Version,
+		Event:      event,
+		StateDelta: event.Actions.StateDelta,
+	})
+	s.publishSharedDelta(key, session.AppName, session.UserID, scoped)
+	
+	session.Events = append(session.Events, event)
+	session.LastUpdateTime = stored.LastUpdateTime
+	session.Version = stored.Version
 	return nil
 }
 
-// ListEvents lists events for a session
-func (s *InMemorySessionService) ListEvents(appName, userID, sessionID string) ([]*events.Event, error) {
+// ListEvents lists the events of a session selected by the config
+func (s *InMemorySessionService) ListEvents(appName, userID, sessionID string, config *GetSessionConfig) ([]*events.Event, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	
@@ -253,11 +771,162 @@ func (s *InMemorySessionService) ListEvents(appName, userID, sessionID string) (
 		return nil, nil // Session not found
 	}
 	
-	return session.GetEvents(), nil
+	return filterEvents(session.snapshot().GetEvents(), config), nil
 }
 
-// CloseSession closes a session
+// CloseSession ends a session: the expiry hooks run with the Closed reason,
+// then the session is removed. If a hook fails, the session is kept and the
+// error returned. Closing a missing session does nothing.
 func (s *InMemorySessionService) CloseSession(appName, userID, sessionID string) error {
-	// For in-memory implementation, we don't need to do anything special
+	key := s.sessionKey(appName, userID, sessionID)
+	
+	s.mu.RLock()
+	session, exists := s.sessions[key]
+	if exists {
+		session = s.view(session)
+	}
+	hooks := s.expiryHooks
+	s.mu.RUnlock()
+	if !exists {
+		return nil
+	}
+	
+	// Hooks run without the lock, since they may use the service
+	if err := runExpiryHooks(context.Background(), hooks, session, Closed); err != nil {
+		return fmt.Errorf("session %s: %w", sessionID, err)
+	}
+	
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(key)
 	return nil
-}
\ No newline at end of file
+}
+
+// RewindSession removes the events after the given event and recomputes the
+// session's own state from the remaining events
+func (s *InMemorySessionService) RewindSession(appName, userID, sessionID, toEventID string) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	
+	key := s.sessionKey(appName, userID, sessionID)
+	session, exists := s.sessions[key]
+	if !exists {
+		return nil, fmt.Errorf("session %s not found", sessionID)
+	}
+	index, err := eventIndex(session.Events, sessionID, toEventID)
+	if err != nil {
+		return nil, err
+	}
+	
+	s.touch(key)
+	
+	kept := make([]*events.Event, index+1)
+	copy(kept, session.Events)
+	session.Events = kept
+	session.State = NewStateWithData(replayState(s.initialStates[key], kept))
+	session.LastUpdateTime = time.Now()
+	session.Version++
+	
+	rewound := s.view(session)
+	s.publish(key, SessionChange{Type: ChangeRewound, Version: rewound.Version, State: rewound.State.ToDict()})
+	return rewound, nil
+}
+
+// ForkSession copies a session up to and including the given event into a
+// new session. If the service is at capacity, the least recently used
+// session is evicted.
+func (s *InMemorySessionService) ForkSession(appName, userID, sessionID, toEventID, newSessionID string) (*Session, error) {
+	var evicted []*Session
+	defer func() { s.notifyEvicted(evicted) }()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	
+	key := s.sessionKey(appName, userID, sessionID)
+	source, exists := s.sessions[key]
+	if !exists {
+		return nil, fmt.Errorf("session %s not found", sessionID)
+	}
+	index, err := eventIndex(source.Events, sessionID, toEventID)
+	if err != nil {
+		return nil, err
+	}
+	
+	if newSessionID == "" {
+		newSessionID = uuid.New().String()
+	}
+	forkKey := s.sessionKey(appName, userID, newSessionID)
+	if _, exists := s.sessions[forkKey]; exists {
+		return nil, fmt.Errorf("session %s already exists", newSessionID)
+	}
+	
+	kept := make([]*events.Event, index+1)
+	copy(kept, source.Events)
+	fork := NewSession(appName, userID, newSessionID, replayState(s.initialStates[key], kept))
+	fork.Events = kept
+	fork.Version = int64(len(kept))
+	s.sessions[forkKey] = fork
+	s.initialStates[forkKey] = NewStateWithData(s.initialStates[key]).ToDict()
+	s.touch(forkKey)
+	evicted = s.evictOverCapacity()
+	
+	forked := s.view(fork)
+	s.publish(forkKey, SessionChange{Type: ChangeCreated, Version: forked.Version, State: forked.State.ToDict()})
+	return forked, nil
+}
+
+// Subscribe follows the changes made to a session from then on. Changes are
+// published as they are made, in order, and queued for slow subscribers.
+func (s *InMemorySessionService) Subscribe(appName, userID, sessionID string) (<-chan SessionChange, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	
+	sub := newSubscription(appName, userID, sessionID)
+	s.subscriptions[sub] = struct{}{}
+	
+	cancel := func() {
+		s.mu.Lock()
+		delete(s.subscriptions, sub)
+		s.mu.Unlock()
+		sub.cancel()
+	}
+	return sub.changes, cancel
+}
+
+// publish sends a change to the subscribers of a session; the caller holds
+// the exclusive lock
+func (s *InMemorySessionService) publish(key string, change SessionChange) {
+	for sub := range s.subscriptions {
+		if s.sessionKey(sub.appName, sub.userID, sub.sessionID) == key {
+			sub.publish(change)
+		}
+	}
+}
+
+// publishSharedDelta tells the subscribers of the app's and user's other
+// sessions about the app and user values of a delta; the caller holds the
+// exclusive lock
+func (s *InMemorySessionService) publishSharedDelta(key, appName, userID string, delta scopedDelta) {
+	if len(delta.app) == 0 && len(delta.user) == 0 {
+		return
+	}
+	for sub := range s.subscriptions {
+		subKey := s.sessionKey(sub.appName, sub.userID, sub.sessionID)
+		stored, exists := s.sessions[subKey]
+		if subKey == key || !exists || sub.appName != appName {
+			continue
+		}
+		
+		changed := make(map[string]interface{})
+		for k, v := range delta.app {
+			changed[k] = v
+		}
+		if sub.userID == userID {
+			for k, v := range delta.user {
+				changed[k] = v
+			}
+		}
+		if len(changed) > 0 {
+			sub.publish(SessionChange{Type: ChangeStateUpdated, Version: stored.Version, StateDelta: changed})
+		}
+	}
+}
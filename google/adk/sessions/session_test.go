@@ -15,9 +15,21 @@
 package sessions
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/adrienveepee/adk-go/google/adk/encryption"
 	"github.com/adrienveepee/adk-go/google/adk/events"
+	_ "modernc.org/sqlite"
 )
 
 func TestNewState(t *testing.T) {
@@ -109,6 +121,31 @@ func TestStateUpdate(t *testing.T) {
 	}
 }
 
+func TestStateWithDelta(t *testing.T) {
+	state := NewState()
+	delta := make(map[string]interface{})
+	view := state.WithDelta(delta)
+	
+	view.Set("view_key", 1)
+	state.Set("state_key", 2)
+	
+	// The view and the state share their values
+	if value, _ := state.Get("view_key"); value != 1 {
+		t.Errorf("Expected the state to see the view's change, got %v", value)
+	}
+	if value, _ := view.Get("state_key"); value != 2 {
+		t.Errorf("Expected the view to see the state's change, got %v", value)
+	}
+	
+	// but record their changes separately
+	if len(delta) != 1 || delta["view_key"] != 1 {
+		t.Errorf("Expected the view's change in its delta, got %v", delta)
+	}
+	if pending := state.Delta(); len(pending) != 1 || pending["state_key"] != 2 {
+		t.Errorf("Expected only the state's own change to be pending, got %v", pending)
+	}
+}
+
 func TestStateToDict(t *testing.T) {
 	state := NewState()
 	
@@ -226,7 +263,7 @@ func TestSessionServiceGetSession(t *testing.T) {
 	createdSession, _ := service.CreateSession(appName, userID, sessionID, nil)
 	
 	// Get the session
-	retrievedSession, err := service.GetSession(appName, userID, sessionID)
+	retrievedSession, err := service.GetSession(appName, userID, sessionID, nil)
 	if err != nil {
 		t.Errorf("GetSession should not return error: %v", err)
 	}
@@ -240,7 +277,7 @@ func TestSessionServiceGetSession(t *testing.T) {
 	}
 	
 	// Test getting non-existent session
-	nonExistentSession, err := service.GetSession(appName, userID, "non_existent")
+	nonExistentSession, err := service.GetSession(appName, userID, "non_existent", nil)
 	if err != nil {
 		t.Errorf("GetSession should not return error for non-existent session: %v", err)
 	}
@@ -267,7 +304,7 @@ func TestSessionServiceDeleteSession(t *testing.T) {
 	}
 	
 	// Verify session is deleted
-	session, _ := service.GetSession(appName, userID, sessionID)
+	session, _ := service.GetSession(appName, userID, sessionID, nil)
 	if session != nil {
 		t.Error("Session should be deleted")
 	}
@@ -281,20 +318,20 @@ func TestSessionServiceAppendEvent(t *testing.T) {
 	sessionID := "test_session"
 	
 	// Create a session
-	service.CreateSession(appName, userID, sessionID, nil)
+	created, _ := service.CreateSession(appName, userID, sessionID, nil)
 	
 	// Create an event
 	event := events.NewEvent()
 	event.Author = "test_author"
 	
 	// Append the event
-	err := service.AppendEvent(appName, userID, sessionID, event)
+	err := service.AppendEvent(created, event)
 	if err != nil {
 		t.Errorf("AppendEvent should not return error: %v", err)
 	}
 	
 	// Verify event was appended
-	session, _ := service.GetSession(appName, userID, sessionID)
+	session, _ := service.GetSession(appName, userID, sessionID, nil)
 	if len(session.Events) != 1 {
 		t.Errorf("Expected 1 event, got %d events", len(session.Events))
 	}
@@ -316,12 +353,1379 @@ func TestSessionServiceListSessions(t *testing.T) {
 	service.CreateSession(appName, "other_user", "session3", nil) // Different user
 	
 	// List sessions for the user
-	sessions, err := service.ListSessions(appName, userID)
+	response, err := service.ListSessions(appName, userID, nil)
 	if err != nil {
 		t.Errorf("ListSessions should not return error: %v", err)
 	}
 	
-	if len(sessions) != 2 {
-		t.Errorf("Expected 2 sessions for user, got %d sessions", len(sessions))
+	if len(response.Sessions) != 2 {
+		t.Errorf("Expected 2 sessions for user, got %d sessions", len(response.Sessions))
+	}
+}
+
+func TestSessionServiceAppendEventConflict(t *testing.T) {
+	service := NewInMemorySessionService()
+	service.CreateSession("test_app", "test_user", "test_session", nil)
+	
+	first, _ := service.GetSession("test_app", "test_user", "test_session", nil)
+	second, _ := service.GetSession("test_app", "test_user", "test_session", nil)
+	
+	if err := service.AppendEvent(first, events.NewEvent()); err != nil {
+		t.Fatalf("AppendEvent should not return error: %v", err)
+	}
+	if first.Version != 1 || len(first.Events) != 1 {
+		t.Errorf("Expected the writer's session to be at version 1 with 1 event, got version %d with %d events", first.Version, len(first.Events))
+	}
+	
+	// The second writer loaded the session before the first one appended
+	err := service.AppendEvent(second, events.NewEvent())
+	conflict, ok := err.(*ConflictError)
+	if !ok {
+		t.Fatalf("Expected a ConflictError, got %v", err)
+	}
+	if conflict.ExpectedVersion != 0 || conflict.ActualVersion != 1 {
+		t.Errorf("Expected conflict between versions 0 and 1, got %d and %d", conflict.ExpectedVersion, conflict.ActualVersion)
+	}
+	
+	// Reloading the session resolves the conflict
+	second, _ = service.GetSession("test_app", "test_user", "test_session", nil)
+	if err := service.AppendEvent(second, events.NewEvent()); err != nil {
+		t.Errorf("AppendEvent on a fresh session should not return error: %v", err)
+	}
+	
+	stored, _ := service.GetSession("test_app", "test_user", "test_session", nil)
+	if len(stored.Events) != 2 || stored.Version != 2 {
+		t.Errorf("Expected 2 events at version 2, got %d events at version %d", len(stored.Events), stored.Version)
+	}
+}
+
+func TestSessionServiceAppendEventStateDelta(t *testing.T) {
+	service := NewInMemorySessionService()
+	session, _ := service.CreateSession("test_app", "test_user", "test_session", nil)
+	
+	event := events.NewEvent()
+	event.Actions.StateDelta = map[string]interface{}{"status": "done"}
+	if err := service.AppendEvent(session, event); err != nil {
+		t.Fatalf("AppendEvent should not return error: %v", err)
+	}
+	
+	stored, _ := service.GetSession("test_app", "test_user", "test_session", nil)
+	if value, _ := stored.State.Get("status"); value != "done" {
+		t.Errorf("Expected state delta to be applied, got %v", value)
+	}
+}
+
+func TestStateDelta(t *testing.T) {
+	state := NewStateWithData(map[string]interface{}{"initial": 1})
+	if state.HasDelta() {
+		t.Error("Initial data should not be part of the delta")
+	}
+	
+	state.Set("status", "done")
+	state.Update(map[string]interface{}{"count": 2})
+	if !state.HasDelta() {
+		t.Fatal("Expected changes to be recorded in the delta")
+	}
+	if delta := state.Delta(); len(delta) != 2 || delta["status"] != "done" || delta["count"] != 2 {
+		t.Errorf("Expected delta with status and count, got %v", delta)
+	}
+	
+	delta := state.TakeDelta()
+	if len(delta) != 2 {
+		t.Errorf("Expected TakeDelta to return 2 changes, got %v", delta)
 	}
-}
\ No newline at end of file
+	if state.HasDelta() || state.TakeDelta() != nil {
+		t.Error("Expected TakeDelta to clear the delta")
+	}
+	if value, _ := state.Get("status"); value != "done" {
+		t.Errorf("Expected the value to stay in the state, got %v", value)
+	}
+}
+
+func TestSessionServiceScopedState(t *testing.T) {
+	service := NewInMemorySessionService()
+	first, _ := service.CreateSession("test_app", "alice", "first", map[string]interface{}{
+		"app:theme": "dark",
+	})
+	
+	event := events.NewEvent()
+	event.Actions.StateDelta = map[string]interface{}{
+		"app:version": 2,
+		"user:name":   "Alice",
+		"temp:draft":  "scratch",
+		"topic":       "billing",
+	}
+	if err := service.AppendEvent(first, event); err != nil {
+		t.Fatalf("AppendEvent should not return error: %v", err)
+	}
+	
+	// Temporary values stay visible to the writer but are not persisted
+	if value, _ := first.State.Get("temp:draft"); value != "scratch" {
+		t.Errorf("Expected the writer to see temp:draft, got %v", value)
+	}
+	if _, exists := event.Actions.StateDelta["temp:draft"]; exists {
+		t.Error("Expected temp:draft to be removed from the persisted event")
+	}
+	
+	for _, tc := range []struct {
+		userID, sessionID string
+		expected          map[string]interface{}
+	}{
+		{"alice", "first", map[string]interface{}{"app:theme": "dark", "app:version": 2, "user:name": "Alice", "topic": "billing"}},
+		{"alice", "second", map[string]interface{}{"app:theme": "dark", "app:version": 2, "user:name": "Alice"}},
+		{"bob", "third", map[string]interface{}{"app:theme": "dark", "app:version": 2}},
+	} {
+		session, _ := service.GetSession("test_app", tc.userID, tc.sessionID, nil)
+		if session == nil {
+			session, _ = service.CreateSession("test_app", tc.userID, tc.sessionID, nil)
+		}
+		state := session.State.ToDict()
+		if len(state) != len(tc.expected) {
+			t.Errorf("Session %s: expected state %v, got %v", tc.sessionID, tc.expected, state)
+			continue
+		}
+		for key, value := range tc.expected {
+			if state[key] != value {
+				t.Errorf("Session %s: expected %s to be %v, got %v", tc.sessionID, key, value, state[key])
+			}
+		}
+	}
+	
+	// Snapshots do not share state with the service
+	snapshot, _ := service.GetSession("test_app", "alice", "first", nil)
+	snapshot.State.Set("topic", "changed")
+	stored, _ := service.GetSession("test_app", "alice", "first", nil)
+	if value, _ := stored.State.Get("topic"); value != "billing" {
+		t.Errorf("Expected unpersisted changes to stay in the snapshot, got %v", value)
+	}
+	
+	// User values stay with their app and user, whatever the names contain
+	tenant, _ := service.CreateSession("a", "b:c", "tenant", nil)
+	appendTextEvent(t, service, tenant, "hi", map[string]interface{}{"user:secret": "mine"})
+	other, _ := service.CreateSession("a:b", "c", "other", nil)
+	if value, exists := other.State.Get("user:secret"); exists {
+		t.Errorf("Expected another app's user not to see user:secret, got %v", value)
+	}
+}
+
+func appendTextEvent(t *testing.T, service SessionService, session *Session, text string, delta map[string]interface{}) {
+	t.Helper()
+	event := events.NewEvent()
+	event.Author = "agent"
+	event.Content = &events.Content{Role: "model", Parts: []events.Part{{Text: text}}}
+	event.Actions.StateDelta = delta
+	if err := service.AppendEvent(session, event); err != nil {
+		t.Fatalf("AppendEvent should not return error: %v", err)
+	}
+}
+
+func TestFileSessionServicePersistence(t *testing.T) {
+	root := t.TempDir()
+	service, err := NewFileSessionService(root)
+	if err != nil {
+		t.Fatalf("NewFileSessionService should not return error: %v", err)
+	}
+	
+	session, err := service.CreateSession("test_app", "alice", "s1", map[string]interface{}{"topic": "billing"})
+	if err != nil {
+		t.Fatalf("CreateSession should not return error: %v", err)
+	}
+	if _, err := service.CreateSession("test_app", "alice", "s1", nil); err == nil {
+		t.Error("Expected creating an existing session to fail")
+	}
+	appendTextEvent(t, service, session, "first", map[string]interface{}{"step": 1, "user:name": "Alice", "temp:scratch": "x"})
+	appendTextEvent(t, service, session, "second", map[string]interface{}{"step": 2, "app:campaign": "summer"})
+	
+	// A new service on the same directory sees everything but temporary state
+	reopened, _ := NewFileSessionService(root)
+	loaded, err := reopened.GetSession("test_app", "alice", "s1", nil)
+	if err != nil || loaded == nil {
+		t.Fatalf("Expected the session to be loaded, got %v, %v", loaded, err)
+	}
+	if len(loaded.Events) != 2 || loaded.Version != 2 {
+		t.Fatalf("Expected 2 events at version 2, got %d events at version %d", len(loaded.Events), loaded.Version)
+	}
+	if loaded.Events[1].Content.Parts[0].Text != "second" {
+		t.Errorf("Expected events in order, got %+v", loaded.Events[1].Content)
+	}
+	state := loaded.State.ToDict()
+	expected := map[string]interface{}{"topic": "billing", "step": float64(2), "user:name": "Alice", "app:campaign": "summer"}
+	if len(state) != len(expected) {
+		t.Errorf("Expected state %v, got %v", expected, state)
+	}
+	for key, value := range expected {
+		if state[key] != value {
+			t.Errorf("Expected %s to be %v, got %v", key, value, state[key])
+		}
+	}
+	
+	// Shared state reaches the user's and app's other sessions
+	other, _ := reopened.CreateSession("test_app", "bob", "s2", nil)
+	if value, _ := other.State.Get("app:campaign"); value != "summer" {
+		t.Errorf("Expected app state to be shared, got %v", value)
+	}
+	if _, exists := other.State.Get("user:name"); exists {
+		t.Error("Expected user state not to leak to other users")
+	}
+	
+	listed, _ := reopened.ListSessions("test_app", "alice", nil)
+	if len(listed.Sessions) != 1 || listed.Sessions[0].ID != "s1" {
+		t.Errorf("Expected alice's session to be listed, got %v", listed.Sessions)
+	}
+	if err := reopened.DeleteSession("test_app", "alice", "s1"); err != nil {
+		t.Errorf("DeleteSession should not return error: %v", err)
+	}
+	if deleted, _ := service.GetSession("test_app", "alice", "s1", nil); deleted != nil {
+		t.Error("Expected the session to be deleted for all services")
+	}
+}
+
+func TestFileSessionServiceConcurrentWriters(t *testing.T) {
+	root := t.TempDir()
+	first, _ := NewFileSessionService(root)
+	second, _ := NewFileSessionService(root)
+	
+	created, _ := first.CreateSession("test_app", "alice", "s1", nil)
+	stale, _ := second.GetSession("test_app", "alice", "s1", nil)
+	appendTextEvent(t, first, created, "from first", nil)
+	
+	err := second.AppendEvent(stale, events.NewEvent())
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) || conflict.ExpectedVersion != 0 || conflict.ActualVersion != 1 {
+		t.Fatalf("Expected a ConflictError from 0 to 1, got %v", err)
+	}
+	
+	fresh, _ := second.GetSession("test_app", "alice", "s1", nil)
+	if len(fresh.Events) != 1 || fresh.Events[0].Content.Parts[0].Text != "from first" {
+		t.Fatalf("Expected the other writer's event, got %d events", len(fresh.Events))
+	}
+	appendTextEvent(t, second, fresh, "from second", nil)
+	
+	reloaded, _ := first.GetSession("test_app", "alice", "s1", nil)
+	if len(reloaded.Events) != 2 || reloaded.Version != 2 {
+		t.Errorf("Expected both events at version 2, got %d events at version %d", len(reloaded.Events), reloaded.Version)
+	}
+}
+
+func TestFileSessionServiceRecovery(t *testing.T) {
+	root := t.TempDir()
+	service, _ := NewFileSessionService(root)
+	session, _ := service.CreateSession("test_app", "alice", "s1", nil)
+	appendTextEvent(t, service, session, "kept", nil)
+	
+	// Simulate a crash in the middle of writing a record
+	logPath := filepath.Join(root, "test_app", "alice", "s1", "events.jsonl")
+	file, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatalf("Expected the event log to exist: %v", err)
+	}
+	file.WriteString(`{"version":2,"event":{"id":"cut`)
+	file.Close()
+	
+	recovered, _ := NewFileSessionService(root)
+	loaded, err := recovered.GetSession("test_app", "alice", "s1", nil)
+	if err != nil || loaded == nil || len(loaded.Events) != 1 {
+		t.Fatalf("Expected the complete event only, got %v, %v", loaded, err)
+	}
+	appendTextEvent(t, recovered, loaded, "after crash", nil)
+	
+	data, _ := os.ReadFile(logPath)
+	if strings.Contains(string(data), "cut") || strings.Count(string(data), "\n") != 2 {
+		t.Errorf("Expected the partial record to be replaced, got %q", data)
+	}
+	reopened, _ := NewFileSessionService(root)
+	if final, _ := reopened.GetSession("test_app", "alice", "s1", nil); len(final.Events) != 2 || final.Version != 2 {
+		t.Errorf("Expected 2 events at version 2 after recovery, got %d at version %d", len(final.Events), final.Version)
+	}
+}
+
+func TestFileSessionServiceFailedSharedStateWrite(t *testing.T) {
+	root := t.TempDir()
+	service, _ := NewFileSessionService(root)
+	session, _ := service.CreateSession("test_app", "alice", "s1", nil)
+	appendTextEvent(t, service, session, "kept", nil)
+	
+	// A directory in place of the app state file makes its update fail
+	if err := os.Mkdir(filepath.Join(root, "test_app", ".app_state.json"), 0o755); err != nil {
+		t.Fatalf("Mkdir should not return error: %v", err)
+	}
+	event := events.NewEvent()
+	event.Author = "agent"
+	event.Actions.StateDelta = map[string]interface{}{"app:theme": "dark", "count": 1}
+	if err := service.AppendEvent(session, event); err == nil {
+		t.Fatal("Expected error when the app state cannot be written")
+	}
+	if len(session.Events) != 1 || session.Version != 1 {
+		t.Errorf("Expected the session unchanged, got %d events at version %d", len(session.Events), session.Version)
+	}
+	
+	// The failed event was not logged, so the session still accepts events
+	os.Remove(filepath.Join(root, "test_app", ".app_state.json"))
+	appendTextEvent(t, service, session, "after failure", nil)
+	reopened, _ := NewFileSessionService(root)
+	loaded, err := reopened.GetSession("test_app", "alice", "s1", nil)
+	if err != nil || len(loaded.Events) != 2 || loaded.Version != 2 {
+		t.Fatalf("Expected 2 events at version 2, got %v, %v", loaded, err)
+	}
+	if _, exists := loaded.State.Get("count"); exists {
+		t.Error("Expected the failed event's state to be dropped")
+	}
+}
+
+func TestFileSessionServiceCompaction(t *testing.T) {
+	root := t.TempDir()
+	service, _ := NewFileSessionService(root)
+	service.SetCompactionThreshold(2).SetSyncMode(SyncNever)
+	
+	session, _ := service.CreateSession("test_app", "alice", "s1", nil)
+	for _, text := range []string{"one", "two", "three"} {
+		appendTextEvent(t, service, session, text, map[string]interface{}{"last": text})
+	}
+	
+	logPath := filepath.Join(root, "test_app", "alice", "s1", "events.jsonl")
+	data, _ := os.ReadFile(logPath)
+	if strings.Count(string(data), "\n") != 1 {
+		t.Errorf("Expected one record left after compaction, got %q", data)
+	}
+	
+	if err := service.Compact("test_app", "alice", "s1"); err != nil {
+		t.Fatalf("Compact should not return error: %v", err)
+	}
+	if info, _ := os.Stat(logPath); info.Size() != 0 {
+		t.Errorf("Expected an empty log after compaction, got %d bytes", info.Size())
+	}
+	
+	reopened, _ := NewFileSessionService(root)
+	loaded, _ := reopened.GetSession("test_app", "alice", "s1", nil)
+	if len(loaded.Events) != 3 || loaded.Version != 3 {
+		t.Fatalf("Expected 3 events at version 3, got %d at version %d", len(loaded.Events), loaded.Version)
+	}
+	if value, _ := loaded.State.Get("last"); value != "three" {
+		t.Errorf("Expected the latest state, got %v", value)
+	}
+}
+
+func newSQLiteSessionService(t *testing.T, path string) *SQLSessionService {
+	t.Helper()
+	// Subscriptions poll while the test writes; in WAL mode readers never
+	// hold writers up, so neither fails with SQLITE_BUSY
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatalf("sql.Open should not return error: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	service, err := NewSQLSessionService(db, DialectSQLite)
+	if err != nil {
+		t.Fatalf("NewSQLSessionService should not return error: %v", err)
+	}
+	return service
+}
+
+func TestSQLSessionService(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.db")
+	service := newSQLiteSessionService(t, path)
+	
+	session, err := service.CreateSession("test_app", "alice", "s1", map[string]interface{}{"topic": "billing", "app:greeting": "hi"})
+	if err != nil {
+		t.Fatalf("CreateSession should not return error: %v", err)
+	}
+	if _, err := service.CreateSession("test_app", "alice", "s1", nil); err == nil {
+		t.Error("Expected creating an existing session to fail")
+	}
+	appendTextEvent(t, service, session, "first", map[string]interface{}{"step": 1, "user:name": "Alice", "temp:scratch": "x"})
+	appendTextEvent(t, service, session, "second", map[string]interface{}{"step": 2})
+	
+	if value, _ := session.State.Get("temp:scratch"); value != "x" {
+		t.Errorf("Expected the writer to keep temp values, got %v", value)
+	}
+	if session.Version != 2 {
+		t.Errorf("Expected version 2, got %d", session.Version)
+	}
+	
+	// Reopening the database runs no migration twice and keeps the data
+	reopened := newSQLiteSessionService(t, path)
+	stored, err := reopened.GetSession("test_app", "alice", "s1", nil)
+	if err != nil || stored == nil {
+		t.Fatalf("Expected the session to be stored, got %v", err)
+	}
+	if len(stored.Events) != 2 || stored.Events[1].Content.Parts[0].Text != "second" {
+		t.Errorf("Expected 2 events in order, got %d", len(stored.Events))
+	}
+	if value, _ := stored.State.Get("step"); value != float64(2) {
+		t.Errorf("Expected step 2, got %v", value)
+	}
+	if _, ok := stored.State.Get("temp:scratch"); ok {
+		t.Error("Expected temp values not to be persisted")
+	}
+	if _, ok := stored.Events[0].Actions.StateDelta["temp:scratch"]; ok {
+		t.Error("Expected temp values to be trimmed from the event")
+	}
+	if stored.Version != 2 {
+		t.Errorf("Expected version 2, got %d", stored.Version)
+	}
+	
+	// App and user values are shared with the user's other sessions
+	other, err := service.CreateSession("test_app", "alice", "s2", nil)
+	if err != nil {
+		t.Fatalf("CreateSession should not return error: %v", err)
+	}
+	if value, _ := other.State.Get("user:name"); value != "Alice" {
+		t.Errorf("Expected user:name Alice, got %v", value)
+	}
+	if value, _ := other.State.Get("app:greeting"); value != "hi" {
+		t.Errorf("Expected app:greeting hi, got %v", value)
+	}
+	bob, err := service.CreateSession("test_app", "bob", "s1", nil)
+	if err != nil {
+		t.Fatalf("CreateSession should not return error: %v", err)
+	}
+	if _, ok := bob.State.Get("user:name"); ok {
+		t.Error("Expected user values not to leak to other users")
+	}
+	
+	if err := service.DeleteSession("test_app", "alice", "s1"); err != nil {
+		t.Fatalf("DeleteSession should not return error: %v", err)
+	}
+	if deleted, _ := service.GetSession("test_app", "alice", "s1", nil); deleted != nil {
+		t.Error("Expected the session to be deleted")
+	}
+	if remaining, _ := service.ListEvents("test_app", "alice", "s1", nil); len(remaining) != 0 {
+		t.Errorf("Expected the session's events to be deleted, got %d", len(remaining))
+	}
+}
+
+func TestSQLSessionServiceConflict(t *testing.T) {
+	service := newSQLiteSessionService(t, filepath.Join(t.TempDir(), "sessions.db"))
+	session, err := service.CreateSession("test_app", "alice", "s1", nil)
+	if err != nil {
+		t.Fatalf("CreateSession should not return error: %v", err)
+	}
+	stale, _ := service.GetSession("test_app", "alice", "s1", nil)
+	appendTextEvent(t, service, session, "first", map[string]interface{}{"step": 1})
+	
+	event := events.NewEvent()
+	event.Actions.StateDelta = map[string]interface{}{"step": 99, "user:name": "Mallory"}
+	err = service.AppendEvent(stale, event)
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("Expected a ConflictError, got %v", err)
+	}
+	
+	// The rejected event must leave no trace
+	stored, _ := service.GetSession("test_app", "alice", "s1", nil)
+	if len(stored.Events) != 1 {
+		t.Errorf("Expected 1 event, got %d", len(stored.Events))
+	}
+	if value, _ := stored.State.Get("step"); value != float64(1) {
+		t.Errorf("Expected step 1, got %v", value)
+	}
+	if _, ok := stored.State.Get("user:name"); ok {
+		t.Error("Expected the rejected user value to be rolled back")
+	}
+}
+
+// sessionServices returns one service of each implementation
+func sessionServices(t *testing.T) map[string]SessionService {
+	t.Helper()
+	fileService, err := NewFileSessionService(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileSessionService should not return error: %v", err)
+	}
+	return map[string]SessionService{
+		"memory": NewInMemorySessionService(),
+		"file":   fileService,
+		"sql":    newSQLiteSessionService(t, filepath.Join(t.TempDir(), "sessions.db")),
+	}
+}
+
+func TestSessionServiceListSessionsPagination(t *testing.T) {
+	for name, service := range sessionServices(t) {
+		t.Run(name, func(t *testing.T) {
+			for _, id := range []string{"c", "a", "e", "b", "d"} {
+				if _, err := service.CreateSession("test_app", "alice", id, nil); err != nil {
+					t.Fatalf("CreateSession should not return error: %v", err)
+				}
+				time.Sleep(time.Millisecond)
+			}
+			updated, _ := service.GetSession("test_app", "alice", "b", nil)
+			appendTextEvent(t, service, updated, "hello", nil)
+			
+			listAll := func(order SessionOrder) ([]string, int) {
+				var ids []string
+				pages := 0
+				config := &ListSessionsConfig{PageSize: 2, OrderBy: order}
+				for {
+					response, err := service.ListSessions("test_app", "alice", config)
+					if err != nil {
+						t.Fatalf("ListSessions should not return error: %v", err)
+					}
+					for _, session := range response.Sessions {
+						if len(session.Events) != 0 {
+							t.Errorf("Expected sessions to be listed without events, got %d", len(session.Events))
+						}
+						ids = append(ids, session.ID)
+					}
+					pages++
+					if response.NextPageToken == "" {
+						return ids, pages
+					}
+					config.PageToken = response.NextPageToken
+				}
+			}
+			
+			if ids, pages := listAll(OrderByID); strings.Join(ids, ",") != "a,b,c,d,e" || pages != 3 {
+				t.Errorf("Expected a,b,c,d,e in 3 pages, got %v in %d pages", ids, pages)
+			}
+			if ids, _ := listAll(OrderByLastUpdateTime); strings.Join(ids, ",") != "b,d,e,a,c" {
+				t.Errorf("Expected b,d,e,a,c, got %v", ids)
+			}
+			
+			first, _ := service.ListSessions("test_app", "alice", &ListSessionsConfig{PageSize: 2})
+			if _, err := service.ListSessions("test_app", "alice", &ListSessionsConfig{PageToken: first.NextPageToken, OrderBy: OrderByLastUpdateTime}); err == nil {
+				t.Error("Expected a page token from another order to fail")
+			}
+			if _, err := service.ListSessions("test_app", "alice", &ListSessionsConfig{PageToken: "!"}); err == nil {
+				t.Error("Expected an invalid page token to fail")
+			}
+			if empty, err := service.ListSessions("test_app", "nobody", nil); err != nil || len(empty.Sessions) != 0 {
+				t.Errorf("Expected no sessions for an unknown user, got %v (%v)", empty, err)
+			}
+		})
+	}
+}
+
+func TestSessionServiceGetSessionConfig(t *testing.T) {
+	for name, service := range sessionServices(t) {
+		t.Run(name, func(t *testing.T) {
+			session, err := service.CreateSession("test_app", "alice", "s1", nil)
+			if err != nil {
+				t.Fatalf("CreateSession should not return error: %v", err)
+			}
+			
+			var middle time.Time
+			for i, branch := range []string{"root", "root.helper", "root", "root.helper_two", "root.helper.search"} {
+				event := events.NewEvent()
+				event.Author = []string{"user", "helper"}[i%2]
+				event.Branch = branch
+				event.Content = &events.Content{Role: "model", Parts: []events.Part{{Text: fmt.Sprintf("event %d", i)}}}
+				if err := service.AppendEvent(session, event); err != nil {
+					t.Fatalf("AppendEvent should not return error: %v", err)
+				}
+				if i == 1 {
+					middle = event.Timestamp
+				}
+				time.Sleep(time.Millisecond)
+			}
+			
+			texts := func(sessionEvents []*events.Event) string {
+				var parts []string
+				for _, event := range sessionEvents {
+					parts = append(parts, event.Content.Parts[0].Text)
+				}
+				return strings.Join(parts, ",")
+			}
+			
+			testCases := []struct {
+				name     string
+				config   *GetSessionConfig
+				expected string
+			}{
+				{"all", nil, "event 0,event 1,event 2,event 3,event 4"},
+				{"recent", &GetSessionConfig{NumRecentEvents: 2}, "event 3,event 4"},
+				{"after", &GetSessionConfig{AfterTimestamp: middle}, "event 2,event 3,event 4"},
+				{"authors", &GetSessionConfig{Authors: []string{"helper"}}, "event 1,event 3"},
+				{"branch", &GetSessionConfig{Branch: "root.helper"}, "event 1,event 4"},
+				{"combined", &GetSessionConfig{Authors: []string{"user"}, NumRecentEvents: 2}, "event 2,event 4"},
+			}
+			for _, tc := range testCases {
+				loaded, err := service.GetSession("test_app", "alice", "s1", tc.config)
+				if err != nil {
+					t.Fatalf("GetSession should not return error: %v", err)
+				}
+				if got := texts(loaded.Events); got != tc.expected {
+					t.Errorf("%s: expected %q, got %q", tc.name, tc.expected, got)
+				}
+				listed, _ := service.ListEvents("test_app", "alice", "s1", tc.config)
+				if got := texts(listed); got != tc.expected {
+					t.Errorf("%s: expected listed events %q, got %q", tc.name, tc.expected, got)
+				}
+			}
+			
+			// A session loaded with recent events only can still be written to
+			recent, _ := service.GetSession("test_app", "alice", "s1", &GetSessionConfig{NumRecentEvents: 1})
+			appendTextEvent(t, service, recent, "event 5", nil)
+			if all, _ := service.ListEvents("test_app", "alice", "s1", nil); len(all) != 6 {
+				t.Errorf("Expected 6 events, got %d", len(all))
+			}
+		})
+	}
+}
+
+func TestSessionServiceRewindAndFork(t *testing.T) {
+	for name, service := range sessionServices(t) {
+		t.Run(name, func(t *testing.T) {
+			session, err := service.CreateSession("test_app", "alice", "s1", map[string]interface{}{"topic": "billing", "step": 0})
+			if err != nil {
+				t.Fatalf("CreateSession should not return error: %v", err)
+			}
+			var ids []string
+			for i, delta := range []map[string]interface{}{
+				{"step": 1},
+				{"step": 2, "draft": "hello", "user:name": "Alice"},
+				{"step": 3, "topic": "refund"},
+			} {
+				appendTextEvent(t, service, session, fmt.Sprintf("event %d", i), delta)
+				ids = append(ids, session.Events[len(session.Events)-1].ID)
+			}
+			
+			fork, err := service.ForkSession("test_app", "alice", "s1", ids[1], "s1-fork")
+			if err != nil {
+				t.Fatalf("ForkSession should not return error: %v", err)
+			}
+			if len(fork.Events) != 2 || fork.Version != 2 {
+				t.Errorf("Expected 2 events at version 2, got %d at version %d", len(fork.Events), fork.Version)
+			}
+			if value, _ := fork.State.Get("step"); value != float64(2) && value != 2 {
+				t.Errorf("Expected forked step 2, got %v", value)
+			}
+			if value, _ := fork.State.Get("topic"); value != "billing" {
+				t.Errorf("Expected forked topic billing, got %v", value)
+			}
+			if _, err := service.ForkSession("test_app", "alice", "s1", ids[0], "s1-fork"); err == nil {
+				t.Error("Expected forking into an existing session to fail")
+			}
+			
+			stale, _ := service.GetSession("test_app", "alice", "s1", nil)
+			rewound, err := service.RewindSession("test_app", "alice", "s1", ids[0])
+			if err != nil {
+				t.Fatalf("RewindSession should not return error: %v", err)
+			}
+			if len(rewound.Events) != 1 || rewound.Events[0].ID != ids[0] {
+				t.Errorf("Expected only the first event to remain, got %d events", len(rewound.Events))
+			}
+			if value, _ := rewound.State.Get("step"); value != float64(1) && value != 1 {
+				t.Errorf("Expected step 1, got %v", value)
+			}
+			if value, _ := rewound.State.Get("topic"); value != "billing" {
+				t.Errorf("Expected the initial topic to be restored, got %v", value)
+			}
+			if _, exists := rewound.State.Get("draft"); exists {
+				t.Error("Expected values set after the rewind point to be removed")
+			}
+			if value, _ := rewound.State.Get("user:name"); value != "Alice" {
+				t.Errorf("Expected user values to be left unchanged, got %v", value)
+			}
+			
+			// Writers holding the history before the rewind are rejected
+			var conflict *ConflictError
+			if err := service.AppendEvent(stale, events.NewEvent()); !errors.As(err, &conflict) {
+				t.Errorf("Expected a ConflictError, got %v", err)
+			}
+			appendTextEvent(t, service, rewound, "regenerated", map[string]interface{}{"step": 2})
+			stored, _ := service.GetSession("test_app", "alice", "s1", nil)
+			if len(stored.Events) != 2 || stored.Events[1].Content.Parts[0].Text != "regenerated" {
+				t.Errorf("Expected the regenerated event to follow the rewind point, got %d events", len(stored.Events))
+			}
+			
+			// The fork is independent of the rewound session
+			forked, _ := service.GetSession("test_app", "alice", "s1-fork", nil)
+			if len(forked.Events) != 2 {
+				t.Errorf("Expected the fork to keep 2 events, got %d", len(forked.Events))
+			}
+			rewoundFork, err := service.RewindSession("test_app", "alice", "s1-fork", ids[0])
+			if err != nil {
+				t.Fatalf("RewindSession should not return error: %v", err)
+			}
+			if value, _ := rewoundFork.State.Get("topic"); value != "billing" {
+				t.Errorf("Expected the fork to keep the initial state, got %v", value)
+			}
+			
+			if _, err := service.RewindSession("test_app", "alice", "s1", "missing"); err == nil {
+				t.Error("Expected rewinding to an unknown event to fail")
+			}
+			if _, err := service.ForkSession("test_app", "alice", "missing", ids[0], ""); err == nil {
+				t.Error("Expected forking an unknown session to fail")
+			}
+		})
+	}
+}
+
+func TestSQLSessionServiceMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("sql.Open should not return error: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE adk_schema_migrations (version INTEGER NOT NULL PRIMARY KEY)"); err != nil {
+		t.Fatalf("Exec should not return error: %v", err)
+	}
+	if _, err := db.Exec(DialectSQLite.Migrations()[0]); err != nil {
+		t.Fatalf("Exec should not return error: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO adk_schema_migrations (version) VALUES (1)"); err != nil {
+		t.Fatalf("Exec should not return error: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO adk_sessions (app_name, user_id, id, state, version, create_time, update_time) VALUES ('test_app', 'alice', 'old', '{\"topic\":\"billing\"}', 0, 0, 0)"); err != nil {
+		t.Fatalf("Exec should not return error: %v", err)
+	}
+	
+	service := newSQLiteSessionService(t, path)
+	var version int
+	if err := db.QueryRow("SELECT MAX(version) FROM adk_schema_migrations").Scan(&version); err != nil || version != len(DialectSQLite.Migrations()) {
+		t.Errorf("Expected schema version %d, got %d (%v)", len(DialectSQLite.Migrations()), version, err)
+	}
+	
+	// Sessions from before the migration are still usable
+	old, err := service.GetSession("test_app", "alice", "old", nil)
+	if err != nil || old == nil {
+		t.Fatalf("Expected the old session to load, got %v", err)
+	}
+	appendTextEvent(t, service, old, "first", map[string]interface{}{"step": 1})
+	rewound, err := service.RewindSession("test_app", "alice", "old", old.Events[0].ID)
+	if err != nil {
+		t.Fatalf("RewindSession should not return error: %v", err)
+	}
+	if value, _ := rewound.State.Get("step"); value != float64(1) {
+		t.Errorf("Expected step 1, got %v", value)
+	}
+}
+
+func TestSessionExportImport(t *testing.T) {
+	source := NewInMemorySessionService()
+	session, err := source.CreateSession("test_app", "alice", "s1", map[string]interface{}{"topic": "billing", "app:greeting": "hi"})
+	if err != nil {
+		t.Fatalf("CreateSession should not return error: %v", err)
+	}
+	appendTextEvent(t, source, session, "first", map[string]interface{}{"step": 1, "user:name": "Alice", "temp:scratch": "x"})
+	event := events.NewEvent()
+	event.Author = "agent"
+	event.Actions.ArtifactDelta = map[string]interface{}{"report.pdf": 2}
+	event.Actions.StateDelta = map[string]interface{}{"step": 2}
+	if err := source.AppendEvent(session, event); err != nil {
+		t.Fatalf("AppendEvent should not return error: %v", err)
+	}
+	
+	stored, _ := source.GetSession("test_app", "alice", "s1", nil)
+	var buffer strings.Builder
+	if err := Export(stored, &buffer); err != nil {
+		t.Fatalf("Export should not return error: %v", err)
+	}
+	exported := buffer.String()
+	
+	for name, service := range sessionServices(t) {
+		t.Run(name, func(t *testing.T) {
+			imported, err := Import(strings.NewReader(exported))
+			if err != nil {
+				t.Fatalf("Import should not return error: %v", err)
+			}
+			if imported.SchemaVersion != ExportSchemaVersion {
+				t.Errorf("Expected schema version %d, got %d", ExportSchemaVersion, imported.SchemaVersion)
+			}
+			if len(imported.Artifacts) != 1 || imported.Artifacts[0] != (ArtifactReference{Filename: "report.pdf", Version: 2}) {
+				t.Errorf("Expected a reference to report.pdf version 2, got %v", imported.Artifacts)
+			}
+			if imported.State.User["user:name"] != "Alice" || imported.State.App["app:greeting"] != "hi" {
+				t.Errorf("Expected state scopes to be exported, got %+v", imported.State)
+			}
+			
+			// Restoring leaves the export unchanged, temporary values included
+			imported.Events[0].Actions.StateDelta["temp:draft"] = "y"
+			restored, err := Restore(service, imported)
+			if err != nil {
+				t.Fatalf("Restore should not return error: %v", err)
+			}
+			if _, exists := imported.Events[0].Actions.StateDelta["temp:draft"]; !exists {
+				t.Error("Expected Restore not to modify the export's state deltas")
+			}
+			loaded, _ := service.GetSession("test_app", "alice", "s1", nil)
+			if len(loaded.Events) != 2 || loaded.Events[0].ID != stored.Events[0].ID || loaded.Version != restored.Version {
+				t.Errorf("Expected the events to be restored, got %d", len(loaded.Events))
+			}
+			for key, expected := range map[string]interface{}{"topic": "billing", "step": float64(2), "user:name": "Alice", "app:greeting": "hi"} {
+				value, _ := loaded.State.Get(key)
+				if value != expected && fmt.Sprint(value) != fmt.Sprint(expected) {
+					t.Errorf("Expected %s to be %v, got %v", key, expected, value)
+				}
+			}
+			if _, exists := loaded.State.Get("temp:scratch"); exists {
+				t.Error("Expected temp values not to be exported")
+			}
+			
+			// The restored session rewinds like the original
+			rewound, err := service.RewindSession("test_app", "alice", "s1", loaded.Events[0].ID)
+			if err != nil {
+				t.Fatalf("RewindSession should not return error: %v", err)
+			}
+			if value, _ := rewound.State.Get("step"); fmt.Sprint(value) != "1" {
+				t.Errorf("Expected step 1 after rewinding, got %v", value)
+			}
+			
+			if _, err := Restore(service, imported); err == nil {
+				t.Error("Expected restoring over an existing session to fail")
+			}
+		})
+	}
+}
+
+func TestSessionImportMigration(t *testing.T) {
+	session := NewSession("test_app", "alice", "s1", map[string]interface{}{"topic": "billing", "user:name": "Alice"})
+	session.AddEvent(events.NewEvent())
+	
+	// Version 0 is the plain JSON encoding of a session, as in file snapshots
+	data, err := json.Marshal(session)
+	if err != nil {
+		t.Fatalf("json.Marshal should not return error: %v", err)
+	}
+	imported, err := Import(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatalf("Import should not return error: %v", err)
+	}
+	if imported.SchemaVersion != ExportSchemaVersion || imported.ID != "s1" || len(imported.Events) != 1 {
+		t.Errorf("Expected a migrated session s1 with 1 event, got %+v", imported)
+	}
+	if imported.State.Session["topic"] != "billing" || imported.State.User["user:name"] != "Alice" {
+		t.Errorf("Expected the state to be split by scope, got %+v", imported.State)
+	}
+	if value, _ := imported.Session().State.Get("topic"); value != "billing" {
+		t.Errorf("Expected the detached session to hold the state, got %v", value)
+	}
+	
+	if _, err := Import(strings.NewReader(`{"schema_version": 99, "id": "s1"}`)); err == nil {
+		t.Error("Expected a newer schema version to fail")
+	}
+	if _, err := Import(strings.NewReader(`{"schema_version": 1}`)); err == nil {
+		t.Error("Expected an export without a session ID to fail")
+	}
+}
+
+func TestInMemorySessionServiceExpiry(t *testing.T) {
+	clock := time.Now()
+	service := NewInMemorySessionService()
+	service.now = func() time.Time { return clock }
+	
+	var expired []string
+	failing := true
+	service.SetExpiryPolicy("", ExpiryPolicy{IdleTTL: time.Hour}).
+		SetExpiryPolicy("strict_app", ExpiryPolicy{MaxAge: 2 * time.Hour}).
+		OnExpire(func(ctx context.Context, session *Session, reason ExpiryReason) error {
+			if session.ID == "flaky" && failing {
+				return errors.New("memory unavailable")
+			}
+			expired = append(expired, session.ID+":"+reason.String())
+			return nil
+		})
+	
+	for _, id := range []string{"idle", "active", "flaky"} {
+		service.CreateSession("test_app", "alice", id, nil)
+	}
+	service.CreateSession("strict_app", "alice", "old", nil)
+	
+	clock = clock.Add(50 * time.Minute)
+	active, _ := service.GetSession("test_app", "alice", "active", nil)
+	appendTextEvent(t, service, active, "still here", nil)
+	strictSession, _ := service.GetSession("strict_app", "alice", "old", nil)
+	appendTextEvent(t, service, strictSession, "busy", nil)
+	
+	clock = clock.Add(20 * time.Minute)
+	removed, err := service.Sweep(context.Background())
+	if err == nil {
+		t.Error("Expected the failing hook to be reported")
+	}
+	if removed != 1 || strings.Join(expired, ",") != "idle:idle" {
+		t.Errorf("Expected only the idle session to expire, got %d removed: %v", removed, expired)
+	}
+	if flaky, _ := service.GetSession("test_app", "alice", "flaky", nil); flaky == nil {
+		t.Error("Expected a session whose hook failed to be kept")
+	}
+	
+	// Active sessions still expire once past their maximum age
+	failing = false
+	clock = clock.Add(2 * time.Hour)
+	expired = nil
+	if _, err := service.Sweep(context.Background()); err != nil {
+		t.Errorf("Sweep should not return error: %v", err)
+	}
+	if got := strings.Join(expired, ","); !strings.Contains(got, "old:max_age") || !strings.Contains(got, "flaky:idle") {
+		t.Errorf("Expected old and flaky to expire, got %v", got)
+	}
+	if remaining, _ := service.ListSessions("strict_app", "alice", nil); len(remaining.Sessions) != 0 {
+		t.Errorf("Expected the old session to be removed, got %d", len(remaining.Sessions))
+	}
+}
+
+func TestInMemorySessionServiceCapacity(t *testing.T) {
+	var hookErrs []error
+	service := NewInMemorySessionService().SetCapacity(2).OnExpiryError(func(err error) {
+		hookErrs = append(hookErrs, err)
+	})
+	var evicted []string
+	service.OnExpire(func(ctx context.Context, session *Session, reason ExpiryReason) error {
+		if reason != Evicted {
+			t.Errorf("Expected an eviction, got %v", reason)
+		}
+		// Hooks may use the service
+		if _, err := service.GetSession(session.AppName, session.UserID, session.ID, nil); err != nil {
+			t.Errorf("GetSession should not return error: %v", err)
+		}
+		evicted = append(evicted, session.ID)
+		return nil
+	})
+	
+	service.CreateSession("test_app", "alice", "a", nil)
+	service.CreateSession("test_app", "alice", "b", nil)
+	service.GetSession("test_app", "alice", "a", nil) // b is now the least recently used
+	service.CreateSession("test_app", "alice", "c", nil)
+	
+	if strings.Join(evicted, ",") != "b" {
+		t.Errorf("Expected b to be evicted, got %v", evicted)
+	}
+	listed, _ := service.ListSessions("test_app", "alice", nil)
+	if len(listed.Sessions) != 2 || listed.Sessions[0].ID != "a" || listed.Sessions[1].ID != "c" {
+		t.Errorf("Expected a and c to remain, got %v", listed.Sessions)
+	}
+	
+	// Hook errors of evicted sessions are reported, since no caller sees them
+	service.OnExpire(func(ctx context.Context, session *Session, reason ExpiryReason) error {
+		return errors.New("memory unavailable")
+	})
+	service.CreateSession("test_app", "alice", "d", nil)
+	if len(hookErrs) != 1 || !strings.Contains(hookErrs[0].Error(), "session a: memory unavailable") {
+		t.Errorf("Expected the eviction hook failure to be reported, got %v", hookErrs)
+	}
+}
+
+func TestJanitor(t *testing.T) {
+	service := NewInMemorySessionService().SetExpiryPolicy("", ExpiryPolicy{IdleTTL: time.Millisecond})
+	service.CreateSession("test_app", "alice", "s1", nil)
+	
+	var sweepErrs []error
+	var mu sync.Mutex
+	janitor, err := StartJanitor(service, time.Millisecond, func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		sweepErrs = append(sweepErrs, err)
+	})
+	if err != nil {
+		t.Fatalf("StartJanitor should not return error: %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if session, _ := service.GetSession("test_app", "alice", "s1", nil); session == nil {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	janitor.Stop()
+	janitor.Stop()
+	
+	if listed, _ := service.ListSessions("test_app", "alice", nil); len(listed.Sessions) != 0 {
+		t.Error("Expected the janitor to remove the idle session")
+	}
+	
+	// Sweep errors reach the error callback
+	service.OnExpire(func(ctx context.Context, session *Session, reason ExpiryReason) error {
+		return errors.New("memory unavailable")
+	})
+	service.CreateSession("test_app", "alice", "s2", nil)
+	janitor, _ = StartJanitor(service, time.Millisecond, func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		sweepErrs = append(sweepErrs, err)
+	})
+	deadline = time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		reported := len(sweepErrs)
+		mu.Unlock()
+		if reported > 0 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	janitor.Stop()
+	mu.Lock()
+	if len(sweepErrs) == 0 || !strings.Contains(sweepErrs[0].Error(), "memory unavailable") {
+		t.Errorf("Expected the hook failure to be reported, got %v", sweepErrs)
+	}
+	mu.Unlock()
+	
+	if _, err := StartJanitor(service, 0, nil); err == nil {
+		t.Error("Expected a zero interval to be rejected")
+	}
+}
+
+func TestInMemorySessionServiceCloseSession(t *testing.T) {
+	service := NewInMemorySessionService()
+	var closed []string
+	failing := true
+	service.OnExpire(func(ctx context.Context, session *Session, reason ExpiryReason) error {
+		if failing {
+			return errors.New("memory unavailable")
+		}
+		closed = append(closed, session.ID+":"+reason.String())
+		return nil
+	})
+	service.CreateSession("test_app", "alice", "s1", nil)
+	
+	if err := service.CloseSession("test_app", "alice", "s1"); err == nil {
+		t.Error("Expected the failing hook to be reported")
+	}
+	if session, _ := service.GetSession("test_app", "alice", "s1", nil); session == nil {
+		t.Error("Expected a session whose hook failed to be kept")
+	}
+	
+	failing = false
+	if err := service.CloseSession("test_app", "alice", "s1"); err != nil {
+		t.Errorf("CloseSession should not return error: %v", err)
+	}
+	if strings.Join(closed, ",") != "s1:closed" {
+		t.Errorf("Expected the hooks to run for the closed session, got %v", closed)
+	}
+	if session, _ := service.GetSession("test_app", "alice", "s1", nil); session != nil {
+		t.Error("Expected the closed session to be removed")
+	}
+	if err := service.CloseSession("test_app", "alice", "missing"); err != nil {
+		t.Errorf("Closing a missing session should not return error: %v", err)
+	}
+}
+
+func TestSQLDialects(t *testing.T) {
+	if got := DialectPostgres.Rebind("a = ? AND b = ?"); got != "a = $1 AND b = $2" {
+		t.Errorf("Expected postgres placeholders, got %s", got)
+	}
+	if got := DialectMySQL.Upsert("t", []string{"k"}, []string{"v"}); got != "INSERT INTO t (k, v) VALUES (?, ?) ON DUPLICATE KEY UPDATE v = VALUES(v)" {
+		t.Errorf("Unexpected MySQL upsert: %s", got)
+	}
+	if got := DialectPostgres.Upsert("t", []string{"k"}, []string{"v"}); got != "INSERT INTO t (k, v) VALUES (?, ?) ON CONFLICT (k) DO UPDATE SET v = excluded.v" {
+		t.Errorf("Unexpected postgres upsert: %s", got)
+	}
+}
+
+func nextChange(t *testing.T, changes <-chan SessionChange) SessionChange {
+	t.Helper()
+	select {
+	case change, ok := <-changes:
+		if !ok {
+			t.Fatal("Expected a change, got a closed channel")
+		}
+		return change
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a session change")
+	}
+	return SessionChange{}
+}
+
+func TestSessionServiceSubscribe(t *testing.T) {
+	root := t.TempDir()
+	fileSubscriber, err := NewFileSessionService(root)
+	if err != nil {
+		t.Fatalf("NewFileSessionService should not return error: %v", err)
+	}
+	fileWriter, err := NewFileSessionService(root)
+	if err != nil {
+		t.Fatalf("NewFileSessionService should not return error: %v", err)
+	}
+	memoryService := NewInMemorySessionService()
+	sqlService := newSQLiteSessionService(t, filepath.Join(t.TempDir(), "sessions.db"))
+	
+	// The file subscriber follows a session written by another service
+	// instance, as another process would
+	services := map[string]struct{ subscriber, writer SessionService }{
+		"memory": {memoryService, memoryService},
+		"file":   {fileSubscriber.SetPollInterval(10 * time.Millisecond), fileWriter},
+		"sql":    {sqlService.SetPollInterval(10 * time.Millisecond), sqlService},
+	}
+	for name, services := range services {
+		t.Run(name, func(t *testing.T) {
+			service := services.writer
+			changes, cancel := services.subscriber.Subscribe("test_app", "alice", "followed")
+			defer cancel()
+			
+			session, err := service.CreateSession("test_app", "alice", "followed", map[string]interface{}{"step": "start"})
+			if err != nil {
+				t.Fatalf("CreateSession should not return error: %v", err)
+			}
+			change := nextChange(t, changes)
+			if change.Type != ChangeCreated || change.SessionID != "followed" || change.State["step"] != "start" {
+				t.Errorf("Expected a creation with the initial state, got %+v", change)
+			}
+			
+			appendTextEvent(t, service, session, "hello", map[string]interface{}{"step": "greeted", "temp:scratch": 1})
+			change = nextChange(t, changes)
+			if change.Type != ChangeEventAppended || change.Event.ID != session.Events[0].ID || change.Version != session.Version {
+				t.Errorf("Expected the appended event at version %d, got %+v", session.Version, change)
+			}
+			if change.StateDelta["step"] != "greeted" {
+				t.Errorf("Expected the event's state delta, got %v", change.StateDelta)
+			}
+			if _, ok := change.StateDelta["temp:scratch"]; ok {
+				t.Error("Expected temporary values not to be published")
+			}
+			
+			// App values written through another session reach this one
+			other, _ := service.CreateSession("test_app", "bob", "other", nil)
+			appendTextEvent(t, service, other, "hi", map[string]interface{}{"app:theme": "dark"})
+			change = nextChange(t, changes)
+			if change.Type != ChangeStateUpdated || change.StateDelta["app:theme"] != "dark" {
+				t.Errorf("Expected the app value update, got %+v", change)
+			}
+			
+			appendTextEvent(t, service, session, "bye", map[string]interface{}{"step": "done"})
+			nextChange(t, changes)
+			if _, err := service.RewindSession("test_app", "alice", "followed", session.Events[0].ID); err != nil {
+				t.Fatalf("RewindSession should not return error: %v", err)
+			}
+			change = nextChange(t, changes)
+			if change.Type != ChangeRewound || change.State["step"] != "greeted" {
+				t.Errorf("Expected a rewind to the greeted state, got %+v", change)
+			}
+			
+			if err := service.DeleteSession("test_app", "alice", "followed"); err != nil {
+				t.Fatalf("DeleteSession should not return error: %v", err)
+			}
+			if change = nextChange(t, changes); change.Type != ChangeDeleted {
+				t.Errorf("Expected a deletion, got %+v", change)
+			}
+			
+			cancel()
+			for range changes {
+			}
+		})
+	}
+}
+
+func TestSubscriptionDoesNotBlockWriters(t *testing.T) {
+	service := NewInMemorySessionService()
+	changes, cancel := service.Subscribe("test_app", "alice", "busy")
+	defer cancel()
+	
+	session, _ := service.CreateSession("test_app", "alice", "busy", nil)
+	for i := 0; i < 100; i++ {
+		appendTextEvent(t, service, session, fmt.Sprintf("event %d", i), nil)
+	}
+	
+	if change := nextChange(t, changes); change.Type != ChangeCreated {
+		t.Errorf("Expected the creation first, got %v", change.Type)
+	}
+	for i := 0; i < 100; i++ {
+		change := nextChange(t, changes)
+		if change.Event.ID != session.Events[i].ID {
+			t.Fatalf("Expected event %d in order, got %+v", i, change.Event)
+		}
+	}
+}
+
+func TestFileSessionServiceSubscribeLoadFailure(t *testing.T) {
+	service, err := NewFileSessionService(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileSessionService should not return error: %v", err)
+	}
+	
+	// An empty session ID cannot be loaded
+	changes, cancel := service.SetPollInterval(10 * time.Millisecond).Subscribe("test_app", "alice", "")
+	defer cancel()
+	
+	change := nextChange(t, changes)
+	if change.Type != ChangeFailed || change.Err == nil || change.UserID != "alice" {
+		t.Errorf("Expected the subscription to fail, got %+v", change)
+	}
+	select {
+	case change, ok := <-changes:
+		if ok {
+			t.Errorf("Expected the changes channel to be closed, got %+v", change)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the changes channel to close")
+	}
+}
+
+func TestFileSessionServiceSubscribeExistingSession(t *testing.T) {
+	service, err := NewFileSessionService(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewFileSessionService should not return error: %v", err)
+	}
+	session, _ := service.CreateSession("test_app", "alice", "long", nil)
+	for i := 0; i < 5; i++ {
+		appendTextEvent(t, service, session, fmt.Sprintf("event %d", i), nil)
+	}
+	
+	changes, cancel := service.SetPollInterval(10 * time.Millisecond).Subscribe("test_app", "alice", "long")
+	defer cancel()
+	
+	// Only changes made after subscribing are reported
+	appendTextEvent(t, service, session, "new", nil)
+	change := nextChange(t, changes)
+	if change.Type != ChangeEventAppended || change.Event.ID != session.Events[5].ID || change.Version != session.Version {
+		t.Errorf("Expected only the new event, got %+v", change)
+	}
+}
+
+func TestEncryptedSessionService(t *testing.T) {
+	for name, inner := range sessionServices(t) {
+		t.Run(name, func(t *testing.T) {
+			keys := encryption.NewKeyRing()
+			keys.AddKey("clinic", "2024", []byte("0123456789abcdef0123456789abcdef"))
+			service := NewEncryptedSessionService(inner, keys)
+			
+			session, err := service.CreateSession("clinic", "patient", "visit", map[string]interface{}{"diagnosis": "flu", "app:clinic_name": "North"})
+			if err != nil {
+				t.Fatalf("CreateSession should not return error: %v", err)
+			}
+			if value, _ := session.State.Get("diagnosis"); value != "flu" {
+				t.Errorf("Expected the created session in clear, got %v", value)
+			}
+			
+			event := events.NewEvent()
+			event.Author = "doctor"
+			event.Content = &events.Content{Role: "model", Parts: []events.Part{{Text: "take rest"}}}
+			event.Actions.StateDelta = map[string]interface{}{"temperature": 38.5, "temp:draft": "x"}
+			if err := service.AppendEvent(session, event); err != nil {
+				t.Fatalf("AppendEvent should not return error: %v", err)
+			}
+			if value, _ := session.State.Get("temperature"); value != 38.5 || session.Version != 1 || len(session.Events) != 1 {
+				t.Errorf("Expected the caller's session to be updated in clear, got %+v", session)
+			}
+			if _, ok := event.Actions.StateDelta["temp:draft"]; ok {
+				t.Error("Expected temporary values to be removed from the event")
+			}
+			
+			// The wrapped service only sees ciphertext, with IDs and authors in clear
+			stored, _ := inner.GetSession("clinic", "patient", "visit", nil)
+			for key, value := range stored.State.ToDict() {
+				if text, ok := value.(string); !ok || !encryption.IsEncrypted([]byte(text)) {
+					t.Errorf("Expected state value %s to be encrypted, got %v", key, value)
+				}
+			}
+			storedEvent := stored.Events[0]
+			if storedEvent.ID != event.ID || storedEvent.Author != "doctor" || !storedEvent.Timestamp.Equal(event.Timestamp) {
+				t.Errorf("Expected the event's ID, author and timestamp in clear, got %+v", storedEvent)
+			}
+			if text := storedEvent.Content.Parts[0].Text; !encryption.IsEncrypted([]byte(text)) || strings.Contains(text, "take rest") {
+				t.Errorf("Expected the event content to be encrypted, got %q", text)
+			}
+			
+			// Events are still selected by their clear fields
+			loaded, err := service.GetSession("clinic", "patient", "visit", &GetSessionConfig{Authors: []string{"doctor"}})
+			if err != nil {
+				t.Fatalf("GetSession should not return error: %v", err)
+			}
+			if len(loaded.Events) != 1 || loaded.Events[0].Content.Parts[0].Text != "take rest" {
+				t.Errorf("Expected the decrypted event, got %+v", loaded.Events)
+			}
+			if value, _ := loaded.State.Get("app:clinic_name"); value != "North" {
+				t.Errorf("Expected the decrypted app value, got %v", value)
+			}
+			
+			// Rotated keys encrypt new data; older data stays readable
+			keys.AddKey("clinic", "2025", []byte("fedcba9876543210fedcba9876543210"))
+			appendTextEvent(t, service, session, "follow up", map[string]interface{}{"diagnosis": "recovered"})
+			stored, _ = inner.GetSession("clinic", "patient", "visit", nil)
+			if keyID, _ := encryption.KeyID([]byte(stored.Events[1].Content.Parts[0].Text)); keyID != "2025" {
+				t.Errorf("Expected the new event under the rotated key, got %q", keyID)
+			}
+			loaded, err = service.GetSession("clinic", "patient", "visit", nil)
+			if err != nil {
+				t.Fatalf("GetSession should not return error: %v", err)
+			}
+			if loaded.Events[0].Content.Parts[0].Text != "take rest" || loaded.Events[1].Content.Parts[0].Text != "follow up" {
+				t.Errorf("Expected both events decrypted, got %+v", loaded.Events)
+			}
+			if value, _ := loaded.State.Get("diagnosis"); value != "recovered" {
+				t.Errorf("Expected the updated diagnosis, got %v", value)
+			}
+			
+			// Rewinding replays the encrypted deltas
+			rewound, err := service.RewindSession("clinic", "patient", "visit", event.ID)
+			if err != nil {
+				t.Fatalf("RewindSession should not return error: %v", err)
+			}
+			if value, _ := rewound.State.Get("diagnosis"); value != "flu" {
+				t.Errorf("Expected the rewound diagnosis, got %v", value)
+			}
+			
+			// Forks are encrypted for their own session
+			fork, err := service.ForkSession("clinic", "patient", "visit", event.ID, "second_opinion")
+			if err != nil {
+				t.Fatalf("ForkSession should not return error: %v", err)
+			}
+			loaded, err = service.GetSession("clinic", "patient", fork.ID, nil)
+			if err != nil {
+				t.Fatalf("GetSession should not return error for the fork: %v", err)
+			}
+			if len(loaded.Events) != 1 || loaded.Events[0].ID != event.ID || loaded.Events[0].Content.Parts[0].Text != "take rest" || loaded.Version != 1 {
+				t.Errorf("Expected the forked event decrypted, got %+v", loaded.Events)
+			}
+			if value, _ := loaded.State.Get("diagnosis"); value != "flu" {
+				t.Errorf("Expected the forked diagnosis, got %v", value)
+			}
+			if value, _ := loaded.State.Get("temperature"); value != 38.5 {
+				t.Errorf("Expected the forked temperature, got %v", value)
+			}
+			
+			// Ciphertext moved to another session cannot be read there
+			stored, _ = inner.GetSession("clinic", "patient", "visit", nil)
+			moved := *stored.Events[0]
+			target, _ := inner.GetSession("clinic", "patient", "second_opinion", nil)
+			if err := inner.AppendEvent(target, &moved); err != nil {
+				t.Fatalf("AppendEvent should not return error: %v", err)
+			}
+			if _, err := service.GetSession("clinic", "patient", "second_opinion", nil); err == nil {
+				t.Error("Expected an event moved from another session to fail decryption")
+			}
+			nurseVisit, _ := service.CreateSession("clinic", "nurse", "visit", nil)
+			inner.AppendEvent(nurseVisit, &events.Event{ID: "moved", Actions: events.EventActions{StateDelta: map[string]interface{}{"diagnosis": stored.State.ToDict()["diagnosis"]}}})
+			if _, err := service.GetSession("clinic", "nurse", "visit", nil); err == nil {
+				t.Error("Expected a value moved from another user's session to fail decryption")
+			}
+			
+			// Data stored in clear is only read when allowed
+			legacy, _ := inner.CreateSession("clinic", "patient", "legacy", map[string]interface{}{"diagnosis": "cold"})
+			appendTextEvent(t, inner, legacy, "written in clear", nil)
+			if _, err := service.GetSession("clinic", "patient", "legacy", nil); err == nil {
+				t.Error("Expected data stored in clear to be rejected by default")
+			}
+			lenient := NewEncryptedSessionService(inner, keys).SetAllowPlaintext(true)
+			loaded, err = lenient.GetSession("clinic", "patient", "legacy", nil)
+			if err != nil {
+				t.Fatalf("GetSession should not return error when plaintext is allowed: %v", err)
+			}
+			if value, _ := loaded.State.Get("diagnosis"); value != "cold" || loaded.Events[0].Content.Parts[0].Text != "written in clear" {
+				t.Errorf("Expected the data stored in clear as it is, got %+v", loaded)
+			}
+			
+			// Without the keys the data cannot be read
+			other := NewEncryptedSessionService(inner, encryption.NewKeyRing())
+			if _, err := other.GetSession("clinic", "patient", "visit", nil); err == nil {
+				t.Error("Expected reading without the keys to fail")
+			}
+		})
+	}
+}
+
+func TestEncryptedSessionServiceSubscribeFailure(t *testing.T) {
+	inner := NewInMemorySessionService()
+	keys := encryption.NewKeyRing()
+	keys.AddKey("clinic", "2024", []byte("0123456789abcdef0123456789abcdef"))
+	service := NewEncryptedSessionService(inner, keys)
+	
+	service.CreateSession("clinic", "patient", "visit", nil)
+	changes, cancel := service.Subscribe("clinic", "patient", "visit")
+	defer cancel()
+	
+	// An event the wrapper cannot decrypt ends the subscription
+	stored, _ := inner.GetSession("clinic", "patient", "visit", nil)
+	appendTextEvent(t, inner, stored, "written in clear", nil)
+	change := nextChange(t, changes)
+	if change.Type != ChangeFailed || change.Err == nil || change.SessionID != "visit" {
+		t.Errorf("Expected a failed change with its error, got %+v", change)
+	}
+	select {
+	case _, ok := <-changes:
+		if ok {
+			t.Error("Expected the changes channel to be closed after a failure")
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected the changes channel to be closed after a failure")
+	}
+}
@@ -0,0 +1,838 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sessions
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adrienveepee/adk-go/google/adk/events"
+	"github.com/google/uuid"
+)
+
+// SQLDialect adapts the SQL session service to a database. Queries are
+// written with ? placeholders and rebound by the dialect.
+type SQLDialect interface {
+	// Name identifies the dialect, e.g. "postgres"
+	Name() string
+
+	// Rebind rewrites the ? placeholders of a query for the database
+	Rebind(query string) string
+
+	// Migrations returns the schema migrations, oldest first; migration i
+	// brings the schema to version i+1
+	Migrations() []string
+
+	// Upsert returns a statement inserting a row or updating its value
+	// columns if a row with the same key exists
+	Upsert(table string, keyColumns, valueColumns []string) string
+}
+
+// Supported SQL dialects
+var (
+	DialectPostgres SQLDialect = postgresDialect{}
+	DialectMySQL    SQLDialect = mysqlDialect{}
+	DialectSQLite   SQLDialect = sqliteDialect{}
+)
+
+// sqlMigrations returns the schema migrations using the given column types
+// for keys, JSON text and integers
+func sqlMigrations(keyType, textType, intType string) []string {
+	return []string{
+		`CREATE TABLE adk_sessions (
+	app_name ` + keyType + ` NOT NULL,
+	user_id ` + keyType + ` NOT NULL,
+	id ` + keyType + ` NOT NULL,
+	state ` + textType + ` NOT NULL,
+	version ` + intType + ` NOT NULL,
+	create_time ` + intType + ` NOT NULL,
+	update_time ` + intType + ` NOT NULL,
+	PRIMARY KEY (app_name, user_id, id)
+);
+CREATE TABLE adk_events (
+	app_name ` + keyType + ` NOT NULL,
+	user_id ` + keyType + ` NOT NULL,
+	session_id ` + keyType + ` NOT NULL,
+	version ` + intType + ` NOT NULL,
+	id ` + keyType + ` NOT NULL,
+	author ` + keyType + ` NOT NULL,
+	branch ` + keyType + ` NOT NULL,
+	timestamp ` + intType + ` NOT NULL,
+	data ` + textType + ` NOT NULL,
+	PRIMARY KEY (app_name, user_id, session_id, version)
+);
+CREATE TABLE adk_app_states (
+	app_name ` + keyType + ` NOT NULL,
+	state_key ` + keyType + ` NOT NULL,
+	state_value ` + textType + ` NOT NULL,
+	PRIMARY KEY (app_name, state_key)
+);
+CREATE TABLE adk_user_states (
+	app_name ` + keyType + ` NOT NULL,
+	user_id ` + keyType + ` NOT NULL,
+	state_key ` + keyType + ` NOT NULL,
+	state_value ` + textType + ` NOT NULL,
+	PRIMARY KEY (app_name, user_id, state_key)
+)`,
+		// The state sessions were created with, replayed on rewind; NULL for
+		// sessions created before this migration
+		`ALTER TABLE adk_sessions ADD COLUMN initial_state ` + textType,
+	}
+}
+
+// postgresDialect is the PostgreSQL dialect
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string { return "postgres" }
+
+func (postgresDialect) Rebind(query string) string {
+	var rebound strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			rebound.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		rebound.WriteRune(r)
+	}
+	return rebound.String()
+}
+
+func (postgresDialect) Migrations() []string {
+	return sqlMigrations("VARCHAR(128)", "TEXT", "BIGINT")
+}
+
+func (postgresDialect) Upsert(table string, keyColumns, valueColumns []string) string {
+	return onConflictUpsert(table, keyColumns, valueColumns)
+}
+
+// mysqlDialect is the MySQL dialect
+type mysqlDialect struct{}
+
+func (mysqlDialect) Name() string { return "mysql" }
+
+func (mysqlDialect) Rebind(query string) string { return query }
+
+func (mysqlDialect) Migrations() []string {
+	return sqlMigrations("VARCHAR(128)", "LONGTEXT", "BIGINT")
+}
+
+func (mysqlDialect) Upsert(table string, keyColumns, valueColumns []string) string {
+	updates := make([]string, len(valueColumns))
+	for i, column := range valueColumns {
+		updates[i] = column + " = VALUES(" + column + ")"
+	}
+	return insertStatement(table, append(keyColumns, valueColumns...)) +
+		" ON DUPLICATE KEY UPDATE " + strings.Join(updates, ", ")
+}
+
+// sqliteDialect is the SQLite dialect
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string { return "sqlite" }
+
+func (sqliteDialect) Rebind(query string) string { return query }
+
+func (sqliteDialect) Migrations() []string {
+	return sqlMigrations("TEXT", "TEXT", "INTEGER")
+}
+
+func (sqliteDialect) Upsert(table string, keyColumns, valueColumns []string) string {
+	return onConflictUpsert(table, keyColumns, valueColumns)
+}
+
+// insertStatement returns an INSERT statement for the given columns
+func insertStatement(table string, columns []string) string {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	return "INSERT INTO " + table + " (" + strings.Join(columns, ", ") + ") VALUES (" + placeholders + ")"
+}
+
+// onConflictUpsert returns an upsert using ON CONFLICT, as supported by
+// PostgreSQL and SQLite
+func onConflictUpsert(table string, keyColumns, valueColumns []string) string {
+	updates := make([]string, len(valueColumns))
+	for i, column := range valueColumns {
+		updates[i] = column + " = excluded." + column
+	}
+	return insertStatement(table, append(keyColumns, valueColumns...)) +
+		" ON CONFLICT (" + strings.Join(keyColumns, ", ") + ") DO UPDATE SET " + strings.Join(updates, ", ")
+}
+
+// SQLSessionService stores sessions in a SQL database through database/sql.
+// The caller opens the database with a driver matching the dialect. Every
+// AppendEvent runs in a transaction, so an event and its state changes are
+// stored together or not at all. State values must be JSON-serializable and
+// are read back as their JSON types.
+type SQLSessionService struct {
+	db           *sql.DB
+	dialect      SQLDialect
+	pollInterval time.Duration
+}
+
+// NewSQLSessionService creates a SQL session service and migrates the
+// database schema to the latest version
+func NewSQLSessionService(db *sql.DB, dialect SQLDialect) (*SQLSessionService, error) {
+	service := &SQLSessionService{db: db, dialect: dialect, pollInterval: DefaultPollInterval}
+	if err := service.Migrate(context.Background()); err != nil {
+		return nil, err
+	}
+	return service, nil
+}
+
+// Migrate applies the schema migrations the database has not seen yet. Each
+// migration runs in its own transaction where the database allows it.
+func (s *SQLSessionService) Migrate(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS adk_schema_migrations (version INTEGER NOT NULL PRIMARY KEY)"); err != nil {
+		return fmt.Errorf("failed to create migrations table: %w", err)
+	}
+
+	var current sql.NullInt64
+	if err := s.db.QueryRowContext(ctx, "SELECT MAX(version) FROM adk_schema_migrations").Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	migrations := s.dialect.Migrations()
+	for version := int(current.Int64) + 1; version <= len(migrations); version++ {
+		err := s.inTx(ctx, func(tx *sql.Tx) error {
+			// Not every driver runs several statements in one call
+			for _, statement := range strings.Split(migrations[version-1], ";\n") {
+				if _, err := tx.ExecContext(ctx, statement); err != nil {
+					return err
+				}
+			}
+			_, err := tx.ExecContext(ctx, s.dialect.Rebind("INSERT INTO adk_schema_migrations (version) VALUES (?)"), version)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply schema migration %d: %w", version, err)
+		}
+	}
+	return nil
+}
+
+// CreateSession creates a new session. Initial app and user values are
+// shared with the app's and user's other sessions.
+func (s *SQLSessionService) CreateSession(appName, userID, sessionID string, initialState map[string]interface{}) (*Session, error) {
+	ctx := context.Background()
+	if sessionID == "" {
+		sessionID = uuid.New().String()
+	}
+
+	scoped := splitStateDelta(initialState)
+	session := NewSession(appName, userID, sessionID, scoped.session)
+	state, err := json.Marshal(session.State)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode session state: %w", err)
+	}
+
+	err = s.inTx(ctx, func(tx *sql.Tx) error {
+		if err := s.insertSession(ctx, tx, session, string(state), string(state)); err != nil {
+			return err
+		}
+		return s.applySharedDelta(ctx, tx, appName, userID, scoped)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetSession(appName, userID, sessionID, nil)
+}
+
+// GetSession retrieves a session by ID with the events selected by the
+// config. Its state merges the session's values with the app and user values.
+func (s *SQLSessionService) GetSession(appName, userID, sessionID string, config *GetSessionConfig) (*Session, error) {
+	ctx := context.Background()
+
+	session, err := s.loadSession(ctx, s.db, appName, userID, sessionID)
+	if err != nil || session == nil {
+		return nil, err
+	}
+	// Events appended since the session row was read are left out, so that
+	// its events and its version always match
+	session.Events, err = s.listEvents(ctx, appName, userID, sessionID, config, session.Version)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// DeleteSession deletes a session and its events
+func (s *SQLSessionService) DeleteSession(appName, userID, sessionID string) error {
+	ctx := context.Background()
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, s.dialect.Rebind("DELETE FROM adk_events WHERE app_name = ? AND user_id = ? AND session_id = ?"), appName, userID, sessionID); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, s.dialect.Rebind("DELETE FROM adk_sessions WHERE app_name = ? AND user_id = ? AND id = ?"), appName, userID, sessionID)
+		return err
+	})
+}
+
+// ListSessions lists a page of a user's sessions, without their events. The
+// page is selected in the database, so large listings stay cheap.
+func (s *SQLSessionService) ListSessions(appName, userID string, config *ListSessionsConfig) (*ListSessionsResponse, error) {
+	ctx := context.Background()
+	if config == nil {
+		config = &ListSessionsConfig{}
+	}
+	if config.PageSize < 0 {
+		return nil, fmt.Errorf("page size must not be negative, got %d", config.PageSize)
+	}
+	after, err := decodePageToken(config.PageToken, config.OrderBy)
+	if err != nil {
+		return nil, err
+	}
+
+	query := "SELECT id, state, version, create_time, update_time FROM adk_sessions WHERE app_name = ? AND user_id = ?"
+	args := []interface{}{appName, userID}
+	order := " ORDER BY id"
+	if config.OrderBy == OrderByLastUpdateTime {
+		order = " ORDER BY update_time DESC, id"
+	}
+	if after != nil {
+		if config.OrderBy == OrderByLastUpdateTime {
+			query += " AND (update_time < ? OR (update_time = ? AND id > ?))"
+			args = append(args, after.UpdateTime, after.UpdateTime, after.ID)
+		} else {
+			query += " AND id > ?"
+			args = append(args, after.ID)
+		}
+	}
+	query += order
+	if config.PageSize > 0 {
+		// Fetch one more row than asked to know whether another page follows
+		query += " LIMIT ?"
+		args = append(args, config.PageSize+1)
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.dialect.Rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	appState, userState, err := s.sharedState(ctx, s.db, appName, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &ListSessionsResponse{Sessions: make([]*Session, 0)}
+	for rows.Next() {
+		session, err := s.scanSession(rows, appName, userID, appState, userState)
+		if err != nil {
+			return nil, err
+		}
+		response.Sessions = append(response.Sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if config.PageSize > 0 && len(response.Sessions) > config.PageSize {
+		response.Sessions = response.Sessions[:config.PageSize]
+		response.NextPageToken = encodePageToken(config.OrderBy, response.Sessions[config.PageSize-1])
+	}
+	return response, nil
+}
+
+// AppendEvent stores an event and its state changes in one transaction and
+// updates the given session to match. The event's state delta is applied by
+// scope; temporary values are removed from it and never persisted.
+func (s *SQLSessionService) AppendEvent(session *Session, event *events.Event) error {
+	ctx := context.Background()
+
+	// Keep the full delta for the writer, which keeps the temporary values
+	// for the rest of its invocation
+	delta := make(map[string]interface{}, len(event.Actions.StateDelta))
+	for key, value := range event.Actions.StateDelta {
+		delta[key] = value
+	}
+	trimTempDelta(event)
+	scoped := splitStateDelta(delta)
+
+	var version int64
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		var state string
+		err := tx.QueryRowContext(ctx, s.dialect.Rebind(
+			"SELECT state, version FROM adk_sessions WHERE app_name = ? AND user_id = ? AND id = ?"),
+			session.AppName, session.UserID, session.ID).Scan(&state, &version)
+		if err == sql.ErrNoRows {
+			version = -1
+			return nil // Session not found
+		}
+		if err != nil {
+			return err
+		}
+
+		// Reject writers working from an outdated copy of the session
+		if version != session.Version {
+			return &ConflictError{SessionID: session.ID, ExpectedVersion: session.Version, ActualVersion: version}
+		}
+
+		stored := NewState()
+		if err := json.Unmarshal([]byte(state), stored); err != nil {
+			return fmt.Errorf("failed to decode session state: %w", err)
+		}
+		stored.applyDelta(scoped.session)
+		newState, err := json.Marshal(stored)
+		if err != nil {
+			return fmt.Errorf("failed to encode session state: %w", err)
+		}
+
+		// The version condition catches writers that committed since the
+		// read above
+		result, err := tx.ExecContext(ctx, s.dialect.Rebind(
+			"UPDATE adk_sessions SET state = ?, version = ?, update_time = ? WHERE app_name = ? AND user_id = ? AND id = ? AND version = ?"),
+			string(newState), version+1, event.Timestamp.UnixNano(), session.AppName, session.UserID, session.ID, version)
+		if err != nil {
+			return err
+		}
+		if updated, err := result.RowsAffected(); err == nil && updated == 0 {
+			return &ConflictError{SessionID: session.ID, ExpectedVersion: session.Version, ActualVersion: version + 1}
+		}
+
+		if err := s.insertEvent(ctx, tx, session, version+1, event); err != nil {
+			return err
+		}
+
+		return s.applySharedDelta(ctx, tx, session.AppName, session.UserID, scoped)
+	})
+	if err != nil || version < 0 {
+		return err
+	}
+
+	session.State.applyDelta(delta)
+	session.Events = append(session.Events, event)
+	session.LastUpdateTime = event.Timestamp
+	session.Version = version + 1
+	return nil
+}
+
+// ListEvents lists the events of a session selected by the config. The
+// filters run in the database.
+func (s *SQLSessionService) ListEvents(appName, userID, sessionID string, config *GetSessionConfig) ([]*events.Event, error) {
+	return s.listEvents(context.Background(), appName, userID, sessionID, config, -1)
+}
+
+// listEvents lists the events selected by the config up to the given
+// session version, or all of them if it is negative
+func (s *SQLSessionService) listEvents(ctx context.Context, appName, userID, sessionID string, config *GetSessionConfig, maxVersion int64) ([]*events.Event, error) {
+	query := "SELECT data FROM adk_events WHERE app_name = ? AND user_id = ? AND session_id = ?"
+	args := []interface{}{appName, userID, sessionID}
+	if maxVersion >= 0 {
+		query += " AND version <= ?"
+		args = append(args, maxVersion)
+	}
+	newestFirst := false
+	if config != nil {
+		if !config.AfterTimestamp.IsZero() {
+			query += " AND timestamp > ?"
+			args = append(args, config.AfterTimestamp.UnixNano())
+		}
+		if len(config.Authors) > 0 {
+			query += " AND author IN (" + strings.TrimSuffix(strings.Repeat("?, ", len(config.Authors)), ", ") + ")"
+			for _, author := range config.Authors {
+				args = append(args, author)
+			}
+		}
+		if config.Branch != "" {
+			query += " AND (branch = ? OR branch LIKE ? ESCAPE '!')"
+			args = append(args, config.Branch, escapeLike(config.Branch)+".%")
+		}
+		if config.NumRecentEvents > 0 {
+			// Read the most recent events and restore their order below
+			query += " ORDER BY version DESC LIMIT ?"
+			args = append(args, config.NumRecentEvents)
+			newestFirst = true
+		}
+	}
+	if !newestFirst {
+		query += " ORDER BY version"
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.dialect.Rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessionEvents := make([]*events.Event, 0)
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		event := &events.Event{}
+		if err := json.Unmarshal([]byte(data), event); err != nil {
+			return nil, fmt.Errorf("failed to decode event: %w", err)
+		}
+		sessionEvents = append(sessionEvents, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if newestFirst {
+		for i, j := 0, len(sessionEvents)-1; i < j; i, j = i+1, j-1 {
+			sessionEvents[i], sessionEvents[j] = sessionEvents[j], sessionEvents[i]
+		}
+	}
+	return sessionEvents, nil
+}
+
+// escapeLike escapes the LIKE wildcards of a value, using ! as the escape
+// character, which needs no quoting in any dialect
+func escapeLike(value string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(value)
+}
+
+// CloseSession closes a session
+func (s *SQLSessionService) CloseSession(appName, userID, sessionID string) error {
+	// Nothing is held open per session
+	return nil
+}
+
+// RewindSession removes the events after the given event and recomputes the
+// session's own state from the remaining events, in one transaction
+func (s *SQLSessionService) RewindSession(appName, userID, sessionID, toEventID string) (*Session, error) {
+	ctx := context.Background()
+
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		var (
+			version      int64
+			initialState sql.NullString
+		)
+		err := tx.QueryRowContext(ctx, s.dialect.Rebind(
+			"SELECT version, initial_state FROM adk_sessions WHERE app_name = ? AND user_id = ? AND id = ?"),
+			appName, userID, sessionID).Scan(&version, &initialState)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("session %s not found", sessionID)
+		}
+		if err != nil {
+			return err
+		}
+
+		kept, toVersion, err := s.eventsUpTo(ctx, tx, appName, userID, sessionID, toEventID)
+		if err != nil {
+			return err
+		}
+		state, err := s.replayInitialState(initialState, kept)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, s.dialect.Rebind(
+			"DELETE FROM adk_events WHERE app_name = ? AND user_id = ? AND session_id = ? AND version > ?"),
+			appName, userID, sessionID, toVersion); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, s.dialect.Rebind(
+			"UPDATE adk_sessions SET state = ?, version = ?, update_time = ? WHERE app_name = ? AND user_id = ? AND id = ?"),
+			state, version+1, time.Now().UnixNano(), appName, userID, sessionID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetSession(appName, userID, sessionID, nil)
+}
+
+// ForkSession copies a session up to and including the given event into a
+// new session, in one transaction
+func (s *SQLSessionService) ForkSession(appName, userID, sessionID, toEventID, newSessionID string) (*Session, error) {
+	ctx := context.Background()
+	if newSessionID == "" {
+		newSessionID = uuid.New().String()
+	}
+
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		var initialState sql.NullString
+		err := tx.QueryRowContext(ctx, s.dialect.Rebind(
+			"SELECT initial_state FROM adk_sessions WHERE app_name = ? AND user_id = ? AND id = ?"),
+			appName, userID, sessionID).Scan(&initialState)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("session %s not found", sessionID)
+		}
+		if err != nil {
+			return err
+		}
+
+		kept, _, err := s.eventsUpTo(ctx, tx, appName, userID, sessionID, toEventID)
+		if err != nil {
+			return err
+		}
+		state, err := s.replayInitialState(initialState, kept)
+		if err != nil {
+			return err
+		}
+
+		fork := NewSession(appName, userID, newSessionID, nil)
+		fork.Version = int64(len(kept))
+		if !initialState.Valid {
+			initialState.String = "{}"
+		}
+		if err := s.insertSession(ctx, tx, fork, state, initialState.String); err != nil {
+			return err
+		}
+
+		for i, event := range kept {
+			if err := s.insertEvent(ctx, tx, fork, int64(i+1), event); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetSession(appName, userID, newSessionID, nil)
+}
+
+// eventsUpTo reads a session's events up to and including the given event,
+// and that event's version
+func (s *SQLSessionService) eventsUpTo(ctx context.Context, tx *sql.Tx, appName, userID, sessionID, eventID string) ([]*events.Event, int64, error) {
+	var toVersion int64
+	err := tx.QueryRowContext(ctx, s.dialect.Rebind(
+		"SELECT version FROM adk_events WHERE app_name = ? AND user_id = ? AND session_id = ? AND id = ? ORDER BY version LIMIT 1"),
+		appName, userID, sessionID, eventID).Scan(&toVersion)
+	if err == sql.ErrNoRows {
+		return nil, 0, fmt.Errorf("event %s not found in session %s", eventID, sessionID)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := tx.QueryContext(ctx, s.dialect.Rebind(
+		"SELECT data FROM adk_events WHERE app_name = ? AND user_id = ? AND session_id = ? AND version <= ? ORDER BY version"),
+		appName, userID, sessionID, toVersion)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var kept []*events.Event
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, 0, err
+		}
+		event := &events.Event{}
+		if err := json.Unmarshal([]byte(data), event); err != nil {
+			return nil, 0, fmt.Errorf("failed to decode event: %w", err)
+		}
+		kept = append(kept, event)
+	}
+	return kept, toVersion, rows.Err()
+}
+
+// replayInitialState replays events over a stored initial state and returns
+// the encoded result
+func (s *SQLSessionService) replayInitialState(initialState sql.NullString, sessionEvents []*events.Event) (string, error) {
+	var initial map[string]interface{}
+	if initialState.Valid {
+		if err := json.Unmarshal([]byte(initialState.String), &initial); err != nil {
+			return "", fmt.Errorf("failed to decode initial state: %w", err)
+		}
+	}
+	state, err := json.Marshal(replayState(initial, sessionEvents))
+	if err != nil {
+		return "", fmt.Errorf("failed to encode session state: %w", err)
+	}
+	return string(state), nil
+}
+
+// insertSession inserts a session row with its encoded state and initial
+// state
+func (s *SQLSessionService) insertSession(ctx context.Context, tx *sql.Tx, session *Session, state, initialState string) error {
+	now := session.LastUpdateTime.UnixNano()
+	_, err := tx.ExecContext(ctx, s.dialect.Rebind(insertStatement("adk_sessions",
+		[]string{"app_name", "user_id", "id", "state", "initial_state", "version", "create_time", "update_time"})),
+		session.AppName, session.UserID, session.ID, state, initialState, session.Version, now, now)
+	if err != nil {
+		return fmt.Errorf("failed to create session %s: %w", session.ID, err)
+	}
+	return nil
+}
+
+// insertEvent inserts an event row at the given version
+func (s *SQLSessionService) insertEvent(ctx context.Context, tx *sql.Tx, session *Session, version int64, event *events.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, s.dialect.Rebind(insertStatement("adk_events",
+		[]string{"app_name", "user_id", "session_id", "version", "id", "author", "branch", "timestamp", "data"})),
+		session.AppName, session.UserID, session.ID, version, event.ID, event.Author, event.Branch, event.Timestamp.UnixNano(), string(data))
+	return err
+}
+
+// sqlQuerier is implemented by both *sql.DB and *sql.Tx
+type sqlQuerier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// loadSession loads a session without its events, or returns nil if it does
+// not exist
+func (s *SQLSessionService) loadSession(ctx context.Context, q sqlQuerier, appName, userID, sessionID string) (*Session, error) {
+	rows, err := q.QueryContext(ctx, s.dialect.Rebind(
+		"SELECT id, state, version, create_time, update_time FROM adk_sessions WHERE app_name = ? AND user_id = ? AND id = ?"),
+		appName, userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, rows.Err() // Session not found
+	}
+	appState, userState, err := s.sharedState(ctx, q, appName, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.scanSession(rows, appName, userID, appState, userState)
+}
+
+// scanSession reads a session row selected as id, state, version,
+// create_time and update_time
+func (s *SQLSessionService) scanSession(rows *sql.Rows, appName, userID string, appState, userState map[string]interface{}) (*Session, error) {
+	var (
+		id, state  string
+		version    int64
+		createTime int64
+		updateTime int64
+	)
+	if err := rows.Scan(&id, &state, &version, &createTime, &updateTime); err != nil {
+		return nil, err
+	}
+	sessionState := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(state), &sessionState); err != nil {
+		return nil, fmt.Errorf("failed to decode session state: %w", err)
+	}
+
+	return &Session{
+		ID:             id,
+		AppName:        appName,
+		UserID:         userID,
+		State:          mergeState(appState, userState, sessionState),
+		Events:         make([]*events.Event, 0),
+		CreateTime:     time.Unix(0, createTime),
+		LastUpdateTime: time.Unix(0, updateTime),
+		Version:        version,
+	}, nil
+}
+
+// sharedState reads the app's and the user's state
+func (s *SQLSessionService) sharedState(ctx context.Context, q sqlQuerier, appName, userID string) (map[string]interface{}, map[string]interface{}, error) {
+	appState, err := s.readState(ctx, q, "SELECT state_key, state_value FROM adk_app_states WHERE app_name = ?", appName)
+	if err != nil {
+		return nil, nil, err
+	}
+	userState, err := s.readState(ctx, q, "SELECT state_key, state_value FROM adk_user_states WHERE app_name = ? AND user_id = ?", appName, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return appState, userState, nil
+}
+
+// readState reads key and JSON value rows into a map
+func (s *SQLSessionService) readState(ctx context.Context, q sqlQuerier, query string, args ...interface{}) (map[string]interface{}, error) {
+	rows, err := q.QueryContext(ctx, s.dialect.Rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	state := make(map[string]interface{})
+	for rows.Next() {
+		var key, data string
+		if err := rows.Scan(&key, &data); err != nil {
+			return nil, err
+		}
+		var value interface{}
+		if err := json.Unmarshal([]byte(data), &value); err != nil {
+			return nil, fmt.Errorf("failed to decode state %s: %w", key, err)
+		}
+		state[key] = value
+	}
+	return state, rows.Err()
+}
+
+// applySharedDelta stores the app and user values of a delta, one row per key
+func (s *SQLSessionService) applySharedDelta(ctx context.Context, tx *sql.Tx, appName, userID string, delta scopedDelta) error {
+	appUpsert := s.dialect.Rebind(s.dialect.Upsert("adk_app_states", []string{"app_name", "state_key"}, []string{"state_value"}))
+	for key, value := range delta.app {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("failed to encode state %s: %w", key, err)
+		}
+		if _, err := tx.ExecContext(ctx, appUpsert, appName, key, string(data)); err != nil {
+			return err
+		}
+	}
+
+	userUpsert := s.dialect.Rebind(s.dialect.Upsert("adk_user_states", []string{"app_name", "user_id", "state_key"}, []string{"state_value"}))
+	for key, value := range delta.user {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("failed to encode state %s: %w", key, err)
+		}
+		if _, err := tx.ExecContext(ctx, userUpsert, appName, userID, key, string(data)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inTx runs fn in a transaction, committing if it succeeds
+func (s *SQLSessionService) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return errors.Join(err, rollbackErr)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetPollInterval sets how often subscribed sessions are checked for changes
+func (s *SQLSessionService) SetPollInterval(interval time.Duration) *SQLSessionService {
+	s.pollInterval = interval
+	return s
+}
+
+// Subscribe follows the changes made to a session from then on, including
+// by other processes sharing the database. The session is checked for
+// changes at every poll interval.
+func (s *SQLSessionService) Subscribe(appName, userID, sessionID string) (<-chan SessionChange, func()) {
+	sub := newSubscription(appName, userID, sessionID)
+	cancel := pollSubscription(sub, s.pollInterval, func(config *GetSessionConfig) (*Session, error) {
+		return s.GetSession(appName, userID, sessionID, config)
+	})
+	return sub.changes, cancel
+}
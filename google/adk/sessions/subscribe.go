@@ -0,0 +1,316 @@
+// Copyright 2025 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sessions
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/adrienveepee/adk-go/google/adk/events"
+)
+
+// DefaultPollInterval is how often services backed by shared storage check a
+// subscribed session for changes made by other processes
+const DefaultPollInterval = 500 * time.Millisecond
+
+// SessionChangeType tells what changed in a subscribed session
+type SessionChangeType int
+
+const (
+	// ChangeCreated marks the creation of the session; State holds its state
+	ChangeCreated SessionChangeType = iota
+	// ChangeEventAppended marks an appended event; StateDelta holds the state
+	// changes it recorded
+	ChangeEventAppended
+	// ChangeStateUpdated marks app or user values changed through another
+	// session; StateDelta holds the changed values
+	ChangeStateUpdated
+	// ChangeRewound marks a rewind of the session; State holds its recomputed
+	// state
+	ChangeRewound
+	// ChangeDeleted marks the removal of the session, including by expiry
+	ChangeDeleted
+	// ChangeFailed marks a failure to follow the session; Err holds the
+	// error and the changes channel is closed after it
+	ChangeFailed
+)
+
+// String returns the change type's name
+func (t SessionChangeType) String() string {
+	switch t {
+	case ChangeCreated:
+		return "created"
+	case ChangeEventAppended:
+		return "event_appended"
+	case ChangeStateUpdated:
+		return "state_updated"
+	case ChangeRewound:
+		return "rewound"
+	case ChangeDeleted:
+		return "deleted"
+	case ChangeFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+// SessionChange describes one change to a subscribed session. Events and
+// state values are shared with the service and must not be modified.
+type SessionChange struct {
+	Type      SessionChangeType
+	AppName   string
+	UserID    string
+	SessionID string
+
+	// Version is the session's version after the change
+	Version int64
+
+	Event      *events.Event
+	StateDelta map[string]interface{}
+	State      map[string]interface{}
+
+	// Err is the error of a ChangeFailed change
+	Err error
+}
+
+// subscription queues the changes of a session for one subscriber, so that
+// a slow subscriber never blocks the writers
+type subscription struct {
+	appName   string
+	userID    string
+	sessionID string
+
+	changes chan SessionChange
+	mu      sync.Mutex
+	queue   []SessionChange
+	ended   bool
+	wake    chan struct{}
+	done    chan struct{}
+	stop    sync.Once
+}
+
+// newSubscription starts delivering the changes published to a subscription
+func newSubscription(appName, userID, sessionID string) *subscription {
+	sub := &subscription{
+		appName:   appName,
+		userID:    userID,
+		sessionID: sessionID,
+		changes:   make(chan SessionChange),
+		wake:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+	go sub.deliver()
+	return sub
+}
+
+// publish queues a change for delivery
+func (s *subscription) publish(change SessionChange) {
+	change.AppName = s.appName
+	change.UserID = s.userID
+	change.SessionID = s.sessionID
+
+	s.mu.Lock()
+	s.queue = append(s.queue, change)
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// fail queues a ChangeFailed change and closes the changes channel once it
+// is delivered
+func (s *subscription) fail(err error) {
+	s.mu.Lock()
+	s.queue = append(s.queue, SessionChange{Type: ChangeFailed, AppName: s.appName, UserID: s.userID, SessionID: s.sessionID, Err: err})
+	s.ended = true
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// cancel stops delivery and closes the changes channel, dropping queued
+// changes. It is safe to call more than once.
+func (s *subscription) cancel() {
+	s.stop.Do(func() { close(s.done) })
+}
+
+// deliver sends queued changes in order until the subscription is cancelled
+func (s *subscription) deliver() {
+	defer close(s.changes)
+
+	for {
+		s.mu.Lock()
+		pending := s.queue
+		s.queue = nil
+		ended := s.ended
+		s.mu.Unlock()
+
+		for _, change := range pending {
+			select {
+			case s.changes <- change:
+			case <-s.done:
+				return
+			}
+		}
+		if ended {
+			return
+		}
+
+		select {
+		case <-s.wake:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// pollSubscription follows a session stored outside the process by loading
+// it at every interval and publishing the differences. Only the last known
+// event and the events after it are loaded at each poll. The session is
+// loaded once before returning, so that changes made from then on are
+// reported; if that load fails, the subscription ends with a ChangeFailed
+// change. Later failed polls are retried at the next interval. It returns a
+// function that stops polling and cancels the subscription.
+func pollSubscription(sub *subscription, interval time.Duration, load func(config *GetSessionConfig) (*Session, error)) func() {
+	previous, err := load(&GetSessionConfig{NumRecentEvents: 1})
+	if err != nil {
+		sub.fail(err)
+		return sub.cancel
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			// Events sharing the last known event's timestamp are loaded too,
+			// so that none appended in the same instant are missed
+			var config *GetSessionConfig
+			if previous != nil && len(previous.Events) > 0 {
+				config = &GetSessionConfig{AfterTimestamp: previous.Events[len(previous.Events)-1].Timestamp.Add(-time.Nanosecond)}
+			}
+			current, err := load(config)
+			if err == nil && config != nil && current != nil && !hasEvent(current, previous.Events[len(previous.Events)-1].ID) {
+				// A rewound session may keep only events older than the
+				// last known one, so its true last event is loaded instead
+				current, err = load(&GetSessionConfig{NumRecentEvents: 1})
+			}
+			if err != nil {
+				continue
+			}
+			for _, change := range sessionChanges(previous, current) {
+				sub.publish(change)
+			}
+
+			// Only the last event is needed to find the next poll's events
+			if current != nil && len(current.Events) > 1 {
+				current.Events = current.Events[len(current.Events)-1:]
+			}
+			previous = current
+		}
+	}()
+
+	return func() {
+		cancel()
+		sub.cancel()
+	}
+}
+
+// hasEvent reports whether a loaded session holds the event with the given ID
+func hasEvent(session *Session, id string) bool {
+	for _, event := range session.Events {
+		if event.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// sessionChanges returns the changes that turn one loaded copy of a session
+// into a later one; either may be nil for a missing session. The previous
+// copy holds at most its last event, and the current one the events from
+// that event on.
+func sessionChanges(previous, current *Session) []SessionChange {
+	switch {
+	case previous == nil && current == nil:
+		return nil
+	case current == nil:
+		return []SessionChange{{Type: ChangeDeleted, Version: previous.Version}}
+	case previous == nil:
+		return []SessionChange{{Type: ChangeCreated, Version: current.Version, State: current.State.ToDict()}}
+	}
+
+	// A session whose last known event is gone, or whose version moved by
+	// more than its appended events, was rewound, or deleted and created
+	// again
+	appended, found := current.Events, true
+	if len(previous.Events) > 0 {
+		last := previous.Events[len(previous.Events)-1]
+		found = false
+		for i, event := range current.Events {
+			if event.ID == last.ID {
+				appended, found = current.Events[i+1:], true
+				break
+			}
+		}
+	}
+	if !found || current.Version-previous.Version != int64(len(appended)) {
+		return []SessionChange{{Type: ChangeRewound, Version: current.Version, State: current.State.ToDict()}}
+	}
+
+	// Each appended event moved the session one version forward
+	var changes []SessionChange
+	explained := make(map[string]bool)
+	for i, event := range appended {
+		changes = append(changes, SessionChange{
+			Type:       ChangeEventAppended,
+			Version:    current.Version - int64(len(appended)-1-i),
+			Event:      event,
+			StateDelta: event.Actions.StateDelta,
+		})
+		for key := range event.Actions.StateDelta {
+			explained[key] = true
+		}
+	}
+
+	// Values changed without an event of this session come from its app's or
+	// user's other sessions
+	delta := make(map[string]interface{})
+	before := previous.State.ToDict()
+	for key, value := range current.State.ToDict() {
+		if old, exists := before[key]; !explained[key] && (!exists || !reflect.DeepEqual(old, value)) {
+			delta[key] = value
+		}
+	}
+	if len(delta) > 0 {
+		changes = append(changes, SessionChange{Type: ChangeStateUpdated, Version: current.Version, StateDelta: delta})
+	}
+	return changes
+}